@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Store looks up stored blocks, a nil block is one that isn't stored
+type Store interface {
+	BlockByEthHash(ctx context.Context, chainId int, ethHash string) (*db.StoredBlock, error)
+	BlockByNumber(ctx context.Context, chainId int, blockNum int64) (*db.StoredBlock, error)
+}
+
+// Block is the json a stored block is answered with
+type Block struct {
+	BlockNumber  int        `json:"blockNumber"`
+	ChainId      int        `json:"chainId"`
+	EthHash      string     `json:"ethHash"`
+	HtmlcoinHash string     `json:"htmlcoinHash"`
+	IngestedAt   *time.Time `json:"ingestedAt,omitempty"`
+	Size         *int64     `json:"size,omitempty"`
+	GasUsedRatio *float64   `json:"gasUsedRatio,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Handler answers lookups of the blocks of chainId stored in store:
+// GET /v1/block/{ethHash} and GET /v1/block/by-number/{n}
+func Handler(logger *logrus.Entry, store Store, chainId int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/block/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			reply(w, http.StatusMethodNotAllowed, errorResponse{"only GET is supported"})
+			return
+		}
+		var block *db.StoredBlock
+		var err error
+		key := strings.TrimPrefix(r.URL.Path, "/v1/block/")
+		if number := strings.TrimPrefix(key, "by-number/"); number != key {
+			blockNum, parseErr := strconv.ParseInt(number, 10, 64)
+			if parseErr != nil || blockNum < 0 {
+				reply(w, http.StatusBadRequest, errorResponse{"invalid block number " + strconv.Quote(number)})
+				return
+			}
+			block, err = store.BlockByNumber(r.Context(), chainId, blockNum)
+		} else {
+			if key == "" || strings.Contains(key, "/") {
+				reply(w, http.StatusNotFound, errorResponse{"not found"})
+				return
+			}
+			// hashes are stored in lower case
+			block, err = store.BlockByEthHash(r.Context(), chainId, strings.ToLower(key))
+		}
+		if err != nil {
+			logger.Error("failed to look up block: ", err)
+			reply(w, http.StatusInternalServerError, errorResponse{"lookup failed"})
+			return
+		}
+		if block == nil {
+			reply(w, http.StatusNotFound, errorResponse{"block not found"})
+			return
+		}
+		reply(w, http.StatusOK, Block{
+			BlockNumber:  block.BlockNumber,
+			ChainId:      block.ChainId,
+			EthHash:      block.EthHash,
+			HtmlcoinHash: block.HtmlcoinHash,
+			IngestedAt:   block.IngestedAt,
+			Size:         block.Size,
+			GasUsedRatio: block.GasUsedRatio,
+		})
+	})
+	return mux
+}
+
+func reply(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Serve answers lookups at addr until the returned server is closed
+func Serve(addr string, handler http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return server, nil
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeStore stores block 7 of chain 4444
+type fakeStore struct {
+	err error
+}
+
+var stored = db.StoredBlock{BlockNumber: 7, ChainId: 4444, EthHash: "0xeth7", HtmlcoinHash: "0xhtmlcoin7"}
+
+func (s *fakeStore) BlockByEthHash(ctx context.Context, chainId int, ethHash string) (*db.StoredBlock, error) {
+	if s.err != nil || chainId != stored.ChainId || ethHash != stored.EthHash {
+		return nil, s.err
+	}
+	return &stored, nil
+}
+
+func (s *fakeStore) BlockByNumber(ctx context.Context, chainId int, blockNum int64) (*db.StoredBlock, error) {
+	if s.err != nil || chainId != stored.ChainId || blockNum != int64(stored.BlockNumber) {
+		return nil, s.err
+	}
+	return &stored, nil
+}
+
+func TestHandler(t *testing.T) {
+	get := func(t *testing.T, store Store, method, path string) (int, map[string]interface{}) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		Handler(logrus.NewEntry(logrus.New()), store, 4444).ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+			t.Fatalf("got invalid json %q: %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, body
+	}
+
+	for _, path := range []string{"/v1/block/0xeth7", "/v1/block/0xETH7", "/v1/block/by-number/7"} {
+		t.Run("stored block is found at "+path, func(t *testing.T) {
+			status, body := get(t, &fakeStore{}, http.MethodGet, path)
+			if status != http.StatusOK || body["blockNumber"] != float64(7) || body["ethHash"] != "0xeth7" || body["htmlcoinHash"] != "0xhtmlcoin7" {
+				t.Errorf("got %d %v, want block 7", status, body)
+			}
+		})
+	}
+
+	cases := []struct {
+		name   string
+		store  Store
+		method string
+		path   string
+		status int
+	}{
+		{"unknown hash isn't found", &fakeStore{}, http.MethodGet, "/v1/block/0xeth8", http.StatusNotFound},
+		{"unknown number isn't found", &fakeStore{}, http.MethodGet, "/v1/block/by-number/8", http.StatusNotFound},
+		{"invalid number is rejected", &fakeStore{}, http.MethodGet, "/v1/block/by-number/seven", http.StatusBadRequest},
+		{"only GET is supported", &fakeStore{}, http.MethodPost, "/v1/block/0xeth7", http.StatusMethodNotAllowed},
+		{"store errors fail the lookup", &fakeStore{err: errors.New("connection refused")}, http.MethodGet, "/v1/block/0xeth7", http.StatusInternalServerError},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if status, body := get(t, c.store, c.method, c.path); status != c.status || body["error"] == nil {
+				t.Errorf("got %d %v, want %d with an error", status, body, c.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// Source pages through the hash pairs of a block range, ordered by block
+// number then eth hash. A nil next cursor marks the last page
+type Source interface {
+	GetHashPairsPage(ctx context.Context, chainId int, firstBlock, lastBlock int64, after *db.HashPairsCursor, limit int) ([]jsonrpc.HashPair, *db.HashPairsCursor, error)
+}
+
+type Kind string
+
+const (
+	// Missing blocks are in the reference only
+	Missing Kind = "missing"
+	// Extra blocks are stored but not in the reference
+	Extra Kind = "extra"
+	// Mismatched blocks are in both with differing hashes
+	Mismatch Kind = "mismatch"
+)
+
+// Difference is a block whose stored hash pairs differ from the reference
+type Difference struct {
+	Kind        Kind
+	BlockNumber int
+	Stored      []jsonrpc.HashPair
+	Reference   []jsonrpc.HashPair
+}
+
+func (d Difference) String() string {
+	return fmt.Sprintf("%s %d stored [%s] reference [%s]", d.Kind, d.BlockNumber, formatPairs(d.Stored), formatPairs(d.Reference))
+}
+
+func formatPairs(pairs []jsonrpc.HashPair) string {
+	formatted := make([]string, len(pairs))
+	for i, pair := range pairs {
+		formatted[i] = fmt.Sprintf("eth=%s htmlcoin=%s", pair.EthHash, pair.HtmlcoinHash)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+type Config struct {
+	ChainId    int
+	FirstBlock int64
+	LastBlock  int64
+	// number of hash pairs read from each source at once
+	PageSize int
+}
+
+// Summary counts the blocks compared and their differences by kind
+type Summary struct {
+	Blocks      int
+	Differences map[Kind]int
+}
+
+// Audit compares the hash pairs stored over the configured range against the
+// reference, calling report with every difference in block order. Both are
+// read a page at a time so memory stays bounded over large ranges
+func Audit(ctx context.Context, stored, reference Source, config Config, report func(Difference) error) (Summary, error) {
+	summary := Summary{Differences: make(map[Kind]int)}
+	if config.PageSize < 1 {
+		return summary, fmt.Errorf("invalid page size %d", config.PageSize)
+	}
+	storedBlocks := newBlockStream(stored, config)
+	referenceBlocks := newBlockStream(reference, config)
+
+	for {
+		storedBlock, storedPairs, err := storedBlocks.peek(ctx)
+		if err != nil {
+			return summary, err
+		}
+		referenceBlock, referencePairs, err := referenceBlocks.peek(ctx)
+		if err != nil {
+			return summary, err
+		}
+		if storedPairs == nil && referencePairs == nil {
+			return summary, nil
+		}
+
+		var difference *Difference
+		switch {
+		case referencePairs == nil || (storedPairs != nil && storedBlock < referenceBlock):
+			difference = &Difference{Kind: Extra, BlockNumber: storedBlock, Stored: storedPairs}
+			storedBlocks.next()
+		case storedPairs == nil || referenceBlock < storedBlock:
+			difference = &Difference{Kind: Missing, BlockNumber: referenceBlock, Reference: referencePairs}
+			referenceBlocks.next()
+		default:
+			if !equalPairs(storedPairs, referencePairs) {
+				difference = &Difference{Kind: Mismatch, BlockNumber: storedBlock, Stored: storedPairs, Reference: referencePairs}
+			}
+			storedBlocks.next()
+			referenceBlocks.next()
+		}
+
+		summary.Blocks++
+		if difference != nil {
+			summary.Differences[difference.Kind]++
+			if err = report(*difference); err != nil {
+				return summary, err
+			}
+		}
+	}
+}
+
+func equalPairs(a, b []jsonrpc.HashPair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].EthHash != b[i].EthHash || a[i].HtmlcoinHash != b[i].HtmlcoinHash {
+			return false
+		}
+	}
+	return true
+}
+
+// blockStream groups the pages of a source by block
+type blockStream struct {
+	source Source
+	config Config
+	page   []jsonrpc.HashPair
+	after  *db.HashPairsCursor
+	// no page left to read
+	exhausted bool
+	// head block and its pairs, nil when it must be read
+	block int
+	pairs []jsonrpc.HashPair
+}
+
+func newBlockStream(source Source, config Config) *blockStream {
+	return &blockStream{source: source, config: config}
+}
+
+// peek returns the head block and its pairs, nil pairs once the source is exhausted
+func (s *blockStream) peek(ctx context.Context) (int, []jsonrpc.HashPair, error) {
+	if s.pairs != nil {
+		return s.block, s.pairs, nil
+	}
+	var block int
+	var pairs []jsonrpc.HashPair
+	for {
+		if len(s.page) == 0 {
+			if s.exhausted {
+				break
+			}
+			// a block's pairs may continue on the next page
+			if err := s.readPage(ctx); err != nil {
+				return 0, nil, err
+			}
+			continue
+		}
+		if pairs != nil && s.page[0].BlockNumber != block {
+			break
+		}
+		block = s.page[0].BlockNumber
+		pairs = append(pairs, s.page[0])
+		s.page = s.page[1:]
+	}
+	s.block, s.pairs = block, pairs
+	return block, pairs, nil
+}
+
+// next moves past the head block
+func (s *blockStream) next() {
+	s.pairs = nil
+}
+
+func (s *blockStream) readPage(ctx context.Context) error {
+	page, after, err := s.source.GetHashPairsPage(ctx, s.config.ChainId, s.config.FirstBlock, s.config.LastBlock, s.after, s.config.PageSize)
+	if err != nil {
+		return err
+	}
+	s.page = page
+	s.after = after
+	s.exhausted = after == nil
+	return nil
+}
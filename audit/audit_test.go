@@ -0,0 +1,212 @@
+package audit
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// memorySource pages through seeded hash pairs, ordered by block number then eth hash
+type memorySource struct {
+	pairs []jsonrpc.HashPair
+	pages int
+}
+
+func (m *memorySource) GetHashPairsPage(ctx context.Context, chainId int, firstBlock, lastBlock int64, after *db.HashPairsCursor, limit int) ([]jsonrpc.HashPair, *db.HashPairsCursor, error) {
+	m.pages++
+	if after == nil {
+		after = &db.HashPairsCursor{BlockNumber: int(firstBlock) - 1}
+	}
+	var page []jsonrpc.HashPair
+	for _, pair := range m.pairs {
+		if int64(pair.BlockNumber) < firstBlock || int64(pair.BlockNumber) > lastBlock {
+			continue
+		}
+		if pair.BlockNumber < after.BlockNumber || (pair.BlockNumber == after.BlockNumber && pair.EthHash <= after.EthHash) {
+			continue
+		}
+		page = append(page, pair)
+		if len(page) == limit {
+			last := page[len(page)-1]
+			return page, &db.HashPairsCursor{BlockNumber: last.BlockNumber, EthHash: last.EthHash}, nil
+		}
+	}
+	return page, nil, nil
+}
+
+func pair(block int, eth, htmlcoin string) jsonrpc.HashPair {
+	return jsonrpc.HashPair{BlockNumber: block, EthHash: eth, HtmlcoinHash: htmlcoin}
+}
+
+func TestAudit(t *testing.T) {
+	stored := &memorySource{pairs: []jsonrpc.HashPair{
+		pair(1, "0xe1", "0xh1"),
+		pair(2, "0xe2", "0xh2"),
+		// a stale pair left by a reorg
+		pair(3, "0xe3", "0xh3"),
+		pair(3, "0xe3stale", "0xh3stale"),
+		pair(5, "0xe5", "0xh5wrong"),
+		pair(6, "0xe6", "0xh6"),
+		pair(8, "0xe8", "0xh8"),
+		pair(9, "0xe9", "0xh9"),
+	}}
+	reference := &memorySource{pairs: []jsonrpc.HashPair{
+		pair(1, "0xe1", "0xh1"),
+		pair(2, "0xe2", "0xh2"),
+		pair(3, "0xe3", "0xh3"),
+		pair(4, "0xe4", "0xh4"),
+		pair(5, "0xe5", "0xh5"),
+		pair(7, "0xe7", "0xh7"),
+		pair(8, "0xe8", "0xh8"),
+		pair(9, "0xe9", "0xh9"),
+		// outside of the audited range
+		pair(10, "0xe10", "0xh10"),
+	}}
+
+	var differences []Difference
+	summary, err := Audit(context.Background(), stored, reference, Config{ChainId: 4444, FirstBlock: 1, LastBlock: 9, PageSize: 2}, func(difference Difference) error {
+		differences = append(differences, difference)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("missing, extra and mismatched blocks are reported in block order", func(t *testing.T) {
+		want := []Difference{
+			{Kind: Mismatch, BlockNumber: 3, Stored: []jsonrpc.HashPair{pair(3, "0xe3", "0xh3"), pair(3, "0xe3stale", "0xh3stale")}, Reference: []jsonrpc.HashPair{pair(3, "0xe3", "0xh3")}},
+			{Kind: Missing, BlockNumber: 4, Reference: []jsonrpc.HashPair{pair(4, "0xe4", "0xh4")}},
+			{Kind: Mismatch, BlockNumber: 5, Stored: []jsonrpc.HashPair{pair(5, "0xe5", "0xh5wrong")}, Reference: []jsonrpc.HashPair{pair(5, "0xe5", "0xh5")}},
+			{Kind: Extra, BlockNumber: 6, Stored: []jsonrpc.HashPair{pair(6, "0xe6", "0xh6")}},
+			{Kind: Missing, BlockNumber: 7, Reference: []jsonrpc.HashPair{pair(7, "0xe7", "0xh7")}},
+		}
+		if !reflect.DeepEqual(differences, want) {
+			t.Errorf("got differences\n%v\nwant\n%v", differences, want)
+		}
+	})
+
+	t.Run("summary counts blocks and differences by kind", func(t *testing.T) {
+		want := Summary{Blocks: 9, Differences: map[Kind]int{Missing: 2, Extra: 1, Mismatch: 2}}
+		if !reflect.DeepEqual(summary, want) {
+			t.Errorf("got %+v, want %+v", summary, want)
+		}
+	})
+
+	t.Run("sources are read a page at a time", func(t *testing.T) {
+		if stored.pages < 4 || reference.pages < 4 {
+			t.Errorf("got %d stored and %d reference pages, want the range read in pages of 2", stored.pages, reference.pages)
+		}
+	})
+}
+
+func TestProviderSource(t *testing.T) {
+	provider, err := jsonrpc.ParseProvider("synthetic://?head=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := NewProviderSource(provider)
+
+	var pairs []jsonrpc.HashPair
+	var after *db.HashPairsCursor
+	for {
+		page, next, err := source.GetHashPairsPage(context.Background(), 4444, 3, 8, after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs = append(pairs, page...)
+		if next == nil {
+			break
+		}
+		after = next
+	}
+
+	t.Run("blocks are fetched up to the provider head", func(t *testing.T) {
+		if len(pairs) != 3 {
+			t.Fatalf("got %d pairs, want blocks 3-5", len(pairs))
+		}
+		for i, pair := range pairs {
+			if pair.BlockNumber != 3+i || pair.HtmlcoinHash != jsonrpc.SyntheticBlockHash(int64(3+i)) || pair.EthHash == "" {
+				t.Errorf("got %+v for block %d", pair, 3+i)
+			}
+		}
+	})
+}
+
+// memoryChain serves the hash pairs of seeded blocks, counting the blocks fetched
+type memoryChain struct {
+	pairs   map[int64]jsonrpc.HashPair
+	fetched int
+}
+
+func (m *memoryChain) GetHashPair(ctx context.Context, blockNumber int64) (jsonrpc.HashPair, error) {
+	m.fetched++
+	pair, ok := m.pairs[blockNumber]
+	if !ok {
+		return jsonrpc.HashPair{}, jsonrpc.ErrNotFound
+	}
+	return pair, nil
+}
+
+func TestVerify(t *testing.T) {
+	stored := &memorySource{pairs: []jsonrpc.HashPair{
+		pair(1, "0xe1", "0xh1"),
+		pair(2, "0xe2", "0xh2"),
+		pair(2, "0xe2stale", "0xh2stale"),
+		pair(3, "0xe3", "0xh3wrong"),
+		pair(5, "0xe5", "0xh5"),
+	}}
+	chain := &memoryChain{pairs: map[int64]jsonrpc.HashPair{
+		1: pair(1, "0xe1", "0xh1"),
+		2: pair(2, "0xe2", "0xh2"),
+		3: pair(3, "0xe3", "0xh3"),
+		4: pair(4, "0xe4", "0xh4"),
+	}}
+	config := VerifyConfig{Config: Config{ChainId: 4444, FirstBlock: 1, LastBlock: 5, PageSize: 2}, Sample: 1}
+
+	t.Run("every stored block is re-queried", func(t *testing.T) {
+		var differences []Difference
+		summary, err := Verify(context.Background(), stored, chain, config, func(difference Difference) error {
+			differences = append(differences, difference)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []Difference{
+			{Kind: Mismatch, BlockNumber: 2, Stored: []jsonrpc.HashPair{pair(2, "0xe2", "0xh2"), pair(2, "0xe2stale", "0xh2stale")}, Reference: []jsonrpc.HashPair{pair(2, "0xe2", "0xh2")}},
+			{Kind: Mismatch, BlockNumber: 3, Stored: []jsonrpc.HashPair{pair(3, "0xe3", "0xh3wrong")}, Reference: []jsonrpc.HashPair{pair(3, "0xe3", "0xh3")}},
+			{Kind: Extra, BlockNumber: 5, Stored: []jsonrpc.HashPair{pair(5, "0xe5", "0xh5")}},
+		}
+		if !reflect.DeepEqual(differences, want) {
+			t.Errorf("got differences\n%v\nwant\n%v", differences, want)
+		}
+		wantSummary := Summary{Blocks: 4, Differences: map[Kind]int{Mismatch: 2, Extra: 1}}
+		if !reflect.DeepEqual(summary, wantSummary) {
+			t.Errorf("got %+v, want %+v", summary, wantSummary)
+		}
+	})
+
+	t.Run("no block is re-queried with an empty sample", func(t *testing.T) {
+		chain.fetched = 0
+		config := config
+		config.Sample = 0
+		summary, err := Verify(context.Background(), stored, chain, config, func(Difference) error { return nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if summary.Blocks != 0 || chain.fetched != 0 {
+			t.Errorf("got %d blocks verified and %d fetched, want none", summary.Blocks, chain.fetched)
+		}
+	})
+
+	t.Run("samples outside of 0-1 are rejected", func(t *testing.T) {
+		config := config
+		config.Sample = 1.5
+		if _, err := Verify(context.Background(), stored, chain, config, func(Difference) error { return nil }); err == nil {
+			t.Error("got no error")
+		}
+	})
+}
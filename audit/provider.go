@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// ProviderSource reads the reference hash pairs from a provider, such as an
+// archive node, hashing blocks the same way workers do. Blocks the provider
+// doesn't have are left out of the reference
+type ProviderSource struct {
+	client          *jsonrpc.Client
+	timestampFormat jsonrpc.NumberFormat
+}
+
+func NewProviderSource(provider *jsonrpc.Provider) *ProviderSource {
+	return &ProviderSource{client: jsonrpc.NewProviderClient(provider, 0), timestampFormat: provider.TimestampFormat}
+}
+
+// GetHashPairsPage fetches up to limit blocks following after. The chain id
+// is the provider's own
+func (p *ProviderSource) GetHashPairsPage(ctx context.Context, chainId int, firstBlock, lastBlock int64, after *db.HashPairsCursor, limit int) ([]jsonrpc.HashPair, *db.HashPairsCursor, error) {
+	if limit < 1 {
+		return nil, nil, fmt.Errorf("invalid page limit %d", limit)
+	}
+	start := firstBlock
+	if after != nil {
+		start = int64(after.BlockNumber) + 1
+	}
+	end := start + int64(limit) - 1
+	if end > lastBlock {
+		end = lastBlock
+	}
+
+	var pairs []jsonrpc.HashPair
+	for blockNumber := start; blockNumber <= end; blockNumber++ {
+		pair, err := p.GetHashPair(ctx, blockNumber)
+		if err == jsonrpc.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("fetching reference block %d: %w", blockNumber, err)
+		}
+		pairs = append(pairs, pair)
+	}
+	if end >= lastBlock {
+		return pairs, nil, nil
+	}
+	return pairs, &db.HashPairsCursor{BlockNumber: int(end)}, nil
+}
+
+// GetHashPair fetches and hashes blockNumber, jsonrpc.ErrNotFound when the
+// provider doesn't have it
+func (p *ProviderSource) GetHashPair(ctx context.Context, blockNumber int64) (jsonrpc.HashPair, error) {
+	rpcResponse, err := p.client.Call(ctx, "eth_getBlockByNumber", fmt.Sprintf("0x%x", blockNumber), false)
+	if err != nil {
+		return jsonrpc.HashPair{}, err
+	}
+	if rpcResponse.Error != nil {
+		return jsonrpc.HashPair{}, rpcResponse.Error
+	}
+	if err = jsonrpc.NormalizeTimestamp(rpcResponse, p.timestampFormat); err != nil {
+		return jsonrpc.HashPair{}, err
+	}
+	var htmlcoinBlock jsonrpc.GetBlockByNumberResponse
+	if err = jsonrpc.DecodeResult(rpcResponse, &htmlcoinBlock, jsonrpc.NullResultNotFound); err != nil {
+		return jsonrpc.HashPair{}, err
+	}
+	var ethBlock jsonrpc.EthBlockHeader
+	if err = jsonrpc.GetBlockFromRPCResponse(rpcResponse, &ethBlock); err != nil {
+		return jsonrpc.HashPair{}, err
+	}
+	return jsonrpc.HashPair{
+		BlockNumber:  int(blockNumber),
+		HtmlcoinHash: htmlcoinBlock.Hash,
+		EthHash:      ethBlock.Hash().String(),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// Chain fetches the hash pair of a block from the chain, jsonrpc.ErrNotFound
+// when the chain doesn't have it
+type Chain interface {
+	GetHashPair(ctx context.Context, blockNumber int64) (jsonrpc.HashPair, error)
+}
+
+// VerifyConfig adds the fraction of stored blocks re-queried to the range
+// verified
+type VerifyConfig struct {
+	Config
+	// between 0 and 1, 1 re-queries every block
+	Sample float64
+}
+
+// Verify re-queries a sample of the blocks stored over the configured range
+// from chain, calling report with every block whose stored hash pairs no
+// longer match, in block order. Blocks the chain doesn't have are reported
+// as extra. Unlike Audit, blocks missing from the store aren't compared
+func Verify(ctx context.Context, stored Source, chain Chain, config VerifyConfig, report func(Difference) error) (Summary, error) {
+	summary := Summary{Differences: make(map[Kind]int)}
+	if config.PageSize < 1 {
+		return summary, fmt.Errorf("invalid page size %d", config.PageSize)
+	}
+	if config.Sample < 0 || config.Sample > 1 {
+		return summary, fmt.Errorf("invalid sample %v, want between 0 and 1", config.Sample)
+	}
+	storedBlocks := newBlockStream(stored, config.Config)
+
+	for {
+		block, storedPairs, err := storedBlocks.peek(ctx)
+		if err != nil {
+			return summary, err
+		}
+		if storedPairs == nil {
+			return summary, nil
+		}
+		storedBlocks.next()
+		if config.Sample < 1 && rand.Float64() >= config.Sample {
+			continue
+		}
+
+		var difference *Difference
+		pair, err := chain.GetHashPair(ctx, int64(block))
+		switch {
+		case err == jsonrpc.ErrNotFound:
+			difference = &Difference{Kind: Extra, BlockNumber: block, Stored: storedPairs}
+		case err != nil:
+			return summary, fmt.Errorf("fetching block %d: %w", block, err)
+		case !equalPairs(storedPairs, []jsonrpc.HashPair{pair}):
+			difference = &Difference{Kind: Mismatch, BlockNumber: block, Stored: storedPairs, Reference: []jsonrpc.HashPair{pair}}
+		}
+
+		summary.Blocks++
+		if difference != nil {
+			summary.Differences[difference.Kind]++
+			if err = report(*difference); err != nil {
+				return summary, err
+			}
+		}
+	}
+}
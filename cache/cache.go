@@ -4,17 +4,51 @@ import (
 	"context"
 	"sync"
 	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/sirupsen/logrus"
 )
 
 type GetMissingBlocks func(ctx context.Context) ([]int64, error)
 
+// RetryGetMissingBlocks retries getMissingBlocks up to retries times, doubling
+// backoff between attempts, so a transient database error doesn't fail the load
+func RetryGetMissingBlocks(logger *logrus.Entry, retries int, backoff time.Duration, getMissingBlocks GetMissingBlocks) GetMissingBlocks {
+	return func(ctx context.Context) ([]int64, error) {
+		backoff := backoff
+		for attempt := 0; ; attempt++ {
+			missingBlocks, err := getMissingBlocks(ctx)
+			if err == nil || attempt >= retries {
+				return missingBlocks, err
+			}
+			logger.WithFields(logrus.Fields{
+				"attempt": attempt + 1,
+				"backoff": backoff,
+			}).Warn("Retrying getting missing blocks: ", err)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
+
 type BlockCache struct {
 	ctx              context.Context
 	mutex            sync.RWMutex
 	updateMutex      sync.RWMutex
 	getMissingBlocks GetMissingBlocks
 	missingBlocks    []int64
-	lastUpdate       time.Time
+	// missing blocks not completed since the last update
+	pending    map[int64]struct{}
+	lastUpdate time.Time
+	// the missing blocks were restored from store rather than loaded, they're
+	// loaded once the restored ones are processed
+	restored bool
+	store    *Store
+	storeKey string
 }
 
 func NewBlockCache(ctx context.Context, getMissingBlocks GetMissingBlocks) *BlockCache {
@@ -22,6 +56,7 @@ func NewBlockCache(ctx context.Context, getMissingBlocks GetMissingBlocks) *Bloc
 		ctx:              ctx,
 		getMissingBlocks: getMissingBlocks,
 		missingBlocks:    []int64{},
+		pending:          map[int64]struct{}{},
 	}
 
 	return blockCache
@@ -39,7 +74,7 @@ func (cache *BlockCache) UpdateMissingBlocks(ctx context.Context) (bool, error)
 	minutesSinceLastUpdate := time.Since(cache.lastUpdate).Minutes()
 	cache.updateMutex.RUnlock()
 
-	if minutesSinceLastUpdate < 1 {
+	if minutesSinceLastUpdate < 1 && !cache.restoredDrained() {
 		return false, nil
 	}
 
@@ -48,7 +83,7 @@ func (cache *BlockCache) UpdateMissingBlocks(ctx context.Context) (bool, error)
 
 	minutesSinceLastUpdate = time.Since(cache.lastUpdate).Minutes()
 
-	if minutesSinceLastUpdate < 1 {
+	if minutesSinceLastUpdate < 1 && !cache.restoredDrained() {
 		return false, nil
 	}
 
@@ -63,8 +98,136 @@ func (cache *BlockCache) UpdateMissingBlocks(ctx context.Context) (bool, error)
 
 	cache.mutex.Lock()
 	cache.missingBlocks = missingBlocks
+	cache.pending = make(map[int64]struct{}, len(missingBlocks))
+	for _, block := range missingBlocks {
+		cache.pending[block] = struct{}{}
+	}
+	metrics.CacheBacklog.Set(float64(len(cache.pending)))
 	cache.lastUpdate = time.Now()
+	cache.restored = false
 	cache.mutex.Unlock()
 
 	return true, nil
 }
+
+// restoredDrained reports whether the restored blocks were all processed
+func (cache *BlockCache) restoredDrained() bool {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return cache.restored && len(cache.pending) == 0
+}
+
+// Restore makes the blocks saved as pending to store under key, by the
+// previous run of the same scan, the missing blocks, so they're dispatched
+// without waiting for the missing blocks to be loaded. They're loaded once
+// the restored blocks are processed, which may not be every block missing.
+// Save saves the pending blocks under key from then on. It returns the
+// number of blocks restored
+func (cache *BlockCache) Restore(store *Store, key string) (int, error) {
+	blocks, err := store.Load(key)
+	if err != nil {
+		return 0, err
+	}
+
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.store = store
+	cache.storeKey = key
+	if len(blocks) == 0 {
+		return 0, nil
+	}
+	cache.missingBlocks = blocks
+	cache.pending = make(map[int64]struct{}, len(blocks))
+	for _, block := range blocks {
+		cache.pending[block] = struct{}{}
+	}
+	metrics.CacheBacklog.Set(float64(len(cache.pending)))
+	cache.lastUpdate = time.Now()
+	cache.restored = true
+	return len(blocks), nil
+}
+
+// Save saves the pending blocks to the store given to Restore, if any
+func (cache *BlockCache) Save() error {
+	cache.mutex.RLock()
+	if cache.store == nil {
+		cache.mutex.RUnlock()
+		return nil
+	}
+	pending := make([]int64, 0, len(cache.pending))
+	for block := range cache.pending {
+		pending = append(pending, block)
+	}
+	cache.mutex.RUnlock()
+
+	return cache.store.Save(cache.storeKey, pending)
+}
+
+// Drained reports whether every missing block was processed. Restored
+// blocks processed aren't, the missing blocks are still to be loaded
+func (cache *BlockCache) Drained() bool {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return len(cache.pending) == 0 && !cache.restored
+}
+
+// Expire has the next update reload the missing blocks however recent the
+// last one is, e.g. once a new head is mined
+func (cache *BlockCache) Expire() {
+	cache.updateMutex.Lock()
+	defer cache.updateMutex.Unlock()
+
+	cache.lastUpdate = time.Time{}
+}
+
+// CompleteBlock removes a processed block from the backlog
+func (cache *BlockCache) CompleteBlock(block int64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.pending, block)
+	metrics.CacheBacklog.Set(float64(len(cache.pending)))
+}
+
+// GetBacklog returns the number of missing blocks not processed yet
+func (cache *BlockCache) GetBacklog() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return len(cache.pending)
+}
+
+// GetWorkingSet returns the number of missing blocks at the last update,
+// the blocks the backlog is draining
+func (cache *BlockCache) GetWorkingSet() int {
+	cache.mutex.RLock()
+	defer cache.mutex.RUnlock()
+
+	return len(cache.missingBlocks)
+}
+
+// ReportBacklog logs the backlog and the rate it drains at every interval
+// until ctx is cancelled. The backlog can grow when the cache is updated
+// with newly mined blocks, yielding a negative rate
+func (cache *BlockCache) ReportBacklog(ctx context.Context, logger *logrus.Entry, interval time.Duration) {
+	last := cache.GetBacklog()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		backlog := cache.GetBacklog()
+		logger.WithFields(logrus.Fields{
+			"backlog":   backlog,
+			"drainRate": float64(last-backlog) / interval.Seconds(),
+		}).Info("Missing blocks backlog (drain rate in blocks/s)")
+		last = backlog
+	}
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheBacklog(t *testing.T) {
+	blockCache := NewBlockCache(context.Background(), func(ctx context.Context) ([]int64, error) {
+		return []int64{1, 2, 3, 4, 5}, nil
+	})
+
+	assertBacklog := func(t *testing.T, want int) {
+		t.Helper()
+		if got := blockCache.GetBacklog(); got != want {
+			t.Errorf("got backlog %d, want %d", got, want)
+		}
+		if got := testutil.ToFloat64(metrics.CacheBacklog); got != float64(want) {
+			t.Errorf("got backlog gauge %v, want %d", got, want)
+		}
+	}
+
+	t.Run("gauge reflects the enqueued missing blocks", func(t *testing.T) {
+		if _, err := blockCache.UpdateMissingBlocks(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		assertBacklog(t, 5)
+	})
+
+	t.Run("gauge drains as blocks are completed", func(t *testing.T) {
+		blockCache.CompleteBlock(2)
+		blockCache.CompleteBlock(4)
+		assertBacklog(t, 3)
+		// completing a block twice doesn't drain it twice
+		blockCache.CompleteBlock(4)
+		assertBacklog(t, 3)
+	})
+
+	t.Run("working set holds until the next update", func(t *testing.T) {
+		if got := blockCache.GetWorkingSet(); got != 5 {
+			t.Errorf("got working set %d, want 5", got)
+		}
+	})
+}
+
+func TestCacheExpire(t *testing.T) {
+	loads := 0
+	blockCache := NewBlockCache(context.Background(), func(ctx context.Context) ([]int64, error) {
+		loads++
+		return []int64{1}, nil
+	})
+	update := func() bool {
+		updated, err := blockCache.UpdateMissingBlocks(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return updated
+	}
+
+	if !update() || update() {
+		t.Fatal("want only the first update within a minute to reload")
+	}
+	blockCache.Expire()
+	if !update() || loads != 2 {
+		t.Errorf("got %d loads, want an expired cache to reload", loads)
+	}
+}
+
+func TestRetryGetMissingBlocks(t *testing.T) {
+	logger, _ := log.GetLogger()
+	errUnavailable := errors.New("the database system is starting up")
+
+	t.Run("loader recovers from a transient failure", func(t *testing.T) {
+		calls := 0
+		getMissingBlocks := RetryGetMissingBlocks(logger.WithField("module", "test"), 3, time.Millisecond, func(ctx context.Context) ([]int64, error) {
+			calls++
+			if calls == 1 {
+				return nil, errUnavailable
+			}
+			return []int64{1, 2}, nil
+		})
+
+		blockCache := NewBlockCache(context.Background(), getMissingBlocks)
+		updated, err := blockCache.UpdateMissingBlocks(context.Background())
+		if err != nil || !updated {
+			t.Fatalf("got updated %v (%v), want the cache updated", updated, err)
+		}
+		if got := blockCache.GetMissingBlocks(); !reflect.DeepEqual(got, []int64{1, 2}) {
+			t.Errorf("got missing blocks %v, want [1 2]", got)
+		}
+		if calls != 2 {
+			t.Errorf("got %d calls, want 2", calls)
+		}
+	})
+
+	t.Run("loader gives up once retries run out", func(t *testing.T) {
+		calls := 0
+		getMissingBlocks := RetryGetMissingBlocks(logger.WithField("module", "test"), 2, time.Millisecond, func(ctx context.Context) ([]int64, error) {
+			calls++
+			return nil, errUnavailable
+		})
+
+		if _, err := getMissingBlocks(context.Background()); err != errUnavailable {
+			t.Errorf("got %v, want %v", err, errUnavailable)
+		}
+		if calls != 3 {
+			t.Errorf("got %d calls, want 3", calls)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// Checkpoint tracks how far processing got over a block range: the
+// contiguous checkpoint, up to which every block is committed, and the
+// high-water mark, the highest block committed. A gap holds the
+// contiguous checkpoint back while the high-water mark keeps advancing
+type Checkpoint struct {
+	mutex sync.Mutex
+	// first block the contiguous checkpoint counts from, and the one a
+	// resumed checkpoint counted from
+	firstBlock int64
+	origin     int64
+	lastBlock  int64
+	contiguous int64
+	highWater  int64
+	// missing blocks of the range in ascending order, those before next
+	// are committed
+	missing   []int64
+	next      int
+	committed map[int64]bool
+}
+
+func NewCheckpoint() *Checkpoint {
+	return &Checkpoint{committed: make(map[int64]bool)}
+}
+
+// Reset tracks the range between firstBlock and lastBlock (inclusive)
+// whose missing blocks are missingBlocks, as freshly read from the database
+func (c *Checkpoint) Reset(firstBlock, lastBlock int64, missingBlocks []int64) {
+	missing := make([]int64, len(missingBlocks))
+	copy(missing, missingBlocks)
+	sort.Slice(missing, func(i, j int) bool { return missing[i] < missing[j] })
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.firstBlock = firstBlock
+	if c.origin > 0 && c.origin < firstBlock {
+		c.firstBlock = c.origin
+	}
+	c.lastBlock = lastBlock
+	c.missing = missing
+	c.next = 0
+	c.committed = make(map[int64]bool)
+	c.contiguous = firstBlock - 1
+	c.advance()
+}
+
+// Resume records that the blocks from origin up to the ranges Reset is
+// given are committed, as a checkpoint resumed from says, so they keep
+// counting towards the contiguous checkpoint
+func (c *Checkpoint) Resume(origin int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.origin = origin
+}
+
+// FirstBlock returns the first block the contiguous checkpoint counts from
+func (c *Checkpoint) FirstBlock() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.firstBlock
+}
+
+// Commit records block as committed
+func (c *Checkpoint) Commit(block int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if block > c.highWater {
+		c.highWater = block
+	}
+	c.committed[block] = true
+	c.advance()
+}
+
+// advance moves the contiguous checkpoint up to the first missing block not
+// committed yet
+func (c *Checkpoint) advance() {
+	for c.next < len(c.missing) && c.committed[c.missing[c.next]] {
+		delete(c.committed, c.missing[c.next])
+		c.next++
+	}
+	if c.next < len(c.missing) {
+		c.contiguous = c.missing[c.next] - 1
+	} else {
+		c.contiguous = c.lastBlock
+	}
+	if c.contiguous > c.highWater {
+		c.highWater = c.contiguous
+	}
+	metrics.CheckpointContiguous.Set(float64(c.contiguous))
+	metrics.CheckpointHighWater.Set(float64(c.highWater))
+}
+
+// After returns the contiguous checkpoint and the high-water mark as
+// committing blocks would move them, without committing them
+func (c *Checkpoint) After(blocks ...int64) (contiguous, highWater int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	highWater = c.highWater
+	pending := make(map[int64]bool, len(blocks))
+	for _, block := range blocks {
+		pending[block] = true
+		if block > highWater {
+			highWater = block
+		}
+	}
+	next := c.next
+	for next < len(c.missing) && (c.committed[c.missing[next]] || pending[c.missing[next]]) {
+		next++
+	}
+	contiguous = c.lastBlock
+	if next < len(c.missing) {
+		contiguous = c.missing[next] - 1
+	}
+	if contiguous > highWater {
+		highWater = contiguous
+	}
+	return contiguous, highWater
+}
+
+// Get returns the contiguous checkpoint and the high-water mark
+func (c *Checkpoint) Get() (contiguous, highWater int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.contiguous, c.highWater
+}
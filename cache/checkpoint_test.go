@@ -0,0 +1,72 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckpoint(t *testing.T) {
+	// blocks 1-3 and 7 are already stored
+	checkpoint := NewCheckpoint()
+	checkpoint.Reset(1, 10, []int64{10, 4, 5, 6, 8, 9})
+
+	assertCheckpoint := func(t *testing.T, wantContiguous, wantHighWater int64) {
+		t.Helper()
+		contiguous, highWater := checkpoint.Get()
+		if contiguous != wantContiguous || highWater != wantHighWater {
+			t.Errorf("got contiguous %d high-water %d, want %d and %d", contiguous, highWater, wantContiguous, wantHighWater)
+		}
+		if got := testutil.ToFloat64(metrics.CheckpointContiguous); got != float64(wantContiguous) {
+			t.Errorf("got contiguous gauge %v, want %d", got, wantContiguous)
+		}
+		if got := testutil.ToFloat64(metrics.CheckpointHighWater); got != float64(wantHighWater) {
+			t.Errorf("got high-water gauge %v, want %d", got, wantHighWater)
+		}
+	}
+
+	t.Run("contiguous checkpoint starts before the first missing block", func(t *testing.T) {
+		assertCheckpoint(t, 3, 3)
+	})
+
+	t.Run("contiguous checkpoint holds at a gap while the high-water mark advances", func(t *testing.T) {
+		checkpoint.Commit(5)
+		checkpoint.Commit(9)
+		checkpoint.Commit(6)
+		assertCheckpoint(t, 3, 9)
+	})
+
+	t.Run("filling the gap advances the contiguous checkpoint over committed and stored blocks", func(t *testing.T) {
+		checkpoint.Commit(4)
+		assertCheckpoint(t, 7, 9)
+		checkpoint.Commit(8)
+		checkpoint.Commit(10)
+		assertCheckpoint(t, 10, 10)
+	})
+}
+
+func TestCheckpointResume(t *testing.T) {
+	checkpoint := NewCheckpoint()
+	checkpoint.Reset(1, 10, []int64{4, 8})
+
+	t.Run("after previews a commit without recording it", func(t *testing.T) {
+		if contiguous, highWater := checkpoint.After(4); contiguous != 7 || highWater != 7 {
+			t.Errorf("got contiguous %d high-water %d, want 7 and 7", contiguous, highWater)
+		}
+		if contiguous, _ := checkpoint.Get(); contiguous != 3 {
+			t.Errorf("got contiguous %d, want 3", contiguous)
+		}
+	})
+
+	t.Run("resumed checkpoint keeps counting from its origin", func(t *testing.T) {
+		checkpoint.Resume(1)
+		checkpoint.Reset(8, 12, []int64{9})
+		if first := checkpoint.FirstBlock(); first != 1 {
+			t.Errorf("got first block %d, want 1", first)
+		}
+		if contiguous, _ := checkpoint.Get(); contiguous != 8 {
+			t.Errorf("got contiguous %d, want 8", contiguous)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// BlockRange is an inclusive range of block numbers
+type BlockRange struct {
+	Start int64
+	End   int64
+}
+
+func (r BlockRange) Len() int64 {
+	return r.End - r.Start + 1
+}
+
+func (r BlockRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("[%d]", r.Start)
+	}
+	return fmt.Sprintf("[%d-%d]", r.Start, r.End)
+}
+
+// CollapseRanges collapses block numbers into ranges of contiguous blocks,
+// the input doesn't need to be sorted and duplicates are ignored
+func CollapseRanges(blocks []int64) []BlockRange {
+	if len(blocks) == 0 {
+		return []BlockRange{}
+	}
+
+	sorted := make([]int64, len(blocks))
+	copy(sorted, blocks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ranges := []BlockRange{{Start: sorted[0], End: sorted[0]}}
+	for _, block := range sorted[1:] {
+		last := &ranges[len(ranges)-1]
+		switch {
+		case block == last.End:
+		case block == last.End+1:
+			last.End = block
+		default:
+			ranges = append(ranges, BlockRange{Start: block, End: block})
+		}
+	}
+
+	return ranges
+}
+
+// FormatRanges renders at most maxRanges ranges, summarizing the remaining
+// ones in a final line. A maxRanges of 0 renders every range
+func FormatRanges(ranges []BlockRange, maxRanges int) []string {
+	shown := len(ranges)
+	if maxRanges > 0 && shown > maxRanges {
+		shown = maxRanges
+	}
+
+	lines := make([]string, 0, shown+1)
+	for _, r := range ranges[:shown] {
+		lines = append(lines, r.String())
+	}
+
+	if shown < len(ranges) {
+		var blocks int64
+		for _, r := range ranges[shown:] {
+			blocks += r.Len()
+		}
+		lines = append(lines, fmt.Sprintf("... and %d more ranges (%d blocks)", len(ranges)-shown, blocks))
+	}
+
+	return lines
+}
+
+var ErrReversedRange = errors.New("blocks are scanned from --from down to --to, pass --swap-range to swap them")
+
+// ValidateScanRange checks the --from/--to scan bounds, where from is the
+// newest block to scan and to the oldest. Either bound may be 0 to leave it
+// open (latest block and block 1 respectively). A reversed range is an error
+// unless swapReversed is set, in which case the bounds are swapped
+func ValidateScanRange(from, to int64, swapReversed bool) (int64, int64, error) {
+	if from < 0 || to < 0 {
+		return from, to, fmt.Errorf("invalid scan range --from %d --to %d: block numbers must not be negative", from, to)
+	}
+
+	if from != 0 && from < to {
+		if !swapReversed {
+			return from, to, fmt.Errorf("invalid scan range --from %d --to %d: %w", from, to, ErrReversedRange)
+		}
+		from, to = to, from
+	}
+
+	return from, to, nil
+}
+
+// ScanBounds resolves the validated --from/--to scan bounds against the
+// latest block into an inclusive range of blocks to scan
+func ScanBounds(from, to, latestBlock int64) (firstBlock int64, lastBlock int64) {
+	firstBlock, lastBlock = to, latestBlock
+	if firstBlock < 1 {
+		firstBlock = 1
+	}
+	if from != 0 && from < latestBlock {
+		lastBlock = from
+	}
+	return firstBlock, lastBlock
+}
@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCollapseRanges(t *testing.T) {
+	t.Run("contiguous and scattered blocks are collapsed into ranges", func(t *testing.T) {
+		blocks := []int64{12, 3, 4, 5, 1, 9, 10, 11, 20, 4}
+		want := []BlockRange{{1, 1}, {3, 5}, {9, 12}, {20, 20}}
+		got := CollapseRanges(blocks)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("no missing blocks yields no ranges", func(t *testing.T) {
+		if got := CollapseRanges(nil); len(got) != 0 {
+			t.Errorf("got %v, want no ranges", got)
+		}
+	})
+}
+
+func TestFormatRanges(t *testing.T) {
+	ranges := []BlockRange{{1, 1}, {3, 5}, {9, 12}, {20, 20}}
+
+	t.Run("all ranges are rendered when under the cap", func(t *testing.T) {
+		want := []string{"[1]", "[3-5]", "[9-12]", "[20]"}
+		got := FormatRanges(ranges, 0)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("ranges over the cap are summarized", func(t *testing.T) {
+		want := []string{"[1]", "[3-5]", "... and 2 more ranges (5 blocks)"}
+		got := FormatRanges(ranges, 2)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
+
+func TestValidateScanRange(t *testing.T) {
+	t.Run("reversed range is rejected by default", func(t *testing.T) {
+		_, _, err := ValidateScanRange(500, 1000, false)
+		if !errors.Is(err, ErrReversedRange) {
+			t.Errorf("got %v, want %v", err, ErrReversedRange)
+		}
+	})
+
+	t.Run("reversed range is swapped when asked to", func(t *testing.T) {
+		from, to, err := ValidateScanRange(500, 1000, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if from != 1000 || to != 500 {
+			t.Errorf("got --from %d --to %d, want --from 1000 --to 500", from, to)
+		}
+	})
+
+	t.Run("open and well ordered ranges are accepted as is", func(t *testing.T) {
+		for _, r := range [][2]int64{{0, 0}, {0, 500}, {1000, 0}, {1000, 500}, {500, 500}} {
+			from, to, err := ValidateScanRange(r[0], r[1], false)
+			if err != nil || from != r[0] || to != r[1] {
+				t.Errorf("got --from %d --to %d (%v), want --from %d --to %d", from, to, err, r[0], r[1])
+			}
+		}
+	})
+
+	t.Run("negative blocks are rejected", func(t *testing.T) {
+		if _, _, err := ValidateScanRange(-1, 0, true); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestScanBounds(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, latest int64
+		first, last      int64
+	}{
+		{0, 0, 2000, 1, 2000},
+		{1000, 500, 2000, 500, 1000},
+		{3000, 500, 2000, 500, 2000},
+	} {
+		first, last := ScanBounds(tc.from, tc.to, tc.latest)
+		if first != tc.first || last != tc.last {
+			t.Errorf("ScanBounds(%d, %d, %d) = %d, %d, want %d, %d", tc.from, tc.to, tc.latest, first, last, tc.first, tc.last)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package cache
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var pendingBucket = []byte("pending")
+
+// Store persists the pending blocks of block caches across restarts in a
+// bbolt file, by the key of the scan they're pending for. A file can only
+// be opened by a single process at once
+type Store struct {
+	db *bolt.DB
+}
+
+// OpenStore opens the store at path, creating it if missing
+func OpenStore(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(pendingBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Load returns the blocks saved as pending under key in ascending order,
+// none when nothing is
+func (s *Store) Load(key string) ([]int64, error) {
+	var ranges []BlockRange
+	err := s.db.View(func(tx *bolt.Tx) error {
+		content := tx.Bucket(pendingBucket).Get([]byte(key))
+		if content == nil {
+			return nil
+		}
+		return json.Unmarshal(content, &ranges)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var blocks []int64
+	for _, r := range ranges {
+		for block := r.Start; block <= r.End; block++ {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, nil
+}
+
+// Save saves blocks as pending under key, as ranges. Saving no blocks
+// forgets the key
+func (s *Store) Save(key string, blocks []int64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pendingBucket)
+		if len(blocks) == 0 {
+			return bucket.Delete([]byte(key))
+		}
+		content, err := json.Marshal(CollapseRanges(blocks))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), content)
+	})
+}
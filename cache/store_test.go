@@ -0,0 +1,90 @@
+package cache
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	t.Run("saved blocks are loaded back in order", func(t *testing.T) {
+		if err := store.Save("4444/0/0", []int64{9, 3, 4, 5, 7}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Load("4444/0/0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int64{3, 4, 5, 7, 9}; !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("saving no blocks forgets the key", func(t *testing.T) {
+		if err := store.Save("4444/0/0", nil); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := store.Load("4444/0/0"); err != nil || got != nil {
+			t.Errorf("got %v (%v), want nothing", got, err)
+		}
+	})
+}
+
+func TestCacheRestore(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "cache.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if err := store.Save("4444/0/0", []int64{2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	loads := 0
+	blockCache := NewBlockCache(context.Background(), func(ctx context.Context) ([]int64, error) {
+		loads++
+		return []int64{1, 2, 3}, nil
+	})
+
+	t.Run("pending blocks are restored without loading", func(t *testing.T) {
+		restored, err := blockCache.Restore(store, "4444/0/0")
+		if err != nil || restored != 2 {
+			t.Fatalf("got %d restored (%v), want 2", restored, err)
+		}
+		if updated, _ := blockCache.UpdateMissingBlocks(context.Background()); updated || loads != 0 {
+			t.Errorf("got %d loads, want the restored blocks used", loads)
+		}
+		if got := blockCache.GetMissingBlocks(); !reflect.DeepEqual(got, []int64{2, 3}) {
+			t.Errorf("got missing blocks %v, want [2 3]", got)
+		}
+	})
+
+	t.Run("blocks pending are saved", func(t *testing.T) {
+		blockCache.CompleteBlock(2)
+		if err := blockCache.Save(); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := store.Load("4444/0/0"); !reflect.DeepEqual(got, []int64{3}) {
+			t.Errorf("got %v saved, want [3]", got)
+		}
+	})
+
+	t.Run("missing blocks are loaded once the restored ones are processed", func(t *testing.T) {
+		blockCache.CompleteBlock(3)
+		if blockCache.Drained() {
+			t.Fatal("got the cache drained before the missing blocks were loaded")
+		}
+		if updated, _ := blockCache.UpdateMissingBlocks(context.Background()); !updated || loads != 1 {
+			t.Fatalf("got %d loads, want the missing blocks loaded", loads)
+		}
+		if got := blockCache.GetBacklog(); got != 3 {
+			t.Errorf("got backlog %d, want the 3 blocks loaded", got)
+		}
+	})
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/dispatcher"
+	"github.com/denuoweb/ethereum-block-processor/errqueue"
+	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/denuoweb/ethereum-block-processor/processor"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// chain is a chain scanned by a pipeline of its own
+type chain struct {
+	id        int
+	providers []*jsonrpc.Provider
+	pool      *jsonrpc.Pool
+	// connection string of the database its hash pairs are stored in
+	dbString string
+	// chain ids of the other chains stored in the same database
+	sharedChainIds []int
+	// added to every line logged for the chain, none when it's the only one
+	logFields logrus.Fields
+}
+
+// parseChains parses the --chain definitions, as chainId=provider[,provider...],
+// and the --chain-db connection strings of their databases, as
+// chainId=connection string
+func parseChains(definitions, databases []string) ([]*chain, error) {
+	parseID := func(flag, definition string) (int, string, error) {
+		parts := strings.SplitN(definition, "=", 2)
+		if len(parts) != 2 {
+			return 0, "", fmt.Errorf("invalid --%s '%s', expected chainId=value", flag, definition)
+		}
+		id, err := strconv.Atoi(parts[0])
+		if err != nil || id < 1 {
+			return 0, "", fmt.Errorf("invalid chain id '%s' in --%s '%s'", parts[0], flag, definition)
+		}
+		return id, parts[1], nil
+	}
+
+	var chains []*chain
+	byID := make(map[int]*chain)
+	for _, definition := range definitions {
+		id, urls, err := parseID("chain", definition)
+		if err != nil {
+			return nil, err
+		}
+		if byID[id] != nil {
+			return nil, fmt.Errorf("chain %d is given more than once", id)
+		}
+		c := &chain{id: id, dbString: getConnectionString()}
+		for _, url := range strings.Split(urls, ",") {
+			provider, err := jsonrpc.ParseProvider(url)
+			if err != nil {
+				return nil, err
+			}
+			c.providers = append(c.providers, provider)
+		}
+		byID[id] = c
+		chains = append(chains, c)
+	}
+	for _, database := range databases {
+		id, dbString, err := parseID("chain-db", database)
+		if err != nil {
+			return nil, err
+		}
+		if byID[id] == nil {
+			return nil, fmt.Errorf("--chain-db given for chain %d, which isn't scanned", id)
+		}
+		byID[id].dbString = dbString
+	}
+	return chains, nil
+}
+
+// storeDriver returns the driver of the database of connection string dsn,
+// per --db-driver or its scheme, and the connection string the driver takes
+func storeDriver(dsn string) (string, string) {
+	driver, driverDSN := db.ParseDSN(dsn)
+	if *dbDriver != "" && *dbDriver != driver {
+		return *dbDriver, dsn
+	}
+	return driver, driverDSN
+}
+
+// newProcessor returns the processor scanning c per the flags, saving its
+// pending blocks to cacheStore when set
+func newProcessor(c *chain, cacheStore *cache.Store) (*processor.Processor, error) {
+	var logFilter eth.LogFilter
+	for _, address := range *filterAddresses {
+		if err := logFilter.AddAddress(address); err != nil {
+			return nil, err
+		}
+	}
+	for _, topic := range *filterTopics {
+		if err := logFilter.AddTopic(topic); err != nil {
+			return nil, err
+		}
+	}
+	var contractABI *abi.ABI
+	if *abiFile != "" {
+		file, err := os.Open(*abiFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		parsed, err := abi.JSON(file)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the abi %s: %w", *abiFile, err)
+		}
+		contractABI = &parsed
+	}
+	opts := []processor.Option{
+		processor.WithChain(c.id, c.providers),
+		processor.WithProviderPool(c.pool),
+		processor.WithWorkers(*numWorkers),
+		processor.WithAutoscale(*minWorkers, *maxWorkers, *autoscaleInterval),
+		processor.WithRange(*blockFrom, *blockTo),
+		processor.WithFollow(*follow),
+		processor.WithLogFields(c.logFields),
+		processor.WithErrorBuffer(*errorBuffer, errqueue.Policy(*errorOverflow)),
+		processor.WithLatencyObjective(*sloLatency, *sloPercentile),
+		processor.WithCheckpoint(*checkpointEvery, *resume),
+		processor.WithRetryFailed(*retryFailed),
+		processor.WithReorgDepth(*reorgDepth),
+		processor.WithLeaderLock(*leaderLockKey, *leaderLockInterval),
+		processor.WithBatchSize(*batchSize),
+		processor.WithRangeSize(*rangeSize),
+		processor.WithNewHeads(*newHeadsURL, *newHeadsInterval),
+		processor.WithMissingBlocksRetries(*loaderRetries, *loaderRetryBackoff),
+		processor.WithBacklogInterval(*backlogInterval),
+		processor.WithProgressInterval(*progressInterval),
+		processor.WithTipLag(*tipLagThreshold, *tipLagInterval),
+		processor.WithChainIdCheck(*chainIdInterval),
+		processor.WithChainIdGuard(*forceChainId, c.sharedChainIds),
+		processor.WithShutdownTimeout(*shutdownTimeout),
+		processor.WithStallTimeout(*stallTimeout),
+		processor.WithLogFilter(logFilter, *filterRange),
+		processor.WithDispatcherOptions(
+			dispatcher.WithStuckWorkerTimeout(*stuckWorkerTimeout),
+			dispatcher.WithDecodeWorkers(*decodeWorkers),
+			dispatcher.WithBlockNumberValidation(*validateBlockNumber),
+			dispatcher.WithSkippedBlockAttempts(*skippedBlockAttempts),
+			dispatcher.WithDeadLetters(*deadLetterAttempts, *maxFailures),
+			dispatcher.WithRefetchConcurrency(*refetchConcurrency),
+			dispatcher.WithReceipts(*withReceipts || *receipts),
+			dispatcher.WithOnlySuccessfulTransactions(*onlySuccessful),
+			dispatcher.WithStoreBlocks(*storeBlocks),
+			dispatcher.WithWeightedProviders(*providerBalance == "throughput"),
+		),
+	}
+	if cacheStore != nil {
+		opts = append(opts, processor.WithCacheStore(cacheStore))
+	}
+	if *metricsAddr != "" {
+		var registerer prometheus.Registerer = metrics.Registry
+		if c.logFields != nil {
+			registerer = prometheus.WrapRegistererWith(prometheus.Labels{"chain_id": strconv.Itoa(c.id)}, metrics.Registry)
+		}
+		opts = append(opts, processor.WithMetrics(registerer))
+	}
+	if *dryRun {
+		opts = append(opts, processor.WithBenchmark())
+	}
+	driver, dsn := storeDriver(c.dbString)
+	if *sinkKind != "postgres" {
+		opts = append(opts, processor.WithSink(newSink))
+	} else if driver != "postgres" {
+		store, err := db.OpenStore(context.Background(), driver, dsn)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, processor.WithStore(store))
+	} else {
+		opts = append(opts,
+			processor.WithDatabase(c.dbString),
+			processor.WithDBOptions(
+				db.WithInsertRetries(*dbRetries, *dbRetryBackoff),
+				db.WithBatch(*dbBatchSize, *dbFlushInterval),
+				db.WithSkipEmptyBlocks(*skipEmptyBlocks),
+				db.WithBlockStats(*blockStats),
+				db.WithPauseBuffer(*pauseBuffer),
+				db.WithTransactionInput(db.InputFormat(*txInputFormat), contractABI),
+			),
+		)
+	}
+	return processor.New(opts...)
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"gopkg.in/yaml.v2"
+)
+
+// Config mirrors the command line flags, it's read from a yaml or json
+// file. Zero values are left to the flags
+type Config struct {
+	DB        db.DbConfig `json:"db" yaml:"db"`
+	DBString  string      `json:"dbstring" yaml:"dbstring"`
+	ChainID   int         `json:"chainId" yaml:"chainId"`
+	Providers []string    `json:"providers" yaml:"providers"`
+	Workers   int         `json:"workers" yaml:"workers"`
+	From      int64       `json:"from" yaml:"from"`
+	To        int64       `json:"to" yaml:"to"`
+	SwapRange bool        `json:"swapRange" yaml:"swapRange"`
+	Debug     bool        `json:"debug" yaml:"debug"`
+	// chains scanned by pipelines of their own instead of ChainID and Providers
+	Chains []Chain `json:"chains" yaml:"chains"`
+	// any other flag by its long name, e.g. "rpc-attempts": "6"
+	Flags map[string]string `json:"flags" yaml:"flags"`
+}
+
+// Chain is a chain scanned by a pipeline of its own, stored to the database
+// of DBString when set
+type Chain struct {
+	ID        int      `json:"id" yaml:"id"`
+	Providers []string `json:"providers" yaml:"providers"`
+	DBString  string   `json:"dbstring" yaml:"dbstring"`
+}
+
+// Load reads the config file at path, as yaml when its extension is .yaml
+// or .yml and as json otherwise
+func Load(path string) (*Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, &config)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(content)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Args returns the config as command line flags
+func (c *Config) Args() []string {
+	var args []string
+	add := func(name, value string) {
+		args = append(args, "--"+name+"="+value)
+	}
+	for _, flag := range []struct{ name, value string }{
+		{"host", c.DB.Host},
+		{"port", c.DB.Port},
+		{"user", c.DB.User},
+		{"password", c.DB.Password},
+		{"dbname", c.DB.DBName},
+		{"dbstring", c.DBString},
+	} {
+		if flag.value != "" {
+			add(flag.name, flag.value)
+		}
+	}
+	for _, flag := range []struct {
+		name  string
+		value int64
+	}{
+		{"chain-id", int64(c.ChainID)},
+		{"workers", int64(c.Workers)},
+		{"from", c.From},
+		{"to", c.To},
+	} {
+		if flag.value != 0 {
+			add(flag.name, strconv.FormatInt(flag.value, 10))
+		}
+	}
+	for _, flag := range []struct {
+		name  string
+		value bool
+	}{
+		{"ssl", c.DB.SSL},
+		{"swap-range", c.SwapRange},
+		{"debug", c.Debug},
+	} {
+		if flag.value {
+			args = append(args, "--"+flag.name)
+		}
+	}
+	for _, provider := range c.Providers {
+		add("providers", provider)
+	}
+	for _, chain := range c.Chains {
+		add("chain", strconv.Itoa(chain.ID)+"="+strings.Join(chain.Providers, ","))
+		if chain.DBString != "" {
+			add("chain-db", strconv.Itoa(chain.ID)+"="+chain.DBString)
+		}
+	}
+	names := make([]string, 0, len(c.Flags))
+	for name := range c.Flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		add(name, c.Flags[name])
+	}
+	return args
+}
+
+// Merge returns args followed by the flags of fileArgs that args don't
+// set, so flags given on the command line override the config file. shorts
+// maps short flags to their long names
+func Merge(fileArgs, args []string, shorts map[byte]string) []string {
+	set := make(map[string]bool)
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			name := strings.SplitN(arg[2:], "=", 2)[0]
+			set[name] = true
+			set[strings.TrimPrefix(name, "no-")] = true
+		case len(arg) > 1 && arg[0] == '-':
+			set[shorts[arg[1]]] = true
+		}
+	}
+	merged := append([]string{}, args...)
+	for _, arg := range fileArgs {
+		name := strings.SplitN(strings.TrimPrefix(arg, "--"), "=", 2)[0]
+		if !set[name] {
+			merged = append(merged, arg)
+		}
+	}
+	return merged
+}
+
+// Path returns the config file given in args with --config, if any
+func Path(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, "--config=") {
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	return ""
+}
+
+// Validate rejects settings no run can work with before any work starts.
+// --from is the newest block scanned and --to the oldest, a reversed range
+// is only accepted with --swap-range
+func (c *Config) Validate() error {
+	if _, _, err := cache.ValidateScanRange(c.From, c.To, c.SwapRange); err != nil {
+		return err
+	}
+	if c.Workers < 1 {
+		return fmt.Errorf("invalid number of workers %d", c.Workers)
+	}
+	if c.DBString == "" && c.DB.DBName == "" {
+		return fmt.Errorf("empty database name")
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	want := &Config{
+		DB:        db.DbConfig{Host: "db.internal", DBName: "hashes"},
+		ChainID:   4444,
+		Providers: []string{"https://a.example", "b=https://b.example"},
+		Workers:   8,
+		Flags:     map[string]string{"rpc-attempts": "6"},
+	}
+
+	t.Run("yaml config is loaded", func(t *testing.T) {
+		path := writeFile(t, "config.yaml", `
+db:
+  host: db.internal
+  dbname: hashes
+chainId: 4444
+providers:
+  - https://a.example
+  - b=https://b.example
+workers: 8
+flags:
+  rpc-attempts: "6"
+`)
+		got, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("json config is loaded", func(t *testing.T) {
+		path := writeFile(t, "config.json", `{"db": {"host": "db.internal", "dbname": "hashes"}, "chainId": 4444, "providers": ["https://a.example", "b=https://b.example"], "workers": 8, "flags": {"rpc-attempts": "6"}}`)
+		got, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("unknown settings are rejected", func(t *testing.T) {
+		for name, content := range map[string]string{"config.yaml": "wrokers: 8\n", "config.json": `{"wrokers": 8}`} {
+			if _, err := Load(writeFile(t, name, content)); err == nil {
+				t.Errorf("expected an error loading %s", name)
+			}
+		}
+	})
+}
+
+func TestMerge(t *testing.T) {
+	config := &Config{
+		DB:        db.DbConfig{DBName: "hashes"},
+		Providers: []string{"https://a.example"},
+		Workers:   8,
+		Debug:     true,
+		Flags:     map[string]string{"rpc-attempts": "6"},
+	}
+	shorts := map[byte]string{'p': "providers", 'w': "workers"}
+
+	got := Merge(config.Args(), []string{"run", "-p", "https://cli.example", "--rpc-attempts=2", "--no-debug"}, shorts)
+	want := []string{"run", "-p", "https://cli.example", "--rpc-attempts=2", "--no-debug", "--dbname=hashes", "--workers=8"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestChainArgs(t *testing.T) {
+	config := &Config{Chains: []Chain{
+		{ID: 4444, Providers: []string{"https://a.example", "b=https://b.example"}},
+		{ID: 8888, Providers: []string{"https://c.example"}, DBString: "postgres://db.internal/chain8888"},
+	}}
+
+	got := config.Args()
+	want := []string{"--chain=4444=https://a.example,b=https://b.example", "--chain=8888=https://c.example", "--chain-db=8888=postgres://db.internal/chain8888"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestPath(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--config", "a.yaml", "run"}, "a.yaml"},
+		{[]string{"run", "--config=b.json"}, "b.json"},
+		{[]string{"run", "--", "--config=c.json"}, ""},
+		{[]string{"run"}, ""},
+	} {
+		if got := Path(tc.args); got != tc.want {
+			t.Errorf("Path(%v) = %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := Config{DB: db.DbConfig{DBName: "hashes"}, Providers: []string{"https://a.example"}, Workers: 1, From: 1000, To: 500}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("got %v for a valid config", err)
+	}
+
+	for name, modify := range map[string]func(c *Config){
+		"reversed range": func(c *Config) { c.From, c.To = 500, 1000 },
+		"no workers":     func(c *Config) { c.Workers = 0 },
+		"empty dbname":   func(c *Config) { c.DB.DBName = "" },
+	} {
+		config := valid
+		modify(&config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("expected an error for %s", name)
+		}
+	}
+
+	t.Run("reversed range is accepted with swap range", func(t *testing.T) {
+		config := valid
+		config.From, config.To, config.SwapRange = 500, 1000, true
+		if err := config.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("connection string stands in for the database name", func(t *testing.T) {
+		config := valid
+		config.DB.DBName, config.DBString = "", "postgres://localhost/hashes"
+		if err := config.Validate(); err != nil {
+			t.Error(err)
+		}
+	})
+}
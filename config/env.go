@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix prefixes the environment variables setting flags
+const EnvPrefix = "EBP_"
+
+// EnvFlag is a flag that can be set from the environment
+type EnvFlag struct {
+	Name string
+	// bool flags are set from true or false, cumulative ones from comma
+	// separated values, or one value per line with Lines for those whose
+	// values hold commas
+	Bool       bool
+	Cumulative bool
+	Lines      bool
+}
+
+// EnvName returns the environment variable setting flag, e.g. EBP_CHAIN_ID
+// for --chain-id
+func EnvName(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Env returns the flags set in environ, as given by os.Environ, as command
+// line flags. Merged under the command line flags and over the config file
+// flags, they override the file while flags given on the command line
+// override them
+func Env(environ []string, flags []EnvFlag) ([]string, error) {
+	values := make(map[string]string)
+	for _, variable := range environ {
+		if i := strings.Index(variable, "="); i > 0 && strings.HasPrefix(variable, EnvPrefix) {
+			values[variable[:i]] = variable[i+1:]
+		}
+	}
+	var args []string
+	for _, flag := range flags {
+		value, ok := values[EnvName(flag.Name)]
+		if !ok {
+			continue
+		}
+		switch {
+		case flag.Bool:
+			set, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", EnvName(flag.Name), err)
+			}
+			if set {
+				args = append(args, "--"+flag.Name)
+			} else {
+				args = append(args, "--no-"+flag.Name)
+			}
+		case flag.Cumulative:
+			separator := ","
+			if flag.Lines {
+				separator = "\n"
+			}
+			for _, item := range strings.Split(value, separator) {
+				if item = strings.TrimSpace(item); item != "" {
+					args = append(args, "--"+flag.Name+"="+item)
+				}
+			}
+		default:
+			args = append(args, "--"+flag.Name+"="+value)
+		}
+	}
+	return args, nil
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+)
+
+func TestEnv(t *testing.T) {
+	flags := []EnvFlag{
+		{Name: "chain-id"},
+		{Name: "password"},
+		{Name: "ssl", Bool: true},
+		{Name: "debug", Bool: true},
+		{Name: "providers", Cumulative: true},
+	}
+
+	t.Run("flags are set from their EBP_ variables", func(t *testing.T) {
+		environ := []string{
+			"EBP_CHAIN_ID=4444",
+			"EBP_PASSWORD=s3cr=t",
+			"EBP_SSL=true",
+			"EBP_DEBUG=0",
+			"EBP_PROVIDERS=a=https://a.example, b=https://b.example",
+			"EBP_UNKNOWN=1",
+			"PASSWORD=ignored",
+		}
+		got, err := Env(environ, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"--chain-id=4444", "--password=s3cr=t", "--ssl", "--no-debug", "--providers=a=https://a.example", "--providers=b=https://b.example"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("chains of several providers are set one per line", func(t *testing.T) {
+		environ := []string{"EBP_CHAIN=1=http://a,http://b\n 2=http://c\n"}
+		got, err := Env(environ, []EnvFlag{{Name: "chain", Cumulative: true, Lines: true}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"--chain=1=http://a,http://b", "--chain=2=http://c"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+
+	t.Run("invalid bool values are rejected", func(t *testing.T) {
+		if _, err := Env([]string{"EBP_SSL=maybe"}, flags); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("flags override the environment, which overrides the config file", func(t *testing.T) {
+		shorts := map[byte]string{'w': "workers"}
+		env, err := Env([]string{"EBP_CHAIN_ID=4444", "EBP_DEBUG=true"}, append(flags, EnvFlag{Name: "workers"}, EnvFlag{Name: "dbname"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := Merge(env, []string{"run", "--chain-id=81"}, shorts)
+		file := &Config{ChainID: 1, Workers: 8, Debug: false, DB: db.DbConfig{DBName: "hashes"}}
+		got := Merge(file.Args(), args, shorts)
+		want := []string{"run", "--chain-id=81", "--debug", "--dbname=hashes", "--workers=8"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/lib/pq"
+)
+
+// batchedPair is a result waiting for its batch to be flushed
+type batchedPair struct {
+	pair jsonrpc.HashPair
+	skip bool
+}
+
+// pendingHash returns the hash of block if it's waiting in the batch to be
+// stored
+func (q *HtmlcoinDB) pendingHash(block int64) (string, bool) {
+	for _, batched := range q.batch {
+		if int64(batched.pair.BlockNumber) == block && !batched.skip {
+			return batched.pair.HtmlcoinHash, true
+		}
+	}
+	return "", false
+}
+
+// dropPending removes block from the batch before it's stored
+func (q *HtmlcoinDB) dropPending(block int64) {
+	batch := q.batch[:0]
+	for _, batched := range q.batch {
+		if int64(batched.pair.BlockNumber) != block {
+			batch = append(batch, batched)
+		}
+	}
+	q.batch = batch
+}
+
+// copyInto copies rows into a temporary table shaped like table, dropped
+// when tx commits, and returns its name
+func copyInto(ctx context.Context, tx *sql.Tx, table string, columns []string, rows [][]interface{}) (string, error) {
+	temporary := table + "Batch"
+	_, err := tx.ExecContext(ctx, `CREATE TEMPORARY TABLE "`+temporary+`" (LIKE "`+table+`" INCLUDING DEFAULTS) ON COMMIT DROP`)
+	if err != nil {
+		return "", err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(temporary, columns...))
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+	for _, row := range rows {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			return "", err
+		}
+	}
+	// flushes the copied rows
+	_, err = stmt.ExecContext(ctx)
+	return temporary, err
+}
+
+// writeBatch stores batch in a single transaction, copying the hashes,
+// receipts, logs, block data and seen blocks with COPY and upserting them from there as rows written one
+// at a time are. When saveCheckpoint is set the checkpoint the batch moves
+// to is saved along with it
+func (q *HtmlcoinDB) writeBatch(ctx context.Context, chainID int, batch []batchedPair, saveCheckpoint bool) error {
+	defer observeWrite("batch", time.Now())
+	var hashes, seen, receipts, logs, headers, transactions [][]interface{}
+	blocks := make([]int64, len(batch))
+	now := time.Now()
+	for i, batched := range batch {
+		pair := batched.pair
+		blocks[i] = int64(pair.BlockNumber)
+		if batched.skip {
+			seen = append(seen, []interface{}{pair.BlockNumber, chainID, pair.Skipped})
+			continue
+		}
+		var size *int64
+		var gasUsedRatio *float64
+		if q.blockStats {
+			size, gasUsedRatio = pair.Size, pair.GasUsedRatio
+		}
+		hashes = append(hashes, []interface{}{pair.BlockNumber, chainID, pair.EthHash, pair.HtmlcoinHash, now, size, gasUsedRatio})
+		for _, receipt := range pair.Receipts {
+			row, err := receiptRow(chainID, pair.BlockNumber, receipt)
+			if err != nil {
+				return err
+			}
+			receipts = append(receipts, row)
+			rows, err := logRows(chainID, pair.BlockNumber, receipt)
+			if err != nil {
+				return err
+			}
+			logs = append(logs, rows...)
+		}
+		if pair.Block != nil {
+			headers = append(headers, blockRow(chainID, pair))
+			for _, transaction := range pair.Block.Transactions {
+				transactions = append(transactions, q.transactionRow(chainID, pair.BlockNumber, transaction))
+			}
+		}
+	}
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = func() error {
+		if len(hashes) > 0 {
+			temporary, err := copyInto(ctx, tx, "Hashes", []string{"BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio"}, hashes)
+			if err != nil {
+				return err
+			}
+			// a block refetched within the batch is only upserted once
+			_, err = tx.ExecContext(ctx, `INSERT INTO "Hashes"("BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio")
+			SELECT DISTINCT ON ("Eth", "ChainId") "BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio" FROM "`+temporary+`"
+			ON CONFLICT ON CONSTRAINT "Hashes_pkey" DO UPDATE SET "Htmlcoin" = EXCLUDED."Htmlcoin", "IngestedAt" = EXCLUDED."IngestedAt", "Size" = COALESCE(EXCLUDED."Size", "Hashes"."Size"), "GasUsedRatio" = COALESCE(EXCLUDED."GasUsedRatio", "Hashes"."GasUsedRatio")`)
+			if err != nil {
+				return err
+			}
+		}
+		if len(seen) > 0 {
+			temporary, err := copyInto(ctx, tx, "SeenBlocks", []string{"BlockNum", "ChainId", "Skipped"}, seen)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO "SeenBlocks"("BlockNum", "ChainId", "Skipped") SELECT "BlockNum", "ChainId", "Skipped" FROM "`+temporary+`" ON CONFLICT DO NOTHING`)
+			if err != nil {
+				return err
+			}
+		}
+		if len(receipts) > 0 {
+			temporary, err := copyInto(ctx, tx, "Receipts", []string{"TxHash", "BlockNum", "ChainId", "TransactionIndex", "GasUsed", "Status", "ContractAddress", "Logs"}, receipts)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO "Receipts"("TxHash", "BlockNum", "ChainId", "TransactionIndex", "GasUsed", "Status", "ContractAddress", "Logs")
+			SELECT DISTINCT ON ("TxHash", "BlockNum", "ChainId") "TxHash", "BlockNum", "ChainId", "TransactionIndex", "GasUsed", "Status", "ContractAddress", "Logs" FROM "`+temporary+`"
+			ON CONFLICT ON CONSTRAINT "Receipts_pkey" DO UPDATE SET "TransactionIndex" = EXCLUDED."TransactionIndex", "GasUsed" = EXCLUDED."GasUsed", "Status" = EXCLUDED."Status", "ContractAddress" = EXCLUDED."ContractAddress", "Logs" = EXCLUDED."Logs"`)
+			if err != nil {
+				return err
+			}
+		}
+		if len(logs) > 0 {
+			temporary, err := copyInto(ctx, tx, "Logs", []string{"TxHash", "LogIndex", "BlockNum", "ChainId", "Address", "Topics", "Data"}, logs)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO "Logs"("TxHash", "LogIndex", "BlockNum", "ChainId", "Address", "Topics", "Data")
+			SELECT DISTINCT ON ("TxHash", "LogIndex", "BlockNum", "ChainId") "TxHash", "LogIndex", "BlockNum", "ChainId", "Address", "Topics", "Data" FROM "`+temporary+`"
+			ON CONFLICT ON CONSTRAINT "Logs_pkey" DO UPDATE SET "Address" = EXCLUDED."Address", "Topics" = EXCLUDED."Topics", "Data" = EXCLUDED."Data"`)
+			if err != nil {
+				return err
+			}
+		}
+		if len(headers) > 0 {
+			temporary, err := copyInto(ctx, tx, "Blocks", []string{"BlockNum", "ChainId", "Timestamp", "Miner", "GasUsed", "ParentHash"}, headers)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO "Blocks"("BlockNum", "ChainId", "Timestamp", "Miner", "GasUsed", "ParentHash")
+			SELECT DISTINCT ON ("BlockNum", "ChainId") "BlockNum", "ChainId", "Timestamp", "Miner", "GasUsed", "ParentHash" FROM "`+temporary+`"
+			ON CONFLICT ON CONSTRAINT "Blocks_pkey" DO UPDATE SET "Timestamp" = EXCLUDED."Timestamp", "Miner" = EXCLUDED."Miner", "GasUsed" = EXCLUDED."GasUsed", "ParentHash" = EXCLUDED."ParentHash"`)
+			if err != nil {
+				return err
+			}
+		}
+		if len(transactions) > 0 {
+			temporary, err := copyInto(ctx, tx, "Transactions", []string{"TxHash", "BlockNum", "ChainId", "TransactionIndex", "From", "To", "Value", "Gas", "Input", "InputBytes", "MethodId", "MethodName", "MethodArgs"}, transactions)
+			if err != nil {
+				return err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO "Transactions"("TxHash", "BlockNum", "ChainId", "TransactionIndex", "From", "To", "Value", "Gas", "Input", "InputBytes", "MethodId", "MethodName", "MethodArgs")
+			SELECT DISTINCT ON ("TxHash", "BlockNum", "ChainId") "TxHash", "BlockNum", "ChainId", "TransactionIndex", "From", "To", "Value", "Gas", "Input", "InputBytes", "MethodId", "MethodName", "MethodArgs" FROM "`+temporary+`"
+			ON CONFLICT ON CONSTRAINT "Transactions_pkey" DO UPDATE SET "TransactionIndex" = EXCLUDED."TransactionIndex", "From" = EXCLUDED."From", "To" = EXCLUDED."To", "Value" = EXCLUDED."Value", "Gas" = EXCLUDED."Gas", "Input" = EXCLUDED."Input", "InputBytes" = EXCLUDED."InputBytes", "MethodId" = EXCLUDED."MethodId", "MethodName" = EXCLUDED."MethodName", "MethodArgs" = EXCLUDED."MethodArgs"`)
+			if err != nil {
+				return err
+			}
+		}
+		if saveCheckpoint {
+			contiguous, highWater := q.checkpoint.After(blocks...)
+			return q.saveCheckpointOn(ctx, tx, chainID, q.checkpoint.FirstBlock(), contiguous, highWater)
+		}
+		return nil
+	}()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// flushBatch stores the batched results, retrying the whole batch on
+// retryable errors, and records them as committed
+func (q *HtmlcoinDB) flushBatch(ctx context.Context, chainID int) error {
+	if len(q.batch) == 0 {
+		return nil
+	}
+	batch := q.batch
+	saveCheckpoint := q.checkpoint != nil && q.uncheckpointedCommits+len(batch) >= q.checkpointEvery
+	err := q.withRetries(ctx, func() error {
+		return q.writeBatch(ctx, chainID, batch, saveCheckpoint)
+	})
+	if err != nil {
+		return err
+	}
+	q.batch = nil
+	for _, batched := range batch {
+		q.committed(batched.pair, batched.skip)
+	}
+	if saveCheckpoint {
+		q.uncheckpointedCommits = 0
+	}
+	q.logger.WithField("blocks", len(batch)).Debug("Flushed batch")
+	return nil
+}
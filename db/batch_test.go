@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestBatchWrites(t *testing.T) {
+	expectCopy := func(mock sqlmock.Sqlmock, table string, rows ...[]interface{}) {
+		mock.ExpectExec(`CREATE TEMPORARY TABLE "` + table + `Batch"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		copyIn := mock.ExpectPrepare(`COPY "` + table + `Batch"`)
+		for _, row := range rows {
+			args := make([]driver.Value, len(row))
+			for i := range row {
+				args[i] = row[i]
+			}
+			copyIn.ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		copyIn.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	newBatchDB := func(t *testing.T, size int, flushInterval time.Duration) (*HtmlcoinDB, sqlmock.Sqlmock, chan error) {
+		q, mock := newMockDB(t)
+		WithBatch(size, flushInterval)(q)
+		WithSkipEmptyBlocks(true)(q)
+		q.resultChan = make(chan jsonrpc.HashPair)
+		q.shutdownChan = make(chan struct{})
+		q.errChan = make(chan error, 1)
+		return q, mock, make(chan error)
+	}
+
+	t.Run("full batches are copied in a single transaction", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 3, time.Hour)
+		mock.ExpectBegin()
+		expectCopy(mock, "Hashes",
+			[]interface{}{1, 4444, "0xeth1", "0xhtmlcoin1", recentTime{}, nil, nil},
+			[]interface{}{3, 4444, "0xeth3", "0xhtmlcoin3", recentTime{}, nil, nil},
+		)
+		mock.ExpectExec(`INSERT INTO "Hashes"(.+) SELECT DISTINCT ON`).WillReturnResult(sqlmock.NewResult(0, 2))
+		expectCopy(mock, "SeenBlocks", []interface{}{2, 4444, false})
+		mock.ExpectExec(`INSERT INTO "SeenBlocks"(.+) SELECT`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth1", HtmlcoinHash: "0xhtmlcoin1"}
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 2, EthHash: "0xeth2", HtmlcoinHash: "0xhtmlcoin2", Empty: true}
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 3, EthHash: "0xeth3", HtmlcoinHash: "0xhtmlcoin3"}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if q.GetRecords() != 2 || q.GetHighestBlock() != 3 {
+			t.Errorf("got %d records up to block %d, want 2 up to block 3", q.GetRecords(), q.GetHighestBlock())
+		}
+	})
+
+	t.Run("logs are copied along with their receipt", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 100, time.Hour)
+		mock.ExpectBegin()
+		expectCopy(mock, "Hashes", []interface{}{6, 4444, "0xeth6", "0xhtmlcoin6", recentTime{}, nil, nil})
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCopy(mock, "Receipts", []interface{}{"0xtx1", 6, 4444, int64(0), int64(21000), int64(1), nil, `[{"address":"0xab","topics":["0xt1"],"data":"0x"}]`})
+		mock.ExpectExec(`INSERT INTO "Receipts"(.+) SELECT DISTINCT ON`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCopy(mock, "Logs", []interface{}{"0xtx1", int64(0), 6, 4444, "0xab", `{"0xt1"}`, "0x"})
+		mock.ExpectExec(`INSERT INTO "Logs"(.+) SELECT DISTINCT ON`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 6, EthHash: "0xeth6", HtmlcoinHash: "0xhtmlcoin6", Receipts: []jsonrpc.GetTransactionReceiptResponse{
+			{TransactionHash: "0xtx1", TransactionIndex: "0x0", GasUsed: "0x5208", Status: "0x1", Logs: []json.RawMessage{json.RawMessage(`{"address":"0xab","topics":["0xt1"],"data":"0x"}`)}},
+		}}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("block data is copied along with its block", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 100, time.Hour)
+		mock.ExpectBegin()
+		expectCopy(mock, "Hashes", []interface{}{5, 4444, "0xeth5", "0xhtmlcoin5", recentTime{}, nil, nil})
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCopy(mock, "Blocks", []interface{}{5, 4444, int64(1624723908), "0xminer", int64(21000), "0xhtmlcoin4"})
+		mock.ExpectExec(`INSERT INTO "Blocks"(.+) SELECT DISTINCT ON`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCopy(mock, "Transactions", []interface{}{"0xtx1", 5, 4444, int64(0), "0xfrom", "0xto", "1", int64(21000), nil, nil, nil, nil, nil})
+		mock.ExpectExec(`INSERT INTO "Transactions"(.+) SELECT DISTINCT ON`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 5, EthHash: "0xeth5", HtmlcoinHash: "0xhtmlcoin5", Block: &jsonrpc.BlockData{
+			Timestamp:    1624723908,
+			Miner:        "0xminer",
+			GasUsed:      21000,
+			ParentHash:   "0xhtmlcoin4",
+			Transactions: []jsonrpc.TransactionData{{Hash: "0xtx1", From: "0xfrom", To: "0xto", Value: "1", Gas: 21000}},
+		}}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("partial batch is flushed on close", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 100, time.Hour)
+		mock.ExpectBegin()
+		expectCopy(mock, "Hashes", []interface{}{7, 4444, "0xeth7", "0xhtmlcoin7", recentTime{}, nil, nil})
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 7, EthHash: "0xeth7", HtmlcoinHash: "0xhtmlcoin7"}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("partial batch is flushed after the flush interval", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 100, 10*time.Millisecond)
+		mock.ExpectBegin()
+		expectCopy(mock, "Hashes", []interface{}{8, 4444, "0xeth8", "0xhtmlcoin8", recentTime{}, nil, nil})
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 8, EthHash: "0xeth8", HtmlcoinHash: "0xhtmlcoin8"}
+		deadline := time.Now().Add(time.Second)
+		for q.GetHighestBlock() != 8 && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if q.GetHighestBlock() != 8 {
+			t.Error("batch wasn't flushed after the flush interval")
+		}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("failed batch is rolled back", func(t *testing.T) {
+		q, mock, dbCloseChan := newBatchDB(t, 1000, time.Hour)
+		failure := errors.New("copy failed")
+		mock.ExpectBegin()
+		mock.ExpectExec(`CREATE TEMPORARY TABLE "HashesBatch"`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectPrepare(`COPY "HashesBatch"`).ExpectExec().WillReturnError(failure)
+		mock.ExpectRollback()
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 9, EthHash: "0xeth9", HtmlcoinHash: "0xhtmlcoin9"}
+		close(q.resultChan)
+		if err := <-dbCloseChan; !errors.Is(err, failure) {
+			t.Errorf("got %v, want %v", err, failure)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if q.GetRecords() != 0 {
+			t.Errorf("got %d records, want none", q.GetRecords())
+		}
+	})
+}
@@ -3,25 +3,31 @@ package db
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
-	"github.com/pkg/errors"
+	"github.com/denuoweb/ethereum-block-processor/cache"
 	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/denuoweb/ethereum-block-processor/slo"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
 	"github.com/schollz/progressbar/v3"
 	"github.com/sirupsen/logrus"
 )
 
 type DbConfig struct {
-	Host     string
-	Port     string
-	User     string
-	Password string
-	DBName   string
-	SSL      bool
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	User     string `json:"user" yaml:"user"`
+	Password string `json:"password" yaml:"password"`
+	DBName   string `json:"dbname" yaml:"dbname"`
+	SSL      bool   `json:"ssl" yaml:"ssl"`
 }
 
 func (config DbConfig) String() string {
@@ -41,9 +47,125 @@ type HtmlcoinDB struct {
 	errChan      chan error
 	running      bool
 	mutex        sync.RWMutex
+	// retries of inserts failing with a serialization failure or deadlock
+	insertRetries int
+	insertBackoff time.Duration
+	// highest block committed, accessed atomically
+	highestBlock int64
+	// record empty blocks as seen instead of storing their hashes
+	skipEmptyBlocks bool
+	latencyTracker  *slo.Tracker
+	// store the size and gasUsed ratio of blocks
+	blockStats bool
+	// checkpoint advanced on every commit and persisted every checkpointEvery commits
+	checkpoint            *cache.Checkpoint
+	checkpointEvery       int
+	uncheckpointedCommits int
+	// writes are paused while set, accessed atomically
+	paused int32
+	// results buffered while paused before the writer stops reading them
+	pauseBuffer int
+	resumeChan  chan struct{}
+	// blocks whose stored hash isn't the parent hash of the next block are
+	// sent to refetchChan, rewinding up to reorgDepth blocks
+	reorgDepth  int64
+	refetchChan chan<- int64
+	rewinds     map[int64]int64
+	// hashes of the last blocks committed, parents among them are checked
+	// without querying the database
+	recentHashes  map[int64]string
+	highestRecent int64
+	// results are written batchSize at a time with COPY, partial batches
+	// after flushInterval
+	batchSize     int
+	flushInterval time.Duration
+	batch         []batchedPair
+	// how transaction input is stored, and the abi naming the methods called
+	inputFormat InputFormat
+	inputABI    *abi.ABI
+}
+
+type Option func(q *HtmlcoinDB)
+
+// WithInsertRetries retries inserts that fail with a serialization failure
+// or a deadlock up to retries times, doubling backoff between attempts
+func WithInsertRetries(retries int, backoff time.Duration) Option {
+	return func(q *HtmlcoinDB) {
+		q.insertRetries = retries
+		q.insertBackoff = backoff
+	}
+}
+
+// WithSkipEmptyBlocks doesn't store the hashes of blocks without
+// transactions, only recording them as seen so they aren't missing
+func WithSkipEmptyBlocks(skip bool) Option {
+	return func(q *HtmlcoinDB) {
+		q.skipEmptyBlocks = skip
+	}
+}
+
+// WithBlockStats stores the size and gasUsed/gasLimit ratio of blocks along
+// with their hashes
+func WithBlockStats(store bool) Option {
+	return func(q *HtmlcoinDB) {
+		q.blockStats = store
+	}
+}
+
+// WithLatencyTracker records the commit of every block on tracker
+func WithLatencyTracker(tracker *slo.Tracker) Option {
+	return func(q *HtmlcoinDB) {
+		q.latencyTracker = tracker
+	}
 }
 
-func NewHtmlcoinDB(ctx context.Context, connectionString string, resultChan chan jsonrpc.HashPair, errChan chan error) (*HtmlcoinDB, error) {
+// WithCheckpoint advances checkpoint on every commit, persisting it to the
+// checkpoint row every `every` commits and once more when the writer stops
+func WithCheckpoint(checkpoint *cache.Checkpoint, every int) Option {
+	return func(q *HtmlcoinDB) {
+		q.checkpoint = checkpoint
+		q.checkpointEvery = every
+	}
+}
+
+// WithReorgDetection checks the parent hash of every block against the
+// stored hash of the block before it, refetching stale blocks through
+// refetch up to depth blocks back from where a reorg is detected
+func WithReorgDetection(depth int64, refetch chan<- int64) Option {
+	return func(q *HtmlcoinDB) {
+		q.reorgDepth = depth
+		q.refetchChan = refetch
+		q.rewinds = make(map[int64]int64)
+		q.recentHashes = make(map[int64]string)
+	}
+}
+
+// WithBatch writes results in batches of up to size with COPY, flushing
+// partial batches flushInterval after their first result. A size of 1 or
+// less writes results one at a time
+func WithBatch(size int, flushInterval time.Duration) Option {
+	return func(q *HtmlcoinDB) {
+		q.batchSize = size
+		q.flushInterval = flushInterval
+	}
+}
+
+// WithPauseBuffer buffers up to size results while writes are paused, once
+// full no more results are read until writes resume
+func WithPauseBuffer(size int) Option {
+	return func(q *HtmlcoinDB) {
+		q.pauseBuffer = size
+	}
+}
+
+// WithLogFields adds fields to every line logged, e.g. the chain written for
+func WithLogFields(fields logrus.Fields) Option {
+	return func(q *HtmlcoinDB) {
+		q.logger = q.logger.WithFields(fields)
+	}
+}
+
+func NewHtmlcoinDB(ctx context.Context, connectionString string, resultChan chan jsonrpc.HashPair, errChan chan error, opts ...Option) (*HtmlcoinDB, error) {
 	dbLogger, _ := log.GetLogger()
 	logger := dbLogger.WithField("module", "db")
 	db, err := sql.Open("postgres", connectionString)
@@ -56,32 +178,287 @@ func NewHtmlcoinDB(ctx context.Context, connectionString string, resultChan chan
 	}
 
 	logger.Debug("Database Connected!")
-	createHashes := `CREATE TABLE IF NOT EXISTS "Hashes" ("BlockNum" int, "ChainId" int, "Eth" text, "Htmlcoin" text NOT NULL, PRIMARY KEY("Eth", "ChainId"))`
-	_, err = db.ExecContext(ctx, createHashes)
+	// the tables of every feature are created, so that those reading them
+	// don't depend on which features earlier runs stored
+	schema, _ := Schema("postgres", AllFeatures)
+	for _, table := range schema {
+		_, err = db.ExecContext(ctx, table.Create)
+
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Failed to create '%s' table", table.Name)
+		}
+		for _, index := range table.Indexes {
+			if _, err = db.ExecContext(ctx, index); err != nil {
+				return nil, errors.WithMessagef(err, "Failed to index '%s' table", table.Name)
+			}
+		}
+	}
+
+	// tables created by earlier versions lack the columns added since
+	for _, column := range []string{`"IngestedAt" timestamptz`, `"Size" int8`, `"GasUsedRatio" double precision`} {
+		_, err = db.ExecContext(ctx, `ALTER TABLE "Hashes" ADD COLUMN IF NOT EXISTS `+column)
+
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Failed to add %s column to 'Hashes' table", column)
+		}
+	}
+	addSkipped := `ALTER TABLE "SeenBlocks" ADD COLUMN IF NOT EXISTS "Skipped" boolean NOT NULL DEFAULT false`
+	_, err = db.ExecContext(ctx, addSkipped)
+
+	if err != nil {
+		return nil, errors.WithMessage(err, "Failed to add 'Skipped' column to 'SeenBlocks' table")
+	}
+	for _, column := range []string{`"Input" text`, `"InputBytes" bytea`, `"MethodId" text`, `"MethodName" text`, `"MethodArgs" jsonb`} {
+		_, err = db.ExecContext(ctx, `ALTER TABLE "Transactions" ADD COLUMN IF NOT EXISTS `+column)
+
+		if err != nil {
+			return nil, errors.WithMessagef(err, "Failed to add %s column to 'Transactions' table", column)
+		}
+	}
+	// checkpoints saved without their first block are never resumed from
+	_, err = db.ExecContext(ctx, `ALTER TABLE "Checkpoints" ADD COLUMN IF NOT EXISTS "FirstBlock" int8`)
 
 	if err != nil {
-		return nil, errors.WithMessage(err, "Failed to create 'Hashes' table")
+		return nil, errors.WithMessage(err, "Failed to add 'FirstBlock' column to 'Checkpoints' table")
+	}
+
+	q := &HtmlcoinDB{db: db, logger: logger, resultChan: resultChan, shutdownChan: make(chan struct{}), errChan: errChan, resumeChan: make(chan struct{}, 1)}
+	for _, opt := range opts {
+		opt(q)
 	}
 
-	return &HtmlcoinDB{db: db, logger: logger, resultChan: resultChan, shutdownChan: make(chan struct{}), errChan: errChan}, nil
+	return q, nil
 }
 
-func (q *HtmlcoinDB) insert(ctx context.Context, blockNum, chainID int, eth, htmlcoin string) (sql.Result, error) {
+// isRetryable reports whether err is a serialization failure or a deadlock,
+// which postgres resolves by aborting one of the conflicting transactions
+func isRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == "40001" || pqErr.Code == "40P01"
+}
+
+// withRetries runs fn, running it again after a backoff when it fails with
+// a retryable error, up to the configured number of insert retries
+func (q *HtmlcoinDB) withRetries(ctx context.Context, fn func() error) error {
+	backoff := q.insertBackoff
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isRetryable(err) || attempt >= q.insertRetries {
+			return err
+		}
+		q.logger.WithFields(logrus.Fields{
+			"attempt": attempt + 1,
+			"backoff": backoff,
+		}).Warn("Retrying after retryable database error: ", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// execer runs statements on the database, or within a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (q *HtmlcoinDB) insert(ctx context.Context, chainID int, pair jsonrpc.HashPair) (sql.Result, error) {
+	return q.insertOn(ctx, q.db, chainID, pair)
+}
+
+func (q *HtmlcoinDB) insertOn(ctx context.Context, exec execer, chainID int, pair jsonrpc.HashPair) (sql.Result, error) {
 	if chainID == 0 {
 		panic(chainID)
 	}
-	insertDynStmt := `INSERT INTO "Hashes"("BlockNum", "ChainId", "Eth", "Htmlcoin") VALUES($1, $2, $3, $4) ON CONFLICT ON CONSTRAINT "Hashes_pkey" DO UPDATE SET "Htmlcoin" = $3`
-	return q.db.ExecContext(ctx, insertDynStmt, blockNum, chainID, eth, htmlcoin)
+	var size *int64
+	var gasUsedRatio *float64
+	if q.blockStats {
+		size, gasUsedRatio = pair.Size, pair.GasUsedRatio
+	}
+	// stats already stored are kept when not stored again
+	insertDynStmt := `INSERT INTO "Hashes"("BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio") VALUES($1, $2, $3, $4, $5, $6, $7) ON CONFLICT ON CONSTRAINT "Hashes_pkey" DO UPDATE SET "Htmlcoin" = $4, "IngestedAt" = $5, "Size" = COALESCE($6, "Hashes"."Size"), "GasUsedRatio" = COALESCE($7, "Hashes"."GasUsedRatio")`
+	return exec.ExecContext(ctx, insertDynStmt, pair.BlockNumber, chainID, pair.EthHash, pair.HtmlcoinHash, time.Now(), size, gasUsedRatio)
+}
+
+// markSeen records a block as processed without storing its hashes,
+// skipped blocks are numbers that don't exist on the chain
+func (q *HtmlcoinDB) markSeen(ctx context.Context, blockNum, chainID int, skipped bool) (sql.Result, error) {
+	return q.markSeenOn(ctx, q.db, blockNum, chainID, skipped)
+}
+
+func (q *HtmlcoinDB) markSeenOn(ctx context.Context, exec execer, blockNum, chainID int, skipped bool) (sql.Result, error) {
+	insertDynStmt := `INSERT INTO "SeenBlocks"("BlockNum", "ChainId", "Skipped") VALUES($1, $2, $3) ON CONFLICT DO NOTHING`
+	return exec.ExecContext(ctx, insertDynStmt, blockNum, chainID, skipped)
+}
+
+// receiptRow returns the columns of the "Receipts" row storing receipt
+func receiptRow(chainID, blockNum int, receipt jsonrpc.GetTransactionReceiptResponse) ([]interface{}, error) {
+	index, err := jsonrpc.ParseQuantity(receipt.TransactionIndex, jsonrpc.NumberAuto)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid transaction index of %s", receipt.TransactionHash)
+	}
+	gasUsed, err := jsonrpc.ParseQuantity(receipt.GasUsed, jsonrpc.NumberAuto)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid gas used of %s", receipt.TransactionHash)
+	}
+	var status, contractAddress interface{}
+	if receipt.Status != "" {
+		if status, err = jsonrpc.ParseQuantity(receipt.Status, jsonrpc.NumberAuto); err != nil {
+			return nil, errors.Wrapf(err, "invalid status of %s", receipt.TransactionHash)
+		}
+	}
+	if receipt.ContractAddress != "" {
+		contractAddress = receipt.ContractAddress
+	}
+	logs := receipt.Logs
+	if logs == nil {
+		logs = []json.RawMessage{}
+	}
+	logsJSON, err := json.Marshal(logs)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{receipt.TransactionHash, blockNum, chainID, index, gasUsed, status, contractAddress, string(logsJSON)}, nil
+}
+
+// logRows returns the columns of the "Logs" rows storing the logs of
+// receipt. Logs without an index are indexed by their position
+func logRows(chainID, blockNum int, receipt jsonrpc.GetTransactionReceiptResponse) ([][]interface{}, error) {
+	rows := make([][]interface{}, len(receipt.Logs))
+	for i, content := range receipt.Logs {
+		var entry jsonrpc.Log
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, errors.Wrapf(err, "invalid log %d of %s", i, receipt.TransactionHash)
+		}
+		index := int64(i)
+		if entry.LogIndex != "" {
+			var err error
+			if index, err = jsonrpc.ParseQuantity(entry.LogIndex, jsonrpc.NumberAuto); err != nil {
+				return nil, errors.Wrapf(err, "invalid index of log %d of %s", i, receipt.TransactionHash)
+			}
+		}
+		topics := entry.Topics
+		if topics == nil {
+			topics = []string{}
+		}
+		rows[i] = []interface{}{receipt.TransactionHash, index, blockNum, chainID, entry.Address, pq.Array(topics), entry.Data}
+	}
+	return rows, nil
+}
+
+func (q *HtmlcoinDB) insertReceiptOn(ctx context.Context, exec execer, chainID, blockNum int, receipt jsonrpc.GetTransactionReceiptResponse) error {
+	row, err := receiptRow(chainID, blockNum, receipt)
+	if err != nil {
+		return err
+	}
+	insertDynStmt := `INSERT INTO "Receipts"("TxHash", "BlockNum", "ChainId", "TransactionIndex", "GasUsed", "Status", "ContractAddress", "Logs") VALUES($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT ON CONSTRAINT "Receipts_pkey" DO UPDATE SET "TransactionIndex" = $4, "GasUsed" = $5, "Status" = $6, "ContractAddress" = $7, "Logs" = $8`
+	if _, err = exec.ExecContext(ctx, insertDynStmt, row...); err != nil {
+		return err
+	}
+	logs, err := logRows(chainID, blockNum, receipt)
+	if err != nil {
+		return err
+	}
+	insertDynStmt = `INSERT INTO "Logs"("TxHash", "LogIndex", "BlockNum", "ChainId", "Address", "Topics", "Data") VALUES($1, $2, $3, $4, $5, $6, $7) ON CONFLICT ON CONSTRAINT "Logs_pkey" DO UPDATE SET "Address" = $5, "Topics" = $6, "Data" = $7`
+	for _, entry := range logs {
+		if _, err = exec.ExecContext(ctx, insertDynStmt, entry...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockRow returns the columns of the "Blocks" row storing the header of pair
+func blockRow(chainID int, pair jsonrpc.HashPair) []interface{} {
+	block := pair.Block
+	return []interface{}{pair.BlockNumber, chainID, block.Timestamp, block.Miner, block.GasUsed, block.ParentHash}
+}
+
+// transactionRow returns the columns of the "Transactions" row storing
+// transaction, contract creations have no recipient
+func (q *HtmlcoinDB) transactionRow(chainID, blockNum int, transaction jsonrpc.TransactionData) []interface{} {
+	var to interface{}
+	if transaction.To != "" {
+		to = transaction.To
+	}
+	return append([]interface{}{transaction.Hash, blockNum, chainID, transaction.Index, transaction.From, to, transaction.Value, transaction.Gas}, q.inputColumns(transaction)...)
+}
+
+func (q *HtmlcoinDB) insertBlockOn(ctx context.Context, exec execer, chainID int, pair jsonrpc.HashPair) error {
+	insertDynStmt := `INSERT INTO "Blocks"("BlockNum", "ChainId", "Timestamp", "Miner", "GasUsed", "ParentHash") VALUES($1, $2, $3, $4, $5, $6) ON CONFLICT ON CONSTRAINT "Blocks_pkey" DO UPDATE SET "Timestamp" = $3, "Miner" = $4, "GasUsed" = $5, "ParentHash" = $6`
+	if _, err := exec.ExecContext(ctx, insertDynStmt, blockRow(chainID, pair)...); err != nil {
+		return err
+	}
+	insertDynStmt = `INSERT INTO "Transactions"("TxHash", "BlockNum", "ChainId", "TransactionIndex", "From", "To", "Value", "Gas", "Input", "InputBytes", "MethodId", "MethodName", "MethodArgs") VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT ON CONSTRAINT "Transactions_pkey" DO UPDATE SET "TransactionIndex" = $4, "From" = $5, "To" = $6, "Value" = $7, "Gas" = $8, "Input" = $9, "InputBytes" = $10, "MethodId" = $11, "MethodName" = $12, "MethodArgs" = $13`
+	for _, transaction := range pair.Block.Transactions {
+		if _, err := exec.ExecContext(ctx, insertDynStmt, q.transactionRow(chainID, pair.BlockNumber, transaction)...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write stores pair along with its receipts and block data, or only records
+// it as seen when skip is set
+func (q *HtmlcoinDB) write(ctx context.Context, exec execer, chainID int, pair jsonrpc.HashPair, skip bool) error {
+	if skip {
+		_, err := q.markSeenOn(ctx, exec, pair.BlockNumber, chainID, pair.Skipped)
+		return err
+	}
+	if _, err := q.insertOn(ctx, exec, chainID, pair); err != nil {
+		return err
+	}
+	for _, receipt := range pair.Receipts {
+		if err := q.insertReceiptOn(ctx, exec, chainID, pair.BlockNumber, receipt); err != nil {
+			return err
+		}
+	}
+	if pair.Block != nil {
+		return q.insertBlockOn(ctx, exec, chainID, pair)
+	}
+	return nil
 }
 
-func (q *HtmlcoinDB) GetMissingBlocks(ctx context.Context, chainId int, latestBlock int64) ([]int64, error) {
+// writeTx writes pair in a single transaction, so a block is never stored
+// without its receipts and block data. With saveCheckpoint the checkpoint its commit moves
+// to is saved in it too, so the saved checkpoint is never ahead of the
+// committed blocks
+func (q *HtmlcoinDB) writeTx(ctx context.Context, chainID int, pair jsonrpc.HashPair, skip, saveCheckpoint bool) error {
+	defer observeWrite("block", time.Now())
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = q.write(ctx, tx, chainID, pair, skip); err == nil && saveCheckpoint {
+		contiguous, highWater := q.checkpoint.After(int64(pair.BlockNumber))
+		err = q.saveCheckpointOn(ctx, tx, chainID, q.checkpoint.FirstBlock(), contiguous, highWater)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// observeWrite records the latency of a write transaction started at start
+func observeWrite(write string, start time.Time) {
+	metrics.DBWriteLatency.WithLabelValues(write).Observe(time.Since(start).Seconds())
+}
+
+// GetMissingBlocks returns the blocks between firstBlock and latestBlock (inclusive) that haven't been stored
+func (q *HtmlcoinDB) GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error) {
 	offset := 0
 	limit := 500000
-	missingBlocks := make([]int64, latestBlock+int64(limit))
+	missingBlocks := make([]int64, latestBlock-firstBlock+1+int64(limit))
 	results := 0
 
 	for {
-		result, nextOffset, err := q.GetMissingBlocksRange(ctx, chainId, latestBlock, limit, offset)
+		result, nextOffset, err := q.GetMissingBlocksRange(ctx, chainId, firstBlock, latestBlock, limit, offset)
 		if err != nil {
 			return nil, err
 		}
@@ -90,7 +467,7 @@ func (q *HtmlcoinDB) GetMissingBlocks(ctx context.Context, chainId int, latestBl
 		}
 
 		// copy results into missing blocks
-		for i := 0; i < len(result)-1; i++ {
+		for i := 0; i < len(result); i++ {
 			missingBlocks[offset+i] = result[i]
 			results++
 		}
@@ -99,18 +476,20 @@ func (q *HtmlcoinDB) GetMissingBlocks(ctx context.Context, chainId int, latestBl
 	}
 }
 
-func (q *HtmlcoinDB) GetMissingBlocksRange(ctx context.Context, chainId int, latestBlock int64, limit, offset int) ([]int64, int, error) {
+func (q *HtmlcoinDB) GetMissingBlocksRange(ctx context.Context, chainId int, firstBlock, latestBlock int64, limit, offset int) ([]int64, int, error) {
 	// takes 1.5 sec for 2m rows on local postgres dev instance
 	missing := `
 	SELECT "B"."BlockNum"
 	FROM "Hashes" AS "A"
-	RIGHT JOIN (select generate_series(1, $1) AS "BlockNum", $2::int4 As "ChainId") AS "B"
+	RIGHT JOIN (select generate_series($5::int8, $1) AS "BlockNum", $2::int4 As "ChainId") AS "B"
 	ON "A"."BlockNum" = "B"."BlockNum"
     AND "A"."ChainId" = "B"."ChainId"
 	WHERE "A"."BlockNum" IS NULL
+	AND NOT EXISTS (SELECT 1 FROM "SeenBlocks" AS "S" WHERE "S"."BlockNum" = "B"."BlockNum" AND "S"."ChainId" = "B"."ChainId")
+	AND NOT EXISTS (SELECT 1 FROM "FailedBlocks" AS "F" WHERE "F"."BlockNum" = "B"."BlockNum" AND "F"."ChainId" = "B"."ChainId")
     LIMIT $3 OFFSET $4
 	`
-	rows, err := q.db.QueryContext(ctx, missing, latestBlock, chainId, limit, offset)
+	rows, err := q.db.QueryContext(ctx, missing, latestBlock, chainId, limit, offset, firstBlock)
 
 	if err != nil {
 		return nil, offset, err
@@ -129,6 +508,119 @@ func (q *HtmlcoinDB) GetMissingBlocksRange(ctx context.Context, chainId int, lat
 	return result[0:rowCount], limit + offset, nil
 }
 
+// Checkpoint is the persisted progress over a chain: every block from
+// FirstBlock up to Contiguous is committed, HighWater is the highest block
+// committed. FirstBlock is 0 for checkpoints saved before it was recorded
+type Checkpoint struct {
+	FirstBlock int64
+	Contiguous int64
+	HighWater  int64
+	UpdatedAt  time.Time
+}
+
+// SaveCheckpoint upserts the checkpoint row of chainId
+func (q *HtmlcoinDB) SaveCheckpoint(ctx context.Context, chainId int, firstBlock, contiguous, highWater int64) error {
+	return q.saveCheckpointOn(ctx, q.db, chainId, firstBlock, contiguous, highWater)
+}
+
+func (q *HtmlcoinDB) saveCheckpointOn(ctx context.Context, exec execer, chainId int, firstBlock, contiguous, highWater int64) error {
+	query := `INSERT INTO "Checkpoints" ("ChainId", "Contiguous", "HighWater", "UpdatedAt", "FirstBlock") VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT ("ChainId") DO UPDATE SET "Contiguous" = EXCLUDED."Contiguous", "HighWater" = EXCLUDED."HighWater", "UpdatedAt" = EXCLUDED."UpdatedAt", "FirstBlock" = EXCLUDED."FirstBlock"`
+	_, err := exec.ExecContext(ctx, query, chainId, contiguous, highWater, time.Now().UTC(), firstBlock)
+	return err
+}
+
+// GetCheckpoint returns the checkpoint row of chainId, nil if none was saved yet
+func (q *HtmlcoinDB) GetCheckpoint(ctx context.Context, chainId int) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	var firstBlock sql.NullInt64
+	err := q.db.QueryRowContext(ctx, `SELECT "FirstBlock", "Contiguous", "HighWater", "UpdatedAt" FROM "Checkpoints" WHERE "ChainId" = $1`, chainId).
+		Scan(&firstBlock, &checkpoint.Contiguous, &checkpoint.HighWater, &checkpoint.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	checkpoint.FirstBlock = firstBlock.Int64
+	return &checkpoint, nil
+}
+
+// flushCheckpoint persists the tracked checkpoint if commits advanced it
+// since it was last persisted
+func (q *HtmlcoinDB) flushCheckpoint(ctx context.Context, chainId int) {
+	if q.checkpoint == nil || q.uncheckpointedCommits == 0 {
+		return
+	}
+	contiguous, highWater := q.checkpoint.Get()
+	if err := q.SaveCheckpoint(ctx, chainId, q.checkpoint.FirstBlock(), contiguous, highWater); err != nil {
+		q.logger.WithError(err).Warn("Failed to save checkpoint")
+		return
+	}
+	q.uncheckpointedCommits = 0
+}
+
+// GetHashPairsRange returns the stored hash pairs for blocks between firstBlock and lastBlock (inclusive), ordered by block number
+func (q *HtmlcoinDB) GetHashPairsRange(ctx context.Context, chainId int, firstBlock, lastBlock int64) ([]jsonrpc.HashPair, error) {
+	selectStatement := `SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes" WHERE "ChainId" = $1 AND "BlockNum" BETWEEN $2 AND $3 ORDER BY "BlockNum", "Eth"`
+	rows, err := q.db.QueryContext(ctx, selectStatement, chainId, firstBlock, lastBlock)
+	if err != nil {
+		return nil, err
+	}
+	return scanHashPairs(rows)
+}
+
+// HashPairsCursor is the position of the last hash pair of a page, pages
+// are ordered by block number then eth hash, which is unique per chain
+type HashPairsCursor struct {
+	BlockNumber int
+	EthHash     string
+}
+
+// GetHashPairsPage returns up to limit hash pairs of the range between firstBlock and lastBlock (inclusive)
+// following after, and the cursor to pass for the next page. A nil after starts at the beginning of the
+// range and a nil next cursor means there are no more pages. Pages are read by keyset so rows stored while
+// paging don't shift later pages
+func (q *HtmlcoinDB) GetHashPairsPage(ctx context.Context, chainId int, firstBlock, lastBlock int64, after *HashPairsCursor, limit int) ([]jsonrpc.HashPair, *HashPairsCursor, error) {
+	if limit < 1 {
+		return nil, nil, errors.Errorf("invalid page limit %d", limit)
+	}
+	if after == nil {
+		after = &HashPairsCursor{BlockNumber: int(firstBlock) - 1}
+	}
+
+	selectStatement := `SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes" WHERE "ChainId" = $1 AND "BlockNum" BETWEEN $2 AND $3 AND ("BlockNum", "Eth") > ($4, $5) ORDER BY "BlockNum", "Eth" LIMIT $6`
+	rows, err := q.db.QueryContext(ctx, selectStatement, chainId, firstBlock, lastBlock, after.BlockNumber, after.EthHash, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	pairs, err := scanHashPairs(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(pairs) < limit {
+		return pairs, nil, nil
+	}
+	last := pairs[len(pairs)-1]
+	return pairs, &HashPairsCursor{BlockNumber: last.BlockNumber, EthHash: last.EthHash}, nil
+}
+
+func scanHashPairs(rows *sql.Rows) ([]jsonrpc.HashPair, error) {
+	defer rows.Close()
+
+	pairs := []jsonrpc.HashPair{}
+	for rows.Next() {
+		var pair jsonrpc.HashPair
+		if err := rows.Scan(&pair.BlockNumber, &pair.EthHash, &pair.HtmlcoinHash); err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+	}
+
+	return pairs, rows.Err()
+}
+
 func (q *HtmlcoinDB) getHtmlcoinHash(ctx context.Context, chainId int, ethHash string) (*sql.Rows, error) {
 	selectStatement := `SELECT "Htmlcoin" FROM "Hashes" WHERE "Hashes"."ChainId" = $1 AND "Hashes"."Eth" = $2`
 	if ctx == nil {
@@ -161,6 +653,63 @@ func (q *HtmlcoinDB) GetHtmlcoinHashContext(ctx context.Context, chainId int, et
 	return &htmlcoinHash, err
 }
 
+// HashRecord is a stored pair of hashes along with when it was ingested
+type HashRecord struct {
+	BlockNum     int
+	ChainId      int
+	EthHash      string
+	HtmlcoinHash string
+	IngestedAt   sql.NullTime
+}
+
+func (q *HtmlcoinDB) GetHashRecord(chainId int, ethHash string) (*HashRecord, error) {
+	return q.GetHashRecordContext(context.Background(), chainId, ethHash)
+}
+
+func (q *HtmlcoinDB) GetHashRecordContext(ctx context.Context, chainId int, ethHash string) (*HashRecord, error) {
+	selectStatement := `SELECT "BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt" FROM "Hashes" WHERE "Hashes"."ChainId" = $1 AND "Hashes"."Eth" = $2`
+	var record HashRecord
+	err := q.db.QueryRowContext(ctx, selectStatement, chainId, ethHash).Scan(
+		&record.BlockNum,
+		&record.ChainId,
+		&record.EthHash,
+		&record.HtmlcoinHash,
+		&record.IngestedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// Pause stops committing results, e.g. during a database maintenance window.
+// Results keep being read into the pause buffer until it's full, then the
+// writer stops reading them, holding back fetching
+func (q *HtmlcoinDB) Pause() {
+	if atomic.CompareAndSwapInt32(&q.paused, 0, 1) {
+		q.logger.Warn("Database writes paused")
+	}
+}
+
+// Resume commits the results buffered while paused and carries on writing
+func (q *HtmlcoinDB) Resume() {
+	if atomic.CompareAndSwapInt32(&q.paused, 1, 0) {
+		q.logger.Info("Database writes resumed")
+		select {
+		case q.resumeChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (q *HtmlcoinDB) Paused() bool {
+	return atomic.LoadInt32(&q.paused) == 1
+}
+
 func (q *HtmlcoinDB) Shutdown() {
 	q.mutex.Lock()
 	defer q.mutex.Unlock()
@@ -185,27 +734,94 @@ func (q *HtmlcoinDB) Start(ctx context.Context, chainId int, dbCloseChan chan er
 			q.running = false
 			q.mutex.Unlock()
 		}()
+		// a panicking writer would never report on dbCloseChan, leaving main
+		// to time out without the cause, so report the panic as the close error
+		var pair jsonrpc.HashPair
+		defer func() {
+			if r := recover(); r != nil {
+				err := errors.Errorf("db writer panicked writing block %d: %v", pair.BlockNumber, r)
+				q.logger.Error(err)
+				q.db.Close()
+				dbCloseChan <- err
+			}
+		}()
 
 		shuttingDown := false
+		// results read while paused, and whether the results channel closed meanwhile
+		var buffered []jsonrpc.HashPair
+		closed := false
+		// fires when the batch has waited for the flush interval
+		var flushTimer *time.Timer
+		var flushChan <-chan time.Time
+		// the batched results are flushed before closing, so none are lost
+		closeDB := func() {
+			err := q.flushBatch(context.Background(), chainId)
+			if err != nil {
+				q.logger.Error("error flushing batch to db: ", err)
+			}
+			q.flushCheckpoint(context.Background(), chainId)
+			if closeErr := q.db.Close(); err == nil {
+				err = closeErr
+			}
+			dbCloseChan <- err
+		}
 
 		for {
 			q.logger.Info("Waiting for results...")
-			var pair jsonrpc.HashPair
 			var ok bool
-
-			if shuttingDown {
+			// results buffered while paused are flushed to shut down rather than lost
+			paused := q.Paused() && !shuttingDown
+
+			if !paused && len(buffered) > 0 {
+				pair, ok = buffered[0], true
+				buffered = buffered[1:]
+				metrics.PausedResults.Set(float64(len(buffered)))
+			} else if closed && !paused {
+				q.logger.Info("HtmlcoinDB -> flushed results buffered while paused")
+				closeDB()
+				return
+			} else if shuttingDown {
 				select {
 				case pair, ok = <-q.resultChan:
 				default:
 					// shutdown, finished draining results
 					q.logger.Info("Database finished draining results, shutting down")
-					err := q.db.Close()
-					dbCloseChan <- err
+					closeDB()
 					return
 				}
 			} else {
+				resultChan := q.resultChan
+				if closed || (paused && len(buffered) >= q.pauseBuffer) {
+					// a full buffer holds back fetching until writes resume
+					resultChan = nil
+				}
 				select {
-				case pair, ok = <-q.resultChan:
+				case pair, ok = <-resultChan:
+					if q.Paused() {
+						if ok {
+							buffered = append(buffered, pair)
+							metrics.PausedResults.Set(float64(len(buffered)))
+						} else {
+							closed = len(buffered) > 0
+						}
+						if ok || closed {
+							continue
+						}
+					}
+				case <-q.resumeChan:
+					continue
+				case <-flushChan:
+					flushChan = nil
+					if q.Paused() {
+						// flushed once writes resume and the next result comes in
+						continue
+					}
+					if err := q.flushBatch(ctx, chainId); err != nil {
+						q.logger.Error("error flushing batch to db: ", err)
+						q.errChan <- err
+						return
+					}
+					continue
 				case <-ctx.Done():
 					shuttingDown = true
 					continue
@@ -218,8 +834,7 @@ func (q *HtmlcoinDB) Start(ctx context.Context, chainId int, dbCloseChan chan er
 
 			if !ok {
 				q.logger.Info("HtmlcoinDB -> channel closed")
-				err := q.db.Close()
-				dbCloseChan <- err
+				closeDB()
 				return
 			} else {
 				q.logger.Info("Got result!")
@@ -242,22 +857,102 @@ func (q *HtmlcoinDB) Start(ctx context.Context, chainId int, dbCloseChan chan er
 				start = time.Now()
 				progBar = getBar(PROGRESS_LEVEL_THRESHOLD)
 			}
-			_, err := q.insert(ctx, pair.BlockNumber, chainId, pair.EthHash, pair.HtmlcoinHash)
+			if pair.Failure != nil {
+				// dead letters aren't batched, there's nothing to store
+				err := q.withRetries(ctx, func() error {
+					return q.deadLetter(ctx, chainId, pair)
+				})
+				if err != nil {
+					q.logger.Error("error recording failed block: ", err, " for block: ", pair.BlockNumber)
+					q.errChan <- err
+					return
+				}
+				q.committed(pair, true)
+				continue
+			}
+			skip := pair.Skipped || (q.skipEmptyBlocks && pair.Empty)
+			if q.refetchChan != nil && !pair.Skipped {
+				if err := q.checkParent(ctx, chainId, pair); err != nil {
+					q.logger.Error("error checking for a reorg: ", err, " for block: ", pair.BlockNumber)
+					q.errChan <- err
+					return
+				}
+			}
+			if q.batchSize > 1 {
+				q.batch = append(q.batch, batchedPair{pair: pair, skip: skip})
+				if len(q.batch) < q.batchSize {
+					if flushChan == nil {
+						if flushTimer == nil {
+							flushTimer = time.NewTimer(q.flushInterval)
+						} else {
+							flushTimer.Reset(q.flushInterval)
+						}
+						flushChan = flushTimer.C
+					}
+					continue
+				}
+				if flushChan != nil && !flushTimer.Stop() {
+					<-flushTimer.C
+				}
+				flushChan = nil
+				if err := q.flushBatch(ctx, chainId); err != nil {
+					q.logger.Error("error flushing batch to db: ", err)
+					q.errChan <- err
+					return
+				}
+				continue
+			}
+			// every checkpointEvery commits the checkpoint is saved along with the block
+			saveCheckpoint := q.checkpoint != nil && q.uncheckpointedCommits+1 >= q.checkpointEvery
+			err := q.withRetries(ctx, func() error {
+				if saveCheckpoint || (!skip && (len(pair.Receipts) > 0 || pair.Block != nil)) {
+					return q.writeTx(ctx, chainId, pair, skip, saveCheckpoint)
+				}
+				return q.write(ctx, q.db, chainId, pair, skip)
+			})
 			if err != nil {
 				q.logger.Error("error writing to db: ", err, " for block: ", pair.BlockNumber)
 				q.errChan <- err
 				return
 			}
-			q.records += 1
+			q.committed(pair, skip)
+			if saveCheckpoint {
+				q.uncheckpointedCommits = 0
+			}
 		}
 	}()
 
 }
 
+// committed records pair as committed, skip is set when only seen
+func (q *HtmlcoinDB) committed(pair jsonrpc.HashPair, skip bool) {
+	if !skip {
+		q.records += 1
+		metrics.BlocksStored.Inc()
+	}
+	q.latencyTracker.Committed(int64(pair.BlockNumber))
+	if !skip {
+		q.rememberHash(pair)
+	}
+	if q.checkpoint != nil {
+		q.checkpoint.Commit(int64(pair.BlockNumber))
+		q.uncheckpointedCommits++
+	}
+	// empty and skipped blocks advance the highest block all the same
+	if int64(pair.BlockNumber) > atomic.LoadInt64(&q.highestBlock) {
+		atomic.StoreInt64(&q.highestBlock, int64(pair.BlockNumber))
+	}
+}
+
 func (q *HtmlcoinDB) GetRecords() int64 {
 	return q.records
 }
 
+// GetHighestBlock returns the highest block committed during this run
+func (q *HtmlcoinDB) GetHighestBlock() int64 {
+	return atomic.LoadInt64(&q.highestBlock)
+}
+
 // creates a progress bar used to display progress when only 1 alien is left
 func getBar(I int) *progressbar.ProgressBar {
 	bar := progressbar.NewOptions(I,
@@ -0,0 +1,553 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// recentTime matches a time.Time argument within a second of now
+type recentTime struct{}
+
+func (recentTime) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Since(t) >= 0 && time.Since(t) < time.Second
+}
+
+func newMockDB(t *testing.T) (*HtmlcoinDB, sqlmock.Sqlmock) {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { mockDB.Close() })
+	dbLogger, _ := log.GetLogger()
+	return &HtmlcoinDB{db: mockDB, logger: dbLogger.WithField("module", "db")}, mock
+}
+
+func TestIngestedAt(t *testing.T) {
+	t.Run("insert records a recent ingestion timestamp", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectExec(`INSERT INTO "Hashes"`).
+			WithArgs(1, 4444, "0xeth", "0xhtmlcoin", recentTime{}, nil, nil).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := q.insert(context.Background(), 4444, jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("read accessor exposes the ingestion timestamp", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		ingestedAt := time.Now()
+		mock.ExpectQuery(`SELECT "BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt" FROM "Hashes"`).
+			WithArgs(4444, "0xeth").
+			WillReturnRows(sqlmock.NewRows([]string{"BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt"}).
+				AddRow(1, 4444, "0xeth", "0xhtmlcoin", ingestedAt))
+
+		record, err := q.GetHashRecord(4444, "0xeth")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !record.IngestedAt.Valid || !record.IngestedAt.Time.Equal(ingestedAt) {
+			t.Errorf("got ingestedAt %v, want %v", record.IngestedAt, ingestedAt)
+		}
+	})
+
+	t.Run("read accessor returns nil for unknown hashes", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT "BlockNum"`).
+			WithArgs(4444, "0xunknown").
+			WillReturnRows(sqlmock.NewRows([]string{"BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt"}))
+
+		record, err := q.GetHashRecord(4444, "0xunknown")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record != nil {
+			t.Errorf("got %+v, want nil", record)
+		}
+	})
+}
+
+func TestGetHashPairsRange(t *testing.T) {
+	q, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes"`).
+		WithArgs(4444, int64(10), int64(11)).
+		WillReturnRows(sqlmock.NewRows([]string{"BlockNum", "Eth", "Htmlcoin"}).
+			AddRow(10, "0xeth10", "0xhtmlcoin10").
+			AddRow(11, "0xeth11", "0xhtmlcoin11"))
+
+	pairs, err := q.GetHashPairsRange(context.Background(), 4444, 10, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []jsonrpc.HashPair{
+		{BlockNumber: 10, EthHash: "0xeth10", HtmlcoinHash: "0xhtmlcoin10"},
+		{BlockNumber: 11, EthHash: "0xeth11", HtmlcoinHash: "0xhtmlcoin11"},
+	}
+	if !reflect.DeepEqual(pairs, want) {
+		t.Errorf("got %+v, want %+v", pairs, want)
+	}
+}
+
+func TestGetHashPairsPage(t *testing.T) {
+	// stored hash pairs of blocks 10-14, block 12 has two eth hashes
+	stored := []jsonrpc.HashPair{
+		{BlockNumber: 10, EthHash: "0xeth10", HtmlcoinHash: "0xhtmlcoin10"},
+		{BlockNumber: 11, EthHash: "0xeth11", HtmlcoinHash: "0xhtmlcoin11"},
+		{BlockNumber: 12, EthHash: "0xeth12a", HtmlcoinHash: "0xhtmlcoin12"},
+		{BlockNumber: 12, EthHash: "0xeth12b", HtmlcoinHash: "0xhtmlcoin12"},
+		{BlockNumber: 13, EthHash: "0xeth13", HtmlcoinHash: "0xhtmlcoin13"},
+	}
+	rows := func(pairs []jsonrpc.HashPair) *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"BlockNum", "Eth", "Htmlcoin"})
+		for _, pair := range pairs {
+			rows.AddRow(pair.BlockNumber, pair.EthHash, pair.HtmlcoinHash)
+		}
+		return rows
+	}
+
+	q, mock := newMockDB(t)
+	// every page must resume right after the last row of the previous one
+	mock.ExpectQuery(`SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes"`).
+		WithArgs(4444, int64(10), int64(14), 9, "", 2).
+		WillReturnRows(rows(stored[0:2]))
+	mock.ExpectQuery(`SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes"`).
+		WithArgs(4444, int64(10), int64(14), 11, "0xeth11", 2).
+		WillReturnRows(rows(stored[2:4]))
+	mock.ExpectQuery(`SELECT "BlockNum", "Eth", "Htmlcoin" FROM "Hashes"`).
+		WithArgs(4444, int64(10), int64(14), 12, "0xeth12b", 2).
+		WillReturnRows(rows(stored[4:]))
+
+	var got []jsonrpc.HashPair
+	var cursor *HashPairsCursor
+	for pages := 0; ; pages++ {
+		if pages > len(stored) {
+			t.Fatal("pagination didn't end")
+		}
+		page, next, err := q.GetHashPairsPage(context.Background(), 4444, 10, 14, cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, page...)
+		if next == nil {
+			break
+		}
+		cursor = next
+	}
+
+	if !reflect.DeepEqual(got, stored) {
+		t.Errorf("got %+v, want %+v", got, stored)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInsertRetries(t *testing.T) {
+	insertWithRetries := func(q *HtmlcoinDB) error {
+		return q.withRetries(context.Background(), func() error {
+			_, err := q.insert(context.Background(), 4444, jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin"})
+			return err
+		})
+	}
+
+	t.Run("deadlocked insert is retried and committed", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithInsertRetries(3, time.Millisecond)(q)
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnError(&pq.Error{Code: "40P01", Message: "deadlock detected"})
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if err := insertWithRetries(q); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("serialization failures are retried until retries run out", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithInsertRetries(2, time.Millisecond)(q)
+		for i := 0; i < 3; i++ {
+			mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnError(&pq.Error{Code: "40001", Message: "could not serialize access"})
+		}
+
+		err := insertWithRetries(q)
+		if !isRetryable(err) {
+			t.Errorf("got %v, want the serialization failure", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("constraint violations are not retried", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithInsertRetries(3, time.Millisecond)(q)
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnError(&pq.Error{Code: "23502", Message: "null value violates not-null constraint"})
+
+		if err := insertWithRetries(q); err == nil {
+			t.Error("expected the constraint violation")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestWriterPanic(t *testing.T) {
+	t.Run("writer panic surfaces on dbCloseChan", func(t *testing.T) {
+		q, _ := newMockDB(t)
+		q.resultChan = make(chan jsonrpc.HashPair, 1)
+		q.shutdownChan = make(chan struct{})
+		dbCloseChan := make(chan error)
+
+		// insert panics on a zero chain id
+		q.Start(context.Background(), 0, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 7, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin"}
+
+		select {
+		case err := <-dbCloseChan:
+			if err == nil || !strings.Contains(err.Error(), "panicked writing block 7") {
+				t.Errorf("got %v, want the writer panic", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for dbCloseChan")
+		}
+	})
+}
+
+func TestSkipEmptyBlocks(t *testing.T) {
+	q, mock := newMockDB(t)
+	WithSkipEmptyBlocks(true)(q)
+	q.resultChan = make(chan jsonrpc.HashPair)
+	q.shutdownChan = make(chan struct{})
+	dbCloseChan := make(chan error)
+
+	// only the non-empty block gets a row, the empty one is only seen
+	mock.ExpectExec(`INSERT INTO "Hashes"`).
+		WithArgs(5, 4444, "0xeth5", "0xhtmlcoin5", recentTime{}, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "SeenBlocks"`).
+		WithArgs(6, 4444, false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	q.Start(context.Background(), 4444, dbCloseChan)
+	q.resultChan <- jsonrpc.HashPair{BlockNumber: 5, EthHash: "0xeth5", HtmlcoinHash: "0xhtmlcoin5"}
+	q.resultChan <- jsonrpc.HashPair{BlockNumber: 6, EthHash: "0xeth6", HtmlcoinHash: "0xhtmlcoin6", Empty: true}
+	close(q.resultChan)
+	if err := <-dbCloseChan; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("empty blocks don't create rows", func(t *testing.T) {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if q.GetRecords() != 1 {
+			t.Errorf("got %d records, want 1", q.GetRecords())
+		}
+	})
+
+	t.Run("highest block advances past empty blocks", func(t *testing.T) {
+		if got := q.GetHighestBlock(); got != 6 {
+			t.Errorf("got highest block %d, want 6", got)
+		}
+	})
+}
+
+func TestSkippedBlocksAreSeen(t *testing.T) {
+	q, mock := newMockDB(t)
+	q.resultChan = make(chan jsonrpc.HashPair)
+	q.shutdownChan = make(chan struct{})
+	dbCloseChan := make(chan error)
+
+	mock.ExpectExec(`INSERT INTO "SeenBlocks"`).
+		WithArgs(13, 4444, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	q.Start(context.Background(), 4444, dbCloseChan)
+	q.resultChan <- jsonrpc.HashPair{BlockNumber: 13, Skipped: true}
+	close(q.resultChan)
+	if err := <-dbCloseChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if got := q.GetHighestBlock(); got != 13 {
+		t.Errorf("got highest block %d, want 13", got)
+	}
+}
+
+func TestBlockStats(t *testing.T) {
+	size := int64(1678)
+	ratio := 0.25
+	pair := jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin", Size: &size, GasUsedRatio: &ratio}
+
+	t.Run("size and gasUsed ratio are stored when enabled", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithBlockStats(true)(q)
+		mock.ExpectExec(`INSERT INTO "Hashes"`).
+			WithArgs(1, 4444, "0xeth", "0xhtmlcoin", recentTime{}, int64(1678), 0.25).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		if _, err := q.insert(context.Background(), 4444, pair); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("missing size is stored as null", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithBlockStats(true)(q)
+		mock.ExpectExec(`INSERT INTO "Hashes"`).
+			WithArgs(1, 4444, "0xeth", "0xhtmlcoin", recentTime{}, nil, 0.25).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		withoutSize := pair
+		withoutSize.Size = nil
+		if _, err := q.insert(context.Background(), 4444, withoutSize); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestReceipts(t *testing.T) {
+	q, mock := newMockDB(t)
+	q.resultChan = make(chan jsonrpc.HashPair)
+	q.shutdownChan = make(chan struct{})
+	dbCloseChan := make(chan error)
+
+	// the block and its receipts are committed together
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(2, 4444, "0xeth2", "0xhtmlcoin2", recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Receipts"`).WithArgs("0xtx1", 2, 4444, int64(0), int64(21000), int64(1), nil, `[{"address":"0xab","topics":["0xt1","0xt2"],"data":"0x01","logIndex":"0x3"}]`).WillReturnResult(sqlmock.NewResult(0, 1))
+	// its logs are decoded into rows of their own
+	mock.ExpectExec(`INSERT INTO "Logs"`).WithArgs("0xtx1", int64(3), 2, 4444, "0xab", `{"0xt1","0xt2"}`, "0x01").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Receipts"`).WithArgs("0xtx2", 2, 4444, int64(1), int64(53000), nil, "0xcd", `[]`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	q.Start(context.Background(), 4444, dbCloseChan)
+	q.resultChan <- jsonrpc.HashPair{BlockNumber: 2, EthHash: "0xeth2", HtmlcoinHash: "0xhtmlcoin2", Receipts: []jsonrpc.GetTransactionReceiptResponse{
+		{TransactionHash: "0xtx1", TransactionIndex: "0x0", GasUsed: "0x5208", Status: "0x1", Logs: []json.RawMessage{json.RawMessage(`{"address":"0xab","topics":["0xt1","0xt2"],"data":"0x01","logIndex":"0x3"}`)}},
+		{TransactionHash: "0xtx2", TransactionIndex: "0x1", GasUsed: "0xcf08", ContractAddress: "0xcd"},
+	}}
+	close(q.resultChan)
+	if err := <-dbCloseChan; err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStoreBlocks(t *testing.T) {
+	q, mock := newMockDB(t)
+	q.resultChan = make(chan jsonrpc.HashPair)
+	q.shutdownChan = make(chan struct{})
+	dbCloseChan := make(chan error)
+
+	// the block is committed along with its header and transactions
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(2, 4444, "0xeth2", "0xhtmlcoin2", recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Blocks"`).WithArgs(2, 4444, int64(1624723908), "0xminer", int64(21000), "0xhtmlcoin1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Transactions"`).WithArgs("0xtx1", 2, 4444, int64(0), "0xfrom", "0xto", "156696819000000000000", int64(21000), "0xa9059cbb", nil, nil, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Transactions"`).WithArgs("0xtx2", 2, 4444, int64(1), "0xfrom", nil, "0", int64(90000), nil, nil, nil, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectClose()
+
+	q.Start(context.Background(), 4444, dbCloseChan)
+	q.resultChan <- jsonrpc.HashPair{BlockNumber: 2, EthHash: "0xeth2", HtmlcoinHash: "0xhtmlcoin2", Block: &jsonrpc.BlockData{
+		Timestamp:  1624723908,
+		Miner:      "0xminer",
+		GasUsed:    21000,
+		ParentHash: "0xhtmlcoin1",
+		Transactions: []jsonrpc.TransactionData{
+			{Hash: "0xtx1", Index: 0, From: "0xfrom", To: "0xto", Value: "156696819000000000000", Gas: 21000, Input: "0xa9059cbb"},
+			// a contract creation
+			{Hash: "0xtx2", Index: 1, From: "0xfrom", Value: "0", Gas: 90000},
+		},
+	}}
+	close(q.resultChan)
+	if err := <-dbCloseChan; err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpointPersistence(t *testing.T) {
+	q, mock := newMockDB(t)
+	checkpoint := cache.NewCheckpoint()
+	checkpoint.Reset(1, 5, []int64{1, 2, 3, 4, 5})
+	WithCheckpoint(checkpoint, 2)(q)
+	q.resultChan = make(chan jsonrpc.HashPair)
+	q.shutdownChan = make(chan struct{})
+	dbCloseChan := make(chan error)
+
+	// block 2 is never committed, holding the contiguous checkpoint at 1
+	mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(1, 4444, "0xeth1", "0xhtmlcoin1", recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	// the checkpoint is saved in the transaction of the block committing it
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(3, 4444, "0xeth3", "0xhtmlcoin3", recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Checkpoints"`).WithArgs(4444, int64(1), int64(3), recentTime{}, int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(4, 4444, "0xeth4", "0xhtmlcoin4", recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO "Checkpoints"`).WithArgs(4444, int64(1), int64(4), recentTime{}, int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectClose()
+
+	q.Start(context.Background(), 4444, dbCloseChan)
+	for _, block := range []int{1, 3, 4} {
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: block, EthHash: fmt.Sprintf("0xeth%d", block), HtmlcoinHash: fmt.Sprintf("0xhtmlcoin%d", block)}
+	}
+	close(q.resultChan)
+	if err := <-dbCloseChan; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("checkpoint row is saved in batches and when the writer stops", func(t *testing.T) {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("checkpoint row is read back", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		updatedAt := time.Now()
+		mock.ExpectQuery(`SELECT "FirstBlock", "Contiguous", "HighWater", "UpdatedAt" FROM "Checkpoints"`).
+			WithArgs(4444).
+			WillReturnRows(sqlmock.NewRows([]string{"FirstBlock", "Contiguous", "HighWater", "UpdatedAt"}).AddRow(1, 1, 4, updatedAt))
+		got, err := q.GetCheckpoint(context.Background(), 4444)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == nil || got.FirstBlock != 1 || got.Contiguous != 1 || got.HighWater != 4 {
+			t.Errorf("got checkpoint %+v, want first block 1, contiguous 1 and high-water 4", got)
+		}
+	})
+
+	t.Run("a failed block insert rolls its checkpoint back", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		checkpoint := cache.NewCheckpoint()
+		checkpoint.Reset(1, 5, []int64{1, 2, 3, 4, 5})
+		WithCheckpoint(checkpoint, 1)(q)
+		mock.ExpectBegin()
+		mock.ExpectExec(`INSERT INTO "Hashes"`).WillReturnError(fmt.Errorf("connection reset"))
+		mock.ExpectRollback()
+
+		if err := q.writeTx(context.Background(), 4444, jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth1", HtmlcoinHash: "0xhtmlcoin1"}, false, true); err == nil {
+			t.Error("expected an error")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if contiguous, _ := checkpoint.Get(); contiguous != 0 {
+			t.Errorf("got contiguous checkpoint %d, want 0", contiguous)
+		}
+		if got := testutil.CollectAndCount(metrics.DBWriteLatency, "block_processor_db_write_seconds"); got < 1 {
+			t.Error("block write wasn't timed")
+		}
+	})
+}
+
+func TestPauseWrites(t *testing.T) {
+	newPausedDB := func(t *testing.T, blocks ...int) (*HtmlcoinDB, sqlmock.Sqlmock, chan error) {
+		q, mock := newMockDB(t)
+		WithPauseBuffer(3)(q)
+		q.resultChan = make(chan jsonrpc.HashPair)
+		q.shutdownChan = make(chan struct{})
+		q.resumeChan = make(chan struct{}, 1)
+		q.errChan = make(chan error, 1)
+		for _, block := range blocks {
+			mock.ExpectExec(`INSERT INTO "Hashes"`).WithArgs(block, 4444, fmt.Sprintf("0xeth%d", block), fmt.Sprintf("0xhtmlcoin%d", block), recentTime{}, nil, nil).WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		mock.ExpectClose()
+		dbCloseChan := make(chan error)
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.Pause()
+		return q, mock, dbCloseChan
+	}
+	result := func(block int) jsonrpc.HashPair {
+		return jsonrpc.HashPair{BlockNumber: block, EthHash: fmt.Sprintf("0xeth%d", block), HtmlcoinHash: fmt.Sprintf("0xhtmlcoin%d", block)}
+	}
+
+	t.Run("results are buffered while paused and all commit on resume", func(t *testing.T) {
+		q, mock, dbCloseChan := newPausedDB(t, 1, 2, 3, 4)
+		for block := 1; block <= 3; block++ {
+			q.resultChan <- result(block)
+		}
+		select {
+		case q.resultChan <- result(4):
+			t.Fatal("got a result past the full pause buffer, want back-pressure")
+		case <-time.After(100 * time.Millisecond):
+		}
+		if got := testutil.ToFloat64(metrics.PausedResults); got != 3 {
+			t.Errorf("got %v buffered results, want 3", got)
+		}
+		if q.GetRecords() != 0 {
+			t.Errorf("got %d records committed while paused, want none", q.GetRecords())
+		}
+
+		q.Resume()
+		q.resultChan <- result(4)
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if q.GetRecords() != 4 {
+			t.Errorf("got %d records, want 4", q.GetRecords())
+		}
+	})
+
+	t.Run("results buffered when the channel closes are committed on resume", func(t *testing.T) {
+		q, mock, dbCloseChan := newPausedDB(t, 1, 2)
+		q.resultChan <- result(1)
+		q.resultChan <- result(2)
+		close(q.resultChan)
+		select {
+		case <-dbCloseChan:
+			t.Fatal("writer stopped while paused with buffered results")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		q.Resume()
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
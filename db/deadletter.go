@@ -0,0 +1,26 @@
+package db
+
+import (
+	"context"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// deadLetter records a block that failed every attempt, it's no longer
+// missing until it's requeued
+func (q *HtmlcoinDB) deadLetter(ctx context.Context, chainId int, pair jsonrpc.HashPair) error {
+	insert := `INSERT INTO "FailedBlocks"("BlockNum", "ChainId", "Error", "Attempts", "FailedAt") VALUES($1, $2, $3, $4, now())
+	ON CONFLICT ("BlockNum", "ChainId") DO UPDATE SET "Error" = EXCLUDED."Error", "Attempts" = "FailedBlocks"."Attempts" + EXCLUDED."Attempts", "FailedAt" = EXCLUDED."FailedAt"`
+	_, err := q.db.ExecContext(ctx, insert, pair.BlockNumber, chainId, pair.Failure.Error, pair.Failure.Attempts)
+	return err
+}
+
+// RequeueFailedBlocks deletes the failed blocks recorded for chainId so they
+// are missing again, returning how many were requeued
+func (q *HtmlcoinDB) RequeueFailedBlocks(ctx context.Context, chainId int) (int64, error) {
+	result, err := q.db.ExecContext(ctx, `DELETE FROM "FailedBlocks" WHERE "ChainId" = $1`, chainId)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
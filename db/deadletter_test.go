@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestDeadLetters(t *testing.T) {
+	t.Run("failed blocks are recorded rather than stored", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		q.resultChan = make(chan jsonrpc.HashPair)
+		q.shutdownChan = make(chan struct{})
+		dbCloseChan := make(chan error)
+
+		mock.ExpectExec(`INSERT INTO "FailedBlocks"`).WithArgs(7, 4444, "block not found", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectClose()
+
+		q.Start(context.Background(), 4444, dbCloseChan)
+		q.resultChan <- jsonrpc.HashPair{BlockNumber: 7, Failure: &jsonrpc.BlockFailure{Error: "block not found", Attempts: 3}}
+		close(q.resultChan)
+		if err := <-dbCloseChan; err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if records := q.GetRecords(); records != 0 {
+			t.Errorf("got %d records, want the failed block not to count", records)
+		}
+	})
+
+	t.Run("failed blocks of the chain are requeued", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectExec(`DELETE FROM "FailedBlocks"`).WithArgs(4444).WillReturnResult(sqlmock.NewResult(0, 2))
+
+		requeued, err := q.RequeueFailedBlocks(context.Background(), 4444)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requeued != 2 {
+			t.Errorf("got %d blocks requeued, want 2", requeued)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+// InputFormat is how the input of stored transactions is serialized
+type InputFormat string
+
+const (
+	// the input as providers return it, hex text in the "Input" column
+	InputHex InputFormat = "hex"
+	// the input bytes in the "InputBytes" column
+	InputBytea InputFormat = "bytea"
+	// the 4-byte method id in the "MethodId" column and the arguments the
+	// abi decodes, as json, in "MethodArgs". Inputs it doesn't decode are
+	// stored as hex
+	InputDecoded InputFormat = "decoded"
+)
+
+// WithTransactionInput stores the input of transactions in format, hex by
+// default. With contractABI the name of the method called is stored along
+// with it, and arguments can be decoded
+func WithTransactionInput(format InputFormat, contractABI *abi.ABI) Option {
+	return func(q *HtmlcoinDB) {
+		q.inputFormat = format
+		q.inputABI = contractABI
+	}
+}
+
+// inputColumns returns the "Input", "InputBytes", "MethodId", "MethodName"
+// and "MethodArgs" columns storing the input of transaction, those the
+// input format doesn't fill are NULL. Inputs that aren't hex are stored as
+// given in "Input", whatever the format, rather than failing their block
+func (q *HtmlcoinDB) inputColumns(transaction jsonrpc.TransactionData) []interface{} {
+	columns := make([]interface{}, 5)
+	if transaction.Input == "" {
+		return columns
+	}
+	if (q.inputFormat == "" || q.inputFormat == InputHex) && q.inputABI == nil {
+		columns[0] = transaction.Input
+		return columns
+	}
+
+	data, err := hex.DecodeString(strings.TrimPrefix(transaction.Input, "0x"))
+	if err != nil || !strings.HasPrefix(transaction.Input, "0x") {
+		q.logger.WithField("transaction", transaction.Hash).Warn("Storing input that isn't hex as given")
+		columns[0] = transaction.Input
+		return columns
+	}
+	// contract creations carry init code rather than a method call
+	var method *abi.Method
+	if q.inputABI != nil && transaction.To != "" && len(data) >= 4 {
+		if method, err = q.inputABI.MethodById(data[:4]); err == nil {
+			columns[3] = method.Name
+		}
+	}
+
+	switch q.inputFormat {
+	case InputBytea:
+		columns[1] = data
+	case InputDecoded:
+		if len(data) >= 4 {
+			columns[2] = "0x" + hex.EncodeToString(data[:4])
+		}
+		// inputs the abi doesn't decode are kept whole
+		if columns[4] = decodeArgs(method, data); columns[4] == nil {
+			columns[0] = transaction.Input
+		}
+	default:
+		columns[0] = transaction.Input
+	}
+	return columns
+}
+
+// decodeArgs returns the arguments of a call to method as json, nil when
+// there's no method or the input doesn't decode
+func decodeArgs(method *abi.Method, data []byte) interface{} {
+	if method == nil {
+		return nil
+	}
+	args := make(map[string]interface{})
+	if err := method.Inputs.UnpackIntoMap(args, data[4:]); err != nil {
+		return nil
+	}
+	encoded, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	return string(encoded)
+}
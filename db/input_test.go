@@ -0,0 +1,54 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+)
+
+const erc20ABI = `[{"type":"function","name":"transfer","inputs":[{"name":"to","type":"address"},{"name":"amount","type":"uint256"}],"outputs":[{"name":"","type":"bool"}]}]`
+
+// transferInput calls transfer(0x...01, 1000)
+const transferInput = "0xa9059cbb" +
+	"0000000000000000000000000000000000000000000000000000000000000001" +
+	"00000000000000000000000000000000000000000000000000000000000003e8"
+
+func TestInputColumns(t *testing.T) {
+	contractABI, err := abi.JSON(strings.NewReader(erc20ABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	transfer := jsonrpc.TransactionData{Hash: "0xtx1", To: "0xtoken", Input: transferInput}
+	unknown := jsonrpc.TransactionData{Hash: "0xtx2", To: "0xtoken", Input: "0x12345678"}
+	creation := jsonrpc.TransactionData{Hash: "0xtx3", Input: transferInput}
+
+	for _, test := range []struct {
+		name        string
+		format      InputFormat
+		contractABI *abi.ABI
+		transaction jsonrpc.TransactionData
+		// "Input", "InputBytes", "MethodId", "MethodName" and "MethodArgs"
+		want []interface{}
+	}{
+		{"input is stored as hex by default", "", nil, transfer, []interface{}{transferInput, nil, nil, nil, nil}},
+		{"transactions without input store none", InputDecoded, &contractABI, jsonrpc.TransactionData{Hash: "0xtx4"}, []interface{}{nil, nil, nil, nil, nil}},
+		{"the method name is extracted with an abi", InputHex, &contractABI, transfer, []interface{}{transferInput, nil, nil, "transfer", nil}},
+		{"input is stored as bytes", InputBytea, &contractABI, jsonrpc.TransactionData{Hash: "0xtx5", To: "0xtoken", Input: "0x0102"}, []interface{}{nil, []byte{1, 2}, nil, nil, nil}},
+		{"calls are decoded by the abi", InputDecoded, &contractABI, transfer, []interface{}{nil, nil, "0xa9059cbb", "transfer", `{"amount":1000,"to":"0x0000000000000000000000000000000000000001"}`}},
+		{"calls the abi doesn't know are kept whole", InputDecoded, &contractABI, unknown, []interface{}{"0x12345678", nil, "0x12345678", nil, nil}},
+		{"contract creations call no method", InputHex, &contractABI, creation, []interface{}{transferInput, nil, nil, nil, nil}},
+		{"input that isn't hex is stored as given as bytes", InputBytea, nil, jsonrpc.TransactionData{Hash: "0xtx6", Input: "0xzz"}, []interface{}{"0xzz", nil, nil, nil, nil}},
+		{"input that isn't hex is stored as given decoded", InputDecoded, &contractABI, jsonrpc.TransactionData{Hash: "0xtx7", To: "0xtoken", Input: "a9059cbb"}, []interface{}{"a9059cbb", nil, nil, nil, nil}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			q, _ := newMockDB(t)
+			WithTransactionInput(test.format, test.contractABI)(q)
+			if columns := q.inputColumns(test.transaction); !reflect.DeepEqual(columns, test.want) {
+				t.Errorf("got %v, want %v", columns, test.want)
+			}
+		})
+	}
+}
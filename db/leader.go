@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+var ErrLeadershipLost = errors.New("leader lock lost")
+
+// advisoryLock is a lock shared by every instance using the database
+type advisoryLock interface {
+	tryLock(ctx context.Context) (bool, error)
+	// held reports whether the lock is still held, it lapses along with
+	// the session holding it
+	held(ctx context.Context) (bool, error)
+	unlock(ctx context.Context) error
+}
+
+// pgAdvisoryLock is a postgres session advisory lock, held on a dedicated
+// connection as the session owns the lock
+type pgAdvisoryLock struct {
+	db   *sql.DB
+	key  int64
+	conn *sql.Conn
+}
+
+func (l *pgAdvisoryLock) tryLock(ctx context.Context) (bool, error) {
+	if l.conn == nil {
+		conn, err := l.db.Conn(ctx)
+		if err != nil {
+			return false, err
+		}
+		l.conn = conn
+	}
+	var locked bool
+	err := l.conn.QueryRowContext(ctx, `SELECT pg_try_advisory_lock($1)`, l.key).Scan(&locked)
+	if err != nil || !locked {
+		// don't hog a connection while standing by
+		l.conn.Close()
+		l.conn = nil
+	}
+	return locked, err
+}
+
+func (l *pgAdvisoryLock) held(ctx context.Context) (bool, error) {
+	if l.conn == nil {
+		return false, nil
+	}
+	var held bool
+	err := l.conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid() AND ((classid::int8 << 32) | objid::int8) = $1)`, l.key).Scan(&held)
+	return held, err
+}
+
+func (l *pgAdvisoryLock) unlock(ctx context.Context) error {
+	if l.conn == nil {
+		return nil
+	}
+	_, err := l.conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, l.key)
+	l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// Leader elects a single instance among those sharing the database to
+// process blocks, the others standing by to take over
+type Leader struct {
+	lock     advisoryLock
+	interval time.Duration
+	logger   *logrus.Entry
+}
+
+// NewLeader creates a leader election on the advisory lock key, acquiring
+// and renewing the lock every interval
+func (q *HtmlcoinDB) NewLeader(key int64, interval time.Duration) *Leader {
+	return newLeader(&pgAdvisoryLock{db: q.db, key: key}, interval)
+}
+
+func newLeader(lock advisoryLock, interval time.Duration) *Leader {
+	leaderLogger, _ := log.GetLogger()
+	return &Leader{
+		lock:     lock,
+		interval: interval,
+		logger:   leaderLogger.WithField("module", "leader"),
+	}
+}
+
+// Acquire stands by until the lock is acquired or ctx is cancelled
+func (l *Leader) Acquire(ctx context.Context) error {
+	for {
+		locked, err := l.lock.tryLock(ctx)
+		if err != nil {
+			l.logger.Warn("Failed acquiring leader lock: ", err)
+		}
+		if locked {
+			l.logger.Info("Acquired leader lock")
+			return nil
+		}
+		l.logger.Debug("Standing by, another instance holds the leader lock")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval):
+		}
+	}
+}
+
+// Hold renews the lock every interval until ctx is cancelled, returning
+// ErrLeadershipLost as soon as the lock lapses
+func (l *Leader) Hold(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.interval):
+		}
+		held, err := l.lock.held(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || !held {
+			l.logger.Error("Leader lock lapsed: ", err)
+			return ErrLeadershipLost
+		}
+	}
+}
+
+// Release gives up the lock for a standby to take over
+func (l *Leader) Release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.interval)
+	defer cancel()
+	return l.lock.unlock(ctx)
+}
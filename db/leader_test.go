@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// memoryLock is an advisory lock shared by instances through owner
+type memoryLock struct {
+	mutex *sync.Mutex
+	owner **memoryLock
+}
+
+func (l *memoryLock) tryLock(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if *l.owner == nil {
+		*l.owner = l
+	}
+	return *l.owner == l, nil
+}
+
+func (l *memoryLock) held(ctx context.Context) (bool, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return *l.owner == l, nil
+}
+
+func (l *memoryLock) unlock(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if *l.owner == l {
+		*l.owner = nil
+	}
+	return nil
+}
+
+func TestLeaderElection(t *testing.T) {
+	var mutex sync.Mutex
+	var owner *memoryLock
+	first := newLeader(&memoryLock{mutex: &mutex, owner: &owner}, 10*time.Millisecond)
+	standby := newLeader(&memoryLock{mutex: &mutex, owner: &owner}, 10*time.Millisecond)
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	if err := first.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	standbyAcquired := make(chan error, 1)
+	go func() {
+		standbyAcquired <- standby.Acquire(ctx)
+	}()
+
+	t.Run("only the lock holder processes", func(t *testing.T) {
+		select {
+		case <-standbyAcquired:
+			t.Fatal("standby acquired the lock while it was held")
+		case <-time.After(100 * time.Millisecond):
+		}
+	})
+
+	t.Run("standby takes over when the holder releases", func(t *testing.T) {
+		if err := first.Release(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-standbyAcquired:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("standby didn't take over")
+		}
+	})
+
+	t.Run("former holder learns it lost the lock", func(t *testing.T) {
+		if err := first.Hold(ctx); err != ErrLeadershipLost {
+			t.Errorf("got %v, want %v", err, ErrLeadershipLost)
+		}
+	})
+}
+
+func TestPgAdvisoryLock(t *testing.T) {
+	q, mock := newMockDB(t)
+	lock := &pgAdvisoryLock{db: q.db, key: 42}
+
+	mock.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectQuery(`SELECT EXISTS \(SELECT 1 FROM pg_locks`).
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).
+		WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	ctx := context.Background()
+	if locked, err := lock.tryLock(ctx); err != nil || !locked {
+		t.Fatalf("got locked %v (%v), want the lock", locked, err)
+	}
+	if held, err := lock.held(ctx); err != nil || !held {
+		t.Errorf("got held %v (%v), want the lock held", held, err)
+	}
+	if err := lock.unlock(ctx); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
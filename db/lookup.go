@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// StoredBlock is the hash pair stored for a block and when and how it was
+// processed
+type StoredBlock struct {
+	BlockNumber  int
+	ChainId      int
+	EthHash      string
+	HtmlcoinHash string
+	IngestedAt   *time.Time
+	// only recorded with block stats on
+	Size         *int64
+	GasUsedRatio *float64
+}
+
+const storedBlockColumns = `"BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio"`
+
+// BlockByEthHash returns the block stored with the eth hash, nil when there's none
+func (q *HtmlcoinDB) BlockByEthHash(ctx context.Context, chainId int, ethHash string) (*StoredBlock, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+storedBlockColumns+` FROM "Hashes" WHERE "Eth" = $1 AND "ChainId" = $2`, ethHash, chainId)
+	return scanStoredBlock(row)
+}
+
+// BlockByNumber returns the block stored at blockNum, the last ingested one
+// when a reorg left several. It's nil when there's none
+func (q *HtmlcoinDB) BlockByNumber(ctx context.Context, chainId int, blockNum int64) (*StoredBlock, error) {
+	row := q.db.QueryRowContext(ctx, `SELECT `+storedBlockColumns+` FROM "Hashes" WHERE "BlockNum" = $1 AND "ChainId" = $2 ORDER BY "IngestedAt" DESC NULLS LAST LIMIT 1`, blockNum, chainId)
+	return scanStoredBlock(row)
+}
+
+func scanStoredBlock(row *sql.Row) (*StoredBlock, error) {
+	var block StoredBlock
+	var ingestedAt sql.NullTime
+	var size sql.NullInt64
+	var gasUsedRatio sql.NullFloat64
+	err := row.Scan(&block.BlockNumber, &block.ChainId, &block.EthHash, &block.HtmlcoinHash, &ingestedAt, &size, &gasUsedRatio)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ingestedAt.Valid {
+		block.IngestedAt = &ingestedAt.Time
+	}
+	if size.Valid {
+		block.Size = &size.Int64
+	}
+	if gasUsedRatio.Valid {
+		block.GasUsedRatio = &gasUsedRatio.Float64
+	}
+	return &block, nil
+}
+
+// GetStoredChainIds returns the chain ids hash pairs are stored for, in
+// ascending order
+func (q *HtmlcoinDB) GetStoredChainIds(ctx context.Context) ([]int, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT DISTINCT "ChainId" FROM "Hashes" ORDER BY "ChainId"`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chainIds := []int{}
+	for rows.Next() {
+		var chainId int
+		if err = rows.Scan(&chainId); err != nil {
+			return nil, err
+		}
+		chainIds = append(chainIds, chainId)
+	}
+	return chainIds, rows.Err()
+}
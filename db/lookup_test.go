@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestBlockLookups(t *testing.T) {
+	columns := []string{"BlockNum", "ChainId", "Eth", "Htmlcoin", "IngestedAt", "Size", "GasUsedRatio"}
+
+	t.Run("block is found by eth hash", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		ingestedAt := time.Now()
+		mock.ExpectQuery(`SELECT (.+) FROM "Hashes" WHERE "Eth" = \$1`).WithArgs("0xeth7", 4444).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(7, 4444, "0xeth7", "0xhtmlcoin7", ingestedAt, 1024, nil))
+		block, err := q.BlockByEthHash(context.Background(), 4444, "0xeth7")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block == nil || block.BlockNumber != 7 || block.HtmlcoinHash != "0xhtmlcoin7" || block.IngestedAt == nil || block.Size == nil || *block.Size != 1024 || block.GasUsedRatio != nil {
+			t.Errorf("got %+v, want block 7 with its size only", block)
+		}
+	})
+
+	t.Run("missing block is nil", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT (.+) FROM "Hashes" WHERE "BlockNum" = \$1`).WithArgs(int64(8), 4444).WillReturnRows(sqlmock.NewRows(columns))
+		block, err := q.BlockByNumber(context.Background(), 4444, 8)
+		if err != nil || block != nil {
+			t.Errorf("got %+v and %v, want no block", block, err)
+		}
+	})
+	t.Run("chain ids with stored hash pairs are listed", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT DISTINCT "ChainId" FROM "Hashes"`).WillReturnRows(sqlmock.NewRows([]string{"ChainId"}).AddRow(1).AddRow(4444))
+		chainIds, err := q.GetStoredChainIds(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chainIds) != 2 || chainIds[0] != 1 || chainIds[1] != 4444 {
+			t.Errorf("got %v, want [1 4444]", chainIds)
+		}
+	})
+}
@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// getStoredHash returns the hash stored for blockNum, empty if it isn't stored
+func (q *HtmlcoinDB) getStoredHash(ctx context.Context, chainId int, blockNum int64) (string, error) {
+	var hash string
+	err := q.db.QueryRowContext(ctx, `SELECT "Htmlcoin" FROM "Hashes" WHERE "BlockNum" = $1 AND "ChainId" = $2 LIMIT 1`, blockNum, chainId).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
+
+// deleteBlock deletes the hashes, receipts, logs and block data stored for
+// blockNum
+func (q *HtmlcoinDB) deleteBlock(ctx context.Context, chainId int, blockNum int64) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"Hashes", "Receipts", "Logs", "Blocks", "Transactions"} {
+		if _, err = tx.ExecContext(ctx, `DELETE FROM "`+table+`" WHERE "BlockNum" = $1 AND "ChainId" = $2`, blockNum, chainId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// checkParent detects a reorg from the parent hash of pair not matching the
+// stored hash of the block before it. The stale block is deleted and
+// refetched, its replacement is checked in turn, rewinding the chain until
+// hashes match again or the rewind goes deeper than the reorg depth.
+// Blocks stored after their child aren't checked
+func (q *HtmlcoinDB) checkParent(ctx context.Context, chainId int, pair jsonrpc.HashPair) error {
+	if pair.ParentHash == "" || pair.BlockNumber < 2 {
+		return nil
+	}
+	block := int64(pair.BlockNumber)
+	// the block a rewind started from
+	origin, rewinding := q.rewinds[block]
+	delete(q.rewinds, block)
+	if !rewinding {
+		origin = block
+	}
+
+	parent := block - 1
+	// the parent may still be waiting in the batch
+	stored, pending := q.pendingHash(parent)
+	var err error
+	if !pending {
+		var recent bool
+		if stored, recent = q.recentHashes[parent]; !recent {
+			if stored, err = q.getStoredHash(ctx, chainId, parent); err != nil {
+				return err
+			}
+		}
+	}
+	if stored == "" || stored == pair.ParentHash {
+		return nil
+	}
+	logger := q.logger.WithFields(logrus.Fields{
+		"block":      block,
+		"parentHash": pair.ParentHash,
+		"storedHash": stored,
+		"depth":      origin - parent,
+	})
+	if !rewinding {
+		metrics.Reorgs.Inc()
+	}
+	if origin-parent > q.reorgDepth {
+		logger.Error("Reorg deeper than the reorg depth, not rewinding further")
+		return nil
+	}
+	logger.Warn("Reorg detected, refetching the stale parent block")
+
+	if pending {
+		q.dropPending(parent)
+	} else if err = q.deleteBlock(ctx, chainId, parent); err != nil {
+		return err
+	}
+	delete(q.recentHashes, parent)
+	q.rewinds[parent] = origin
+	select {
+	case q.refetchChan <- parent:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// rememberHash keeps the hash of a committed block while it's among the
+// last reorgDepth+1 blocks, the deepest parent a rewind checks
+func (q *HtmlcoinDB) rememberHash(pair jsonrpc.HashPair) {
+	if q.recentHashes == nil || pair.HtmlcoinHash == "" {
+		return
+	}
+	block := int64(pair.BlockNumber)
+	q.recentHashes[block] = pair.HtmlcoinHash
+	if block > q.highestRecent {
+		q.highestRecent = block
+	}
+	// pruned once twice as large, keeping it amortized
+	if int64(len(q.recentHashes)) > 2*(q.reorgDepth+1) {
+		for recent := range q.recentHashes {
+			if recent <= q.highestRecent-q.reorgDepth-1 {
+				delete(q.recentHashes, recent)
+			}
+		}
+	}
+}
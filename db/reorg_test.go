@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReorgDetection(t *testing.T) {
+	storedHash := `SELECT "Htmlcoin" FROM "Hashes"`
+	expectDelete := func(mock sqlmock.Sqlmock, block int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(`DELETE FROM "Hashes"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`DELETE FROM "Receipts"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM "Logs"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM "Blocks"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`DELETE FROM "Transactions"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectCommit()
+	}
+
+	t.Run("matching parent hash is stored as is", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		refetch := make(chan int64, 1)
+		WithReorgDetection(2, refetch)(q)
+
+		mock.ExpectQuery(storedHash).WithArgs(int64(9), 4444).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin"}).AddRow("0xhtmlcoin9"))
+		if err := q.checkParent(context.Background(), 4444, jsonrpc.HashPair{BlockNumber: 10, ParentHash: "0xhtmlcoin9"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if len(refetch) != 0 {
+			t.Errorf("got %d blocks to refetch, want none", len(refetch))
+		}
+	})
+
+	t.Run("stale blocks are refetched up to the reorg depth", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		refetch := make(chan int64, 2)
+		WithReorgDetection(2, refetch)(q)
+		reorgs := testutil.ToFloat64(metrics.Reorgs)
+
+		// block 10 doesn't build on the stored 9, whose replacement doesn't
+		// build on the stored 8, which is as deep as the rewind goes
+		mock.ExpectQuery(storedHash).WithArgs(int64(9), 4444).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin"}).AddRow("0xstale9"))
+		expectDelete(mock, 9)
+		mock.ExpectQuery(storedHash).WithArgs(int64(8), 4444).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin"}).AddRow("0xstale8"))
+		expectDelete(mock, 8)
+		mock.ExpectQuery(storedHash).WithArgs(int64(7), 4444).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin"}).AddRow("0xstale7"))
+
+		ctx := context.Background()
+		for _, pair := range []jsonrpc.HashPair{
+			{BlockNumber: 10, ParentHash: "0xhtmlcoin9"},
+			{BlockNumber: 9, ParentHash: "0xhtmlcoin8"},
+			{BlockNumber: 8, ParentHash: "0xhtmlcoin7"},
+		} {
+			if err := q.checkParent(ctx, 4444, pair); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		close(refetch)
+		var blocks []int64
+		for block := range refetch {
+			blocks = append(blocks, block)
+		}
+		if len(blocks) != 2 || blocks[0] != 9 || blocks[1] != 8 {
+			t.Errorf("got blocks %v to refetch, want [9 8]", blocks)
+		}
+		if got := testutil.ToFloat64(metrics.Reorgs) - reorgs; got != 1 {
+			t.Errorf("got %v reorgs, want 1", got)
+		}
+	})
+
+	t.Run("missing parent isn't a reorg", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		WithReorgDetection(2, make(chan int64))(q)
+
+		mock.ExpectQuery(storedHash).WithArgs(int64(9), 4444).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin"}))
+		if err := q.checkParent(context.Background(), 4444, jsonrpc.HashPair{BlockNumber: 10, ParentHash: "0xhtmlcoin9"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("parents among the last blocks committed are checked without a query", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		refetch := make(chan int64, 1)
+		WithReorgDetection(2, refetch)(q)
+		for block := 1; block <= 9; block++ {
+			q.committed(jsonrpc.HashPair{BlockNumber: block, HtmlcoinHash: fmt.Sprintf("0xhtmlcoin%d", block)}, false)
+		}
+		if len(q.recentHashes) > 6 {
+			t.Errorf("got %d recent hashes kept, want at most 6", len(q.recentHashes))
+		}
+
+		ctx := context.Background()
+		if err := q.checkParent(ctx, 4444, jsonrpc.HashPair{BlockNumber: 10, ParentHash: "0xhtmlcoin9"}); err != nil {
+			t.Fatal(err)
+		}
+		// the stale row is deleted all the same
+		expectDelete(mock, 9)
+		if err := q.checkParent(ctx, 4444, jsonrpc.HashPair{BlockNumber: 10, ParentHash: "0xother9"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		if block := <-refetch; block != 9 {
+			t.Errorf("got block %d refetched, want 9", block)
+		}
+		if _, ok := q.recentHashes[9]; ok {
+			t.Error("stale hash was kept")
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Table is a table this package stores to and the statements creating it
+// and its indexes
+type Table struct {
+	Name    string
+	Create  string
+	Indexes []string
+	// stored reports whether the table is stored to with features, nil
+	// when it always is
+	stored func(Features) bool
+}
+
+// Features are the optional data stored along with the hash pairs
+type Features struct {
+	// receipts in the Receipts table and their logs in the Logs table
+	Receipts bool
+	// block headers in the Blocks table and their transactions in the
+	// Transactions table
+	Blocks bool
+}
+
+// AllFeatures stores every optional data
+var AllFeatures = Features{Receipts: true, Blocks: true}
+
+func withReceipts(f Features) bool { return f.Receipts }
+func withBlocks(f Features) bool   { return f.Blocks }
+
+var postgresSchema = []Table{
+	{
+		Name:   "Hashes",
+		Create: `CREATE TABLE IF NOT EXISTS "Hashes" ("BlockNum" int, "ChainId" int, "Eth" text, "Htmlcoin" text NOT NULL, "IngestedAt" timestamptz, "Size" int8, "GasUsedRatio" double precision, PRIMARY KEY("Eth", "ChainId"))`,
+		// blocks are looked up by number, e.g. checking a parent's hash
+		Indexes: []string{`CREATE INDEX IF NOT EXISTS "Hashes_ChainId_BlockNum" ON "Hashes" ("ChainId", "BlockNum")`},
+	},
+	{
+		Name:   "SeenBlocks",
+		Create: `CREATE TABLE IF NOT EXISTS "SeenBlocks" ("BlockNum" int, "ChainId" int, "Skipped" boolean NOT NULL DEFAULT false, PRIMARY KEY("BlockNum", "ChainId"))`,
+	},
+	{
+		Name:   "Checkpoints",
+		Create: `CREATE TABLE IF NOT EXISTS "Checkpoints" ("ChainId" int PRIMARY KEY, "Contiguous" int8 NOT NULL, "HighWater" int8 NOT NULL, "UpdatedAt" timestamptz NOT NULL, "FirstBlock" int8)`,
+	},
+	{
+		Name:   "Receipts",
+		Create: `CREATE TABLE IF NOT EXISTS "Receipts" ("TxHash" text, "BlockNum" int, "ChainId" int, "TransactionIndex" int, "GasUsed" int8, "Status" int2, "ContractAddress" text, "Logs" jsonb, PRIMARY KEY("TxHash", "BlockNum", "ChainId"))`,
+		stored: withReceipts,
+	},
+	{
+		Name:   "Logs",
+		Create: `CREATE TABLE IF NOT EXISTS "Logs" ("TxHash" text, "LogIndex" int, "BlockNum" int, "ChainId" int, "Address" text NOT NULL, "Topics" text[] NOT NULL, "Data" text NOT NULL, PRIMARY KEY("TxHash", "LogIndex", "BlockNum", "ChainId"))`,
+		stored: withReceipts,
+	},
+	{
+		Name:   "Blocks",
+		Create: `CREATE TABLE IF NOT EXISTS "Blocks" ("BlockNum" int, "ChainId" int, "Timestamp" int8 NOT NULL, "Miner" text, "GasUsed" int8 NOT NULL, "ParentHash" text, PRIMARY KEY("BlockNum", "ChainId"))`,
+		stored: withBlocks,
+	},
+	{
+		Name:   "Transactions",
+		Create: `CREATE TABLE IF NOT EXISTS "Transactions" ("TxHash" text, "BlockNum" int, "ChainId" int, "TransactionIndex" int, "From" text, "To" text, "Value" numeric(78, 0) NOT NULL, "Gas" int8 NOT NULL, "Input" text, "InputBytes" bytea, "MethodId" text, "MethodName" text, "MethodArgs" jsonb, PRIMARY KEY("TxHash", "BlockNum", "ChainId"))`,
+		stored: withBlocks,
+	},
+	{
+		Name:   "FailedBlocks",
+		Create: `CREATE TABLE IF NOT EXISTS "FailedBlocks" ("BlockNum" int, "ChainId" int, "Error" text NOT NULL, "Attempts" int NOT NULL, "FailedAt" timestamptz NOT NULL, PRIMARY KEY("BlockNum", "ChainId"))`,
+	},
+}
+
+// storeSchemas are the tables of the sql stores, those of postgres the
+// hash pairs and checkpoints are stored in
+var storeSchemas = map[string][]Table{
+	"sqlite": {
+		{
+			Name:    "Hashes",
+			Create:  `CREATE TABLE IF NOT EXISTS Hashes (BlockNum integer, ChainId integer, Eth text, Htmlcoin text NOT NULL, IngestedAt timestamp, PRIMARY KEY(Eth, ChainId))`,
+			Indexes: []string{`CREATE INDEX IF NOT EXISTS Hashes_ChainId_BlockNum ON Hashes (ChainId, BlockNum)`},
+		},
+		{Name: "SeenBlocks", Create: `CREATE TABLE IF NOT EXISTS SeenBlocks (BlockNum integer, ChainId integer, Skipped boolean NOT NULL DEFAULT false, PRIMARY KEY(BlockNum, ChainId))`},
+		{Name: "Checkpoints", Create: `CREATE TABLE IF NOT EXISTS Checkpoints (ChainId integer PRIMARY KEY, Contiguous integer NOT NULL, HighWater integer NOT NULL, UpdatedAt timestamp NOT NULL, FirstBlock integer NOT NULL)`},
+	},
+	"mysql": {
+		{Name: "Hashes", Create: `CREATE TABLE IF NOT EXISTS Hashes (BlockNum int, ChainId int, Eth varchar(66), Htmlcoin varchar(66) NOT NULL, IngestedAt datetime(6), PRIMARY KEY(Eth, ChainId), INDEX (ChainId, BlockNum))`},
+		{Name: "SeenBlocks", Create: `CREATE TABLE IF NOT EXISTS SeenBlocks (BlockNum int, ChainId int, Skipped boolean NOT NULL DEFAULT false, PRIMARY KEY(BlockNum, ChainId))`},
+		{Name: "Checkpoints", Create: `CREATE TABLE IF NOT EXISTS Checkpoints (ChainId int PRIMARY KEY, Contiguous bigint NOT NULL, HighWater bigint NOT NULL, UpdatedAt datetime(6) NOT NULL, FirstBlock bigint NOT NULL)`},
+	},
+}
+
+// Schema returns the tables this package expects for driver storing
+// features, in creation order. The sql stores only store hash pairs
+func Schema(driver string, features Features) ([]Table, error) {
+	switch driver {
+	case "postgres":
+		var schema []Table
+		for _, table := range postgresSchema {
+			if table.stored == nil || table.stored(features) {
+				schema = append(schema, table)
+			}
+		}
+		return schema, nil
+	case "sqlite", "mysql":
+		return storeSchemas[driver], nil
+	default:
+		return nil, errors.Errorf("unsupported driver %q", driver)
+	}
+}
@@ -0,0 +1,57 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSchema(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		features Features
+		want     []string
+	}{
+		{"hash pairs are stored without features", Features{}, []string{"Hashes", "SeenBlocks", "Checkpoints", "FailedBlocks"}},
+		{"receipts and blocks are stored in tables of their own", Features{Receipts: true, Blocks: true}, []string{"Hashes", "SeenBlocks", "Checkpoints", "Receipts", "Logs", "Blocks", "Transactions", "FailedBlocks"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			schema, err := Schema("postgres", test.features)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var statements []string
+			for _, table := range schema {
+				statements = append(statements, table.Create)
+				statements = append(statements, table.Indexes...)
+			}
+			ddl := strings.Join(statements, ";\n")
+			for _, table := range test.want {
+				if !strings.Contains(ddl, `CREATE TABLE IF NOT EXISTS "`+table+`"`) {
+					t.Errorf("got no %s table in\n%s", table, ddl)
+				}
+			}
+			if tables := strings.Count(ddl, "CREATE TABLE"); tables != len(test.want) {
+				t.Errorf("got %d tables, want %v", tables, test.want)
+			}
+			if !strings.Contains(ddl, `CREATE INDEX IF NOT EXISTS "Hashes_ChainId_BlockNum" ON "Hashes" ("ChainId", "BlockNum")`) {
+				t.Errorf("got no index of the hashes by block in\n%s", ddl)
+			}
+		})
+	}
+
+	t.Run("sql stores store hash pairs only", func(t *testing.T) {
+		schema, err := Schema("sqlite", AllFeatures)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(schema) != 3 {
+			t.Errorf("got %d tables, want Hashes, SeenBlocks and Checkpoints", len(schema))
+		}
+	})
+
+	t.Run("unsupported drivers are rejected", func(t *testing.T) {
+		if _, err := Schema("oracle", Features{}); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,230 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	// drivers of the sql stores
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// Store is a database hash pairs are stored to and the checkpoint of the
+// scan saved in. HtmlcoinDB is the postgres store, OpenStore opens sqlite
+// and mysql ones
+type Store interface {
+	GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error)
+	SaveHashPairs(ctx context.Context, chainId int, pairs []jsonrpc.HashPair) error
+	GetRecords() int64
+	SaveCheckpoint(ctx context.Context, chainId int, firstBlock, contiguous, highWater int64) error
+	GetCheckpoint(ctx context.Context, chainId int) (*Checkpoint, error)
+	Close() error
+}
+
+var _ Store = (*HtmlcoinDB)(nil)
+
+// SaveHashPairs stores pairs in a single transaction, skipped blocks are
+// only recorded as seen. Unlike those of the writer started by Start, the
+// pairs aren't counted by GetRecords
+func (q *HtmlcoinDB) SaveHashPairs(ctx context.Context, chainId int, pairs []jsonrpc.HashPair) error {
+	return q.withRetries(ctx, func() error {
+		tx, err := q.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, pair := range pairs {
+			if err = q.write(ctx, tx, chainId, pair, pair.Skipped || (q.skipEmptyBlocks && pair.Empty)); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+}
+
+// Close closes the connection to the database
+func (q *HtmlcoinDB) Close() error {
+	return q.db.Close()
+}
+
+// ParseDSN returns the driver a connection string is for by its scheme,
+// "sqlite:" or "mysql://", and the connection string the driver takes.
+// Others are postgres connection strings
+func ParseDSN(dsn string) (string, string) {
+	for _, driver := range []string{"sqlite", "mysql"} {
+		if rest := strings.TrimPrefix(dsn, driver+":"); rest != dsn {
+			return driver, strings.TrimPrefix(rest, "//")
+		}
+	}
+	return "postgres", dsn
+}
+
+// storeDialect is what tells the sql stores apart
+type storeDialect struct {
+	// name of the database/sql driver
+	driver string
+	// statement upserting the checkpoint row of a chain from the chain id,
+	// contiguous and high-water blocks, update time and first block
+	upsertCheckpoint string
+	// prefix of statements inserting rows unless their key is taken
+	insertIgnore string
+}
+
+var storeDialects = map[string]storeDialect{
+	"sqlite": {
+		driver: "sqlite3",
+		upsertCheckpoint: `INSERT INTO Checkpoints (ChainId, Contiguous, HighWater, UpdatedAt, FirstBlock) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (ChainId) DO UPDATE SET Contiguous = excluded.Contiguous, HighWater = excluded.HighWater, UpdatedAt = excluded.UpdatedAt, FirstBlock = excluded.FirstBlock`,
+		insertIgnore: "INSERT OR IGNORE INTO",
+	},
+	"mysql": {
+		driver: "mysql",
+		upsertCheckpoint: `INSERT INTO Checkpoints (ChainId, Contiguous, HighWater, UpdatedAt, FirstBlock) VALUES (?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE Contiguous = VALUES(Contiguous), HighWater = VALUES(HighWater), UpdatedAt = VALUES(UpdatedAt), FirstBlock = VALUES(FirstBlock)`,
+		insertIgnore: "INSERT IGNORE INTO",
+	},
+}
+
+// SQLStore stores hash pairs to a sqlite or mysql database, in the Hashes,
+// SeenBlocks and Checkpoints tables of the postgres one. Receipts and block
+// data aren't stored
+type SQLStore struct {
+	db      *sql.DB
+	dialect storeDialect
+	records int64 // accessed atomically
+}
+
+// OpenStore connects to the sqlite or mysql database of dsn, creating the
+// tables if missing. A sqlite database is created if missing, e.g. with
+// "hashes.db"; mysql ones need parseTime, e.g. "user:pass@tcp(host)/db?parseTime=true"
+func OpenStore(ctx context.Context, driver, dsn string) (*SQLStore, error) {
+	dialect, ok := storeDialects[driver]
+	if !ok {
+		return nil, errors.Errorf("unsupported store driver %q", driver)
+	}
+	db, err := sql.Open(dialect.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite" {
+		// sqlite takes one writer at a time
+		db.SetMaxOpenConns(1)
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	schema, _ := Schema(driver, Features{})
+	for _, table := range schema {
+		if _, err = db.ExecContext(ctx, table.Create); err != nil {
+			db.Close()
+			return nil, errors.WithMessagef(err, "Failed to create '%s' table", table.Name)
+		}
+		for _, index := range table.Indexes {
+			if _, err = db.ExecContext(ctx, index); err != nil {
+				db.Close()
+				return nil, errors.WithMessagef(err, "Failed to index '%s' table", table.Name)
+			}
+		}
+	}
+	return &SQLStore{db: db, dialect: dialect}, nil
+}
+
+// GetMissingBlocks returns the blocks between firstBlock and latestBlock
+// (inclusive) neither stored nor seen, in ascending order
+func (s *SQLStore) GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT BlockNum FROM Hashes WHERE ChainId = ? AND BlockNum BETWEEN ? AND ?
+	UNION SELECT BlockNum FROM SeenBlocks WHERE ChainId = ? AND BlockNum BETWEEN ? AND ?`,
+		chainId, firstBlock, latestBlock, chainId, firstBlock, latestBlock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make(map[int64]bool)
+	for rows.Next() {
+		var block int64
+		if err = rows.Scan(&block); err != nil {
+			return nil, err
+		}
+		stored[block] = true
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var missing []int64
+	for block := firstBlock; block <= latestBlock; block++ {
+		if !stored[block] {
+			missing = append(missing, block)
+		}
+	}
+	return missing, nil
+}
+
+// SaveHashPairs stores pairs in a single transaction, skipped blocks are
+// only recorded as seen. Pairs already stored are left as they are
+func (s *SQLStore) SaveHashPairs(ctx context.Context, chainId int, pairs []jsonrpc.HashPair) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var stored int64
+	for _, pair := range pairs {
+		if pair.Skipped {
+			_, err = tx.ExecContext(ctx, s.dialect.insertIgnore+` SeenBlocks (BlockNum, ChainId, Skipped) VALUES (?, ?, ?)`, pair.BlockNumber, chainId, true)
+		} else {
+			var result sql.Result
+			result, err = tx.ExecContext(ctx, s.dialect.insertIgnore+` Hashes (BlockNum, ChainId, Eth, Htmlcoin, IngestedAt) VALUES (?, ?, ?, ?, ?)`,
+				pair.BlockNumber, chainId, pair.EthHash, pair.HtmlcoinHash, time.Now().UTC())
+			if err == nil {
+				var inserted int64
+				inserted, err = result.RowsAffected()
+				stored += inserted
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.records, stored)
+	return nil
+}
+
+// GetRecords returns how many hash pairs were stored
+func (s *SQLStore) GetRecords() int64 {
+	return atomic.LoadInt64(&s.records)
+}
+
+// SaveCheckpoint upserts the checkpoint row of chainId
+func (s *SQLStore) SaveCheckpoint(ctx context.Context, chainId int, firstBlock, contiguous, highWater int64) error {
+	_, err := s.db.ExecContext(ctx, s.dialect.upsertCheckpoint, chainId, contiguous, highWater, time.Now().UTC(), firstBlock)
+	return err
+}
+
+// GetCheckpoint returns the checkpoint row of chainId, nil if none was saved yet
+func (s *SQLStore) GetCheckpoint(ctx context.Context, chainId int) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	err := s.db.QueryRowContext(ctx, `SELECT FirstBlock, Contiguous, HighWater, UpdatedAt FROM Checkpoints WHERE ChainId = ?`, chainId).
+		Scan(&checkpoint.FirstBlock, &checkpoint.Contiguous, &checkpoint.HighWater, &checkpoint.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// Close closes the connection to the database
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,48 @@
+package db
+
+import (
+	"context"
+
+	"github.com/lib/pq"
+)
+
+// DuplicateHash is a htmlcoin hash stored for several blocks, which at most
+// one of them can have
+type DuplicateHash struct {
+	HtmlcoinHash string
+	// in ascending order
+	Blocks []int64
+}
+
+// GetDuplicateHashes returns the htmlcoin hashes stored for several blocks
+// between firstBlock and lastBlock (inclusive), ordered by their first block
+func (q *HtmlcoinDB) GetDuplicateHashes(ctx context.Context, chainId int, firstBlock, lastBlock int64) ([]DuplicateHash, error) {
+	selectStatement := `SELECT "Htmlcoin", array_agg(DISTINCT "BlockNum"::int8 ORDER BY "BlockNum"::int8) FROM "Hashes" WHERE "ChainId" = $1 AND "BlockNum" BETWEEN $2 AND $3
+	GROUP BY "Htmlcoin" HAVING count(DISTINCT "BlockNum") > 1 ORDER BY min("BlockNum")`
+	rows, err := q.db.QueryContext(ctx, selectStatement, chainId, firstBlock, lastBlock)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	duplicates := []DuplicateHash{}
+	for rows.Next() {
+		var duplicate DuplicateHash
+		if err = rows.Scan(&duplicate.HtmlcoinHash, pq.Array(&duplicate.Blocks)); err != nil {
+			return nil, err
+		}
+		duplicates = append(duplicates, duplicate)
+	}
+	return duplicates, rows.Err()
+}
+
+// RequeueBlocks deletes the data stored for blocks so they are missing
+// again and the next scan refetches them
+func (q *HtmlcoinDB) RequeueBlocks(ctx context.Context, chainId int, blocks []int64) error {
+	for _, block := range blocks {
+		if err := q.deleteBlock(ctx, chainId, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
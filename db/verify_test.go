@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestVerifyQueries(t *testing.T) {
+	t.Run("hashes stored for several blocks are duplicates", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT "Htmlcoin", array_agg(.+) FROM "Hashes" (.+) HAVING count`).WithArgs(4444, int64(1), int64(100)).
+			WillReturnRows(sqlmock.NewRows([]string{"Htmlcoin", "Blocks"}).AddRow("0xh7", "{7,9}").AddRow("0xh20", "{20,21,22}"))
+		duplicates, err := q.GetDuplicateHashes(context.Background(), 4444, 1, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []DuplicateHash{{HtmlcoinHash: "0xh7", Blocks: []int64{7, 9}}, {HtmlcoinHash: "0xh20", Blocks: []int64{20, 21, 22}}}
+		if !reflect.DeepEqual(duplicates, want) {
+			t.Errorf("got %+v, want %+v", duplicates, want)
+		}
+	})
+
+	t.Run("requeued blocks are deleted from every table", func(t *testing.T) {
+		q, mock := newMockDB(t)
+		for _, block := range []int64{3, 5} {
+			mock.ExpectBegin()
+			for _, table := range []string{"Hashes", "Receipts", "Logs", "Blocks", "Transactions"} {
+				mock.ExpectExec(`DELETE FROM "`+table+`"`).WithArgs(block, 4444).WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+			mock.ExpectCommit()
+		}
+		if err := q.RequeueBlocks(context.Background(), 4444, []int64{3, 5}); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -3,13 +3,16 @@ package dispatcher
 import (
 	"context"
 	"math/rand"
-	"net/url"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/denuoweb/ethereum-block-processor/cache"
 	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/denuoweb/ethereum-block-processor/slo"
 	"github.com/denuoweb/ethereum-block-processor/workers"
 	"github.com/sirupsen/logrus"
 )
@@ -24,10 +27,50 @@ type dispatcher struct {
 	blockCache         *cache.BlockCache
 	latestBlock        int64
 	firstBlock         int64
-	urls               []*url.URL
+	providers          []*jsonrpc.Provider
 	logger             *logrus.Entry
-	dispatchedBlocks   int64
+	dispatchedBlocks   int64 // accessed atomically
+	completedBlocks    int64 // accessed atomically
 	workers            *workers.Workers
+	stuckWorkerTimeout time.Duration
+	decodeWorkers      int
+	validateBlockNum   bool
+	chainId            int64
+	chainIdInterval    time.Duration
+	latencyTracker     *slo.Tracker
+	skippedAttempts    int
+	rangeSize          int
+	batchSize          int
+	withReceipts       bool
+	onlySuccessful     bool
+	storeBlocks        bool
+	logFields          logrus.Fields
+	deadLetterAttempts int
+	maxFailures        int
+	newHeads           <-chan int64
+	refetchChan        <-chan int64
+	pool               *jsonrpc.Pool
+	weightedProviders  bool
+	workersWaitGroup   sync.WaitGroup
+	// blocks dispatched whose processing hasn't completed
+	inFlight      map[int64]struct{}
+	inFlightMutex sync.Mutex
+	// refetches in flight, at most refetchLimit of them when it's set, by
+	// the failed blocks recorded when they were queued
+	refetchLimit int
+	refetching   map[int64]int
+	refetchMutex sync.Mutex
+	refetchFreed chan struct{}
+	// completions from workers, before they're forwarded, and the context
+	// forwarding them stops with
+	interceptChan chan int64
+	interceptCtx  context.Context
+	flushed       chan struct{}
+	// sizes the workers every autoscaleInterval when set
+	autoscaler        *workers.Autoscaler
+	autoscaleInterval time.Duration
+	// keep scanning for new blocks once the missing ones are processed
+	follow bool
 
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -36,19 +79,185 @@ type dispatcher struct {
 
 type EthJSONRPC func(ctx context.Context, method string, params ...interface{})
 
+type Option func(d *dispatcher)
+
+// WithStuckWorkerTimeout replaces workers that make no progress on a block
+// within timeout, re-enqueuing the block. A timeout of 0 disables it
+func WithStuckWorkerTimeout(timeout time.Duration) Option {
+	return func(d *dispatcher) {
+		d.stuckWorkerTimeout = timeout
+	}
+}
+
+// WithDecodeWorkers caps the number of blocks decoded at once to
+// decodeWorkers, independently of the number of workers fetching blocks
+func WithDecodeWorkers(decodeWorkers int) Option {
+	return func(d *dispatcher) {
+		d.decodeWorkers = decodeWorkers
+	}
+}
+
+// WithBlockNumberValidation rejects fetched blocks whose number isn't the
+// requested one, so they are retried instead of stored
+func WithBlockNumberValidation(validate bool) Option {
+	return func(d *dispatcher) {
+		d.validateBlockNum = validate
+	}
+}
+
+// WithChainIdVerification verifies every interval that the providers still
+// serve chainId, quarantining those that don't. An interval of 0 disables it
+func WithChainIdVerification(chainId int64, interval time.Duration) Option {
+	return func(d *dispatcher) {
+		d.chainId = chainId
+		d.chainIdInterval = interval
+	}
+}
+
+// WithSkippedBlockAttempts records blocks every provider reported not found
+// attempts times as skipped, so the missing blocks don't stall on block
+// numbers that don't exist. 0 disables it
+func WithSkippedBlockAttempts(attempts int) Option {
+	return func(d *dispatcher) {
+		d.skippedAttempts = attempts
+	}
+}
+
+// WithRangeSize fetches up to size contiguous blocks in a single request
+// from providers with a range method
+func WithRangeSize(size int) Option {
+	return func(d *dispatcher) {
+		d.rangeSize = size
+	}
+}
+
+// WithBatchSize fetches up to size queued blocks in a single json rpc batch
+// request, from providers without a range method
+func WithBatchSize(size int) Option {
+	return func(d *dispatcher) {
+		d.batchSize = size
+	}
+}
+
+// WithReceipts has workers fetch the receipts of every block's transactions
+func WithReceipts(fetch bool) Option {
+	return func(d *dispatcher) {
+		d.withReceipts = fetch
+	}
+}
+
+// WithOnlySuccessfulTransactions has workers leave the transactions that
+// reverted, with their receipts and logs, out of the blocks they hand over.
+// It needs receipts
+func WithOnlySuccessfulTransactions(only bool) Option {
+	return func(d *dispatcher) {
+		d.onlySuccessful = only
+	}
+}
+
+// WithLogFields adds fields to every line logged by the dispatcher and its
+// workers, e.g. the chain they scan
+func WithLogFields(fields logrus.Fields) Option {
+	return func(d *dispatcher) {
+		d.logFields = fields
+		d.logger = d.logger.WithFields(fields)
+	}
+}
+
+// WithStoreBlocks has workers hand over the header and the transactions of
+// every block along with its hashes
+func WithStoreBlocks(store bool) Option {
+	return func(d *dispatcher) {
+		d.storeBlocks = store
+	}
+}
+
+// WithDeadLetters records blocks that failed attempts times as failed and
+// carries on scanning without them, aborting once more than maxFailures
+// blocks are. An attempts of 0 retries failed blocks indefinitely
+func WithDeadLetters(attempts, maxFailures int) Option {
+	return func(d *dispatcher) {
+		d.deadLetterAttempts = attempts
+		d.maxFailures = maxFailures
+	}
+}
+
+// WithNewHeads reloads the missing blocks as soon as heads signals a new
+// head while the dispatcher is idle, rather than on its next poll
+func WithNewHeads(heads <-chan int64) Option {
+	return func(d *dispatcher) {
+		d.newHeads = heads
+	}
+}
+
+// WithRefetch refetches the blocks received on blocks, e.g. those a reorg
+// left stale in the database
+func WithRefetch(blocks <-chan int64) Option {
+	return func(d *dispatcher) {
+		d.refetchChan = blocks
+	}
+}
+
+// WithRefetchConcurrency bounds the refetches in flight to limit, so they
+// don't compete with the blocks dispatched for provider capacity. Refetches
+// are already picked up by workers only while no dispatched block is queued.
+// A limit of 0 leaves them unbounded
+func WithRefetchConcurrency(limit int) Option {
+	return func(d *dispatcher) {
+		d.refetchLimit = limit
+	}
+}
+
+// WithProviderPool has workers call their provider through pool, failing
+// over to the other providers while it's unhealthy
+func WithProviderPool(pool *jsonrpc.Pool) Option {
+	return func(d *dispatcher) {
+		d.pool = pool
+	}
+}
+
+// WithAutoscale grows and shrinks the workers between minWorkers and
+// maxWorkers every interval, by the provider latency and error rate the pool
+// observes and the blocks queued up for the workers. A maxWorkers of 0
+// disables it
+func WithAutoscale(minWorkers, maxWorkers int, interval time.Duration) Option {
+	return func(d *dispatcher) {
+		if maxWorkers > 0 {
+			d.autoscaler = &workers.Autoscaler{Min: minWorkers, Max: maxWorkers}
+		}
+		d.autoscaleInterval = interval
+	}
+}
+
+// WithWeightedProviders has workers call a provider of the pool picked by
+// its observed throughput rather than their own, when weighted is set
+func WithWeightedProviders(weighted bool) Option {
+	return func(d *dispatcher) {
+		d.weightedProviders = weighted
+	}
+}
+
+// WithLatencyTracker records the dispatch of every block on tracker
+func WithLatencyTracker(tracker *slo.Tracker) Option {
+	return func(d *dispatcher) {
+		d.latencyTracker = tracker
+	}
+}
+
 func NewDispatcher(
 	blockChan chan int64,
 	resultChan chan jsonrpc.HashPair,
 	completedBlockChan chan int64,
-	urls []*url.URL,
+	providers []*jsonrpc.Provider,
 	blockFrom int64,
 	blockTo int64,
 	done chan struct{},
 	errChan chan error,
 	blockCache *cache.BlockCache,
+	opts ...Option,
 ) *dispatcher {
 	dispatchLogger, _ := log.GetLogger()
-	return &dispatcher{
+	d := &dispatcher{
 		blockChan:          blockChan,
 		failedBlocksChan:   make(chan int64, 4),
 		resultChan:         resultChan,
@@ -56,14 +265,26 @@ func NewDispatcher(
 		completedBlockChan: completedBlockChan,
 		done:               done,
 		errChan:            errChan,
-		urls:               urls,
+		providers:          providers,
 		logger:             dispatchLogger.WithField("module", "dispatcher"),
 		latestBlock:        blockFrom,
 		firstBlock:         blockTo,
 		workers:            workers.NewWorkers(),
+		inFlight:           map[int64]struct{}{},
+		refetching:         map[int64]int{},
+		refetchFreed:       make(chan struct{}, 1),
+		flushed:            make(chan struct{}),
 	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
 }
 
+// Shutdown stops dispatching blocks. Blocks already dispatched are still
+// processed, Wait returns once the workers have finished them
 func (d *dispatcher) Shutdown() {
 	d.ctxMutex.Lock()
 	defer d.ctxMutex.Unlock()
@@ -79,7 +300,7 @@ func (d *dispatcher) Shutdown() {
 func (d *dispatcher) Start(
 	ctx context.Context,
 	numWorkers int,
-	providers []*url.URL,
+	providers []*jsonrpc.Provider,
 	keepScaningForNewBlocks bool,
 ) bool {
 	d.ctxMutex.Lock()
@@ -92,6 +313,7 @@ func (d *dispatcher) Start(
 
 	d.ctx = completedBlockChanCtx
 	d.ctxCancel = completedBlockChanCancel
+	d.follow = keepScaningForNewBlocks
 
 	d.ctxMutex.Unlock()
 
@@ -99,19 +321,27 @@ func (d *dispatcher) Start(
 
 	d.blockCache.UpdateMissingBlocks(completedBlockChanCtx)
 
-	var wg sync.WaitGroup
-	var blocksProcessingWaitGroup sync.WaitGroup
-	blocksProcessingFinished := make(chan struct{})
-
-	var completedBlocks int
 	completedBlockInterceptChan := make(chan int64, numWorkers)
+	d.interceptChan = completedBlockInterceptChan
+	d.interceptCtx = ctx
 
 	go func() {
 		for {
 			select {
 			case block := <-completedBlockInterceptChan:
+				if block == flushMarker {
+					select {
+					case d.flushed <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+					continue
+				}
+				d.untrackInFlight(block)
+				d.releaseRefetch(block)
 				d.completedBlockChan <- block
-				completedBlocks++
+				atomic.AddInt64(&d.completedBlocks, 1)
+				metrics.BlocksCompleted.Inc()
 			case <-ctx.Done():
 				return
 			}
@@ -126,9 +356,34 @@ func (d *dispatcher) Start(
 		completedBlockInterceptChan,
 		d.resultChan,
 		providers,
-		&wg,
+		&d.workersWaitGroup,
 		d.errChan,
+		workers.WithDecodeWorkers(d.decodeWorkers),
+		workers.WithBlockNumberValidation(d.validateBlockNum),
+		workers.WithSkippedBlockAttempts(d.skippedAttempts),
+		workers.WithRangeSize(d.rangeSize),
+		workers.WithBatchSize(d.batchSize),
+		workers.WithReceipts(d.withReceipts),
+		workers.WithOnlySuccessfulTransactions(d.onlySuccessful),
+		workers.WithStoreBlocks(d.storeBlocks),
+		workers.WithDeadLetters(d.deadLetterAttempts, d.maxFailures),
+		workers.WithProviderPool(d.pool, d.weightedProviders),
+		workers.WithLogFields(d.logFields),
 	)
+	d.workers = workerState
+
+	if d.refetchChan != nil {
+		go d.refetch(completedBlockChanCtx)
+	}
+	if d.autoscaler != nil {
+		go d.autoscale(completedBlockChanCtx, workerState)
+	}
+	if d.stuckWorkerTimeout > 0 {
+		go workerState.MonitorHeartbeats(completedBlockChanCtx, d.stuckWorkerTimeout, d.failedBlocksChan)
+	}
+	if d.chainIdInterval > 0 {
+		go workerState.MonitorChainIds(completedBlockChanCtx, d.chainIdInterval, d.chainId, d.failedBlocksChan, d.errChan)
+	}
 
 	go func() {
 		for {
@@ -142,8 +397,8 @@ func (d *dispatcher) Start(
 
 			d.logger.Infof(
 				"Block hash computation statistics: dispatched: %d, completed: %d, failures: %d",
-				d.dispatchedBlocks,
-				completedBlocks,
+				d.GetDispatchedBlocks(),
+				d.GetCompletedBlocks(),
 				totalFailedBlocks,
 			)
 		}
@@ -153,25 +408,15 @@ func (d *dispatcher) Start(
 		processingMissingBlocksComplete := make(chan struct{})
 
 		// stopping means just canceling the context
-		go d.processMissingBlocks(completedBlockChanCtx, blocksProcessingWaitGroup, processingMissingBlocksComplete)
+		go d.processMissingBlocks(completedBlockChanCtx, processingMissingBlocksComplete)
 		// go d.processFailedBlocks(completedBlockChanCtx, workerState)
 
 		if keepScaningForNewBlocks {
-			<-ctx.Done()
-
+			<-completedBlockChanCtx.Done()
 		} else {
-			go func() {
-				blocksProcessingWaitGroup.Wait()
-				blocksProcessingFinished <- struct{}{}
-			}()
-
-			// need to wait for workers to finish processing blocks
+			// processMissingBlocks returns once every missing block has been
+			// processed
 			d.logger.Info("Waiting for blocks to finish processing")
-			select {
-			case <-blocksProcessingFinished:
-				d.logger.Info("blocks finished processing")
-			case <-ctx.Done():
-			}
 		}
 
 		// wait for processMissingBlocks to exit before we close d.blockChan
@@ -181,14 +426,18 @@ func (d *dispatcher) Start(
 		d.logger.Info("closing block channel")
 		close(d.blockChan)
 		d.logger.Debug("finished dispatching blocks")
-		d.done <- struct{}{}
+		select {
+		case d.done <- struct{}{}:
+		case <-ctx.Done():
+		}
 	}()
 
 	return true
 }
 
-// Loops indefinitely checking for new blocks
-func (d *dispatcher) processMissingBlocks(ctx context.Context, blocksProcessingWaitGroup sync.WaitGroup, finished chan struct{}) {
+// Loops checking for new blocks, indefinitely when following the chain and
+// otherwise until every missing block has been processed
+func (d *dispatcher) processMissingBlocks(ctx context.Context, finished chan struct{}) {
 	queuedBlocks := make(map[int64]bool)
 	defer func() {
 		finished <- struct{}{}
@@ -197,11 +446,21 @@ func (d *dispatcher) processMissingBlocks(ctx context.Context, blocksProcessingW
 	dispatch := func(blockToTry int64) bool {
 		if _, ok := queuedBlocks[blockToTry]; !ok {
 			d.logger.Infof("Queuing up block: %d\n", blockToTry)
-			blocksProcessingWaitGroup.Add(1)
-			d.blockChan <- int64(blockToTry)
+			d.latencyTracker.Dispatched(blockToTry)
+			// tracked before it's sent, a worker may complete it right away
+			tracked := d.trackInFlight(blockToTry)
+			select {
+			case d.blockChan <- int64(blockToTry):
+			case <-ctx.Done():
+				if tracked {
+					d.untrackInFlight(blockToTry)
+				}
+				return false
+			}
 			queuedBlocks[blockToTry] = true
 			dispatched++
-			d.dispatchedBlocks++
+			atomic.AddInt64(&d.dispatchedBlocks, 1)
+			metrics.BlocksDispatched.Inc()
 			return true
 		}
 
@@ -232,12 +491,21 @@ func (d *dispatcher) processMissingBlocks(ctx context.Context, blocksProcessingW
 
 		if len(missingBlocks)-dispatched == 0 {
 			d.logger.Info("No missing blocks")
+			// blocks that failed are still in the backlog, they're retried
+			// once the missing blocks are reloaded
+			if !d.follow && d.blockCache.Drained() {
+				d.logger.Info("All missing blocks processed")
+				return
+			}
 			if len(missingBlocks) != 0 {
 				// clear queuedBlocks
 				queuedBlocks = make(map[int64]bool)
 			}
 			select {
 			case <-time.After(10 * time.Second):
+			case head := <-d.newHeads:
+				d.logger.Info("New head mined: ", head)
+				d.blockCache.Expire()
 			case <-ctx.Done():
 				return
 			}
@@ -254,8 +522,8 @@ func (d *dispatcher) processMissingBlocks(ctx context.Context, blocksProcessingW
 			}
 
 			if !successfullyDispatched {
-				for i := int64(0); i < int64(len(missingBlocks)); i++ {
-					if dispatch(i) {
+				for _, blockToTry := range missingBlocks {
+					if dispatch(blockToTry) {
 						successfullyDispatched = true
 						break
 					}
@@ -270,6 +538,182 @@ func (d *dispatcher) processMissingBlocks(ctx context.Context, blocksProcessingW
 	}
 }
 
+// refetch queues the blocks to refetch for workers along with failed blocks,
+// waiting for a refetch slot when they're bounded
+func (d *dispatcher) refetch(ctx context.Context) {
+	for {
+		select {
+		case block := <-d.refetchChan:
+			if !d.acquireRefetch(ctx, block) {
+				return
+			}
+			d.logger.Info("Refetching block: ", block)
+			tracked := d.trackInFlight(block)
+			select {
+			case d.failedBlocksChan <- block:
+			case <-ctx.Done():
+				if tracked {
+					d.untrackInFlight(block)
+				}
+				d.releaseRefetch(block)
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// acquireRefetch waits for fewer than the refetch limit of refetches to be in
+// flight and adds block to them, reporting false once ctx is cancelled. While
+// waiting, the slots of refetches workers failed since they were queued are
+// reclaimed every second, as failed blocks don't complete
+func (d *dispatcher) acquireRefetch(ctx context.Context, block int64) bool {
+	if d.refetchLimit <= 0 {
+		return true
+	}
+	for {
+		d.refetchMutex.Lock()
+		if len(d.refetching) < d.refetchLimit {
+			d.refetching[block] = len(d.workers.GetFailedBlocks())
+			d.refetchMutex.Unlock()
+			return true
+		}
+		d.refetchMutex.Unlock()
+
+		select {
+		case <-d.refetchFreed:
+		case <-time.After(time.Second):
+			d.reclaimFailedRefetches()
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// reclaimFailedRefetches frees the refetch slots of blocks that failed after
+// they were queued
+func (d *dispatcher) reclaimFailedRefetches() {
+	failed := d.workers.GetFailedBlocks()
+	d.refetchMutex.Lock()
+	defer d.refetchMutex.Unlock()
+	for block, queuedAt := range d.refetching {
+		if queuedAt > len(failed) {
+			// the failed blocks were reset since
+			queuedAt = 0
+		}
+		for _, failedBlock := range failed[queuedAt:] {
+			if failedBlock == block {
+				delete(d.refetching, block)
+				break
+			}
+		}
+	}
+}
+
+// releaseRefetch frees the refetch slot of block, if it's being refetched
+func (d *dispatcher) releaseRefetch(block int64) {
+	if d.refetchLimit <= 0 {
+		return
+	}
+	d.refetchMutex.Lock()
+	defer d.refetchMutex.Unlock()
+	if _, ok := d.refetching[block]; !ok {
+		return
+	}
+	delete(d.refetching, block)
+	select {
+	case d.refetchFreed <- struct{}{}:
+	default:
+	}
+}
+
+// trackInFlight adds block to the blocks in flight, reporting whether it
+// wasn't already, e.g. dispatched again after failing
+func (d *dispatcher) trackInFlight(block int64) bool {
+	d.inFlightMutex.Lock()
+	defer d.inFlightMutex.Unlock()
+	if _, ok := d.inFlight[block]; ok {
+		return false
+	}
+	d.inFlight[block] = struct{}{}
+	return true
+}
+
+func (d *dispatcher) untrackInFlight(block int64) {
+	d.inFlightMutex.Lock()
+	defer d.inFlightMutex.Unlock()
+	delete(d.inFlight, block)
+}
+
+// autoscale resizes the workers every autoscale interval until ctx is
+// cancelled
+func (d *dispatcher) autoscale(ctx context.Context, workerState *workers.Workers) {
+	ticker := time.NewTicker(d.autoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		latency, errorRate := d.providerHealth()
+		size := workerState.Size()
+		queued := len(d.blockChan) > 0
+		next := d.autoscaler.Next(size, queued, d.blockCache.GetBacklog() > 0, latency, errorRate)
+		if next == size {
+			continue
+		}
+		d.logger.WithFields(logrus.Fields{
+			"workers":   workerState.Scale(next),
+			"previous":  size,
+			"latency":   latency,
+			"errorRate": errorRate,
+			"queued":    len(d.blockChan),
+		}).Info("Scaled workers")
+	}
+}
+
+// providerHealth returns the mean latency of the healthy providers of the
+// pool, 0 when unknown, and the mean error rate of every provider
+func (d *dispatcher) providerHealth() (time.Duration, float64) {
+	if d.pool == nil {
+		return 0, 0
+	}
+	stats := d.pool.Stats()
+	var latency time.Duration
+	var errorRate float64
+	answered := 0
+	for _, provider := range stats {
+		errorRate += provider.ErrorRate / float64(len(stats))
+		if provider.Healthy && provider.Latency > 0 {
+			latency += provider.Latency
+			answered++
+		}
+	}
+	if answered > 0 {
+		latency /= time.Duration(answered)
+	}
+	return latency, errorRate
+}
+
+// UnfinishedBlocks returns the blocks dispatched whose processing hasn't
+// completed, in ascending order: those workers are still processing, those
+// that failed and those never picked up. After Wait they're the blocks a
+// shutdown left unfinished
+func (d *dispatcher) UnfinishedBlocks() []int64 {
+	d.inFlightMutex.Lock()
+	defer d.inFlightMutex.Unlock()
+
+	blocks := make([]int64, 0, len(d.inFlight))
+	for block := range d.inFlight {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	return blocks
+}
+
 func (d *dispatcher) processFailedBlocks(ctx context.Context, workerState *workers.Workers) {
 	attempts := 0
 	for {
@@ -318,6 +762,42 @@ func (d *dispatcher) processFailedBlocks(ctx context.Context, workerState *worke
 
 // }
 
+// flushMarker is sent after the completions of exited workers, it's
+// acknowledged once those before it are forwarded
+const flushMarker = -1
+
+// Wait blocks until every worker has exited, which happens once the block
+// channel is closed and drained or the context Start was given is canceled,
+// and the blocks they completed are forwarded
+func (d *dispatcher) Wait() {
+	d.workersWaitGroup.Wait()
+	if d.interceptChan == nil {
+		return
+	}
+	select {
+	case d.interceptChan <- flushMarker:
+	case <-d.interceptCtx.Done():
+		return
+	}
+	select {
+	case <-d.flushed:
+	case <-d.interceptCtx.Done():
+	}
+}
+
 func (d *dispatcher) GetDispatchedBlocks() int64 {
-	return d.dispatchedBlocks
+	return atomic.LoadInt64(&d.dispatchedBlocks)
+}
+
+// GetCompletedBlocks returns the number of blocks workers have completed
+func (d *dispatcher) GetCompletedBlocks() int64 {
+	return atomic.LoadInt64(&d.completedBlocks)
+}
+
+// Workers returns the number of workers running, 0 before Start
+func (d *dispatcher) Workers() int {
+	if d.workers == nil {
+		return 0
+	}
+	return d.workers.Size()
 }
@@ -3,135 +3,286 @@ package dispatcher
 import (
 	"bytes"
 	"context"
-	"fmt"
-	"net/http"
-	"net/http/httptest"
-	"net/url"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/denuoweb/ethereum-block-processor/log"
-	"github.com/denuoweb/ethereum-block-processor/workers"
 )
 
-func TestDispatcher(t *testing.T) {
+// testRun is a dispatcher fetching the missing blocks of a fake database
+// from synthetic providers, the database storing the results it's sent
+type testRun struct {
+	d    *dispatcher
+	done chan struct{}
+
+	mutex  sync.Mutex
+	stored map[int]int
+}
 
+func newTestRun(t *testing.T, provider string, missing []int64, opts ...Option) *testRun {
+	t.Helper()
 	buffer := bytes.Buffer{}
-	log.GetLogger(log.WithDebugLevel(true), log.WithWriter(&buffer))
-	server := makeJSONRPCServer()
-	defer server.Close()
-	urlsGood := []*url.URL{
-		{ // HTMLCOIN
-			Scheme: "http",
-			Host:   server.Listener.Addr().String(),
-			Path:   "/eth_getBlockByNumber",
-		},
+	log.GetLogger(log.WithWriter(&buffer))
+	p, err := jsonrpc.ParseProvider(provider)
+	if err != nil {
+		t.Fatal(err)
 	}
-	urlJSONerr := []*url.URL{
-		{ // jsonError
-			Scheme: "http",
-			Host:   server.Listener.Addr().String(),
-			Path:   "/jsonError",
-		},
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	r := &testRun{done: make(chan struct{}), stored: make(map[int]int)}
+	blockCache := cache.NewBlockCache(ctx, func(ctx context.Context) ([]int64, error) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		var left []int64
+		for _, block := range missing {
+			if r.stored[int(block)] == 0 {
+				left = append(left, block)
+			}
+		}
+		return left, nil
+	})
+	resultChan := make(chan jsonrpc.HashPair, 8)
+	completedBlockChan := make(chan int64, 8)
+	go func() {
+		for {
+			select {
+			case pair := <-resultChan:
+				r.mutex.Lock()
+				r.stored[pair.BlockNumber]++
+				r.mutex.Unlock()
+			case block := <-completedBlockChan:
+				blockCache.CompleteBlock(block)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	r.d = NewDispatcher(make(chan int64, 8), resultChan, completedBlockChan, []*jsonrpc.Provider{p}, 0, 0, r.done, make(chan error, 8), blockCache, opts...)
+	return r
+}
+
+// wait waits for the dispatcher to be done and its workers to exit
+func (r *testRun) wait(t *testing.T, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+		t.Fatal("timed out waiting for the dispatcher")
 	}
-	// urlHTTPerr := []*url.URL{
-	// 	{ // httpError
-	// 		Scheme: "http",
-	// 		Host:   server.Listener.Addr().String(),
-	// 		Path:   "/httpError",
-	// 	},
-	// }
-
-	t.Run("dispatcher queries rpc endpoint for latest block and dispatches it", func(t *testing.T) {
-		want := "0x5"
-		errChan := make(chan error)
-		got := createAndStartDispatcher(urlsGood, 0, 0, errChan)
-		if got[0] != want {
-			t.Errorf("got %s, want %s", got, want)
+	r.d.Wait()
+}
+
+func (r *testRun) storedBlocks() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.stored)
+}
+
+func blockRange(first, last int64) []int64 {
+	var blocks []int64
+	for block := first; block <= last; block++ {
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestDispatcher(t *testing.T) {
+	t.Run("every missing block is dispatched until they're all processed", func(t *testing.T) {
+		missing := blockRange(1, 30)
+		r := newTestRun(t, "synthetic://?latency=1ms&head=100", missing)
+		r.d.Start(context.Background(), 2, r.d.providers, false)
+		r.wait(t, 30*time.Second)
+
+		for _, block := range missing {
+			if r.stored[int(block)] == 0 {
+				t.Errorf("block %d wasn't processed", block)
+			}
+		}
+		if completed, dispatched := r.d.GetCompletedBlocks(), r.d.GetDispatchedBlocks(); completed != dispatched || completed < int64(len(missing)) {
+			t.Errorf("got %d blocks completed of %d dispatched, want every missing block", completed, dispatched)
+		}
+		if unfinished := r.d.UnfinishedBlocks(); len(unfinished) != 0 {
+			t.Errorf("got blocks %v unfinished", unfinished)
 		}
 	})
 
-	t.Run("dispatcher receives a range of blocks and dispatches them", func(t *testing.T) {
-		want := []string{"0xa", "0x9", "0x8", "0x7", "0x6"}
-		errChan := make(chan error)
-		got := createAndStartDispatcher(urlsGood, 10, 5, errChan)
+	t.Run("blocks already dispatched are processed once shut down", func(t *testing.T) {
+		missing := blockRange(1, 500)
+		r := newTestRun(t, "synthetic://?latency=10ms&head=1000", missing)
+		r.d.Start(context.Background(), 2, r.d.providers, false)
+		// progress is read while blocks are dispatched
+		for r.storedBlocks() == 0 || r.d.GetDispatchedBlocks() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		r.d.Shutdown()
+		r.wait(t, 10*time.Second)
 
-		if reflect.DeepEqual(got, want) == false {
-			t.Errorf("got %v, want %v", got, want)
+		completed, dispatched := r.d.GetCompletedBlocks(), r.d.GetDispatchedBlocks()
+		if completed != dispatched || dispatched >= int64(len(missing)) {
+			t.Errorf("got %d blocks completed of %d dispatched, want those dispatched before shutting down processed", completed, dispatched)
+		}
+		if unfinished := r.d.UnfinishedBlocks(); len(unfinished) != 0 {
+			t.Errorf("got blocks %v unfinished", unfinished)
 		}
 	})
 
-	t.Run("dispatcher retries failed blocks #9, #8, #7", func(t *testing.T) {
-		want := []string{"0xa", "0x9", "0x8", "0x7", "0x6", "0x9", "0x8", "0x7"}
-		workers.SetFailedBlocks([]int64{9, 8, 7})
-		errChan := make(chan error)
-		got := createAndStartDispatcher(urlsGood, 10, 5, errChan)
+	t.Run("failed blocks #9, #8, #7 are retried", func(t *testing.T) {
+		r := newTestRun(t, "synthetic://", nil)
+		r.d.workers.SetFailedBlocks([]int64{9, 8, 7})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.d.processFailedBlocks(ctx, r.d.workers)
 
-		if reflect.DeepEqual(got, want) == false {
+		var got []int64
+		for len(got) < 3 {
+			select {
+			case block := <-r.d.failedBlocksChan:
+				got = append(got, block)
+			case <-time.After(time.Second):
+				t.Fatalf("got blocks %v retried, want 3", got)
+			}
+		}
+		if want := []int64{9, 8, 7}; !reflect.DeepEqual(got, want) {
 			t.Errorf("got %v, want %v", got, want)
 		}
+		if failed := r.d.workers.GetFailedBlocks(); len(failed) != 0 {
+			t.Errorf("got failed blocks %v left, want them reset", failed)
+		}
 	})
 
-	t.Run("dispatcher send error to chsnnel when receiving bad rpc response", func(t *testing.T) {
-		errChan := make(chan error)
-		blockChan := make(chan string)
-		done := make(chan struct{})
-		dispatcher := NewDispatcher(blockChan, urlJSONerr, 0, 0, done, errChan)
-		dispatcher.Start(context.Background())
+	t.Run("refetched blocks are queued for workers and in flight", func(t *testing.T) {
+		refetch := make(chan int64, 1)
+		r := newTestRun(t, "synthetic://", nil, WithRefetch(refetch))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go r.d.refetch(ctx)
+
+		refetch <- 42
 		select {
-		case err := <-errChan:
-			if err != nil {
-				t.Errorf("got %v, want %v", err, nil)
+		case block := <-r.d.failedBlocksChan:
+			if block != 42 {
+				t.Errorf("got block %d queued, want 42", block)
 			}
-		case <-time.After(time.Second * 2):
-			t.Errorf("got %v, want %v", "timeout", nil)
+		case <-time.After(time.Second):
+			t.Fatal("refetched block wasn't queued")
+		}
+		if unfinished := r.d.UnfinishedBlocks(); !reflect.DeepEqual(unfinished, []int64{42}) {
+			t.Errorf("got blocks %v in flight, want [42]", unfinished)
 		}
 	})
 
+	t.Run("blocks are tracked in flight once", func(t *testing.T) {
+		r := newTestRun(t, "synthetic://", nil)
+		if !r.d.trackInFlight(5) || r.d.trackInFlight(5) {
+			t.Error("want block 5 tracked the first time only")
+		}
+		r.d.trackInFlight(3)
+		if unfinished := r.d.UnfinishedBlocks(); !reflect.DeepEqual(unfinished, []int64{3, 5}) {
+			t.Errorf("got blocks %v in flight, want [3 5]", unfinished)
+		}
+		r.d.untrackInFlight(5)
+		if unfinished := r.d.UnfinishedBlocks(); !reflect.DeepEqual(unfinished, []int64{3}) {
+			t.Errorf("got blocks %v in flight, want [3]", unfinished)
+		}
+	})
 }
 
-func createAndStartDispatcher(urls []*url.URL, blockFrom, blockTo int64, errChan chan error) (got []string) {
-	blockChan := make(chan string)
-	done := make(chan struct{})
-	dispatcher := NewDispatcher(blockChan, urls, blockFrom, blockTo, done, errChan)
-	dispatcher.Start(context.Background())
-	for b := range blockChan {
-		got = append(got, b)
+func TestAutoscale(t *testing.T) {
+	const provider = "synthetic://?latency=5ms&head=100000"
+	p, err := jsonrpc.ParseProvider(provider)
+	if err != nil {
+		t.Fatal(err)
 	}
-	<-done
-	return got
-}
+	pool := jsonrpc.NewPool([]*jsonrpc.Provider{p}, 3, 30*time.Second)
+	r := newTestRun(t, provider, blockRange(1, 100000), WithProviderPool(pool), WithAutoscale(1, 8, 50*time.Millisecond))
+	r.d.Start(context.Background(), 2, r.d.providers, false)
 
-func makeJSONRPCServer() *httptest.Server {
-
-	get_block_by_number := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":{"number":"0x5","hash":"0x","parentHash":"0x","nonce":"0x","sha3Uncles":"0x","logsBloom":"0x","transactionsRoot":"0x","stateRoot":"0x","miner":"0x","difficulty":"0x","totalDifficulty":"0x","extraData":"0x","size":"0x","gasLimit":"0x","gasUsed":"0x","timestamp":"0x","transactions":[],"uncles":[]}}`)
+	// blocks queue up for the workers of a provider keeping up
+	deadline := time.Now().Add(5 * time.Second)
+	for r.d.Workers() <= 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if workers := r.d.Workers(); workers <= 2 || workers > 8 {
+		t.Errorf("got %d workers, want the 2 grown within 8", workers)
 	}
+	r.d.Shutdown()
+	r.wait(t, 10*time.Second)
+}
 
-	get_json_error := func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"Internal error"}}`)
+func TestProviderHealth(t *testing.T) {
+	r := newTestRun(t, "synthetic://", nil)
+	if latency, errorRate := r.d.providerHealth(); latency != 0 || errorRate != 0 {
+		t.Errorf("got latency %v and error rate %v without a pool, want none", latency, errorRate)
 	}
 
-	get_http_error := func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
+	p, err := jsonrpc.ParseProvider("synthetic://?latency=2ms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := jsonrpc.NewPool([]*jsonrpc.Provider{p}, 3, 30*time.Second)
+	WithProviderPool(pool)(r.d)
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Call(context.Background(), "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
 	}
+	if latency, errorRate := r.d.providerHealth(); latency < 2*time.Millisecond || errorRate != 0 {
+		t.Errorf("got latency %v and error rate %v, want the provider's latency and no errors", latency, errorRate)
+	}
+}
 
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		switch r.URL.Path {
-		case "/jsonError":
-			get_json_error(w, r)
-		case "/eth_getBlockByNumber":
-			get_block_by_number(w, r)
-		case "/httpError":
-			get_http_error(w, r)
-		default:
-			w.WriteHeader(http.StatusNotFound)
-		}
-	}))
-	return server // defer server.Close()
+func TestRefetchConcurrency(t *testing.T) {
+	const limit = 2
+	refetch := make(chan int64)
+	foreground, refetched := blockRange(1, 300), blockRange(1001, 1010)
+	r := newTestRun(t, "synthetic://?latency=5ms&head=2000", foreground, WithRefetch(refetch), WithRefetchConcurrency(limit))
+	r.d.Start(context.Background(), 4, r.d.providers, true)
+	go func() {
+		for _, block := range refetched {
+			select {
+			case refetch <- block:
+			case <-time.After(30 * time.Second):
+				return
+			}
+		}
+	}()
+
+	// refetches are picked up by workers between the dispatched blocks,
+	// not all of them are processed with those
+	processed := func(blocks []int64) (processed int) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		for _, block := range blocks {
+			if r.stored[int(block)] > 0 {
+				processed++
+			}
+		}
+		return processed
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	maxRefetching := 0
+	for processed(foreground) < len(foreground) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the dispatched blocks to be processed")
+		}
+		r.d.refetchMutex.Lock()
+		if refetching := len(r.d.refetching); refetching > maxRefetching {
+			maxRefetching = refetching
+		}
+		r.d.refetchMutex.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	if maxRefetching > limit {
+		t.Errorf("got %d refetches in flight at once, want at most %d", maxRefetching, limit)
+	}
+	if processed(refetched) == 0 {
+		t.Error("got no block refetched along with the dispatched blocks")
+	}
+	r.d.Shutdown()
+	r.wait(t, 10*time.Second)
 }
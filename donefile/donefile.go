@@ -0,0 +1,45 @@
+package donefile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Summary is the final summary of a run, written to the done file once it
+// succeeded
+type Summary struct {
+	Workers            int       `json:"workers"`
+	SuccessBlocks      int64     `json:"successBlocks"`
+	TotalScannedBlocks int64     `json:"totalScannedBlocks"`
+	Duration           string    `json:"duration"`
+	DroppedErrors      int64     `json:"droppedErrors"`
+	FinishedAt         time.Time `json:"finishedAt"`
+}
+
+// Clear removes the done file left by an earlier run, so a run failing
+// before it finishes never leaves one behind
+func Clear(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Finish writes summary to the done file when the run succeeded, and makes
+// sure there is none when it failed. The file is replaced atomically so it's
+// never seen partially written
+func Finish(path string, success bool, summary Summary) error {
+	if !success {
+		return Clear(path)
+	}
+	content, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(path+".tmp", append(content, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,57 @@
+package donefile
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFinish(t *testing.T) {
+	summary := Summary{
+		Workers:            8,
+		SuccessBlocks:      990,
+		TotalScannedBlocks: 1000,
+		Duration:           "1m30s",
+		FinishedAt:         time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC),
+	}
+
+	t.Run("done file holds the summary on success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "done.json")
+		if err := Finish(path, true, summary); err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Summary
+		if err = json.Unmarshal(content, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != summary {
+			t.Errorf("got %+v, want %+v", got, summary)
+		}
+	})
+
+	t.Run("done file is absent on error, even when left by an earlier run", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "done.json")
+		if err := Finish(path, true, summary); err != nil {
+			t.Fatal(err)
+		}
+		if err := Finish(path, false, summary); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("got %v, want the done file removed", err)
+		}
+	})
+
+	t.Run("clearing a missing done file isn't an error", func(t *testing.T) {
+		if err := Clear(filepath.Join(t.TempDir(), "done.json")); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package errqueue
+
+import (
+	"sync/atomic"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// Policy is what the queue does with an error sent while its buffer is full
+type Policy string
+
+const (
+	// Block holds the sender until the buffer has room
+	Block Policy = "block"
+	// DropOldest discards the oldest buffered error to make room
+	DropOldest Policy = "drop-oldest"
+)
+
+// Queue relays errors sent on In to Out, buffering up to size of them.
+// Goroutines send on In and the consumer receives from Out
+type Queue struct {
+	In      chan error
+	Out     chan error
+	policy  Policy
+	dropped int64
+}
+
+// New starts relaying errors. The relay runs for the life of the process so
+// errors sent while shutting down, after the consumer stopped receiving,
+// are buffered as a buffered channel would and don't hang their sender
+func New(size int, policy Policy) *Queue {
+	q := &Queue{
+		In:     make(chan error),
+		Out:    make(chan error, size),
+		policy: policy,
+	}
+	go q.relay()
+	return q
+}
+
+func (q *Queue) relay() {
+	for err := range q.In {
+		select {
+		case q.Out <- err:
+			continue
+		default:
+		}
+
+		if q.policy == DropOldest {
+			select {
+			case <-q.Out:
+				atomic.AddInt64(&q.dropped, 1)
+				metrics.ErrorsDropped.Inc()
+			default:
+			}
+		}
+		// blocks the senders on a full buffer unless the oldest was dropped
+		q.Out <- err
+	}
+}
+
+// Dropped returns the number of errors discarded under DropOldest
+func (q *Queue) Dropped() int64 {
+	return atomic.LoadInt64(&q.dropped)
+}
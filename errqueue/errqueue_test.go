@@ -0,0 +1,77 @@
+package errqueue
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueue(t *testing.T) {
+	t.Run("error flood doesn't deadlock and drops the oldest errors", func(t *testing.T) {
+		q := New(2, DropOldest)
+
+		sent := make(chan struct{})
+		go func() {
+			for i := 0; i < 10; i++ {
+				q.In <- fmt.Errorf("error %d", i)
+			}
+			close(sent)
+		}()
+		select {
+		case <-sent:
+		case <-time.After(2 * time.Second):
+			t.Fatal("senders deadlocked on a full buffer")
+		}
+
+		// the last error may still be in flight in the relay
+		deadline := time.Now().Add(2 * time.Second)
+		for q.Dropped() < 8 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if q.Dropped() != 8 {
+			t.Errorf("got %d dropped errors, want 8", q.Dropped())
+		}
+		for _, want := range []string{"error 8", "error 9"} {
+			if got := <-q.Out; got.Error() != want {
+				t.Errorf("got %v, want %s", got, want)
+			}
+		}
+	})
+
+	t.Run("blocking policy holds senders on a full buffer", func(t *testing.T) {
+		q := New(1, Block)
+
+		sent := make(chan int, 3)
+		go func() {
+			for i := 0; i < 3; i++ {
+				q.In <- fmt.Errorf("error %d", i)
+				sent <- i
+			}
+		}()
+		// one error is buffered and one held by the relay, the third blocks
+		time.Sleep(100 * time.Millisecond)
+		if len(sent) != 2 {
+			t.Errorf("got %d errors accepted, want 2", len(sent))
+		}
+		<-q.Out
+		select {
+		case <-time.After(time.Second):
+			t.Error("sender wasn't released once the buffer had room")
+		case <-waitFor(sent, 3):
+		}
+		if q.Dropped() != 0 {
+			t.Errorf("got %d dropped errors, want none", q.Dropped())
+		}
+	})
+}
+
+func waitFor(sent chan int, n int) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for len(sent) < n {
+			time.Sleep(time.Millisecond)
+		}
+		close(done)
+	}()
+	return done
+}
@@ -0,0 +1,29 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// GetChainId returns the chain id the providers of pool serve, from
+// eth_chainId or, for nodes without it, from net_version
+func GetChainId(ctx context.Context, pool *jsonrpc.Pool) (int64, error) {
+	var chainId string
+	err := pool.CallResult(ctx, &chainId, "eth_chainId", jsonrpc.NullResultError)
+	if err == nil {
+		return jsonrpc.ParseQuantity(chainId, jsonrpc.NumberAuto)
+	}
+	// only a node answering with an error may lack the method
+	var rpcError *jsonrpc.JSONRPCError
+	if !errors.As(err, &rpcError) {
+		return 0, err
+	}
+	var networkId string
+	if err = pool.CallResult(ctx, &networkId, "net_version", jsonrpc.NullResultError); err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(networkId, 10, 64)
+}
@@ -0,0 +1,47 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestGetChainId(t *testing.T) {
+	t.Run("chain id is read from eth_chainId", func(t *testing.T) {
+		provider, err := jsonrpc.ParseProvider("synthetic://?chainId=4444")
+		if err != nil {
+			t.Fatal(err)
+		}
+		chainId, err := GetChainId(context.Background(), jsonrpc.NewPool([]*jsonrpc.Provider{provider}, 3, time.Second))
+		if err != nil || chainId != 4444 {
+			t.Errorf("got chain id %d and %v, want 4444", chainId, err)
+		}
+	})
+
+	t.Run("nodes without eth_chainId fall back to net_version", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request jsonrpc.JSONRPCRequest
+			json.NewDecoder(r.Body).Decode(&request)
+			if request.Method == "net_version" {
+				fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":"4444"}`, request.ID)
+				return
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"error":{"code":-32601,"message":"method not found"}}`, request.ID)
+		}))
+		defer server.Close()
+		provider, err := jsonrpc.ParseProvider(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chainId, err := GetChainId(context.Background(), jsonrpc.NewPool([]*jsonrpc.Provider{provider}, 3, time.Second))
+		if err != nil || chainId != 4444 {
+			t.Errorf("got chain id %d and %v, want 4444", chainId, err)
+		}
+	})
+}
@@ -2,30 +2,24 @@ package eth
 
 import (
 	"context"
-	"strconv"
 
 	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/sirupsen/logrus"
 )
 
-func GetLatestBlock(ctx context.Context, logger *logrus.Entry, url string) (latestBlock int64, err error) {
-	rpcClient := jsonrpc.NewClient(url, 0)
-	rpcResponse, err := rpcClient.Call(ctx, "eth_getBlockByNumber", "latest", false)
+func GetLatestBlock(ctx context.Context, logger *logrus.Entry, pool *jsonrpc.Pool) (latestBlock int64, err error) {
+	var htmlcoinBlock jsonrpc.GetBlockByNumberResponse
+	// there is always a latest block, a null one means the provider is broken
+	err = pool.CallResult(ctx, &htmlcoinBlock, "eth_getBlockByNumber", jsonrpc.NullResultError, "latest", false)
 	if err != nil {
-		logger.Error("Invalid endpoint: ", err)
-		return
-	}
-	if rpcResponse.Error != nil {
-		logger.Error("rpc response error: ", rpcResponse.Error)
+		logger.Error("could not get latest block: ", err)
 		return
 	}
-	var htmlcoinBlock jsonrpc.GetBlockByNumberResponse
-	err = jsonrpc.GetBlockFromRPCResponse(rpcResponse, &htmlcoinBlock)
+	latestBlock, err = jsonrpc.ParseQuantity(htmlcoinBlock.Number, jsonrpc.NumberAuto)
 	if err != nil {
-		logger.Error("could not convert result to htmlcoin.GetBlockByNumberResponse", err)
+		logger.Error("invalid latest block number: ", err)
 		return
 	}
-	latestBlock, _ = strconv.ParseInt(htmlcoinBlock.Number, 0, 64)
 	logger.Debug("LatestBlock: ", latestBlock)
 	return
 }
@@ -0,0 +1,174 @@
+package eth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// LogFilter selects the logs sparse scans look for: logs emitted by any of
+// Addresses, whose topics match Topics position by position. An empty
+// position matches any topic, as does a filter without addresses any address
+type LogFilter struct {
+	Addresses []string
+	Topics    [][]string
+}
+
+// AddAddress adds a contract address whose logs are matched
+func (f *LogFilter) AddAddress(address string) error {
+	if !strings.HasPrefix(address, "0x") || len(address) != 42 {
+		return fmt.Errorf("invalid address '%s', want a 0x prefixed 20 bytes address", address)
+	}
+	f.Addresses = append(f.Addresses, strings.ToLower(address))
+	return nil
+}
+
+// AddTopic adds a topic given as "[position:]topic" to the topics matched
+// at its position, 0 (the event signature) by default
+func (f *LogFilter) AddTopic(definition string) error {
+	position, topic := 0, definition
+	if i := strings.Index(definition, ":"); i != -1 {
+		var err error
+		if position, err = strconv.Atoi(definition[:i]); err != nil || position < 0 || position > 3 {
+			return fmt.Errorf("invalid topic position in '%s', want 0 to 3", definition)
+		}
+		topic = definition[i+1:]
+	}
+	if !strings.HasPrefix(topic, "0x") {
+		return fmt.Errorf("invalid topic '%s', want a 0x prefixed hash", definition)
+	}
+	for len(f.Topics) <= position {
+		f.Topics = append(f.Topics, nil)
+	}
+	f.Topics[position] = append(f.Topics[position], strings.ToLower(topic))
+	return nil
+}
+
+// Empty reports whether the filter matches every log
+func (f LogFilter) Empty() bool {
+	return len(f.Addresses) == 0 && len(f.Topics) == 0
+}
+
+type logEntry struct {
+	BlockNumber string `json:"blockNumber"`
+}
+
+// LogIndex finds the blocks with logs matching its filter with ranged
+// eth_getLogs queries. The blocks found are kept, so every block is only
+// queried once however often the blocks missing are reloaded. It's safe
+// for concurrent use
+type LogIndex struct {
+	pool      *jsonrpc.Pool
+	filter    LogFilter
+	rangeSize int64
+
+	mutex sync.Mutex
+	// the blocks queried, from first to last, and those with matching logs
+	first  int64
+	last   int64
+	blocks map[int64]struct{}
+}
+
+// NewLogIndex returns an index querying logs of up to rangeSize blocks at
+// once
+func NewLogIndex(pool *jsonrpc.Pool, filter LogFilter, rangeSize int64) *LogIndex {
+	return &LogIndex{
+		pool:      pool,
+		filter:    filter,
+		rangeSize: rangeSize,
+		first:     -1,
+		last:      -1,
+		blocks:    map[int64]struct{}{},
+	}
+}
+
+// Filter returns the blocks of candidates with matching logs, querying the
+// blocks between first and last not queried yet
+func (i *LogIndex) Filter(ctx context.Context, first, last int64, candidates []int64) ([]int64, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.first == -1 {
+		if err := i.query(ctx, first, last); err != nil {
+			return nil, err
+		}
+		i.first, i.last = first, last
+	}
+	if first < i.first {
+		if err := i.query(ctx, first, i.first-1); err != nil {
+			return nil, err
+		}
+		i.first = first
+	}
+	if last > i.last {
+		if err := i.query(ctx, i.last+1, last); err != nil {
+			return nil, err
+		}
+		i.last = last
+	}
+
+	matching := make([]int64, 0, len(i.blocks))
+	for _, block := range candidates {
+		if _, ok := i.blocks[block]; ok {
+			matching = append(matching, block)
+		}
+	}
+	return matching, nil
+}
+
+// query adds the blocks between first and last with matching logs, a range
+// at a time
+func (i *LogIndex) query(ctx context.Context, first, last int64) error {
+	for from := first; from <= last; from += i.rangeSize {
+		to := from + i.rangeSize - 1
+		if to > last {
+			to = last
+		}
+		if err := i.queryRange(ctx, from, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryRange adds the blocks between from and to with matching logs. Ranges
+// a node refuses, such as those with more logs than it answers at once, are
+// split in halves
+func (i *LogIndex) queryRange(ctx context.Context, from, to int64) error {
+	params := map[string]interface{}{
+		"fromBlock": fmt.Sprintf("0x%x", from),
+		"toBlock":   fmt.Sprintf("0x%x", to),
+	}
+	if len(i.filter.Addresses) > 0 {
+		params["address"] = i.filter.Addresses
+	}
+	if len(i.filter.Topics) > 0 {
+		params["topics"] = i.filter.Topics
+	}
+	var logs []logEntry
+	err := i.pool.CallResult(ctx, &logs, "eth_getLogs", jsonrpc.NullResultError, params)
+	var rpcError *jsonrpc.JSONRPCError
+	if errors.As(err, &rpcError) && from < to {
+		middle := from + (to-from)/2
+		if err = i.queryRange(ctx, from, middle); err != nil {
+			return err
+		}
+		return i.queryRange(ctx, middle+1, to)
+	}
+	if err != nil {
+		return fmt.Errorf("getting the logs of blocks %d to %d: %w", from, to, err)
+	}
+	for _, log := range logs {
+		block, err := jsonrpc.ParseQuantity(log.BlockNumber, jsonrpc.NumberAuto)
+		if err != nil {
+			return fmt.Errorf("invalid block number of a log: %w", err)
+		}
+		i.blocks[block] = struct{}{}
+	}
+	return nil
+}
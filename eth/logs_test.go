@@ -0,0 +1,119 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestLogFilter(t *testing.T) {
+	var filter LogFilter
+	for _, topic := range []string{"0xDDF2", "2:0xab", "0xc3"} {
+		if err := filter.AddTopic(topic); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := [][]string{{"0xddf2", "0xc3"}, nil, {"0xab"}}
+	if !reflect.DeepEqual(filter.Topics, want) {
+		t.Errorf("got topics %v, want %v", filter.Topics, want)
+	}
+	for _, topic := range []string{"ddf2", "4:0xab", "x:0xab"} {
+		if err := filter.AddTopic(topic); err == nil {
+			t.Errorf("expected an error for topic '%s'", topic)
+		}
+	}
+	if err := filter.AddAddress("0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48"); err != nil || filter.Addresses[0] != "0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48" {
+		t.Errorf("got addresses %v and %v, want the address in lower case", filter.Addresses, err)
+	}
+	if err := filter.AddAddress("0x01"); err == nil {
+		t.Error("expected an error for a short address")
+	}
+}
+
+func TestLogIndex(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("only candidates with logs are kept and ranges are queried once", func(t *testing.T) {
+		var mutex sync.Mutex
+		var ranges [][2]int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var request struct {
+				ID     int                 `json:"id"`
+				Params []map[string]string `json:"params"`
+			}
+			json.NewDecoder(r.Body).Decode(&request)
+			from, _ := jsonrpc.ParseQuantity(request.Params[0]["fromBlock"], jsonrpc.NumberHex)
+			to, _ := jsonrpc.ParseQuantity(request.Params[0]["toBlock"], jsonrpc.NumberHex)
+			mutex.Lock()
+			ranges = append(ranges, [2]int64{from, to})
+			mutex.Unlock()
+			// nodes capping the logs answered refuse wide ranges
+			if to-from >= 10 {
+				fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"error":{"code":-32005,"message":"query returned more than 10000 results"}}`, request.ID)
+				return
+			}
+			logs := []string{}
+			for block := from; block <= to; block++ {
+				if block%7 == 0 {
+					logs = append(logs, fmt.Sprintf(`{"blockNumber":"0x%x"}`, block))
+				}
+			}
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%d,"result":[%s]}`, request.ID, strings.Join(logs, ","))
+		}))
+		defer server.Close()
+		provider, err := jsonrpc.ParseProvider(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool := jsonrpc.NewPool([]*jsonrpc.Provider{provider}, 3, time.Second)
+		index := NewLogIndex(pool, LogFilter{Addresses: []string{"0x01"}}, 16)
+
+		blocks, err := index.Filter(ctx, 1, 30, []int64{1, 7, 14, 20, 28})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int64{7, 14, 28}; !reflect.DeepEqual(blocks, want) {
+			t.Errorf("got blocks %v, want %v", blocks, want)
+		}
+		queried := len(ranges)
+
+		blocks, err = index.Filter(ctx, 1, 35, []int64{7, 22, 35})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int64{7, 35}; !reflect.DeepEqual(blocks, want) {
+			t.Errorf("got blocks %v, want %v", blocks, want)
+		}
+		if got := ranges[queried:]; !reflect.DeepEqual(got, [][2]int64{{31, 35}}) {
+			t.Errorf("got ranges %v queried, want only the new blocks", got)
+		}
+	})
+
+	t.Run("synthetic provider serves logs", func(t *testing.T) {
+		provider, err := jsonrpc.ParseProvider("synthetic://?head=100&logs=25")
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := NewLogIndex(jsonrpc.NewPool([]*jsonrpc.Provider{provider}, 3, time.Second), LogFilter{}, 1000)
+		candidates := make([]int64, 100)
+		for i := range candidates {
+			candidates[i] = int64(i + 1)
+		}
+		blocks, err := index.Filter(ctx, 1, 100, candidates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int64{25, 50, 75, 100}; !reflect.DeepEqual(blocks, want) {
+			t.Errorf("got blocks %v, want %v", blocks, want)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package eth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotWebsocket is returned subscribing through urls that aren't ws:// or wss://
+var ErrNotWebsocket = errors.New("not a websocket url")
+
+// newHead is the head of an eth_subscription notification of a new head
+type newHead struct {
+	Number string `json:"number"`
+}
+
+// SubscribeNewHeads subscribes to newHeads through the websocket provider at
+// wsURL, streaming the numbers of new heads as they arrive. The channel is
+// closed when the socket drops or ctx is cancelled, resubscribing is up to
+// the caller
+func SubscribeNewHeads(ctx context.Context, wsURL string) (<-chan int64, error) {
+	if !jsonrpc.IsWebsocketURL(wsURL) {
+		return nil, ErrNotWebsocket
+	}
+	transport := jsonrpc.NewWebsocketTransport(wsURL)
+	notifications, err := transport.Subscribe(ctx, "newHeads")
+	if err != nil {
+		transport.Close()
+		return nil, err
+	}
+
+	heads := make(chan int64)
+	go func() {
+		defer close(heads)
+		defer transport.Close()
+		for notification := range notifications {
+			var head newHead
+			if json.Unmarshal(notification, &head) != nil {
+				continue
+			}
+			number, err := jsonrpc.ParseQuantity(head.Number, jsonrpc.NumberAuto)
+			if err != nil {
+				continue
+			}
+			select {
+			case heads <- number:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return heads, nil
+}
+
+// HeadFollower follows the chain head, preferring a newHeads subscription
+// and polling for the latest block whenever it's not subscribed: the url
+// isn't a websocket one, subscribing failed or the subscription dropped. It
+// resubscribes every interval until it succeeds
+type HeadFollower struct {
+	logger         *logrus.Entry
+	wsURL          string
+	interval       time.Duration
+	getLatestBlock func(ctx context.Context) (int64, error)
+	latest         int64
+}
+
+func NewHeadFollower(
+	logger *logrus.Entry,
+	wsURL string,
+	interval time.Duration,
+	getLatestBlock func(ctx context.Context) (int64, error),
+) *HeadFollower {
+	return &HeadFollower{
+		logger:         logger.WithField("module", "newHeads"),
+		wsURL:          wsURL,
+		interval:       interval,
+		getLatestBlock: getLatestBlock,
+	}
+}
+
+// Latest returns the highest head seen, 0 until one is
+func (f *HeadFollower) Latest() int64 {
+	return atomic.LoadInt64(&f.latest)
+}
+
+// Run follows the head until ctx is cancelled, signalling on heads whenever
+// it moves. A signal left pending isn't repeated, heads should be buffered
+func (f *HeadFollower) Run(ctx context.Context, heads chan<- int64) {
+	for ctx.Err() == nil {
+		subscription, err := SubscribeNewHeads(ctx, f.wsURL)
+		if err == nil {
+			f.logger.Info("Subscribed to new heads")
+			for head := range subscription {
+				f.publish(head, heads)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			f.logger.Warn("New heads subscription dropped, polling until resubscribed")
+		} else if err != ErrNotWebsocket && ctx.Err() == nil {
+			f.logger.Warn("Failed subscribing to new heads, polling until subscribed: ", err)
+		}
+
+		if latest, err := f.getLatestBlock(ctx); err == nil {
+			f.publish(latest, heads)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.interval):
+		}
+	}
+}
+
+func (f *HeadFollower) publish(head int64, heads chan<- int64) {
+	if head <= atomic.LoadInt64(&f.latest) {
+		return
+	}
+	atomic.StoreInt64(&f.latest, head)
+	f.logger.Debug("New head: ", head)
+	select {
+	case heads <- head:
+	default:
+	}
+}
@@ -0,0 +1,117 @@
+package eth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// newHeadsServer accepts newHeads subscriptions, sending the heads of the
+// nth connection before dropping it
+func newHeadsServer(t *testing.T, heads ...[]int64) (*httptest.Server, *int32) {
+	var connections int32
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		n := int(atomic.AddInt32(&connections, 1)) - 1
+
+		var request jsonrpc.JSONRPCRequest
+		if err := conn.ReadJSON(&request); err != nil || request.Method != "eth_subscribe" {
+			t.Errorf("got request %+v (%v), want eth_subscribe", request, err)
+			return
+		}
+		conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": "0xabc"})
+		if n >= len(heads) {
+			// keep the last connection open
+			conn.ReadMessage()
+			return
+		}
+		for _, head := range heads[n] {
+			conn.WriteJSON(map[string]interface{}{
+				"jsonrpc": "2.0",
+				"method":  "eth_subscription",
+				"params":  map[string]interface{}{"subscription": "0xabc", "result": map[string]interface{}{"number": fmt.Sprintf("0x%x", head)}},
+			})
+		}
+	}))
+	return server, &connections
+}
+
+func wsURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestSubscribeNewHeads(t *testing.T) {
+	t.Run("new heads are streamed until the socket drops", func(t *testing.T) {
+		server, _ := newHeadsServer(t, []int64{10, 11})
+		defer server.Close()
+
+		heads, err := SubscribeNewHeads(context.Background(), wsURL(server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []int64
+		for head := range heads {
+			got = append(got, head)
+		}
+		if fmt.Sprint(got) != "[10 11]" {
+			t.Errorf("got heads %v, want [10 11]", got)
+		}
+	})
+
+	t.Run("http urls aren't subscribed to", func(t *testing.T) {
+		if _, err := SubscribeNewHeads(context.Background(), "http://127.0.0.1:8545"); err != ErrNotWebsocket {
+			t.Errorf("got %v, want %v", err, ErrNotWebsocket)
+		}
+	})
+}
+
+func TestHeadFollower(t *testing.T) {
+	logger := logrus.NewEntry(logrus.New())
+	run := func(t *testing.T, url string, want int64, latestBlock func(ctx context.Context) (int64, error)) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		follower := NewHeadFollower(logger, url, 10*time.Millisecond, latestBlock)
+		heads := make(chan int64, 1)
+		go follower.Run(ctx, heads)
+
+		deadline := time.After(5 * time.Second)
+		for follower.Latest() < want {
+			select {
+			case <-heads:
+			case <-deadline:
+				t.Fatalf("got head %d, want %d", follower.Latest(), want)
+			}
+		}
+	}
+
+	t.Run("dropped subscriptions are resubscribed", func(t *testing.T) {
+		server, connections := newHeadsServer(t, []int64{10}, []int64{12})
+		defer server.Close()
+		run(t, wsURL(server), 12, func(ctx context.Context) (int64, error) { return 0, fmt.Errorf("unavailable") })
+		if atomic.LoadInt32(connections) < 2 {
+			t.Errorf("got %d connections, want at least 2", atomic.LoadInt32(connections))
+		}
+	})
+
+	t.Run("non websocket urls are polled", func(t *testing.T) {
+		var polls int64
+		run(t, "https://info.htmlcoin.com/janusapi", 3, func(ctx context.Context) (int64, error) {
+			return atomic.AddInt64(&polls, 1), nil
+		})
+	})
+}
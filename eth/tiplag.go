@@ -0,0 +1,84 @@
+package eth
+
+import (
+	"context"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// TipLagMonitor alerts when the highest committed block falls behind the
+// chain tip by more than a threshold, meaning the processor can't keep up.
+// Without a threshold the lag is only exported
+type TipLagMonitor struct {
+	logger          *logrus.Entry
+	interval        time.Duration
+	threshold       int64
+	getLatestBlock  func(ctx context.Context) (int64, error)
+	getHighestBlock func() int64
+}
+
+func NewTipLagMonitor(
+	logger *logrus.Entry,
+	interval time.Duration,
+	threshold int64,
+	getLatestBlock func(ctx context.Context) (int64, error),
+	getHighestBlock func() int64,
+) *TipLagMonitor {
+	return &TipLagMonitor{
+		logger:          logger.WithField("module", "tipLag"),
+		interval:        interval,
+		threshold:       threshold,
+		getLatestBlock:  getLatestBlock,
+		getHighestBlock: getHighestBlock,
+	}
+}
+
+// Run checks the tip lag every interval until ctx is cancelled
+func (m *TipLagMonitor) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(m.interval):
+		}
+
+		if _, _, err := m.check(ctx); err != nil && ctx.Err() == nil {
+			m.logger.Warn("Failed checking tip lag: ", err)
+		}
+	}
+}
+
+// check measures the tip lag, reporting whether it exceeds the threshold.
+// The lag is only known once a block has been committed
+func (m *TipLagMonitor) check(ctx context.Context) (lag int64, alert bool, err error) {
+	highestBlock := m.getHighestBlock()
+	if highestBlock == 0 {
+		return 0, false, nil
+	}
+
+	latestBlock, err := m.getLatestBlock(ctx)
+	if err != nil {
+		return 0, false, err
+	}
+
+	lag = latestBlock - highestBlock
+	if lag < 0 {
+		lag = 0
+	}
+	metrics.TipLag.Set(float64(lag))
+
+	if m.threshold > 0 && lag > m.threshold {
+		metrics.TipLagAlerts.Inc()
+		m.logger.WithFields(logrus.Fields{
+			"latestBlock":  latestBlock,
+			"highestBlock": highestBlock,
+			"lag":          lag,
+			"threshold":    m.threshold,
+		}).Warn("Processor is falling behind the chain tip")
+		return lag, true, nil
+	}
+
+	return lag, false, nil
+}
@@ -0,0 +1,87 @@
+package eth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTipLagMonitor(t *testing.T) {
+	logger, _ := log.GetLogger()
+
+	// the head advances 10 blocks between checks while 1 block is committed
+	var latestBlock, highestBlock int64 = 100, 95
+	monitor := NewTipLagMonitor(
+		logger.WithField("module", "test"),
+		time.Second,
+		20,
+		func(ctx context.Context) (int64, error) {
+			latestBlock += 10
+			return latestBlock, nil
+		},
+		func() int64 {
+			highestBlock++
+			return highestBlock
+		},
+	)
+
+	alertsBefore := testutil.ToFloat64(metrics.TipLagAlerts)
+
+	t.Run("no alert while the lag is under the threshold", func(t *testing.T) {
+		lag, alert, err := monitor.check(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alert || lag != 14 {
+			t.Errorf("got lag %d alert %v, want lag 14 and no alert", lag, alert)
+		}
+	})
+
+	t.Run("alert fires once the processor lags behind the head", func(t *testing.T) {
+		var lag int64
+		var alert bool
+		for i := 0; i < 3 && !alert; i++ {
+			var err error
+			lag, alert, err = monitor.check(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !alert || lag <= 20 {
+			t.Errorf("got lag %d alert %v, want an alert for a lag over 20", lag, alert)
+		}
+		if got := testutil.ToFloat64(metrics.TipLagAlerts) - alertsBefore; got != 1 {
+			t.Errorf("got %v alerts, want 1", got)
+		}
+		if got := testutil.ToFloat64(metrics.TipLag); got != float64(lag) {
+			t.Errorf("got tip lag gauge %v, want %d", got, lag)
+		}
+	})
+
+	t.Run("lag is unknown before anything is committed", func(t *testing.T) {
+		monitor := NewTipLagMonitor(logger.WithField("module", "test"), time.Second, 20,
+			func(ctx context.Context) (int64, error) { return 1000, nil },
+			func() int64 { return 0 },
+		)
+		if _, alert, _ := monitor.check(context.Background()); alert {
+			t.Error("got an alert before any block was committed")
+		}
+	})
+
+	t.Run("lag is only exported without a threshold", func(t *testing.T) {
+		monitor := NewTipLagMonitor(logger.WithField("module", "test"), time.Second, 0,
+			func(ctx context.Context) (int64, error) { return 1000, nil },
+			func() int64 { return 900 },
+		)
+		if lag, alert, _ := monitor.check(context.Background()); alert || lag != 100 {
+			t.Errorf("got lag %d alert %v, want lag 100 and no alert", lag, alert)
+		}
+		if got := testutil.ToFloat64(metrics.TipLag); got != 100 {
+			t.Errorf("got tip lag gauge %v, want 100", got)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+type Source interface {
+	GetHashPairsRange(ctx context.Context, chainId int, firstBlock, lastBlock int64) ([]jsonrpc.HashPair, error)
+}
+
+type Config struct {
+	ChainId    int
+	FirstBlock int64
+	LastBlock  int64
+	// number of blocks read from the source and written between cursor updates
+	ChunkSize int64
+	Output    string
+	Resume    bool
+}
+
+// Cursor records how far an export got: the last block fully written and
+// the size of the output at that point
+type Cursor struct {
+	LastBlock int64 `json:"lastBlock"`
+	Offset    int64 `json:"offset"`
+}
+
+// CursorPath is the sidecar file an export's cursor is persisted to
+func CursorPath(output string) string {
+	return output + ".cursor"
+}
+
+// Export writes the hash pairs of the configured block range to a csv file,
+// persisting a cursor after every chunk so an interrupted export can be
+// resumed. On resume the output is truncated back to the cursor, dropping
+// any rows written after it, so no rows are duplicated or skipped
+func Export(ctx context.Context, logger *logrus.Entry, source Source, config Config) error {
+	if config.ChunkSize < 1 {
+		return fmt.Errorf("invalid chunk size %d", config.ChunkSize)
+	}
+
+	file, cursor, err := open(logger, config)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	for cursor.LastBlock < config.LastBlock {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		firstBlock := cursor.LastBlock + 1
+		lastBlock := firstBlock + config.ChunkSize - 1
+		if lastBlock > config.LastBlock {
+			lastBlock = config.LastBlock
+		}
+
+		pairs, err := source.GetHashPairsRange(ctx, config.ChainId, firstBlock, lastBlock)
+		if err != nil {
+			return err
+		}
+
+		writer := csv.NewWriter(file)
+		for _, pair := range pairs {
+			writer.Write([]string{strconv.Itoa(pair.BlockNumber), pair.EthHash, pair.HtmlcoinHash})
+		}
+		writer.Flush()
+		if err = writer.Error(); err != nil {
+			return err
+		}
+
+		// rows must be on disk before the cursor moves past them
+		if err = file.Sync(); err != nil {
+			return err
+		}
+		offset, err := file.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		cursor = Cursor{LastBlock: lastBlock, Offset: offset}
+		if err = writeCursor(config.Output, cursor); err != nil {
+			return err
+		}
+		logger.WithFields(logrus.Fields{
+			"lastBlock": lastBlock,
+			"rows":      len(pairs),
+		}).Debug("Exported chunk")
+	}
+
+	logger.WithField("output", config.Output).Info("Export finished")
+	return nil
+}
+
+// open prepares the output file for writing, positioned at the end of the
+// last completed chunk, and returns the cursor to continue from
+func open(logger *logrus.Entry, config Config) (*os.File, Cursor, error) {
+	if config.Resume {
+		cursor, err := readCursor(config.Output)
+		if err == nil {
+			if cursor.LastBlock < config.FirstBlock-1 || cursor.LastBlock > config.LastBlock {
+				return nil, cursor, fmt.Errorf("cursor at block %d is outside of the export range %d-%d", cursor.LastBlock, config.FirstBlock, config.LastBlock)
+			}
+			file, err := os.OpenFile(config.Output, os.O_RDWR, 0644)
+			if err != nil {
+				return nil, cursor, err
+			}
+			if err = file.Truncate(cursor.Offset); err != nil {
+				file.Close()
+				return nil, cursor, err
+			}
+			if _, err = file.Seek(cursor.Offset, io.SeekStart); err != nil {
+				file.Close()
+				return nil, cursor, err
+			}
+			logger.WithField("lastBlock", cursor.LastBlock).Info("Resuming export")
+			return file, cursor, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, cursor, err
+		}
+		logger.Warn("No export cursor found, starting from the beginning")
+	}
+
+	file, err := os.Create(config.Output)
+	if err != nil {
+		return nil, Cursor{}, err
+	}
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"BlockNum", "Eth", "Htmlcoin"})
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		file.Close()
+		return nil, Cursor{}, err
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		file.Close()
+		return nil, Cursor{}, err
+	}
+	cursor := Cursor{LastBlock: config.FirstBlock - 1, Offset: offset}
+	if err = writeCursor(config.Output, cursor); err != nil {
+		file.Close()
+		return nil, cursor, err
+	}
+
+	return file, cursor, nil
+}
+
+func readCursor(output string) (Cursor, error) {
+	var cursor Cursor
+	content, err := ioutil.ReadFile(CursorPath(output))
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(content, &cursor)
+	return cursor, err
+}
+
+// writeCursor replaces the cursor file atomically so an interruption never
+// leaves a partially written cursor behind
+func writeCursor(output string, cursor Cursor) error {
+	content, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	path := CursorPath(output)
+	if err = ioutil.WriteFile(path+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
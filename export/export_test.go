@@ -0,0 +1,126 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+)
+
+var errInterrupted = errors.New("interrupted")
+
+// fakeSource serves a hash pair for every block, failing once calls reaches failAt
+type fakeSource struct {
+	calls  int
+	failAt int
+}
+
+func (s *fakeSource) GetHashPairsRange(ctx context.Context, chainId int, firstBlock, lastBlock int64) ([]jsonrpc.HashPair, error) {
+	s.calls++
+	if s.failAt != 0 && s.calls >= s.failAt {
+		return nil, errInterrupted
+	}
+	pairs := []jsonrpc.HashPair{}
+	for block := firstBlock; block <= lastBlock; block++ {
+		pairs = append(pairs, newHashPair(block))
+	}
+	return pairs, nil
+}
+
+func newHashPair(block int64) jsonrpc.HashPair {
+	return jsonrpc.HashPair{
+		BlockNumber:  int(block),
+		EthHash:      fmt.Sprintf("0xeth%d", block),
+		HtmlcoinHash: fmt.Sprintf("0xhtmlcoin%d", block),
+	}
+}
+
+func expectedOutput(firstBlock, lastBlock int64) string {
+	lines := []string{"BlockNum,Eth,Htmlcoin"}
+	for block := firstBlock; block <= lastBlock; block++ {
+		pair := newHashPair(block)
+		lines = append(lines, fmt.Sprintf("%d,%s,%s", pair.BlockNumber, pair.EthHash, pair.HtmlcoinHash))
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestResumableExport(t *testing.T) {
+	logger, _ := log.GetLogger()
+	exportLogger := logger.WithField("module", "export")
+
+	config := Config{
+		ChainId:    4444,
+		FirstBlock: 1,
+		LastBlock:  50,
+		ChunkSize:  7,
+		Output:     filepath.Join(t.TempDir(), "hashes.csv"),
+	}
+
+	t.Run("interrupted export resumes without duplicating or skipping rows", func(t *testing.T) {
+		err := Export(context.Background(), exportLogger, &fakeSource{failAt: 4}, config)
+		if err != errInterrupted {
+			t.Fatalf("got %v, want %v", err, errInterrupted)
+		}
+		cursor, err := readCursor(config.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor.LastBlock != 21 {
+			t.Errorf("got cursor at block %d, want 21", cursor.LastBlock)
+		}
+
+		// rows written after the cursor, e.g. when killed mid chunk, are discarded
+		file, err := os.OpenFile(config.Output, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprint(file, "22,0xeth22,0xhtml")
+		file.Close()
+
+		resumed := config
+		resumed.Resume = true
+		if err = Export(context.Background(), exportLogger, &fakeSource{}, resumed); err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := ioutil.ReadFile(config.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expectedOutput(1, 50) {
+			t.Errorf("got output:\n%s\nwant:\n%s", content, expectedOutput(1, 50))
+		}
+	})
+
+	t.Run("resuming a finished export writes nothing", func(t *testing.T) {
+		source := &fakeSource{}
+		resumed := config
+		resumed.Resume = true
+		if err := Export(context.Background(), exportLogger, source, resumed); err != nil {
+			t.Fatal(err)
+		}
+		if source.calls != 0 {
+			t.Errorf("got %d calls to the source, want none", source.calls)
+		}
+	})
+
+	t.Run("export without resume starts over", func(t *testing.T) {
+		if err := Export(context.Background(), exportLogger, &fakeSource{}, config); err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(config.Output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expectedOutput(1, 50) {
+			t.Errorf("got output:\n%s\nwant:\n%s", content, expectedOutput(1, 50))
+		}
+	})
+}
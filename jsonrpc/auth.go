@@ -0,0 +1,77 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// splitLabel splits a definition of the form "[label=]rest", the label only
+// being what comes before an '=' without sep before it
+func splitLabel(definition string, sep string) (string, string) {
+	if i := strings.Index(definition, "="); i != -1 && !strings.Contains(definition[:i], sep) {
+		return definition[:i], definition[i+1:]
+	}
+	return "", definition
+}
+
+// ParseHeader parses a header definition of the form "[label=]name:value",
+// e.g. "archive=X-Api-Key:key", sent on every request to the provider
+// labeled label, or to every provider without a label
+func ParseHeader(definition string) (string, string, string, error) {
+	label, header := splitLabel(definition, ":")
+	i := strings.Index(header, ":")
+	if i < 1 || strings.TrimSpace(header[i+1:]) == "" {
+		return "", "", "", fmt.Errorf("invalid provider header '%s', want [label=]name:value", definition)
+	}
+	return label, strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]), nil
+}
+
+// ParseBearerToken parses a bearer token definition of the form
+// "[label=]VAR", returning the label, empty for every provider, and the
+// token read from the environment variable VAR. Tokens are read from the
+// environment so they stay out of the command line and logs
+func ParseBearerToken(definition string) (string, string, error) {
+	label, variable := splitLabel(definition, ":")
+	if variable == "" {
+		return "", "", fmt.Errorf("invalid provider token '%s', want [label=]VAR", definition)
+	}
+	token := os.Getenv(variable)
+	if token == "" {
+		return "", "", fmt.Errorf("environment variable %s of provider token '%s' is not set", variable, definition)
+	}
+	return label, token, nil
+}
+
+// SetHeader sets a header sent on every request to the provider
+func (p *Provider) SetHeader(name, value string) {
+	if p.Headers == nil {
+		p.Headers = http.Header{}
+	}
+	p.Headers.Set(name, value)
+}
+
+// withoutCredentials returns rawURL without the credentials it carries, and
+// the basic authorization header they make. The url is returned unchanged
+// when it has none
+func withoutCredentials(rawURL string) (string, string) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, ""
+	}
+	password, _ := u.User.Password()
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(u.User.Username(), password)
+	u.User = nil
+	return u.String(), req.Header.Get("Authorization")
+}
+
+// redactURL hides the password of rawURL, if any
+func redactURL(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil {
+		return u.Redacted()
+	}
+	return rawURL
+}
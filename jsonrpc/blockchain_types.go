@@ -10,6 +10,49 @@ type HashPair struct {
 	BlockNumber int
 	HtmlcoinHash    string
 	EthHash     string
+	// the block has no transactions
+	Empty bool
+	// block size in bytes, nil when the provider doesn't report it
+	Size *int64
+	// gasUsed/gasLimit, nil when the block has no gas limit
+	GasUsedRatio *float64
+	// the block number doesn't exist on the chain, it has no hashes
+	Skipped bool
+	// hash of the parent block, checked against the stored one for reorgs
+	ParentHash string
+	// receipts of the block's transactions, stored along with the block
+	Receipts []GetTransactionReceiptResponse
+	// the block failed every attempt, it's dead-lettered instead of stored
+	Failure *BlockFailure
+	// header and transactions of the block, nil unless blocks are stored
+	Block *BlockData
+}
+
+// BlockData is the header and the transactions of a block
+type BlockData struct {
+	Timestamp    int64
+	Miner        string
+	GasUsed      int64
+	ParentHash   string
+	Transactions []TransactionData
+}
+
+// TransactionData is a transaction of a block. To is empty for contract
+// creations, Value is in wei, as a decimal number, and Input is hex
+type TransactionData struct {
+	Hash  string
+	Index int64
+	From  string
+	To    string
+	Value string
+	Gas   int64
+	Input string
+}
+
+// BlockFailure is why and how many times a dead-lettered block failed
+type BlockFailure struct {
+	Error    string
+	Attempts int
 }
 
 type GetBlockByNumberRequest struct {
@@ -45,3 +88,28 @@ type GetBlockByHashResponse struct {
 	Sha3Uncles string   `json:"sha3Uncles"`
 	Uncles     []string `json:"uncles"`
 }
+
+type GetTransactionReceiptResponse struct {
+	TransactionHash   string `json:"transactionHash"`
+	TransactionIndex  string `json:"transactionIndex"`
+	BlockHash         string `json:"blockHash"`
+	BlockNumber       string `json:"blockNumber"`
+	From              string `json:"from"`
+	To                string `json:"to"`
+	CumulativeGasUsed string `json:"cumulativeGasUsed"`
+	GasUsed           string `json:"gasUsed"`
+	// empty unless the transaction created a contract
+	ContractAddress string            `json:"contractAddress"`
+	Logs            []json.RawMessage `json:"logs"`
+	LogsBloom       string            `json:"logsBloom"`
+	// empty for transactions before byzantium, which report a root instead
+	Status string `json:"status"`
+}
+
+// Log is an event log entry of a transaction receipt
+type Log struct {
+	Address  string   `json:"address"`
+	Topics   []string `json:"topics"`
+	Data     string   `json:"data"`
+	LogIndex string   `json:"logIndex"`
+}
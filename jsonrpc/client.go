@@ -4,32 +4,84 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 var TIMEOUT = 20
 
+// RetryConfig is how calls failing with transient errors are retried
+type RetryConfig struct {
+	// attempts made in total, including the first
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// http statuses retried, 429 and every 5xx when empty. Other 4xx and
+	// 5xx statuses fail the call at once
+	RetryStatuses []int
+}
+
+// retries reports whether a response of http status is retried
+func (retry RetryConfig) retries(status int) bool {
+	if len(retry.RetryStatuses) == 0 {
+		return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+	}
+	for _, retried := range retry.RetryStatuses {
+		if status == retried {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultRetryConfig makes 4 attempts, backing off 1s, 2s and 4s
+var DefaultRetryConfig = RetryConfig{MaxAttempts: 4, BaseDelay: time.Second, MaxDelay: 8 * time.Second}
+
 type Client struct {
 	httpClient *http.Client
 	url        string
 	logger     *logrus.Entry
 	id         int
+	nullResult NullResult
+	// identifies the provider in metrics
+	name   string
+	signer RequestSigner
+	// sent on every request
+	headers http.Header
+	retry   RetryConfig
+	// requests to the provider wait for a token, unlimited when nil
+	limiter *rate.Limiter
+	// set once the provider answered a batch with something else than an array
+	batchUnsupported int32
+	// largest batch sent to the provider, unbounded when 0. Halved whenever
+	// the provider rejects a batch as too large
+	maxBatchSize int32
 }
 
-func NewClient(url string, id int) *Client {
+// NewClient creates a client for url, retrying calls as configured by retry.
+// A zero retry config retries as DefaultRetryConfig does
+func NewClient(url string, id int, retry RetryConfig) *Client {
+	if retry.MaxAttempts < 1 {
+		retry = DefaultRetryConfig
+	}
 	clientLogger, _ := log.GetLogger()
 	logger := clientLogger.WithFields(logrus.Fields{
 		"component": "httpClient",
-		"endpoint":  url,
+		"endpoint":  redactURL(url),
 		"clientId":  id,
 	})
 
@@ -49,15 +101,39 @@ func NewClient(url string, id int) *Client {
 		Timeout:   30 * time.Second,
 		Transport: tr,
 	}
+	if u, err := neturl.Parse(url); err == nil && u.Scheme == SyntheticScheme {
+		// the url was validated by ParseProvider
+		httpClient.Transport, _ = newSyntheticTransport(u)
+	} else if IsWebsocketURL(url) {
+		httpClient.Transport = NewWebsocketTransport(url)
+	}
 
 	return &Client{
 		httpClient: httpClient,
 		url:        url,
 		logger:     logger,
 		id:         id,
+		name:       redactURL(url),
+		retry:      retry,
 	}
 }
 
+// NewProviderClient creates a client for the given provider, identifying it
+// in logs by the provider name rather than its raw url
+func NewProviderClient(provider *Provider, id int) *Client {
+	c := NewClient(provider.URL.String(), id, provider.Retry)
+	c.logger = c.logger.WithField("endpoint", provider.Name())
+	c.name = provider.Name()
+	c.signer = provider.Signer
+	c.limiter = provider.Limiter
+	c.headers = provider.Headers
+	c.maxBatchSize = int32(provider.MaxBatchSize)
+	if transport, ok := c.httpClient.Transport.(*WebsocketTransport); ok {
+		transport.header = provider.Headers
+	}
+	return c
+}
+
 func (c *Client) Call(ctx context.Context, method string, params ...interface{}) (*JSONRPCResponse, error) {
 	rpcRequest := newJSONRPCRequest(method, params...)
 	jsonRequest, err := json.Marshal(rpcRequest)
@@ -65,7 +141,179 @@ func (c *Client) Call(ctx context.Context, method string, params ...interface{})
 		return nil, err
 	}
 	// c.logger.Error("Returning from Call")
-	return c.doWithRetries(ctx, jsonRequest)
+	var rpcResponse JSONRPCResponse
+	retries, err := c.doWithRetries(ctx, jsonRequest, &rpcResponse)
+	if err != nil {
+		return nil, err
+	}
+	rpcResponse.Retries = retries
+	if rpcResponse.Error != nil {
+		metrics.RPCErrors.WithLabelValues(c.name, "response").Inc()
+	}
+	return &rpcResponse, nil
+}
+
+// CallBatch makes requests in a single json rpc batch, returning their
+// responses in the order of requests whatever order the provider answers
+// in. Entries may carry json rpc error objects while others succeed. Calls
+// left out of the answer are made on their own, and a provider answering
+// batches with anything but an array gets calls one at a time from then on.
+// Requests are split in batches of at most the provider's maximum batch
+// size, and a batch the provider rejects as too large is halved and retried,
+// later batches keeping to the halved size
+func (c *Client) CallBatch(ctx context.Context, requests []Request) ([]*JSONRPCResponse, error) {
+	if len(requests) == 0 {
+		return nil, nil
+	}
+	responses := make([]*JSONRPCResponse, 0, len(requests))
+	for len(requests) > 0 {
+		size := len(requests)
+		if maxSize := int(atomic.LoadInt32(&c.maxBatchSize)); maxSize > 0 && size > maxSize {
+			size = maxSize
+		}
+		batchResponses, err := c.callBatch(ctx, requests[:size])
+		if errors.Is(err, errBatchTooLarge) {
+			c.lowerMaxBatchSize(size)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, batchResponses...)
+		requests = requests[size:]
+	}
+	return responses, nil
+}
+
+// errBatchTooLarge is a batch the provider rejected for its size
+var errBatchTooLarge = errors.New("batch too large")
+
+// isBatchTooLarge reports whether a provider rejected a batch for its size,
+// answering it with http status 413 or with an error object such as geth's
+// "batch too large" or "batch limit exceeded"
+func isBatchTooLarge(err error, body json.RawMessage) bool {
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return permanent.status == http.StatusRequestEntityTooLarge
+	}
+	var rpcResponse JSONRPCResponse
+	if json.Unmarshal(body, &rpcResponse) != nil || rpcResponse.Error == nil {
+		return false
+	}
+	message := strings.ToLower(rpcResponse.Error.Message)
+	if !strings.Contains(message, "batch") {
+		return false
+	}
+	for _, tooLarge := range []string{"too large", "too big", "limit", "exceed"} {
+		if strings.Contains(message, tooLarge) {
+			return true
+		}
+	}
+	return false
+}
+
+// lowerMaxBatchSize halves the maximum batch size after a batch of size was
+// rejected as too large, unless another batch already lowered it further
+func (c *Client) lowerMaxBatchSize(size int) {
+	halved := int32(size / 2)
+	if halved < 1 {
+		halved = 1
+	}
+	for {
+		current := atomic.LoadInt32(&c.maxBatchSize)
+		if current > 0 && current <= halved {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.maxBatchSize, current, halved) {
+			c.logger.Warnf("Provider rejected a batch of %d requests as too large, batching at most %d from now on", size, halved)
+			return
+		}
+	}
+}
+
+// callBatch makes requests in a single json rpc batch, returning
+// errBatchTooLarge when the provider rejects it for its size
+func (c *Client) callBatch(ctx context.Context, requests []Request) ([]*JSONRPCResponse, error) {
+	if atomic.LoadInt32(&c.batchUnsupported) == 1 {
+		return callEach(ctx, c, requests)
+	}
+	batch := make([]*JSONRPCRequest, len(requests))
+	for i, request := range requests {
+		batch[i] = newJSONRPCRequest(request.Method, request.Params...)
+		batch[i].ID = i + 1
+	}
+	jsonRequest, err := json.Marshal(batch)
+	if err != nil {
+		return nil, err
+	}
+	var body json.RawMessage
+	retries, err := c.doWithRetries(ctx, jsonRequest, &body)
+	var permanent *permanentError
+	if err != nil && !errors.As(err, &permanent) {
+		return nil, err
+	}
+	if len(requests) > 1 && isBatchTooLarge(err, body) {
+		return nil, errBatchTooLarge
+	}
+	var answers []*JSONRPCResponse
+	if err != nil || json.Unmarshal(body, &answers) != nil {
+		// rejected, or answered with a single error object
+		c.logger.Warn("Provider doesn't support batch requests, calling one at a time from now on")
+		atomic.StoreInt32(&c.batchUnsupported, 1)
+		return callEach(ctx, c, requests)
+	}
+
+	responses := make([]*JSONRPCResponse, len(requests))
+	for _, answer := range answers {
+		if answer == nil || answer.ID < 1 || answer.ID > len(requests) {
+			continue
+		}
+		answer.Retries = retries
+		if answer.Error != nil {
+			metrics.RPCErrors.WithLabelValues(c.name, "response").Inc()
+		}
+		responses[answer.ID-1] = answer
+	}
+	for i, response := range responses {
+		if response != nil {
+			continue
+		}
+		if responses[i], err = c.Call(ctx, requests[i].Method, requests[i].Params...); err != nil {
+			return nil, err
+		}
+	}
+	return responses, nil
+}
+
+// callEach makes requests one at a time, for providers without batches
+func callEach(ctx context.Context, client caller, requests []Request) ([]*JSONRPCResponse, error) {
+	responses := make([]*JSONRPCResponse, len(requests))
+	for i, request := range requests {
+		rpcResponse, err := client.Call(ctx, request.Method, request.Params...)
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = rpcResponse
+	}
+	return responses, nil
+}
+
+// SetNullResult sets how CallResult treats a null result
+func (c *Client) SetNullResult(nullResult NullResult) {
+	c.nullResult = nullResult
+}
+
+// CallResult calls method and decodes its result into result, returning
+// json rpc error objects as errors
+func (c *Client) CallResult(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	rpcResponse, err := c.Call(ctx, method, params...)
+	if err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error
+	}
+	return DecodeResult(rpcResponse, result, c.nullResult)
 }
 
 func (c *Client) newHttpRequest(ctx context.Context, jsonReq []byte) (*http.Request, error) {
@@ -75,28 +323,52 @@ func (c *Client) newHttpRequest(ctx context.Context, jsonReq []byte) (*http.Requ
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	for name, values := range c.headers {
+		req.Header[name] = values
+	}
+	if c.signer != nil {
+		c.signer.Sign(req, jsonReq)
+	}
 	req.Close = true
 	req = req.WithContext(ctx)
 
 	return req, nil
 }
 
-func (c *Client) do(ctx context.Context, jsonReq []byte) (*JSONRPCResponse, error) {
+// permanentError is a failed request retrying wouldn't help
+type permanentError struct {
+	err error
+	// http status of the response, 0 when there was none
+	status int
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isErrorResponse reports whether body is a json rpc error object
+func isErrorResponse(body json.RawMessage) bool {
+	var rpcResponse JSONRPCResponse
+	return json.Unmarshal(body, &rpcResponse) == nil && rpcResponse.Error != nil
+}
+
+// do sends the request once, decoding the response body into result
+func (c *Client) do(ctx context.Context, jsonReq []byte, result interface{}) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(TIMEOUT))
 	defer cancel()
 
-	if rand.Intn(4) == 0 {
-		// return nil, errors.New("Fake failure")
-	}
-
 	httpReq, err := c.newHttpRequest(ctx, jsonReq)
 	if err != nil {
-		return nil, err
+		return &permanentError{err: err}
 	}
 
 	httpResp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("http response error: %s ", err)
+		return fmt.Errorf("http response error: %s ", err)
 	}
 
 	defer func() {
@@ -104,50 +376,99 @@ func (c *Client) do(ctx context.Context, jsonReq []byte) (*JSONRPCResponse, erro
 		httpResp.Body.Close()
 	}()
 
-	var rpcResponse JSONRPCResponse
-	err = json.NewDecoder(httpResp.Body).Decode(&rpcResponse)
+	var body json.RawMessage
+	err = json.NewDecoder(httpResp.Body).Decode(&body)
+	// an error object is an answer whatever the status, e.g. method not found
+	if err != nil || !isErrorResponse(body) {
+		if c.retry.retries(httpResp.StatusCode) {
+			return fmt.Errorf("http status %s", httpResp.Status)
+		}
+		if httpResp.StatusCode >= http.StatusBadRequest {
+			return &permanentError{err: fmt.Errorf("http status %s", httpResp.Status), status: httpResp.StatusCode}
+		}
+	}
+	if err == io.EOF {
+		return fmt.Errorf("empty response body")
+	}
+	if err == nil {
+		err = json.Unmarshal(body, result)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("json decoder error: %s ", err)
+		return fmt.Errorf("json decoder error: %s ", err)
 	}
+	return nil
+}
 
-	return &rpcResponse, nil
+// backoff returns the delay before retry, doubling from BaseDelay up to
+// MaxDelay with up to half of it added as jitter
+func (retry RetryConfig) backoff(attempt int) time.Duration {
+	delay := retry.BaseDelay << uint(attempt)
+	if delay > retry.MaxDelay || delay <= 0 {
+		delay = retry.MaxDelay
+	}
+	if delay > 1 {
+		delay += time.Duration(rand.Int63n(int64(delay / 2)))
+	}
+	return delay
 }
 
-func (c *Client) doWithRetries(ctx context.Context, jsonReq []byte) (*JSONRPCResponse, error) {
-	var rpcResponse *JSONRPCResponse
+// doWithRetries sends the request, retrying network errors, 429 and 5xx
+// responses and empty or malformed bodies with exponential backoff. It
+// returns the number of retries it took
+func (c *Client) doWithRetries(ctx context.Context, jsonReq []byte, result interface{}) (int, error) {
 	var err error
-	var backoffSchedule = []time.Duration{
-		1 * time.Second,
-		2 * time.Second,
-		4 * time.Second,
-		0 * time.Second,
-	}
-	for i, backoff := range backoffSchedule {
+	for i := 0; i < c.retry.MaxAttempts; i++ {
+		if ctx.Err() != nil {
+			c.logger.Debug("Client cancelled")
+			return i, ctx.Err()
+		}
+		attempt := "first"
+		if i > 0 {
+			attempt = "retry"
+		}
+		if c.limiter != nil {
+			if err = c.limiter.Wait(ctx); err != nil {
+				if ctx.Err() != nil {
+					c.logger.Debug("Client cancelled")
+					return i, ctx.Err()
+				}
+				// the token wouldn't be available before the deadline
+				return i, err
+			}
+		}
+		metrics.RPCCalls.WithLabelValues(c.name, attempt).Inc()
+		start := time.Now()
+		err = c.do(ctx, jsonReq, result)
+		metrics.RPCLatency.WithLabelValues(c.name).Observe(time.Since(start).Seconds())
+		if err == nil {
+			return i, nil
+		}
+		if ctx.Err() != nil {
+			c.logger.Debug("Client cancelled")
+			return i, ctx.Err()
+		}
+		metrics.RPCErrors.WithLabelValues(c.name, "request").Inc()
+		c.logger.Warnf("Request error: %+v", err)
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return i, err
+		}
+		if i == c.retry.MaxAttempts-1 {
+			break
+		}
+		backoff := c.retry.backoff(i)
+		c.logger.Warnf("Retrying in %v", backoff)
 		select {
 		case <-ctx.Done():
 			c.logger.Debug("Client cancelled")
-			return nil, ctx.Err()
-		default:
-			rpcResponse, err = c.do(ctx, jsonReq)
-			if err == nil {
-				break
-			}
-			c.logger.Warnf("Request error: %+v", err)
-			if i == len(backoffSchedule)-1 {
-				break
-			}
-			rand.Seed(time.Now().UnixNano())
-			n := rand.Intn(2)
-			c.logger.Warnf("Retrying in %v", backoff+500*time.Millisecond*time.Duration(n))
-			time.Sleep(backoff + 500*time.Millisecond*time.Duration(n))
+			return i, ctx.Err()
+		case <-time.After(backoff):
 		}
-
 	}
-	// c.logger.Error("Returning from doWithRetries with err: ", err)
-	return rpcResponse, err
+	return c.retry.MaxAttempts - 1, fmt.Errorf("%w (after %d attempts)", err, c.retry.MaxAttempts)
 }
 
-//Required for CircuitBreaker proxy
+// Required for CircuitBreaker proxy
 func (c *Client) GetState() string {
 	return "UNDEFINED"
 }
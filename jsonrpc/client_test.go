@@ -3,11 +3,24 @@ package jsonrpc
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/time/rate"
 	// "github.com/sirupsen/logrus"
 )
 
@@ -18,7 +31,7 @@ func TestClientWithCancel(t *testing.T) {
 	log.GetLogger(log.WithDebugLevel(true))
 	ctx, cancel := context.WithCancel(context.Background())
 	errorChan := make(chan error)
-	c := NewClient("http://localhost:8080", 0)
+	c := NewClient("http://localhost:8080", 0, RetryConfig{})
 
 	t.Run("Client stops retrying when context.Cancel", func(t *testing.T) {
 		go func() {
@@ -42,7 +55,7 @@ func TestClientWithRetries(t *testing.T) {
 	t.Run("Client retries 3 times before erroring", func(t *testing.T) {
 		buffer.Reset()
 		errorChan := make(chan error)
-		c := NewClient("http://localhost:8080", 3)
+		c := NewClient("http://localhost:8080", 3, RetryConfig{})
 		go func() {
 			_, err := c.Call(context.Background(), "getblockcount", []interface{}{})
 			errorChan <- err
@@ -55,3 +68,423 @@ func TestClientWithRetries(t *testing.T) {
 
 	})
 }
+
+func TestClientAttemptMetrics(t *testing.T) {
+	// fails the first failures requests with a malformed response
+	newServer := func(failures int32) (*httptest.Server, *int32) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&requests, 1) <= failures {
+				fmt.Fprint(w, `{"jsonrpc":`)
+				return
+			}
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+		}))
+		return server, &requests
+	}
+	calls := func(name, attempt string) float64 {
+		return testutil.ToFloat64(metrics.RPCCalls.WithLabelValues(name, attempt))
+	}
+	// the counters are global, calls are checked by how much they moved
+	callDeltas := func(name string) func() (float64, float64) {
+		first, retries := calls(name, "first"), calls(name, "retry")
+		return func() (float64, float64) {
+			return calls(name, "first") - first, calls(name, "retry") - retries
+		}
+	}
+	latencySamples := func(t *testing.T, name string) uint64 {
+		t.Helper()
+		var metric dto.Metric
+		if err := metrics.RPCLatency.WithLabelValues(name).(prometheus.Metric).Write(&metric); err != nil {
+			t.Fatal(err)
+		}
+		return metric.GetHistogram().GetSampleCount()
+	}
+
+	t.Run("successful call is counted once as a first attempt", func(t *testing.T) {
+		server, requests := newServer(0)
+		defer server.Close()
+		provider, _ := ParseProvider("first=" + server.URL)
+		c := NewProviderClient(provider, 0)
+		deltas := callDeltas("first")
+
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+		if *requests != 1 {
+			t.Errorf("got %d requests, want 1", *requests)
+		}
+		if first, retries := deltas(); first != 1 || retries != 0 {
+			t.Errorf("got %v first attempts and %v retries, want 1 and 0", first, retries)
+		}
+	})
+
+	t.Run("retried call counts its retries", func(t *testing.T) {
+		server, requests := newServer(2)
+		defer server.Close()
+		provider, _ := ParseProvider("retried=" + server.URL)
+		c := NewProviderClient(provider, 0)
+		deltas := callDeltas("retried")
+
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+		if *requests != 3 {
+			t.Errorf("got %d requests, want 3", *requests)
+		}
+		if first, retries := deltas(); first != 1 || retries != 2 {
+			t.Errorf("got %v first attempts and %v retries, want 1 and 2", first, retries)
+		}
+	})
+
+	t.Run("failed requests are counted as errors and timed", func(t *testing.T) {
+		server, _ := newServer(2)
+		defer server.Close()
+		provider, _ := ParseProvider("failing=" + server.URL)
+		c := NewProviderClient(provider, 0)
+		failedBefore := testutil.ToFloat64(metrics.RPCErrors.WithLabelValues("failing", "request"))
+		samplesBefore := latencySamples(t, "failing")
+
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+		if got := testutil.ToFloat64(metrics.RPCErrors.WithLabelValues("failing", "request")) - failedBefore; got != 2 {
+			t.Errorf("got %v failed requests, want 2", got)
+		}
+		// every attempt is timed, failed or not
+		if got := latencySamples(t, "failing") - samplesBefore; got != 3 {
+			t.Errorf("got %d latency samples, want 3", got)
+		}
+	})
+}
+
+func TestClientRetryConfig(t *testing.T) {
+	retry := RetryConfig{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 20 * time.Millisecond}
+	// answers each request with the next status, 200 once they run out
+	newServer := func(statuses ...int) (*httptest.Server, *int32) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request := int(atomic.AddInt32(&requests, 1))
+			if request <= len(statuses) {
+				w.WriteHeader(statuses[request-1])
+				return
+			}
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+		}))
+		return server, &requests
+	}
+
+	t.Run("429 and 5xx responses are retried and the retries recorded", func(t *testing.T) {
+		server, requests := newServer(http.StatusTooManyRequests, http.StatusBadGateway)
+		defer server.Close()
+		c := NewClient(server.URL, 0, retry)
+
+		rpcResponse, err := c.Call(context.Background(), "eth_blockNumber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *requests != 3 || rpcResponse.Retries != 2 {
+			t.Errorf("got %d requests and %d retries, want 3 and 2", *requests, rpcResponse.Retries)
+		}
+	})
+
+	t.Run("other 4xx responses fail immediately", func(t *testing.T) {
+		server, requests := newServer(http.StatusUnauthorized)
+		defer server.Close()
+		c := NewClient(server.URL, 0, retry)
+
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err == nil {
+			t.Error("expected an error")
+		}
+		if *requests != 1 {
+			t.Errorf("got %d requests, want 1", *requests)
+		}
+	})
+
+	t.Run("only the configured statuses are retried", func(t *testing.T) {
+		server, requests := newServer(http.StatusBadGateway, http.StatusNotImplemented)
+		defer server.Close()
+		configured := retry
+		configured.RetryStatuses = []int{http.StatusBadGateway}
+		c := NewClient(server.URL, 0, configured)
+
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err == nil {
+			t.Error("expected an error")
+		}
+		if *requests != 2 {
+			t.Errorf("got %d requests, want the 502 retried and the 501 to fail the call", *requests)
+		}
+	})
+
+	t.Run("empty responses are retried until attempts run out", func(t *testing.T) {
+		server, requests := newServer(http.StatusOK, http.StatusOK, http.StatusOK)
+		defer server.Close()
+		c := NewClient(server.URL, 0, retry)
+
+		_, err := c.Call(context.Background(), "eth_blockNumber")
+		if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+			t.Errorf("got %v, want an error after 3 attempts", err)
+		}
+		if *requests != 3 {
+			t.Errorf("got %d requests, want 3", *requests)
+		}
+	})
+
+	t.Run("json-rpc errors are returned without retrying", func(t *testing.T) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`)
+		}))
+		defer server.Close()
+		c := NewClient(server.URL, 0, retry)
+
+		rpcResponse, err := c.Call(context.Background(), "eth_blockNumber")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rpcResponse.Error == nil || requests != 1 {
+			t.Errorf("got %v after %d requests, want a json-rpc error after 1", rpcResponse.Error, requests)
+		}
+	})
+
+	t.Run("backoff stops when the context is cancelled", func(t *testing.T) {
+		server, _ := newServer(http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+		defer server.Close()
+		c := NewClient(server.URL, 0, RetryConfig{MaxAttempts: 3, BaseDelay: time.Minute, MaxDelay: time.Minute})
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if _, err := c.Call(ctx, "eth_blockNumber"); err != context.DeadlineExceeded {
+			t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Errorf("call returned after %v", time.Since(start))
+		}
+	})
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+	}))
+	defer server.Close()
+	providerURL, _ := url.Parse(server.URL)
+
+	t.Run("clients of a provider share its bucket", func(t *testing.T) {
+		provider := &Provider{URL: providerURL, Limiter: rate.NewLimiter(rate.Every(50*time.Millisecond), 1)}
+		first, second := NewProviderClient(provider, 0), NewProviderClient(provider, 1)
+
+		start := time.Now()
+		for _, c := range []*Client{first, second, first} {
+			if _, err := c.Call(context.Background(), "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("3 calls took %v, want at least 100ms", elapsed)
+		}
+	})
+
+	t.Run("waiting for a token is cancelled with the context", func(t *testing.T) {
+		provider := &Provider{URL: providerURL, Limiter: rate.NewLimiter(rate.Every(time.Hour), 1)}
+		c := NewProviderClient(provider, 0)
+		if _, err := c.Call(context.Background(), "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+		atomic.StoreInt32(&requests, 0)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(20*time.Millisecond, cancel)
+		if _, err := c.Call(ctx, "eth_blockNumber"); err != context.Canceled {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+		if got := atomic.LoadInt32(&requests); got != 0 {
+			t.Errorf("got %d requests, want none", got)
+		}
+	})
+}
+
+func TestCallBatch(t *testing.T) {
+	requests := []Request{
+		{Method: "eth_getBlockByNumber", Params: []interface{}{"0x1", true}},
+		{Method: "eth_getBlockByNumber", Params: []interface{}{"0x2", true}},
+		{Method: "eth_getBlockByNumber", Params: []interface{}{"0x3", true}},
+	}
+
+	t.Run("responses are matched to requests by id", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			var batch []JSONRPCRequest
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Error(err)
+			}
+			// answered in reverse, with an error for the second block
+			var answers []map[string]interface{}
+			for i := len(batch) - 1; i >= 0; i-- {
+				answer := map[string]interface{}{"jsonrpc": "2.0", "id": batch[i].ID, "result": batch[i].Params[0]}
+				if batch[i].Params[0] == "0x2" {
+					answer = map[string]interface{}{"jsonrpc": "2.0", "id": batch[i].ID, "error": map[string]interface{}{"code": -32000, "message": "header not found"}}
+				}
+				answers = append(answers, answer)
+			}
+			json.NewEncoder(w).Encode(answers)
+		}))
+		defer server.Close()
+		c := NewClient(server.URL, 0, RetryConfig{})
+
+		responses, err := c.CallBatch(context.Background(), requests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if requestCount != 1 || len(responses) != 3 {
+			t.Fatalf("got %d responses in %d requests, want 3 in 1", len(responses), requestCount)
+		}
+		if responses[0].Result != "0x1" || responses[1].Error == nil || responses[2].Result != "0x3" {
+			t.Errorf("got responses %v, %v, %v", responses[0], responses[1], responses[2])
+		}
+	})
+
+	t.Run("providers without batches are called one at a time", func(t *testing.T) {
+		var requestCount int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requestCount, 1)
+			var rpcRequest JSONRPCRequest
+			if err := json.NewDecoder(r.Body).Decode(&rpcRequest); err != nil {
+				fmt.Fprint(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch requests are not supported"}}`)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": rpcRequest.ID, "result": rpcRequest.Params[0]})
+		}))
+		defer server.Close()
+		c := NewClient(server.URL, 0, RetryConfig{})
+
+		for _, want := range []int32{4, 7} {
+			responses, err := c.CallBatch(context.Background(), requests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, response := range responses {
+				if response.Result != requests[i].Params[0] {
+					t.Errorf("got %v for request %d", response.Result, i)
+				}
+			}
+			// the unsupported batch is only tried once
+			if requestCount != want {
+				t.Errorf("got %d requests, want %d", requestCount, want)
+			}
+		}
+	})
+
+	// limitedServer answers batches of up to limit requests, rejecting larger
+	// ones with reject, and records the size of every batch it's sent
+	limitedServer := func(t *testing.T, limit int, reject func(w http.ResponseWriter)) (*httptest.Server, *[]int) {
+		var mutex sync.Mutex
+		var sizes []int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var batch []JSONRPCRequest
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				t.Error(err)
+			}
+			mutex.Lock()
+			sizes = append(sizes, len(batch))
+			mutex.Unlock()
+			if len(batch) > limit {
+				reject(w)
+				return
+			}
+			var answers []map[string]interface{}
+			for _, request := range batch {
+				answers = append(answers, map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": request.Params[0]})
+			}
+			json.NewEncoder(w).Encode(answers)
+		}))
+		t.Cleanup(server.Close)
+		return server, &sizes
+	}
+	blockRequests := func(count int) []Request {
+		var requests []Request
+		for i := 1; i <= count; i++ {
+			requests = append(requests, Request{Method: "eth_getBlockByNumber", Params: []interface{}{fmt.Sprintf("0x%x", i), true}})
+		}
+		return requests
+	}
+	checkResponses := func(t *testing.T, responses []*JSONRPCResponse, requests []Request) {
+		t.Helper()
+		if len(responses) != len(requests) {
+			t.Fatalf("got %d responses, want %d", len(responses), len(requests))
+		}
+		for i, response := range responses {
+			if response.Result != requests[i].Params[0] {
+				t.Errorf("got %v for request %d, want %v", response.Result, i, requests[i].Params[0])
+			}
+		}
+	}
+
+	for _, rejection := range []struct {
+		name   string
+		reject func(w http.ResponseWriter)
+	}{
+		{"an error object", func(w http.ResponseWriter) {
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":null,"error":{"code":-32600,"message":"batch too large"}}`)
+		}},
+		{"http status 413", func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}},
+	} {
+		rejection := rejection
+		t.Run("batches rejected as too large with "+rejection.name+" are halved", func(t *testing.T) {
+			server, sizes := limitedServer(t, 3, rejection.reject)
+			c := NewClient(server.URL, 0, RetryConfig{})
+
+			requests := blockRequests(10)
+			responses, err := c.CallBatch(context.Background(), requests)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkResponses(t, responses, requests)
+			// 10 and 5 rejected, then batches of 2
+			if want := []int{10, 5, 2, 2, 2, 2, 2}; !reflect.DeepEqual(*sizes, want) {
+				t.Errorf("got batches of %v, want %v", *sizes, want)
+			}
+
+			// later batches keep to the halved size
+			*sizes = nil
+			if responses, err = c.CallBatch(context.Background(), requests[:4]); err != nil {
+				t.Fatal(err)
+			}
+			checkResponses(t, responses, requests[:4])
+			if want := []int{2, 2}; !reflect.DeepEqual(*sizes, want) {
+				t.Errorf("got batches of %v, want %v", *sizes, want)
+			}
+		})
+	}
+
+	t.Run("batches are split under the provider's maximum batch size", func(t *testing.T) {
+		server, sizes := limitedServer(t, 4, func(w http.ResponseWriter) {
+			t.Error("got a batch over the maximum batch size")
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		})
+		provider, err := ParseProvider(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provider.MaxBatchSize = 4
+		c := NewProviderClient(provider, 0)
+
+		requests := blockRequests(10)
+		responses, err := c.CallBatch(context.Background(), requests)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkResponses(t, responses, requests)
+		if want := []int{4, 4, 2}; !reflect.DeepEqual(*sizes, want) {
+			t.Errorf("got batches of %v, want %v", *sizes, want)
+		}
+	})
+}
@@ -2,6 +2,8 @@ package jsonrpc
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 )
 
 const (
@@ -16,6 +18,12 @@ type JSONRPCRequest struct {
 	ID      int           `json:"id"`
 }
 
+// Request is a call made as part of a batch
+type Request struct {
+	Method string
+	Params []interface{}
+}
+
 // http://www.jsonrpc.org/specification#response_object
 type JSONRPCResponse struct {
 	JSONRPC string      `json:"jsonrpc"`
@@ -23,6 +31,8 @@ type JSONRPCResponse struct {
 	// Error   string `json:"error"`
 	Error *JSONRPCError `json:"error"`
 	ID    int           `json:"id"`
+	// retries the client made to get the response
+	Retries int `json:"-"`
 }
 
 // http://www.jsonrpc.org/specification#error_object
@@ -34,6 +44,27 @@ type JSONRPCError struct {
 	ID      int    `json:"id"`
 }
 
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("json rpc error %d: %s", e.Code, e.Message)
+}
+
+// NullResult is how a null result is treated when decoding a response
+type NullResult int
+
+const (
+	// NullResultZero leaves the target untouched, as its zero value
+	NullResultZero NullResult = iota
+	// NullResultNotFound returns ErrNotFound, for lookups of things that may not exist
+	NullResultNotFound
+	// NullResultError returns ErrNullResult, for calls that must always return a result
+	NullResultError
+)
+
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrNullResult = errors.New("unexpected null result")
+)
+
 func newJSONRPCRequest(method string, params ...interface{}) *JSONRPCRequest {
 	return &JSONRPCRequest{
 		JSONRPC: jsonrpcVersion,
@@ -43,12 +74,36 @@ func newJSONRPCRequest(method string, params ...interface{}) *JSONRPCRequest {
 	}
 }
 
+// GetBlockFromRPCResponse decodes the result of rpcResponse into block,
+// leaving block untouched when the result is null
 func GetBlockFromRPCResponse(rpcResponse *JSONRPCResponse, block interface{}) error {
+	return DecodeResult(rpcResponse, block, NullResultZero)
+}
+
+// GetReceiptFromRPCResponse decodes the result of rpcResponse into
+// receipt, a null result is an unknown or pending transaction
+func GetReceiptFromRPCResponse(rpcResponse *JSONRPCResponse, receipt interface{}) error {
+	return DecodeResult(rpcResponse, receipt, NullResultNotFound)
+}
+
+// DecodeResult decodes the result of rpcResponse into target, treating a
+// null result according to nullResult
+func DecodeResult(rpcResponse *JSONRPCResponse, target interface{}, nullResult NullResult) error {
+	if rpcResponse.Result == nil {
+		switch nullResult {
+		case NullResultNotFound:
+			return ErrNotFound
+		case NullResultError:
+			return ErrNullResult
+		default:
+			return nil
+		}
+	}
 	jsonResult, err := json.Marshal(rpcResponse.Result)
 	if err != nil {
 		return err
 	}
-	err = json.Unmarshal(jsonResult, block)
+	err = json.Unmarshal(jsonResult, target)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,117 @@
+package jsonrpc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDecodeResult(t *testing.T) {
+	nullResponse := &JSONRPCResponse{JSONRPC: jsonrpcVersion, ID: 1}
+
+	t.Run("null result is left as the zero value", func(t *testing.T) {
+		var block GetBlockByNumberResponse
+		if err := DecodeResult(nullResponse, &block, NullResultZero); err != nil {
+			t.Errorf("got %v, want nil", err)
+		}
+		if block.Hash != "" {
+			t.Errorf("got hash %s, want zero value", block.Hash)
+		}
+	})
+
+	t.Run("null result is reported as not found", func(t *testing.T) {
+		var block GetBlockByNumberResponse
+		if err := DecodeResult(nullResponse, &block, NullResultNotFound); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("null result is reported as an error", func(t *testing.T) {
+		var block GetBlockByNumberResponse
+		if err := DecodeResult(nullResponse, &block, NullResultError); err != ErrNullResult {
+			t.Errorf("got %v, want %v", err, ErrNullResult)
+		}
+	})
+
+	t.Run("non null result is decoded in every mode", func(t *testing.T) {
+		response := &JSONRPCResponse{Result: map[string]interface{}{"number": "0x5", "hash": "0xab"}}
+		for _, mode := range []NullResult{NullResultZero, NullResultNotFound, NullResultError} {
+			var block GetBlockByNumberResponse
+			if err := DecodeResult(response, &block, mode); err != nil {
+				t.Fatal(err)
+			}
+			if block.Number != "0x5" || block.Hash != "0xab" {
+				t.Errorf("got %+v, want number 0x5 and hash 0xab", block)
+			}
+		}
+	})
+}
+
+func TestGetReceiptFromRPCResponse(t *testing.T) {
+	t.Run("receipt is decoded with its logs", func(t *testing.T) {
+		response := &JSONRPCResponse{Result: map[string]interface{}{
+			"transactionHash": "0xcd",
+			"gasUsed":         "0x5208",
+			"status":          "0x1",
+			"logs":            []interface{}{map[string]interface{}{"address": "0xef"}},
+		}}
+		var receipt GetTransactionReceiptResponse
+		if err := GetReceiptFromRPCResponse(response, &receipt); err != nil {
+			t.Fatal(err)
+		}
+		if receipt.TransactionHash != "0xcd" || receipt.GasUsed != "0x5208" || receipt.Status != "0x1" || len(receipt.Logs) != 1 {
+			t.Errorf("got %+v, want hash 0xcd, gas used 0x5208, status 0x1 and a log", receipt)
+		}
+	})
+
+	t.Run("null receipt is reported as not found", func(t *testing.T) {
+		var receipt GetTransactionReceiptResponse
+		if err := GetReceiptFromRPCResponse(&JSONRPCResponse{}, &receipt); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestCallResult(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/null":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":null}`)
+		case "/error":
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"error":{"code":-32601,"message":"method not found"}}`)
+		}
+	}))
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name       string
+		nullResult NullResult
+		want       error
+	}{
+		{"client leaves null results as zero values", NullResultZero, nil},
+		{"client reports null results as not found", NullResultNotFound, ErrNotFound},
+		{"client reports null results as errors", NullResultError, ErrNullResult},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(server.URL+"/null", 0, RetryConfig{})
+			c.SetNullResult(tc.nullResult)
+			var block GetBlockByNumberResponse
+			if err := c.CallResult(context.Background(), &block, "eth_getBlockByNumber", "0x5", false); err != tc.want {
+				t.Errorf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	t.Run("json rpc error objects are returned as errors", func(t *testing.T) {
+		c := NewClient(server.URL+"/error", 0, RetryConfig{})
+		var block GetBlockByNumberResponse
+		err := c.CallResult(context.Background(), &block, "eth_getBlockByNumber", "0x5", false)
+		rpcErr, ok := err.(*JSONRPCError)
+		if !ok || rpcErr.Code != -32601 {
+			t.Errorf("got %v, want json rpc error -32601", err)
+		}
+	})
+}
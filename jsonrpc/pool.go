@@ -0,0 +1,350 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// statsDecay is the weight of the latest call in the moving averages of
+// provider latency and error rate
+const statsDecay = 0.2
+
+// minWeightShare is the least share of the best provider's weight every
+// healthy provider is given, so recovering providers are still sampled
+const minWeightShare = 0.05
+
+// ErrNoHealthyProvider is matched by the errors of pool calls no provider
+// could serve
+var ErrNoHealthyProvider = errors.New("no healthy provider")
+
+// caller is what a pool calls providers through
+type caller interface {
+	Call(ctx context.Context, method string, params ...interface{}) (*JSONRPCResponse, error)
+}
+
+// batchCaller is a caller making batches in single requests
+type batchCaller interface {
+	CallBatch(ctx context.Context, requests []Request) ([]*JSONRPCResponse, error)
+}
+
+type poolMember struct {
+	provider *Provider
+	client   caller
+	// consecutive failed calls, and until when the provider is skipped
+	failures       int
+	unhealthyUntil time.Time
+	// set from the provider's ejection until it answers a call again, its
+	// first failure back from the cooldown ejects it again
+	probation bool
+	// moving averages of successful call latency and of failed calls,
+	// latency is zero until the provider answered a call
+	latency   time.Duration
+	errorRate float64
+	// calls the provider answered and failed
+	answered int64
+	failed   int64
+}
+
+// ProviderStats is the health of a provider as observed by a pool
+type ProviderStats struct {
+	Name      string
+	Latency   time.Duration
+	ErrorRate float64
+	Healthy   bool
+	// calls the provider answered, its share of the pool's work, and
+	// failed
+	Answered int64
+	Failed   int64
+}
+
+// Pool spreads calls across providers round-robin, failing over to the next
+// provider when a call fails. A provider failing failureThreshold calls in a
+// row is skipped for cooldown before being tried again, and skipped again by
+// the first call it fails until it answers one, like a half-open circuit
+// breaker. The latency and
+// error rate of every provider are tracked to weigh them by throughput. It's
+// safe for concurrent use
+type Pool struct {
+	mutex            sync.Mutex
+	members          []*poolMember
+	next             int
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+	random           func() float64
+}
+
+func NewPool(providers []*Provider, failureThreshold int, cooldown time.Duration) *Pool {
+	clients := make([]caller, len(providers))
+	for i, provider := range providers {
+		clients[i] = NewProviderClient(provider, i)
+	}
+	return newPool(providers, clients, failureThreshold, cooldown)
+}
+
+func newPool(providers []*Provider, clients []caller, failureThreshold int, cooldown time.Duration) *Pool {
+	members := make([]*poolMember, len(providers))
+	for i, provider := range providers {
+		members[i] = &poolMember{provider: provider, client: clients[i]}
+	}
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	return &Pool{members: members, failureThreshold: failureThreshold, cooldown: cooldown, now: time.Now, random: rand.Float64}
+}
+
+// PoolError aggregates why every provider failed a call
+type PoolError struct {
+	// failures by provider name, in the order providers were tried
+	Failures []string
+}
+
+func (e *PoolError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrNoHealthyProvider, strings.Join(e.Failures, "; "))
+}
+
+func (e *PoolError) Is(target error) bool {
+	return target == ErrNoHealthyProvider
+}
+
+// Call calls method on the next provider in turn
+func (p *Pool) Call(ctx context.Context, method string, params ...interface{}) (*JSONRPCResponse, error) {
+	return p.call(ctx, p.rotate(), method, params...)
+}
+
+// Preferring returns a client calling provider first, failing over to the
+// other providers of the pool when it fails or is unhealthy, so workers
+// bound to a provider share the pool's view of provider health
+func (p *Pool) Preferring(provider *Provider) *PoolClient {
+	for i, member := range p.members {
+		if member.provider == provider {
+			return &PoolClient{pool: p, first: i}
+		}
+	}
+	return &PoolClient{pool: p, first: -1}
+}
+
+// Weighted returns a client calling a provider picked in proportion to its
+// observed throughput first, so faster providers serve more calls and
+// failing ones fewer, failing over to the other providers like Preferring
+func (p *Pool) Weighted() *PoolClient {
+	return &PoolClient{pool: p, first: -1, weighted: true}
+}
+
+// PoolClient calls the pool starting from a preferred provider
+type PoolClient struct {
+	pool *Pool
+	// index of the preferred provider, -1 rotates like the pool or picks
+	// by weight when weighted
+	first    int
+	weighted bool
+}
+
+func (c *PoolClient) Call(ctx context.Context, method string, params ...interface{}) (*JSONRPCResponse, error) {
+	return c.pool.call(ctx, c.start(), method, params...)
+}
+
+// CallBatch makes requests as a batch, failing over like Call
+func (c *PoolClient) CallBatch(ctx context.Context, requests []Request) ([]*JSONRPCResponse, error) {
+	return c.pool.callBatch(ctx, c.start(), requests)
+}
+
+// start returns the index of the provider to call first
+func (c *PoolClient) start() int {
+	switch {
+	case c.first >= 0:
+		return c.first
+	case c.weighted:
+		return c.pool.pick()
+	default:
+		return c.pool.rotate()
+	}
+}
+
+// Required for CircuitBreaker proxy
+func (c *PoolClient) GetState() string {
+	return "UNDEFINED"
+}
+
+func (p *Pool) rotate() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if len(p.members) == 0 {
+		return 0
+	}
+	first := p.next
+	p.next = (p.next + 1) % len(p.members)
+	return first
+}
+
+// pick returns a healthy provider at random, weighted by the calls per
+// second its average latency allows discounted by its error rate.
+// Providers that didn't answer yet weigh as much as the best one
+func (p *Pool) pick() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	weights := make([]float64, len(p.members))
+	best, total := 0.0, 0.0
+	now := p.now()
+	for i, member := range p.members {
+		if now.Before(member.unhealthyUntil) || member.latency == 0 {
+			continue
+		}
+		weights[i] = (1 - member.errorRate) / member.latency.Seconds()
+		if weights[i] > best {
+			best = weights[i]
+		}
+	}
+	if best == 0 {
+		best = 1
+	}
+	for i, member := range p.members {
+		if now.Before(member.unhealthyUntil) {
+			continue
+		}
+		switch {
+		case member.latency == 0:
+			weights[i] = best
+		case weights[i] < best*minWeightShare:
+			weights[i] = best * minWeightShare
+		}
+		total += weights[i]
+	}
+	if total == 0 {
+		// every provider is unhealthy, the call fails over them in turn
+		return 0
+	}
+	target := p.random() * total
+	for i, weight := range weights {
+		if target < weight {
+			return i
+		}
+		target -= weight
+	}
+	return len(weights) - 1
+}
+
+// Stats returns the health of every provider of the pool, in the order they
+// were given
+func (p *Pool) Stats() []ProviderStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats := make([]ProviderStats, len(p.members))
+	for i, member := range p.members {
+		stats[i] = ProviderStats{
+			Name:      member.provider.Name(),
+			Latency:   member.latency,
+			ErrorRate: member.errorRate,
+			Healthy:   !p.now().Before(member.unhealthyUntil),
+			Answered:  member.answered,
+			Failed:    member.failed,
+		}
+	}
+	return stats
+}
+
+func (p *Pool) call(ctx context.Context, first int, method string, params ...interface{}) (*JSONRPCResponse, error) {
+	var rpcResponse *JSONRPCResponse
+	err := p.try(ctx, first, func(client caller) (err error) {
+		rpcResponse, err = client.Call(ctx, method, params...)
+		return err
+	})
+	return rpcResponse, err
+}
+
+func (p *Pool) callBatch(ctx context.Context, first int, requests []Request) ([]*JSONRPCResponse, error) {
+	var responses []*JSONRPCResponse
+	err := p.try(ctx, first, func(client caller) (err error) {
+		if batcher, ok := client.(batchCaller); ok {
+			responses, err = batcher.CallBatch(ctx, requests)
+		} else {
+			responses, err = callEach(ctx, client, requests)
+		}
+		return err
+	})
+	return responses, err
+}
+
+// try calls every healthy provider once with call, starting from first,
+// until one succeeds. Json rpc error objects are answers, only failed calls
+// fail over
+func (p *Pool) try(ctx context.Context, first int, call func(client caller) error) error {
+	var failures []string
+	for i := 0; i < len(p.members); i++ {
+		member := p.members[(first+i)%len(p.members)]
+		if until, healthy := p.healthy(member); !healthy {
+			failures = append(failures, fmt.Sprintf("%s: unhealthy until %s", member.provider.Name(), until.Format(time.RFC3339)))
+			continue
+		}
+		start := p.now()
+		err := call(member.client)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		p.record(member, err, p.now().Sub(start))
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", member.provider.Name(), err))
+	}
+	return &PoolError{Failures: failures}
+}
+
+func (p *Pool) healthy(member *poolMember) (time.Time, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return member.unhealthyUntil, !p.now().Before(member.unhealthyUntil)
+}
+
+func (p *Pool) record(member *poolMember, err error, latency time.Duration) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if err == nil {
+		member.answered++
+		member.failures = 0
+		member.probation = false
+		member.errorRate *= 1 - statsDecay
+		if latency <= 0 {
+			return
+		}
+		if member.latency == 0 {
+			member.latency = latency
+		} else {
+			member.latency = time.Duration(float64(member.latency)*(1-statsDecay) + float64(latency)*statsDecay)
+		}
+		return
+	}
+	member.failed++
+	member.errorRate = member.errorRate*(1-statsDecay) + statsDecay
+	if p.now().Before(member.unhealthyUntil) {
+		// a call made before the provider was ejected
+		return
+	}
+	member.failures++
+	if member.failures >= p.failureThreshold || member.probation {
+		member.failures = 0
+		member.probation = true
+		member.unhealthyUntil = p.now().Add(p.cooldown)
+		metrics.ProviderEjections.WithLabelValues(member.provider.Name()).Inc()
+	}
+}
+
+// CallResult calls method and decodes its result into result, returning
+// json rpc error objects as errors
+func (p *Pool) CallResult(ctx context.Context, result interface{}, method string, nullResult NullResult, params ...interface{}) error {
+	rpcResponse, err := p.Call(ctx, method, params...)
+	if err != nil {
+		return err
+	}
+	if rpcResponse.Error != nil {
+		return rpcResponse.Error
+	}
+	return DecodeResult(rpcResponse, result, nullResult)
+}
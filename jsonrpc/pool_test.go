@@ -0,0 +1,247 @@
+package jsonrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeCaller answers calls, or fails them while failing is set
+type fakeCaller struct {
+	calls   int32
+	failing int32
+	rpcErr  *JSONRPCError
+}
+
+func (c *fakeCaller) Call(ctx context.Context, method string, params ...interface{}) (*JSONRPCResponse, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if atomic.LoadInt32(&c.failing) == 1 {
+		return nil, errors.New("connection reset by peer")
+	}
+	return &JSONRPCResponse{JSONRPC: "2.0", Result: "0x10", Error: c.rpcErr, ID: 1}, nil
+}
+
+func newFakePool(t *testing.T, n, failureThreshold int, cooldown time.Duration) (*Pool, []*fakeCaller, *time.Time) {
+	t.Helper()
+	providers := make([]*Provider, n)
+	callers := make([]*fakeCaller, n)
+	clients := make([]caller, n)
+	for i := range providers {
+		provider, err := ParseProvider(fmt.Sprintf("p%d=http://127.0.0.1:%d", i, 8545+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers[i], callers[i] = provider, &fakeCaller{}
+		clients[i] = callers[i]
+	}
+	pool := newPool(providers, clients, failureThreshold, cooldown)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time { return now }
+	return pool, callers, &now
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("calls are spread round-robin", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 3, 2, time.Minute)
+		for i := 0; i < 9; i++ {
+			if _, err := pool.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, caller := range callers {
+			if caller.calls != 3 {
+				t.Errorf("got %d calls to p%d, want 3", caller.calls, i)
+			}
+		}
+	})
+
+	t.Run("failing provider fails over and is skipped during its cooldown", func(t *testing.T) {
+		pool, callers, now := newFakePool(t, 2, 2, time.Minute)
+		callers[0].failing = 1
+		for i := 0; i < 6; i++ {
+			if _, err := pool.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// p0 fails twice, then is skipped
+		if callers[0].calls != 2 {
+			t.Errorf("got %d calls to the failing provider, want 2", callers[0].calls)
+		}
+		if callers[1].calls != 6 {
+			t.Errorf("got %d calls to the healthy provider, want 6", callers[1].calls)
+		}
+
+		callers[0].failing = 0
+		*now = now.Add(time.Minute)
+		for i := 0; i < 2; i++ {
+			if _, err := pool.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if callers[0].calls != 3 {
+			t.Errorf("got %d calls to the recovered provider, want it tried again after its cooldown", callers[0].calls)
+		}
+	})
+
+	t.Run("provider back from its cooldown is ejected by its first failure", func(t *testing.T) {
+		pool, callers, now := newFakePool(t, 2, 2, time.Minute)
+		callers[0].failing = 1
+		for i := 0; i < 4; i++ {
+			pool.Call(ctx, "eth_blockNumber")
+		}
+		*now = now.Add(time.Minute)
+		for i := 0; i < 4; i++ {
+			pool.Call(ctx, "eth_blockNumber")
+		}
+		if callers[0].calls != 3 {
+			t.Errorf("got %d calls to the failing provider, want a single one after its cooldown", callers[0].calls)
+		}
+
+		// answering a call ends its probation
+		callers[0].failing = 0
+		*now = now.Add(time.Minute)
+		pool.Call(ctx, "eth_blockNumber")
+		callers[0].failing = 1
+		for i := 0; i < 4; i++ {
+			pool.Call(ctx, "eth_blockNumber")
+		}
+		if callers[0].calls != 6 {
+			t.Errorf("got %d calls to the recovered provider, want it ejected after %d failures again", callers[0].calls, 2)
+		}
+	})
+
+	t.Run("every provider unhealthy returns an aggregated error", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 2, 1, time.Minute)
+		for _, caller := range callers {
+			caller.failing = 1
+		}
+		_, err := pool.Call(ctx, "eth_blockNumber")
+		if !errors.Is(err, ErrNoHealthyProvider) {
+			t.Fatalf("got %v, want %v", err, ErrNoHealthyProvider)
+		}
+		for _, name := range []string{"p0: connection reset by peer", "p1: connection reset by peer"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("got %q, want it to contain %q", err, name)
+			}
+		}
+
+		// now in cooldown, the call fails without calling any provider
+		_, err = pool.Call(ctx, "eth_blockNumber")
+		if !errors.Is(err, ErrNoHealthyProvider) || !strings.Contains(err.Error(), "unhealthy until") {
+			t.Errorf("got %v, want every provider reported unhealthy", err)
+		}
+		if callers[0].calls+callers[1].calls != 2 {
+			t.Errorf("got %d calls, want unhealthy providers skipped", callers[0].calls+callers[1].calls)
+		}
+	})
+
+	t.Run("json rpc error objects don't fail over", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 2, 1, time.Minute)
+		callers[0].rpcErr = &JSONRPCError{Code: -32601, Message: "the method does not exist"}
+		rpcResponse, err := pool.Call(ctx, "eth_unknown")
+		if err != nil || rpcResponse.Error == nil {
+			t.Fatalf("got %v, %v, want the rpc error answered", rpcResponse, err)
+		}
+		if callers[1].calls != 0 {
+			t.Errorf("got %d calls to the other provider, want none", callers[1].calls)
+		}
+	})
+
+	t.Run("preferring client starts from its provider", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 3, 1, time.Minute)
+		client := pool.Preferring(pool.members[2].provider)
+		for i := 0; i < 3; i++ {
+			if _, err := client.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if callers[2].calls != 3 {
+			t.Errorf("got %d calls to the preferred provider, want 3", callers[2].calls)
+		}
+
+		callers[2].failing = 1
+		if _, err := client.Call(ctx, "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+		if callers[0].calls != 1 {
+			t.Errorf("got %d calls to the next provider, want the preferred one to fail over", callers[0].calls)
+		}
+	})
+
+	t.Run("weighted client spreads calls by observed throughput", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 2, 3, time.Minute)
+		// p0 answers in 10ms and p1 in 30ms, so p0 is picked three times as often
+		pool.record(pool.members[0], nil, 10*time.Millisecond)
+		pool.record(pool.members[1], nil, 30*time.Millisecond)
+		picks := 0
+		pool.random = func() float64 {
+			picks++
+			return float64(picks%100) / 100
+		}
+		client := pool.Weighted()
+		for i := 0; i < 100; i++ {
+			if _, err := client.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if callers[0].calls != 75 || callers[1].calls != 25 {
+			t.Errorf("got %d and %d calls, want 75 and 25", callers[0].calls, callers[1].calls)
+		}
+	})
+
+	t.Run("weighted client skips unhealthy providers and tracks error rates", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 2, 1, time.Minute)
+		pool.random = func() float64 { return 0 }
+		callers[0].failing = 1
+		client := pool.Weighted()
+		for i := 0; i < 3; i++ {
+			if _, err := client.Call(ctx, "eth_blockNumber"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// p0 fails the first call over to p1 and is skipped after it
+		if callers[0].calls != 1 || callers[1].calls != 3 {
+			t.Errorf("got %d and %d calls, want the failing provider called once", callers[0].calls, callers[1].calls)
+		}
+		stats := pool.Stats()
+		if stats[0].Healthy || stats[0].ErrorRate == 0 || !stats[1].Healthy || stats[1].ErrorRate != 0 {
+			t.Errorf("got %+v, want p0 unhealthy with errors and p1 healthy", stats)
+		}
+		if stats[0].Answered != 0 || stats[1].Answered != 3 {
+			t.Errorf("got %d and %d calls answered, want p1 to answer every call", stats[0].Answered, stats[1].Answered)
+		}
+		if stats[0].Failed != 1 || stats[1].Failed != 0 {
+			t.Errorf("got %d and %d calls failed, want p0 to fail one call", stats[0].Failed, stats[1].Failed)
+		}
+	})
+
+	t.Run("pool is safe for concurrent use", func(t *testing.T) {
+		pool, callers, _ := newFakePool(t, 4, 2, time.Minute)
+		callers[3].failing = 1
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					if _, err := pool.Call(ctx, "eth_blockNumber"); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		served := callers[0].calls + callers[1].calls + callers[2].calls
+		if served != 1000 {
+			t.Errorf("got %d calls served, want 1000", served)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Provider is an RPC endpoint with an optional human-readable label
+type Provider struct {
+	Label string
+	URL   *url.URL
+	// signs every request to the provider when set
+	Signer RequestSigner
+	// encoding of block timestamps, detected when unset
+	TimestampFormat NumberFormat
+	// method returning the blocks between two block numbers (inclusive),
+	// contiguous blocks are fetched with it when set
+	RangeMethod string
+	// how calls to the provider are retried, DefaultRetryConfig when unset
+	Retry RetryConfig
+	// shared by every client of the provider, every request waits for a
+	// token when set
+	Limiter *rate.Limiter
+	// sent on every request to the provider, such as credentials of an
+	// authenticated gateway. Credentials in the url are sent as basic auth
+	Headers http.Header
+	// largest json rpc batch request the provider accepts, larger batches
+	// are split under it. Unbounded when unset
+	MaxBatchSize int
+}
+
+// ParseProvider parses a provider definition of the form "[label=]url",
+// e.g. "infura-primary=https://mainnet.infura.io/v3/key"
+func ParseProvider(definition string) (*Provider, error) {
+	var label string
+	rawURL := definition
+	// only treat '=' as a label separator when it comes before the scheme,
+	// urls are allowed to carry '=' in their query string
+	if i := strings.Index(definition, "="); i != -1 && !strings.ContainsAny(definition[:i], ":/") {
+		label = definition[:i]
+		rawURL = definition[i+1:]
+		if label == "" {
+			return nil, fmt.Errorf("empty label in provider definition '%s'", definition)
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == SyntheticScheme {
+		if _, err = newSyntheticTransport(u); err != nil {
+			return nil, err
+		}
+	} else if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid provider url '%s'", u.Redacted())
+	}
+
+	return &Provider{Label: label, URL: u}, nil
+}
+
+// ParseRateLimit parses a rate limit of the form "rps[:burst]", e.g. "5:10"
+// for 5 requests per second in bursts of up to 10. The burst defaults to
+// rps, at least 1
+func ParseRateLimit(s string) (rate.Limit, int, error) {
+	parts := strings.SplitN(s, ":", 2)
+	rps, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rps <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit '%s', want rps[:burst]", s)
+	}
+	burst := int(rps)
+	if len(parts) == 2 {
+		if burst, err = strconv.Atoi(parts[1]); err != nil || burst < 1 {
+			return 0, 0, fmt.Errorf("invalid burst in rate limit '%s'", s)
+		}
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.Limit(rps), burst, nil
+}
+
+// Name returns the provider label, falling back to the redacted url when unset
+func (p *Provider) Name() string {
+	if p.Label != "" {
+		return p.Label
+	}
+	return p.URL.Redacted()
+}
+
+func (p *Provider) String() string {
+	return p.URL.String()
+}
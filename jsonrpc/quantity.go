@@ -0,0 +1,74 @@
+package jsonrpc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NumberFormat is how a provider encodes numeric quantities. Standard
+// ethereum providers use hex, some janus-compatible gateways use decimal
+type NumberFormat string
+
+const (
+	// NumberAuto parses 0x prefixed quantities as hex, others as decimal
+	NumberAuto    NumberFormat = "auto"
+	NumberHex     NumberFormat = "hex"
+	NumberDecimal NumberFormat = "decimal"
+)
+
+// ParseNumberFormat parses the name of a number format
+func ParseNumberFormat(s string) (NumberFormat, error) {
+	switch format := NumberFormat(s); format {
+	case NumberAuto, NumberHex, NumberDecimal:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown number format %q, want %s, %s or %s", s, NumberAuto, NumberHex, NumberDecimal)
+	}
+}
+
+// ParseQuantity parses a numeric quantity encoded in format, the zero format
+// being NumberAuto. Unlike base 0 parsing, a leading zero is never octal
+func ParseQuantity(s string, format NumberFormat) (int64, error) {
+	hex := strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X")
+	switch format {
+	case NumberHex:
+		return strconv.ParseInt(strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X"), 16, 64)
+	case NumberDecimal:
+		return strconv.ParseInt(s, 10, 64)
+	case NumberAuto, "":
+		if hex {
+			return strconv.ParseInt(s[2:], 16, 64)
+		}
+		return strconv.ParseInt(s, 10, 64)
+	default:
+		return 0, fmt.Errorf("unknown number format %q", format)
+	}
+}
+
+// NormalizeTimestamp rewrites the timestamp of a block result encoded in
+// format as the hex quantity block headers decode, so the eth block hash is
+// computed over the right timestamp whatever the provider's encoding
+func NormalizeTimestamp(rpcResponse *JSONRPCResponse, format NumberFormat) error {
+	block, ok := rpcResponse.Result.(map[string]interface{})
+	if !ok {
+		// null, the block isn't there
+		return nil
+	}
+	var timestamp int64
+	var err error
+	switch value := block["timestamp"].(type) {
+	case string:
+		timestamp, err = ParseQuantity(value, format)
+	case float64:
+		// some gateways return it as a json number
+		timestamp = int64(value)
+	default:
+		return fmt.Errorf("invalid block timestamp %v", value)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid block timestamp %v: %w", block["timestamp"], err)
+	}
+	block["timestamp"] = fmt.Sprintf("0x%x", timestamp)
+	return nil
+}
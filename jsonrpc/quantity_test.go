@@ -0,0 +1,101 @@
+package jsonrpc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseQuantity(t *testing.T) {
+	for _, tc := range []struct {
+		s      string
+		format NumberFormat
+		want   int64
+	}{
+		{"0x5f5e1000", NumberAuto, 1600000000},
+		{"1600000000", NumberAuto, 1600000000},
+		{"0x5f5e1000", "", 1600000000},
+		{"010", NumberAuto, 10},
+		{"0x5f5e1000", NumberHex, 1600000000},
+		{"5f5e1000", NumberHex, 1600000000},
+		{"1600000000", NumberDecimal, 1600000000},
+	} {
+		got, err := ParseQuantity(tc.s, tc.format)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseQuantity(%q, %q) = %d, %v, want %d", tc.s, tc.format, got, err, tc.want)
+		}
+	}
+
+	t.Run("quantities not in the format are rejected", func(t *testing.T) {
+		for _, tc := range []struct {
+			s      string
+			format NumberFormat
+		}{
+			{"0x5f5e1000", NumberDecimal},
+			{"5f5e1000", NumberAuto},
+			{"1600000000", "octal"},
+		} {
+			if _, err := ParseQuantity(tc.s, tc.format); err == nil {
+				t.Errorf("expected error parsing %q as %q", tc.s, tc.format)
+			}
+		}
+	})
+}
+
+func TestNormalizeTimestamp(t *testing.T) {
+	blockWithTimestamp := func(t *testing.T, timestamp string) *JSONRPCResponse {
+		t.Helper()
+		header := syntheticBlock(7)
+		header["timestamp"] = json.RawMessage(timestamp)
+		raw, _ := json.Marshal(header)
+		var rpcResponse JSONRPCResponse
+		if err := json.Unmarshal([]byte(`{"jsonrpc":"2.0","id":1,"result":`+string(raw)+`}`), &rpcResponse); err != nil {
+			t.Fatal(err)
+		}
+		return &rpcResponse
+	}
+	decode := func(t *testing.T, rpcResponse *JSONRPCResponse, format NumberFormat) EthBlockHeader {
+		t.Helper()
+		if err := NormalizeTimestamp(rpcResponse, format); err != nil {
+			t.Fatal(err)
+		}
+		var header EthBlockHeader
+		if err := GetBlockFromRPCResponse(rpcResponse, &header); err != nil {
+			t.Fatal(err)
+		}
+		return header
+	}
+
+	hexHeader := decode(t, blockWithTimestamp(t, `"0x5f5e1000"`), NumberHex)
+	for _, tc := range []struct {
+		name      string
+		timestamp string
+		format    NumberFormat
+	}{
+		{"hex timestamp is detected", `"0x5f5e1000"`, NumberAuto},
+		{"decimal timestamp is detected", `"1600000000"`, NumberAuto},
+		{"decimal timestamp is parsed as configured", `"1600000000"`, NumberDecimal},
+		{"json number timestamp is converted", `1600000000`, NumberAuto},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header := decode(t, blockWithTimestamp(t, tc.timestamp), tc.format)
+			if header.Time != 1600000000 {
+				t.Errorf("got timestamp %d, want 1600000000", header.Time)
+			}
+			if header.Hash() != hexHeader.Hash() {
+				t.Errorf("got hash %s, want %s", header.Hash(), hexHeader.Hash())
+			}
+		})
+	}
+
+	t.Run("timestamp not in the configured format is rejected", func(t *testing.T) {
+		if err := NormalizeTimestamp(blockWithTimestamp(t, `"0x5f5e1000"`), NumberDecimal); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("null block is left alone", func(t *testing.T) {
+		if err := NormalizeTimestamp(&JSONRPCResponse{}, NumberAuto); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package jsonrpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// RequestSigner signs requests before they're sent, for providers such as
+// managed gateways that authenticate every request
+type RequestSigner interface {
+	Sign(req *http.Request, body []byte)
+}
+
+// HMACSigner sets its header to the hex encoded HMAC-SHA256 of the request
+// body under a shared secret
+type HMACSigner struct {
+	header string
+	secret []byte
+}
+
+func NewHMACSigner(header string, secret []byte) *HMACSigner {
+	return &HMACSigner{header: header, secret: secret}
+}
+
+func (s *HMACSigner) Sign(req *http.Request, body []byte) {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write(body)
+	req.Header.Set(s.header, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// String describes the signer without its secret, so it's safe to log
+func (s *HMACSigner) String() string {
+	return fmt.Sprintf("hmac-sha256 in %s", s.header)
+}
+
+// ParseSigning parses a request signing definition of the form
+// "label=header:secretFile", signing requests to the provider labeled label
+// with the secret read from secretFile. The secret is read from a file so it
+// stays out of the command line and logs
+func ParseSigning(definition string) (string, *HMACSigner, error) {
+	i := strings.Index(definition, "=")
+	j := strings.Index(definition, ":")
+	if i < 1 || j < i+2 || j == len(definition)-1 {
+		return "", nil, fmt.Errorf("invalid request signing '%s', want label=header:secretFile", definition)
+	}
+	label, header, secretFile := definition[:i], definition[i+1:j], definition[j+1:]
+
+	secret, err := ioutil.ReadFile(secretFile)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading the request signing secret of '%s': %s", label, err)
+	}
+	secret = []byte(strings.TrimRight(string(secret), "\r\n"))
+	if len(secret) == 0 {
+		return "", nil, fmt.Errorf("empty request signing secret for '%s'", label)
+	}
+	return label, NewHMACSigner(header, secret), nil
+}
@@ -0,0 +1,92 @@
+package jsonrpc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHMACSigner(t *testing.T) {
+	t.Run("known body and secret get the expected signature", func(t *testing.T) {
+		body := []byte(`{"jsonrpc":"2.0","method":"eth_blockNumber","params":[],"id":1}`)
+		req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1", nil)
+		NewHMACSigner("X-Signature", []byte("gateway-secret")).Sign(req, body)
+
+		want := "5ec32d1a0e80e81c6d5063509babb231ff414dcd70a535c0bd4c1d3b18c8e38c"
+		if got := req.Header.Get("X-Signature"); got != want {
+			t.Errorf("got signature %s, want %s", got, want)
+		}
+	})
+
+	t.Run("provider clients sign every request", func(t *testing.T) {
+		var bodies, signatures []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(body))
+			signatures = append(signatures, r.Header.Get("X-Gateway-Signature"))
+			fmt.Fprint(w, `{"jsonrpc":"2.0","id":1,"result":"0x10"}`)
+		}))
+		defer server.Close()
+
+		provider, err := ParseProvider("gateway=" + server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		provider.Signer = NewHMACSigner("X-Gateway-Signature", []byte("gateway-secret"))
+		var result string
+		if err = NewProviderClient(provider, 1).CallResult(context.Background(), &result, "eth_blockNumber"); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bodies) != 1 {
+			t.Fatalf("got %d requests, want 1", len(bodies))
+		}
+		mac := hmac.New(sha256.New, []byte("gateway-secret"))
+		mac.Write([]byte(bodies[0]))
+		if want := hex.EncodeToString(mac.Sum(nil)); signatures[0] != want {
+			t.Errorf("got signature %s, want %s", signatures[0], want)
+		}
+	})
+
+	t.Run("signer doesn't reveal its secret", func(t *testing.T) {
+		signer := NewHMACSigner("X-Signature", []byte("gateway-secret"))
+		for _, s := range []string{signer.String(), fmt.Sprint(signer), fmt.Sprintf("%v", signer)} {
+			if strings.Contains(s, "gateway-secret") {
+				t.Errorf("got %q, want it without the secret", s)
+			}
+		}
+	})
+}
+
+func TestParseSigning(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(secretFile, []byte("gateway-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("signing definition is parsed and its secret read", func(t *testing.T) {
+		label, signer, err := ParseSigning("gateway=X-Signature:" + secretFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if label != "gateway" || signer.header != "X-Signature" || string(signer.secret) != "gateway-secret" {
+			t.Errorf("got label %s header %s secret %q", label, signer.header, signer.secret)
+		}
+	})
+
+	t.Run("invalid definitions are rejected", func(t *testing.T) {
+		for _, definition := range []string{"gateway", "=X-Signature:" + secretFile, "gateway=:" + secretFile, "gateway=X-Signature:", "gateway=X-Signature:/does/not/exist"} {
+			if _, _, err := ParseSigning(definition); err == nil {
+				t.Errorf("expected error parsing %q", definition)
+			}
+		}
+	})
+}
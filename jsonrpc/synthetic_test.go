@@ -0,0 +1,75 @@
+package jsonrpc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSyntheticProvider(t *testing.T) {
+	provider, err := ParseProvider("bench=synthetic://?head=100&chainId=8889")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewProviderClient(provider, 0)
+
+	t.Run("latest block is the configured head", func(t *testing.T) {
+		var block GetBlockByNumberResponse
+		if err := c.CallResult(context.Background(), &block, "eth_getBlockByNumber", "latest", false); err != nil {
+			t.Fatal(err)
+		}
+		if block.Number != "0x64" || block.Hash != SyntheticBlockHash(100) {
+			t.Errorf("got block %s %s, want block 0x64", block.Number, block.Hash)
+		}
+	})
+
+	t.Run("generated block decodes as an ethereum header", func(t *testing.T) {
+		rpcResponse, err := c.Call(context.Background(), "eth_getBlockByNumber", "0x2a", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header EthBlockHeader
+		if err := GetBlockFromRPCResponse(rpcResponse, &header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Number.Int64() != 42 {
+			t.Errorf("got block %d, want 42", header.Number.Int64())
+		}
+	})
+
+	t.Run("blocks past the head aren't mined yet", func(t *testing.T) {
+		c.SetNullResult(NullResultNotFound)
+		var block GetBlockByNumberResponse
+		if err := c.CallResult(context.Background(), &block, "eth_getBlockByNumber", "0x65", false); err != ErrNotFound {
+			t.Errorf("got %v, want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("chain id is the configured one", func(t *testing.T) {
+		var chainId string
+		if err := c.CallResult(context.Background(), &chainId, "eth_chainId"); err != nil {
+			t.Fatal(err)
+		}
+		if chainId != "0x22b9" {
+			t.Errorf("got chain id %s, want 0x22b9", chainId)
+		}
+	})
+
+	t.Run("batches are answered", func(t *testing.T) {
+		responses, err := c.CallBatch(context.Background(), []Request{
+			{Method: "eth_chainId"},
+			{Method: "eth_blockNumber"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if responses[0].Result != "0x22b9" || responses[1].Result != "0x64" {
+			t.Errorf("got results %v and %v, want 0x22b9 and 0x64", responses[0].Result, responses[1].Result)
+		}
+	})
+
+	t.Run("invalid parameters are rejected", func(t *testing.T) {
+		if _, err := ParseProvider("synthetic://?latency=fast"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
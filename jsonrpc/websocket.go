@@ -0,0 +1,377 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// errConnectionDropped fails the requests awaiting answers on a dropped socket
+var errConnectionDropped = errors.New("websocket connection dropped")
+
+// IsWebsocketURL reports whether rawURL is a ws:// or wss:// url
+func IsWebsocketURL(rawURL string) bool {
+	u, err := neturl.Parse(rawURL)
+	return err == nil && (u.Scheme == "ws" || u.Scheme == "wss")
+}
+
+// WebsocketTransport carries json rpc requests over a websocket connection,
+// so clients of ws:// and wss:// providers work as they do over http, and
+// subscribes to notifications pushed by the provider. Requests are given ids
+// of their own on the socket and answers are matched back to them whatever
+// order they arrive in. The connection is dialed on the first request and
+// redialed by the first request after it drops
+type WebsocketTransport struct {
+	url string
+	// sent with the handshake
+	header        http.Header
+	mutex         sync.Mutex
+	conn          *websocket.Conn
+	nextID        int
+	calls         map[int]*websocketCall
+	subscriptions map[string]chan json.RawMessage
+}
+
+// websocketCall is a request, or the requests of a batch, awaiting answers
+type websocketCall struct {
+	// request ids by their id on the socket
+	ids     map[int]json.RawMessage
+	batch   bool
+	answers []json.RawMessage
+	// registered under the subscription id answered, for eth_subscribe
+	subscription chan json.RawMessage
+	done         chan struct{}
+	err          error
+}
+
+// websocketMessage is the part of an answer or a notification routing it
+type websocketMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Params struct {
+		Subscription string          `json:"subscription"`
+		Result       json.RawMessage `json:"result"`
+	} `json:"params"`
+}
+
+func NewWebsocketTransport(url string) *WebsocketTransport {
+	return &WebsocketTransport{
+		url:           url,
+		calls:         make(map[int]*websocketCall),
+		subscriptions: make(map[string]chan json.RawMessage),
+	}
+}
+
+// RoundTrip sends a request, or each request of a batch, over the socket and
+// answers with what the provider answered them
+func (t *WebsocketTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	content, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []json.RawMessage
+	batch := false
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		batch = true
+		err = json.Unmarshal(content, &requests)
+	} else {
+		requests = []json.RawMessage{content}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	answers, err := t.call(req.Context(), requests, batch, nil)
+	if err != nil {
+		return nil, err
+	}
+	body := answers[0]
+	if batch {
+		if body, err = json.Marshal(answers); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// Subscribe calls eth_subscribe with params, streaming the results of the
+// notifications of the subscription. The channel is closed when the socket
+// drops or ctx is cancelled, resubscribing is up to the caller
+func (t *WebsocketTransport) Subscribe(ctx context.Context, params ...interface{}) (<-chan json.RawMessage, error) {
+	request, err := json.Marshal(newJSONRPCRequest("eth_subscribe", params...))
+	if err != nil {
+		return nil, err
+	}
+	notifications := make(chan json.RawMessage, 16)
+	answers, err := t.call(ctx, []json.RawMessage{request}, false, notifications)
+	if err != nil {
+		return nil, err
+	}
+	var reply JSONRPCResponse
+	if err = json.Unmarshal(answers[0], &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	id, ok := reply.Result.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid subscription id %v", reply.Result)
+	}
+
+	go func() {
+		<-ctx.Done()
+		t.unsubscribe(id)
+	}()
+	return notifications, nil
+}
+
+// Close closes the connection, failing the requests awaiting answers
+func (t *WebsocketTransport) Close() error {
+	t.mutex.Lock()
+	conn := t.conn
+	t.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.Close()
+}
+
+// call sends requests, returning their answers in order
+func (t *WebsocketTransport) call(ctx context.Context, requests []json.RawMessage, batch bool, subscription chan json.RawMessage) ([]json.RawMessage, error) {
+	call := &websocketCall{
+		ids:          make(map[int]json.RawMessage, len(requests)),
+		batch:        batch,
+		subscription: subscription,
+		done:         make(chan struct{}),
+	}
+	messages := make([]json.RawMessage, len(requests))
+	order := make([]int, len(requests))
+
+	t.mutex.Lock()
+	conn, err := t.connect(ctx)
+	if err != nil {
+		t.mutex.Unlock()
+		return nil, err
+	}
+	for i, request := range requests {
+		var fields map[string]json.RawMessage
+		if err = json.Unmarshal(request, &fields); err != nil {
+			t.mutex.Unlock()
+			return nil, err
+		}
+		t.nextID++
+		order[i] = t.nextID
+		call.ids[t.nextID] = fields["id"]
+		fields["id"], _ = json.Marshal(t.nextID)
+		if messages[i], err = json.Marshal(fields); err != nil {
+			t.mutex.Unlock()
+			return nil, err
+		}
+	}
+	var message interface{} = messages[0]
+	if batch {
+		message = messages
+	}
+	for _, id := range order {
+		t.calls[id] = call
+	}
+	// gorilla connections support a single concurrent writer
+	err = conn.WriteJSON(message)
+	t.mutex.Unlock()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		t.mutex.Lock()
+		for _, id := range order {
+			delete(t.calls, id)
+		}
+		t.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+	if call.err != nil {
+		return nil, call.err
+	}
+	return call.answers, nil
+}
+
+// connect returns the connection, dialing it unless it's up. The mutex
+// must be held
+func (t *WebsocketTransport) connect(ctx context.Context) (*websocket.Conn, error) {
+	if t.conn != nil {
+		return t.conn, nil
+	}
+	// websocket urls can't carry credentials, they're sent as basic auth
+	url, authorization := withoutCredentials(t.url)
+	header := http.Header{}
+	for name, values := range t.header {
+		header[name] = values
+	}
+	if authorization != "" && header.Get("Authorization") == "" {
+		header.Set("Authorization", authorization)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = conn
+	go t.read(conn)
+	return conn, nil
+}
+
+// read routes the messages of conn until it drops
+func (t *WebsocketTransport) read(conn *websocket.Conn) {
+	for {
+		_, content, err := conn.ReadMessage()
+		if err != nil {
+			t.drop(conn)
+			return
+		}
+
+		var messages []json.RawMessage
+		if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+			if json.Unmarshal(content, &messages) != nil {
+				continue
+			}
+		} else {
+			messages = []json.RawMessage{content}
+		}
+
+		t.mutex.Lock()
+		answered := make(map[*websocketCall]bool)
+		for _, message := range messages {
+			if call := t.deliver(message); call != nil {
+				answered[call] = true
+			}
+		}
+		for call := range answered {
+			// a batch is answered in a single array, entries left out of
+			// it are left unanswered
+			if len(call.ids) == 0 || call.batch {
+				t.finish(call)
+			}
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// deliver routes a single answer or notification, returning the call it
+// answers. The mutex must be held
+func (t *WebsocketTransport) deliver(content json.RawMessage) *websocketCall {
+	var message websocketMessage
+	if json.Unmarshal(content, &message) != nil {
+		return nil
+	}
+	if message.Method == "eth_subscription" {
+		if notifications, ok := t.subscriptions[message.Params.Subscription]; ok {
+			select {
+			case notifications <- message.Params.Result:
+			default:
+				// a later notification supersedes one the subscriber
+				// hasn't taken yet
+			}
+		}
+		return nil
+	}
+	if message.ID == nil {
+		return nil
+	}
+	call, ok := t.calls[*message.ID]
+	if !ok {
+		return nil
+	}
+	delete(t.calls, *message.ID)
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(content, &fields) == nil {
+		fields["id"] = call.ids[*message.ID]
+		if answer, err := json.Marshal(fields); err == nil {
+			content = answer
+		}
+	}
+	delete(call.ids, *message.ID)
+	call.answers = append(call.answers, content)
+
+	if call.subscription != nil {
+		// registered before the next message is read, which may be the
+		// subscription's first notification
+		var id string
+		if json.Unmarshal(message.Result, &id) == nil {
+			t.subscriptions[id] = call.subscription
+		}
+	}
+	return call
+}
+
+// finish releases a call's caller. The mutex must be held
+func (t *WebsocketTransport) finish(call *websocketCall) {
+	for id := range call.ids {
+		delete(t.calls, id)
+	}
+	close(call.done)
+}
+
+// drop forgets conn, failing the calls awaiting answers on it and ending
+// its subscriptions
+func (t *WebsocketTransport) drop(conn *websocket.Conn) {
+	conn.Close()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.conn != conn {
+		return
+	}
+	t.conn = nil
+	finished := make(map[*websocketCall]bool)
+	for id, call := range t.calls {
+		delete(t.calls, id)
+		if !finished[call] {
+			finished[call] = true
+			call.err = errConnectionDropped
+			close(call.done)
+		}
+	}
+	for id, notifications := range t.subscriptions {
+		delete(t.subscriptions, id)
+		close(notifications)
+	}
+}
+
+// unsubscribe ends the subscription id
+func (t *WebsocketTransport) unsubscribe(id string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	notifications, ok := t.subscriptions[id]
+	if !ok {
+		return
+	}
+	delete(t.subscriptions, id)
+	close(notifications)
+	if t.conn != nil {
+		// the answer is ignored, it has no call awaiting it
+		request := newJSONRPCRequest("eth_unsubscribe", id)
+		t.nextID++
+		request.ID = t.nextID
+		t.conn.WriteJSON(request)
+	}
+}
@@ -0,0 +1,118 @@
+package jsonrpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketServer answers eth_blockNumber with the id it was sent with, a
+// batch in reverse order, and pushes a notification after each subscription
+func websocketServer(t *testing.T) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		answer := func(request JSONRPCRequest) map[string]interface{} {
+			if request.Method == "eth_subscribe" {
+				return map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": "0xabc"}
+			}
+			return map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": request.ID}
+		}
+		for {
+			_, content, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var batch []JSONRPCRequest
+			if json.Unmarshal(content, &batch) == nil {
+				answers := make([]interface{}, len(batch))
+				for i, request := range batch {
+					answers[len(batch)-1-i] = answer(request)
+				}
+				conn.WriteJSON(answers)
+				continue
+			}
+			var request JSONRPCRequest
+			if json.Unmarshal(content, &request) != nil {
+				return
+			}
+			conn.WriteJSON(answer(request))
+			if request.Method == "eth_subscribe" {
+				conn.WriteJSON(map[string]interface{}{
+					"jsonrpc": "2.0",
+					"method":  "eth_subscription",
+					"params":  map[string]interface{}{"subscription": "0xabc", "result": map[string]interface{}{"number": "0x10"}},
+				})
+			}
+		}
+	}))
+}
+
+func TestWebsocketTransport(t *testing.T) {
+	server := websocketServer(t)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	t.Run("calls are answered with the ids they were made with", func(t *testing.T) {
+		client := NewClient(url, 1, RetryConfig{})
+		for i := 0; i < 2; i++ {
+			rpcResponse, err := client.Call(context.Background(), "eth_blockNumber")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rpcResponse.ID != 1 {
+				t.Errorf("got answer id %d, want 1", rpcResponse.ID)
+			}
+			// the server echoes the id the request had on the socket
+			if rpcResponse.Result != float64(i+1) {
+				t.Errorf("got socket id %v, want %d", rpcResponse.Result, i+1)
+			}
+		}
+	})
+
+	t.Run("batches are matched back to their requests", func(t *testing.T) {
+		client := NewClient(url, 1, RetryConfig{})
+		responses, err := client.CallBatch(context.Background(), []Request{{Method: "eth_blockNumber"}, {Method: "eth_blockNumber"}, {Method: "eth_blockNumber"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, response := range responses {
+			if response.ID != i+1 || response.Result != float64(i+1) {
+				t.Errorf("got response %d %+v, want id and socket id %d", i, response, i+1)
+			}
+		}
+	})
+
+	t.Run("subscription notifications are streamed", func(t *testing.T) {
+		transport := NewWebsocketTransport(url)
+		defer transport.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		notifications, err := transport.Subscribe(ctx, "newHeads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if notification := <-notifications; string(notification) != `{"number":"0x10"}` {
+			t.Errorf("got notification %s, want head 0x10", notification)
+		}
+		cancel()
+		if _, ok := <-notifications; ok {
+			t.Error("subscription wasn't ended by cancelling it")
+		}
+	})
+
+	t.Run("http urls aren't websocket ones", func(t *testing.T) {
+		if IsWebsocketURL(server.URL) || !IsWebsocketURL(url) {
+			t.Errorf("got %s and %s misclassified", server.URL, url)
+		}
+	})
+}
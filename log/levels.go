@@ -0,0 +1,88 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// moduleAliases are the module or component fields of the lines logged by
+// each module whose level can be set
+var moduleAliases = map[string][]string{
+	"dispatcher": {"dispatcher"},
+	"workers":    {"worker", "chainIdMonitor"},
+	"db":         {"db", "leader"},
+	"jsonrpc":    {"httpClient"},
+}
+
+// ParseLevel parses a level of the form "[module=]level", e.g. "db=warn",
+// returning an empty module for the level of every other line
+func ParseLevel(definition string) (string, logrus.Level, error) {
+	module, name := "", definition
+	if i := strings.Index(definition, "="); i != -1 {
+		module, name = definition[:i], definition[i+1:]
+		if module == "" {
+			return "", 0, fmt.Errorf("empty module in log level '%s'", definition)
+		}
+	}
+	level, err := logrus.ParseLevel(name)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid log level '%s': %w", definition, err)
+	}
+	return module, level, nil
+}
+
+// WithLevels sets the level of lines by their module, those of other
+// modules are logged at level. Modules are dispatcher, workers, db and
+// jsonrpc, or the module field of lines, such as blockCache. It applies to
+// the formatter set by then, so it goes after WithFormat
+func WithLevels(level logrus.Level, modules map[string]logrus.Level) Option {
+	return func(logger *logrus.Logger) error {
+		levels := map[string]logrus.Level{}
+		verbosest := level
+		for module, moduleLevel := range modules {
+			aliases, ok := moduleAliases[module]
+			if !ok {
+				aliases = []string{module}
+			}
+			for _, alias := range aliases {
+				levels[alias] = moduleLevel
+			}
+			if moduleLevel > verbosest {
+				verbosest = moduleLevel
+			}
+		}
+		// the logger lets through the lines of the verbosest module, the
+		// formatter drops those of others
+		logger.SetLevel(verbosest)
+		if len(levels) > 0 {
+			logger.SetFormatter(&levelFormatter{Formatter: logger.Formatter, level: level, levels: levels})
+		}
+		return nil
+	}
+}
+
+// levelFormatter formats the lines logged at the level of their module, and
+// nothing for the others
+type levelFormatter struct {
+	logrus.Formatter
+	level  logrus.Level
+	levels map[string]logrus.Level
+}
+
+func (f *levelFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	level := f.level
+	for _, key := range []string{"module", "component"} {
+		if module, ok := entry.Data[key].(string); ok {
+			if moduleLevel, ok := f.levels[module]; ok {
+				level = moduleLevel
+				break
+			}
+		}
+	}
+	if entry.Level > level {
+		return nil, nil
+	}
+	return f.Formatter.Format(entry)
+}
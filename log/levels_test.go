@@ -0,0 +1,60 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestParseLevel(t *testing.T) {
+	module, level, err := ParseLevel("db=warn")
+	if err != nil || module != "db" || level != logrus.WarnLevel {
+		t.Errorf("got %s, %s and %v, want db at warn", module, level, err)
+	}
+	if module, level, err = ParseLevel("info"); err != nil || module != "" || level != logrus.InfoLevel {
+		t.Errorf("got %s, %s and %v, want info for every module", module, level, err)
+	}
+	for _, definition := range []string{"=info", "db=loud"} {
+		if _, _, err = ParseLevel(definition); err == nil {
+			t.Errorf("expected an error for '%s'", definition)
+		}
+	}
+}
+
+func TestWithLevels(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := createNewLogger(
+		WithFormat("json"),
+		WithWriter(&output),
+		WithLevels(logrus.InfoLevel, map[string]logrus.Level{"workers": logrus.DebugLevel, "db": logrus.ErrorLevel}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.WithField("component", "worker").Debug("worker debug")
+	logger.WithField("module", "db").Warn("db warning")
+	logger.WithField("module", "db").Error("db error")
+	logger.WithField("module", "dispatcher").Debug("dispatcher debug")
+	logger.Info("main info")
+
+	var messages []string
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("line %q isn't json: %v", line, err)
+		}
+		messages = append(messages, fields["msg"].(string))
+	}
+	if got := strings.Join(messages, ", "); got != "worker debug, db error, main info" {
+		t.Errorf("got lines %s, want those at the level of their module", got)
+	}
+}
+
+func TestWithFormat(t *testing.T) {
+	if _, err := createNewLogger(WithFormat("xml")); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -5,9 +5,11 @@ import (
 	"io"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/rifflock/lfshook"
 	"github.com/sirupsen/logrus"
+	"gopkg.in/natefinch/lumberjack.v2"
 	// "github.com/sirupsen/logrus/hooks/writer"
 )
 
@@ -18,7 +20,9 @@ func WithDebugLevel(debug bool) Option {
 		if debug {
 			logger.SetLevel(logrus.DebugLevel)
 			logger.SetReportCaller(true)
-			logger.Formatter.(*logrus.TextFormatter).FullTimestamp = true
+			if formatter, ok := logger.Formatter.(*logrus.TextFormatter); ok {
+				formatter.FullTimestamp = true
+			}
 		}
 		return nil
 	}
@@ -31,6 +35,43 @@ func WithWriter(output io.Writer) Option {
 	}
 }
 
+// WithFormat formats lines as "text", the default, or as "json" objects
+// with the fields of the line as keys
+func WithFormat(format string) Option {
+	return func(logger *logrus.Logger) error {
+		switch format {
+		case "", "text":
+		case "json":
+			logger.SetFormatter(&logrus.JSONFormatter{
+				CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+					return formatFilePath(f.Function), fmt.Sprintf("%s:%d", formatFilePath(f.File), f.Line)
+				},
+			})
+		default:
+			return fmt.Errorf("unknown log format '%s', want text or json", format)
+		}
+		return nil
+	}
+}
+
+// WithRotatingFile writes to the file at path instead, rotating it once it
+// grows past maxSize megabytes. Rotated files are deleted once older than
+// maxAge or beyond the maxBackups most recent, 0 keeps them all
+func WithRotatingFile(path string, maxSize int, maxAge time.Duration, maxBackups int) Option {
+	return func(logger *logrus.Logger) error {
+		if maxSize < 1 || maxAge < 0 || maxBackups < 0 {
+			return fmt.Errorf("invalid log rotation of %dMB, %s and %d backups", maxSize, maxAge, maxBackups)
+		}
+		logger.SetOutput(&lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxAge:     int((maxAge + 24*time.Hour - 1) / (24 * time.Hour)),
+			MaxBackups: maxBackups,
+		})
+		return nil
+	}
+}
+
 func WithFiles(outputFile string, errorFile string) Option {
 	return func(logger *logrus.Logger) error {
 		if _, err := os.Stat(outputFile); err == nil {
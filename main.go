@@ -2,31 +2,95 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/denuoweb/ethereum-block-processor/api"
+	"github.com/denuoweb/ethereum-block-processor/audit"
 	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/config"
 	"github.com/denuoweb/ethereum-block-processor/db"
-	"github.com/denuoweb/ethereum-block-processor/dispatcher"
+	"github.com/denuoweb/ethereum-block-processor/donefile"
+	"github.com/denuoweb/ethereum-block-processor/errqueue"
 	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/export"
 	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/denuoweb/ethereum-block-processor/processor"
+	"github.com/denuoweb/ethereum-block-processor/sink"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
+	configFile = kingpin.Flag("config", "yaml or json config file mirroring the flags, flags given on the command line override it").String()
 	chainId    = kingpin.Flag("chain-id", "chain id").Int()
-	providers  = kingpin.Flag("providers", "htmlcoin rpc providers").Default("https://info.htmlcoin.com/janusapi").Short('p').URLList()
+	providers  = providerListFlag(kingpin.Flag("providers", "htmlcoin rpc providers, optionally labeled as label=url").Default("https://info.htmlcoin.com/janusapi").Short('p'))
 	numWorkers = kingpin.Flag("workers", "Number of workers. Defaults to system's number of CPUs.").Default(strconv.Itoa(runtime.NumCPU())).Short('w').Int()
+	minWorkers = kingpin.Flag("min-workers", "fewest workers autoscaling shrinks to").Default("1").Int()
+	maxWorkers = kingpin.Flag("max-workers", "most workers autoscaling grows to, starting from --workers, by the provider latency and error rate and the blocks queued up for the workers (0 disables autoscaling)").Default("0").Int()
 	debug      = kingpin.Flag("debug", "debug mode").Short('d').Default("false").Bool()
+	logFormat  = kingpin.Flag("log-format", "format of the log lines, text or json").Default("text").Enum("text", "json")
+	logFile    = kingpin.Flag("log-file", "write the logs to this file, rotated by size, instead of stdout").String()
+	logMaxSize = kingpin.Flag("log-max-size", "megabytes --log-file grows to before it's rotated").Default("100").Int()
+	logMaxAge  = kingpin.Flag("log-max-age", "delete rotated log files older than this, rounded up to days (0 keeps them)").Default("0").Duration()
+	logBackups = kingpin.Flag("log-max-backups", "rotated log files kept (0 keeps them all)").Default("0").Int()
+	logLevels  = kingpin.Flag("log-level", "level of the log lines, or of those of a module as module=level with module one of dispatcher, workers, db and jsonrpc, repeatable").Strings()
 	blockFrom  = kingpin.Flag("from", "block number to start scanning from (default: 'Latest'").Short('f').Default("0").Int64()
 	blockTo    = kingpin.Flag("to", "block number to stop scanning (default: 1)").Short('t').Default("0").Int64()
+	swapRange  = kingpin.Flag("swap-range", "swap --from and --to when --from is lower than --to instead of failing").Bool()
+
+	chainDefinitions = kingpin.Flag("chain", "chain scanned by a pipeline of its own, as chainId=provider[,provider...], repeatable. Replaces --chain-id and --providers").Strings()
+	chainDatabases   = kingpin.Flag("chain-db", "database connection string of a --chain, as chainId=connection string, defaults to the database of the other flags").Strings()
+
+	providerFailures = kingpin.Flag("provider-failure-threshold", "consecutive failed calls after which a provider is skipped for --provider-cooldown").Default("3").Int()
+	providerCooldown = kingpin.Flag("provider-cooldown", "how long a failing provider is skipped before it's tried again").Default("30s").Duration()
+	providerBalance  = kingpin.Flag("provider-balancing", "how workers pick the provider they call first: their own (worker) or one picked in proportion to the observed throughput of every healthy provider (throughput)").Default("throughput").Enum("worker", "throughput")
+	timestampFormats = kingpin.Flag("timestamp-format", "block timestamp encoding of a labeled provider, as label=auto|hex|decimal. auto treats 0x prefixed timestamps as hex and others as decimal").Strings()
+	rangeMethods     = kingpin.Flag("range-method", "method of a labeled provider returning the blocks between two block numbers, as label=method. Contiguous blocks are fetched from it in single requests").Strings()
+	maxBatchSizes    = kingpin.Flag("max-batch-size", "largest json rpc batch request a labeled provider accepts, as label=n. Larger batches are split, and batches a provider rejects as too large are halved whatever its maximum").Strings()
+	rangeSize        = kingpin.Flag("range-size", "maximum number of contiguous blocks fetched in a single range request").Default("20").Int()
+	batchSize        = kingpin.Flag("batch-size", "maximum number of queued blocks fetched in a single json rpc batch request from providers without a range method, e.g. 50 to 200 for backfills (1 disables)").Default("1").Int()
+	providerSigning  = kingpin.Flag("provider-signing", "sign requests to a labeled provider with an HMAC of their body, as label=header:secretFile").Strings()
+	providerHeaders  = kingpin.Flag("provider-header", "header sent on every request to the providers, or to a labeled one, as [label=]name:value, repeatable").Strings()
+	providerTokens   = kingpin.Flag("provider-token-env", "environment variable holding a bearer token sent to the providers, or to a labeled one, as [label=]VAR, repeatable").Strings()
+
+	rpcAttempts    = kingpin.Flag("rpc-attempts", "attempts made at rpc calls failing with network errors, responses of a retried http status or empty bodies").Default("4").Int()
+	rpcBaseDelay   = kingpin.Flag("rpc-base-delay", "backoff before the first rpc retry, doubled on every retry").Default("1s").Duration()
+	rpcMaxDelay    = kingpin.Flag("rpc-max-delay", "maximum backoff between rpc retries").Default("8s").Duration()
+	rpcRetryStatus = kingpin.Flag("rpc-retry-status", "http status of rpc responses that's retried, repeatable (429 and every 5xx unless set)").Ints()
+
+	rps        = kingpin.Flag("rps", "maximum requests per second sent to each provider, shared by all its workers (0 disables)").Default("0").Float64()
+	rateLimits = kingpin.Flag("rate-limit", "requests per second sent to each provider, shared by all its workers, as rps[:burst], or to a labeled provider as label=rps[:burst]. Overrides --rps").Strings()
+
+	decodeWorkers      = kingpin.Flag("decode-workers", "maximum number of blocks decoded at once. Defaults to system's number of CPUs.").Default(strconv.Itoa(runtime.NumCPU())).Int()
+	stuckWorkerTimeout = kingpin.Flag("stuck-worker-timeout", "replace workers that make no progress on a block for this long (0 disables)").Default("5m").Duration()
+	filterAddresses    = kingpin.Flag("filter-address", "only scan the blocks with logs emitted by this contract address, repeatable").Strings()
+	filterTopics       = kingpin.Flag("filter-topic", "only scan the blocks with logs of this topic, as [position:]topic with position 0, the event signature, by default, repeatable").Strings()
+	filterRange        = kingpin.Flag("filter-range", "blocks whose logs are queried at once by eth_getLogs with --filter-address or --filter-topic").Default("1000").Int64()
+	stallTimeout       = kingpin.Flag("stall-timeout", "report the scan unhealthy at /healthz once missing blocks went without any completing for this long (0 disables)").Default("5m").Duration()
+
+	chainIdInterval = kingpin.Flag("chain-id-check-interval", "verify providers still serve --chain-id this often, quarantining those that don't (0 disables)").Default("0").Duration()
+	forceChainId    = kingpin.Flag("force", "only warn when --chain-id disagrees with the chain id the providers serve or with the rows of the database").Bool()
+
+	skippedBlockAttempts = kingpin.Flag("skipped-block-attempts", "record blocks every provider reported not found this many times as skipped block numbers (0 disables)").Default("0").Int()
+
+	deadLetterAttempts = kingpin.Flag("dead-letter-attempts", "record blocks that failed this many times in the FailedBlocks table and carry on without them, they aren't scanned again until --retry-failed (0 retries them indefinitely)").Default("0").Int()
+	maxFailures        = kingpin.Flag("max-failures", "abort the run once more than this many blocks failed every attempt (0 never aborts)").Default("0").Int()
+	retryFailed        = kingpin.Flag("retry-failed", "requeue the blocks recorded as failed by earlier runs, scanning the whole range rather than resuming from the checkpoint").Bool()
+
+	validateBlockNumber = kingpin.Flag("validate-block-number", "reject and retry blocks whose number isn't the requested one, e.g. stale responses from a caching provider").Bool()
 
 	host     = kingpin.Flag("host", "database hostname").Default("127.0.0.1").String()
 	port     = kingpin.Flag("port", "database port").Default("5432").String()
@@ -35,18 +99,368 @@ var (
 	dbname   = kingpin.Flag("dbname", "database name").Default("htmlcoin").String()
 	ssl      = kingpin.Flag("ssl", "database ssl").Bool()
 
+	dbRetries      = kingpin.Flag("db-retries", "retries of inserts failing with a serialization failure or deadlock").Default("3").Int()
+	dbRetryBackoff = kingpin.Flag("db-retry-backoff", "backoff before the first insert retry, doubled on every retry").Default("100ms").Duration()
+
+	dbBatchSize     = kingpin.Flag("db-batch-size", "results written to the database at once with COPY, in a single transaction (1 writes them one at a time)").Default("1").Int()
+	dbFlushInterval = kingpin.Flag("db-flush-interval", "how long a partial batch of results waits for more before it's written").Default("1s").Duration()
+
 	dbConnectionString = kingpin.Flag("dbstring", "database connection string").String()
+	dbDriver           = kingpin.Flag("db-driver", "driver of the database hash pairs are stored to, postgres, sqlite or mysql. Detected from the --dbstring scheme, sqlite: or mysql://, postgres otherwise").Enum("postgres", "sqlite", "mysql")
+
+	leaderLockKey      = kingpin.Flag("leader-lock-key", "postgres advisory lock key electing the single instance processing blocks, others stand by to take over (0 disables)").Default("0").Int64()
+	leaderLockInterval = kingpin.Flag("leader-lock-interval", "how often the leader lock is renewed, or tried by a standby").Default("10s").Duration()
+
+	loaderRetries      = kingpin.Flag("missing-blocks-retries", "retries of the missing blocks query when it fails, e.g. while the database is briefly unavailable").Default("5").Int()
+	loaderRetryBackoff = kingpin.Flag("missing-blocks-retry-backoff", "backoff before the first missing blocks query retry, doubled on every retry").Default("1s").Duration()
+
+	skipEmptyBlocks = kingpin.Flag("skip-empty-blocks", "don't store the hashes of blocks without transactions, only record them as seen").Bool()
+	blockStats      = kingpin.Flag("block-stats", "store the size and gasUsed/gasLimit ratio of blocks").Bool()
+	withReceipts    = kingpin.Flag("with-receipts", "fetch the receipts of every block's transactions and store them in the Receipts table and their logs in the Logs table, committed along with their block").Bool()
+	receipts        = kingpin.Flag("receipts", "alias of --with-receipts").Hidden().Bool()
+	onlySuccessful  = kingpin.Flag("only-successful-txs", "leave the transactions whose receipt reports they reverted, and their receipts and logs, out of storage, still recording their block. Needs --with-receipts").Bool()
+	storeBlocks     = kingpin.Flag("store-blocks", "store the header of every block in the Blocks table and its transactions in the Transactions table, committed along with their block").Bool()
+	txInputFormat   = kingpin.Flag("tx-input-format", "how the input of stored transactions is serialized: hex as the provider returns it, bytea, or decoded into its method id and the arguments --abi decodes").Default("hex").Enum("hex", "bytea", "decoded")
+	abiFile         = kingpin.Flag("abi", "json abi of the contracts called, the name of the method a stored transaction calls is extracted from its input's 4-byte selector").String()
+
+	pauseBuffer = kingpin.Flag("pause-buffer", "results buffered while database writes are paused (SIGUSR1 pauses, SIGUSR2 resumes) before fetching is held back").Default("10000").Int()
+
+	checkpointEvery = kingpin.Flag("checkpoint-every", "persist the contiguous checkpoint and high-water mark every n committed blocks (0 disables)").Default("0").Int()
+	resume          = kingpin.Flag("resume", "resume from the checkpoint saved by the previous run, checkpointing every 1000 committed blocks unless --checkpoint-every is set, or an interrupted export from its cursor file. Can't be used with --to when scanning").Bool()
+
+	reorgDepth         = kingpin.Flag("reorg-depth", "how many blocks back a reorg, detected from a block's parent hash not matching the stored hash, is rewound and refetched (0 disables reorg detection)").Default("0").Int64()
+	refetchConcurrency = kingpin.Flag("refetch-concurrency", "how many blocks a reorg refetches at once, so refetches don't compete with the blocks scanned (0 for no limit)").Default("2").Int()
+
+	doneFile = kingpin.Flag("done-file", "file the final summary is written to once the run succeeds, it's removed at startup and left absent when the run fails").String()
+
+	follow           = kingpin.Flag("follow", "keep following the chain tip once the missing blocks are processed, storing new blocks as they're mined, instead of exiting. Can't be used with --from").Bool()
+	newHeadsURL      = kingpin.Flag("new-heads-url", "websocket provider url subscribed to for new heads when --from isn't set, instead of polling for the latest block, defaults to the first ws:// or wss:// provider. The latest block is polled while not subscribed").String()
+	newHeadsInterval = kingpin.Flag("new-heads-interval", "how often the latest block is polled, and resubscribing tried, while not subscribed to new heads").Default("15s").Duration()
+
+	sinkKind     = kingpin.Flag("sink", "where hash pairs are written: the postgres database, an append-only ndjson or csv file at --sink-path, stdout as ndjson, a kafka topic or discarded").Default("postgres").Enum("postgres", "ndjson", "csv", "stdout", "kafka", "discard")
+	dryRun       = kingpin.Flag("dry-run", "fetch the blocks without writing the hash pairs anywhere, reporting the rate of blocks and calls, the calls failed and the fewest workers reaching 90% of the best rate, of each number of workers --max-workers autoscaling goes through").Bool()
+	sinkPath     = kingpin.Flag("sink-path", "file the ndjson and csv sinks append to, blocks it already has aren't fetched again").String()
+	kafkaBrokers = kingpin.Flag("kafka-brokers", "kafka broker addresses the kafka sink produces to, such as kafka:9092").Strings()
+	kafkaTopic   = kingpin.Flag("kafka-topic", "kafka topic the kafka sink produces hash pairs to").Default("hash-pairs").String()
+
+	pushgateway = kingpin.Flag("pushgateway", "prometheus pushgateway url to push metrics to on exit").String()
+	metricsAddr = kingpin.Flag("metrics-addr", "address to serve prometheus metrics on at /metrics, the scan progress at /status and health checks at /healthz and /readyz while running, such as :9090 (empty disables)").String()
+	apiAddr     = kingpin.Flag("api-addr", "address to answer lookups of the stored hash pairs on while running, at /v1/block/{ethHash} and /v1/block/by-number/{n}, such as :8080 (empty disables)").String()
+
+	sloLatency    = kingpin.Flag("slo-latency", "latency objective from a block's dispatch to the commit of its hashes, reported on exit (0 disables)").Default("0").Duration()
+	sloPercentile = kingpin.Flag("slo-percentile", "percentage of blocks that must meet --slo-latency").Default("95").Float64()
+
+	errorBuffer   = kingpin.Flag("error-buffer", "number of errors buffered for main to handle (0 sizes it to the number of workers + 1)").Default("0").Int()
+	errorOverflow = kingpin.Flag("error-overflow", "what to do with errors sent while the buffer is full: block the sender or drop the oldest error").Default(string(errqueue.Block)).Enum(string(errqueue.Block), string(errqueue.DropOldest))
+
+	cacheFile = kingpin.Flag("cache-file", "file the blocks pending are saved to, so a restarted scan of the same chain and range dispatches them right away rather than waiting for the missing blocks query, which is run once they're processed (empty disables)").String()
+
+	backlogInterval = kingpin.Flag("backlog-log-interval", "how often the missing blocks backlog and its drain rate are logged (0 disables)").Default("0").Duration()
+
+	progressInterval = kingpin.Flag("progress-interval", "how often the scan's progress through the missing blocks, its rate and the estimated time remaining are logged (0 disables)").Default("30s").Duration()
+
+	tipLagThreshold = kingpin.Flag("tip-lag-threshold", "warn when the highest stored block falls this many blocks behind the chain tip (0 disables, the lag is still exported with --metrics-addr)").Default("0").Int64()
+	tipLagInterval  = kingpin.Flag("tip-lag-interval", "how often the tip lag is checked").Default("1m").Duration()
+
+	autoscaleInterval = kingpin.Flag("autoscale-interval", "how often the workers are autoscaled").Default("10s").Duration()
+
+	shutdownTimeout = kingpin.Flag("shutdown-timeout", "how long the blocks already dispatched get to be processed and written on ^C, and the database to close, before exiting without them. A second ^C exits immediately").Default("30s").Duration()
+
+	runCommand = kingpin.Command("run", "scan blocks and store their hash pairs").Default()
+
+	gapsCommand = kingpin.Command("gaps", "report blocks missing from the database as ranges")
+	maxRanges   = gapsCommand.Flag("max-ranges", "maximum number of ranges to list before summarizing the rest (0 lists all)").Default("100").Int()
+
+	exportCommand   = kingpin.Command("export", "export stored hash pairs of the --from/--to range to a csv file")
+	exportOutput    = exportCommand.Flag("output", "csv file to export to").Short('o').Required().String()
+	exportChunkSize = exportCommand.Flag("chunk-size", "number of blocks exported between cursor updates").Default("10000").Int64()
+
+	auditCommand   = kingpin.Command("audit", "compare the stored hash pairs of the --from/--to range against a reference database or provider")
+	auditReference = auditCommand.Flag("reference", "reference postgres connection string, or rpc provider url such as an archive node").Required().String()
+	auditPageSize  = auditCommand.Flag("page-size", "number of hash pairs read from the database and the reference at once").Default("1000").Int()
+
+	verifyCommand   = kingpin.Command("verify", "report missing blocks, duplicate hashes and stored hashes no longer matching the chain over the --from/--to range")
+	verifySample    = verifyCommand.Flag("sample", "fraction of the stored blocks re-queried from the first provider, between 0 and 1").Default("1").Float64()
+	verifyPageSize  = verifyCommand.Flag("page-size", "number of hash pairs read from the database at once").Default("1000").Int()
+	verifyMaxRanges = verifyCommand.Flag("max-ranges", "maximum number of missing ranges to list before summarizing the rest (0 lists all)").Default("100").Int()
+	verifyRequeue   = verifyCommand.Flag("requeue", "delete the mismatched blocks and those sharing a hash so the next scan refetches them").Bool()
+
+	schemaCommand = kingpin.Command("print-schema", "print the statements creating the tables the processor stores to, those of --with-receipts and --store-blocks when given")
+	schemaDriver  = schemaCommand.Flag("driver", "database driver to print the statements for").Default("postgres").String()
 )
 var logger *logrus.Logger
-var start time.Time
+var providerPool *jsonrpc.Pool
+var command string
+
+type providerList []*jsonrpc.Provider
+
+func (p *providerList) Set(value string) error {
+	provider, err := jsonrpc.ParseProvider(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, provider)
+	return nil
+}
+
+func (p *providerList) String() string {
+	names := make([]string, len(*p))
+	for i, provider := range *p {
+		names[i] = provider.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (p *providerList) IsCumulative() bool {
+	return true
+}
+
+// labeledProvider returns the provider labeled label
+func labeledProvider(providers []*jsonrpc.Provider, label string) (*jsonrpc.Provider, error) {
+	for _, provider := range providers {
+		if provider.Label == label {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown provider '%s'", label)
+}
+
+// applySigning attaches the request signers defined by definitions to the
+// providers they're labeled for
+func applySigning(providers []*jsonrpc.Provider, definitions []string) error {
+	for _, definition := range definitions {
+		label, signer, err := jsonrpc.ParseSigning(definition)
+		if err != nil {
+			return err
+		}
+		provider, err := labeledProvider(providers, label)
+		if err != nil {
+			return fmt.Errorf("request signing configured for %s", err)
+		}
+		provider.Signer = signer
+	}
+	return nil
+}
+
+// applyHeaders sets the headers and bearer tokens of definitions on the
+// providers they're labeled for, or on every provider when unlabeled
+func applyHeaders(providers []*jsonrpc.Provider, headers, tokens []string) error {
+	for _, definition := range headers {
+		label, name, value, err := jsonrpc.ParseHeader(definition)
+		if err != nil {
+			return err
+		}
+		if err = setHeader(providers, label, name, value); err != nil {
+			return fmt.Errorf("provider header configured for %s", err)
+		}
+	}
+	for _, definition := range tokens {
+		label, token, err := jsonrpc.ParseBearerToken(definition)
+		if err != nil {
+			return err
+		}
+		if err = setHeader(providers, label, "Authorization", "Bearer "+token); err != nil {
+			return fmt.Errorf("provider token configured for %s", err)
+		}
+	}
+	return nil
+}
+
+// setHeader sets a header on the provider labeled label, or on every
+// provider when label is empty
+func setHeader(providers []*jsonrpc.Provider, label, name, value string) error {
+	if label == "" {
+		for _, provider := range providers {
+			provider.SetHeader(name, value)
+		}
+		return nil
+	}
+	provider, err := labeledProvider(providers, label)
+	if err != nil {
+		return err
+	}
+	provider.SetHeader(name, value)
+	return nil
+}
+
+// applyProviderSettings applies definitions of the form label=value with
+// apply to the providers they're labeled for
+func applyProviderSettings(providers []*jsonrpc.Provider, definitions []string, setting string, apply func(provider *jsonrpc.Provider, value string) error) error {
+	for _, definition := range definitions {
+		i := strings.Index(definition, "=")
+		if i < 1 || i == len(definition)-1 {
+			return fmt.Errorf("invalid %s '%s', want label=value", setting, definition)
+		}
+		provider, err := labeledProvider(providers, definition[:i])
+		if err != nil {
+			return fmt.Errorf("%s configured for %s", setting, err)
+		}
+		if err = apply(provider, definition[i+1:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyTimestampFormats sets the block timestamp encoding of the providers
+// labeled in definitions of the form label=format
+func applyTimestampFormats(providers []*jsonrpc.Provider, definitions []string) error {
+	return applyProviderSettings(providers, definitions, "timestamp format", func(provider *jsonrpc.Provider, value string) error {
+		format, err := jsonrpc.ParseNumberFormat(value)
+		provider.TimestampFormat = format
+		return err
+	})
+}
+
+// applyRangeMethods sets the range method of the providers labeled in
+// definitions of the form label=method
+func applyRangeMethods(providers []*jsonrpc.Provider, definitions []string) error {
+	return applyProviderSettings(providers, definitions, "range method", func(provider *jsonrpc.Provider, value string) error {
+		provider.RangeMethod = value
+		return nil
+	})
+}
+
+// applyMaxBatchSizes sets the maximum batch size of the providers labeled in
+// definitions of the form label=n
+func applyMaxBatchSizes(providers []*jsonrpc.Provider, definitions []string) error {
+	return applyProviderSettings(providers, definitions, "max batch size", func(provider *jsonrpc.Provider, value string) error {
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 1 {
+			return fmt.Errorf("invalid max batch size '%s', want a positive number", value)
+		}
+		provider.MaxBatchSize = size
+		return nil
+	})
+}
+
+// applyRateLimits gives providers token buckets of their own per definitions
+// of the form rps[:burst], applying to every provider, or label=rps[:burst]
+func applyRateLimits(providers []*jsonrpc.Provider, definitions []string) error {
+	var labeled []string
+	for _, definition := range definitions {
+		if strings.Contains(definition, "=") {
+			labeled = append(labeled, definition)
+			continue
+		}
+		limit, burst, err := jsonrpc.ParseRateLimit(definition)
+		if err != nil {
+			return err
+		}
+		for _, provider := range providers {
+			provider.Limiter = rate.NewLimiter(limit, burst)
+		}
+	}
+	// labeled limits override those of every provider
+	return applyProviderSettings(providers, labeled, "rate limit", func(provider *jsonrpc.Provider, value string) error {
+		limit, burst, err := jsonrpc.ParseRateLimit(value)
+		provider.Limiter = rate.NewLimiter(limit, burst)
+		return err
+	})
+}
+
+func providerListFlag(s kingpin.Settings) *[]*jsonrpc.Provider {
+	target := new([]*jsonrpc.Provider)
+	s.SetValue((*providerList)(target))
+	return target
+}
+
+// shortFlags maps the short flags to their long names
+func shortFlags() map[byte]string {
+	shorts := make(map[byte]string)
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Short != 0 {
+			shorts[byte(flag.Short)] = flag.Name
+		}
+	}
+	return shorts
+}
+
+// lineSeparatedFlags are the cumulative flags whose values hold commas, set
+// one value per line from the environment
+var lineSeparatedFlags = map[string]bool{"chain": true, "chain-db": true, "provider-header": true}
+
+// envFlags returns the flags that can be set from EBP_* environment variables
+func envFlags() []config.EnvFlag {
+	var flags []config.EnvFlag
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Hidden || flag.Name == "help" || flag.Name == "version" {
+			continue
+		}
+		cumulative, ok := flag.Value.(interface{ IsCumulative() bool })
+		flags = append(flags, config.EnvFlag{
+			Name:       flag.Name,
+			Bool:       flag.IsBoolFlag(),
+			Cumulative: ok && cumulative.IsCumulative(),
+			Lines:      lineSeparatedFlags[flag.Name],
+		})
+	}
+	return flags
+}
+
+// effectiveConfig returns the settings the flags and config file add up to
+func effectiveConfig() *config.Config {
+	providerURLs := make([]string, len(*providers))
+	for i, provider := range *providers {
+		providerURLs[i] = provider.URL.String()
+	}
+	return &config.Config{
+		DB:        db.DbConfig{Host: *host, Port: *port, User: *user, Password: *password, DBName: *dbname, SSL: *ssl},
+		DBString:  *dbConnectionString,
+		ChainID:   *chainId,
+		Providers: providerURLs,
+		Workers:   *numWorkers,
+		From:      *blockFrom,
+		To:        *blockTo,
+		SwapRange: *swapRange,
+		Debug:     *debug,
+	}
+}
 
 func init() {
 	kingpin.Version("0.0.1")
-	kingpin.Parse()
-	mainLogger, err := log.GetLogger(
+	// flags override the environment, which overrides the config file
+	envArgs, err := config.Env(os.Environ(), envFlags())
+	kingpin.FatalIfError(err, "")
+	args := config.Merge(envArgs, os.Args[1:], shortFlags())
+	if path := config.Path(args); path != "" {
+		fileConfig, err := config.Load(path)
+		kingpin.FatalIfError(err, "")
+		args = config.Merge(fileConfig.Args(), args, shortFlags())
+	}
+	command = kingpin.MustParse(kingpin.CommandLine.Parse(args))
+	if *dryRun {
+		if *sinkKind != "postgres" && *sinkKind != "discard" {
+			kingpin.Fatalf("--dry-run discards the hash pairs, it can't be used with --sink %s", *sinkKind)
+		}
+		*sinkKind = "discard"
+	}
+	// the stdout sink has stdout to itself
+	logOutput := os.Stdout
+	if *sinkKind == "stdout" {
+		logOutput = os.Stderr
+	}
+	level := logrus.DebugLevel
+	moduleLevels := map[string]logrus.Level{}
+	for _, definition := range *logLevels {
+		module, moduleLevel, err := log.ParseLevel(definition)
+		kingpin.FatalIfError(err, "")
+		if module == "" {
+			level = moduleLevel
+		} else {
+			moduleLevels[module] = moduleLevel
+		}
+	}
+	logOpts := []log.Option{
+		log.WithFormat(*logFormat),
 		log.WithDebugLevel(*debug),
-		log.WithWriter(os.Stdout),
-	)
+		log.WithWriter(logOutput),
+	}
+	if *logFile != "" {
+		logOpts = append(logOpts, log.WithRotatingFile(*logFile, *logMaxSize, *logMaxAge, *logBackups))
+	}
+	logOpts = append(logOpts, log.WithLevels(level, moduleLevels))
+	mainLogger, err := log.GetLogger(logOpts...)
 	if err != nil {
 		logrus.Panic(err)
 	}
@@ -59,21 +473,12 @@ func checkError(e error) {
 	}
 }
 
-func main() {
-
-	ctx, cancelFunc := context.WithCancel(context.Background())
-	var wg sync.WaitGroup
+func getConnectionString() string {
+	if dbConnectionString != nil && *dbConnectionString != "" {
+		return *dbConnectionString
+	}
 
-	logger.Info("Number of workers: ", *numWorkers)
-	// channel to receive errors from goroutines
-	errChan := make(chan error, *numWorkers+1)
-	// channel to pass blocks to workers
-	blockChan := make(chan int64, *numWorkers)
-	completedBlockChan := make(chan int64, *numWorkers)
-	// channel to pass results from workers to DB
-	resultChan := make(chan jsonrpc.HashPair, *numWorkers)
-
-	connectionString := db.DbConfig{
+	return db.DbConfig{
 		Host:     *host,
 		Port:     *port,
 		User:     *user,
@@ -81,88 +486,474 @@ func main() {
 		DBName:   *dbname,
 		SSL:      *ssl,
 	}.String()
+}
 
-	if dbConnectionString != nil && *dbConnectionString != "" {
-		connectionString = *dbConnectionString
+// rpcRetryConfig is how rpc calls are retried per the --rpc-* flags
+func rpcRetryConfig() jsonrpc.RetryConfig {
+	return jsonrpc.RetryConfig{
+		MaxAttempts:   *rpcAttempts,
+		BaseDelay:     *rpcBaseDelay,
+		MaxDelay:      *rpcMaxDelay,
+		RetryStatuses: *rpcRetryStatus,
 	}
+}
 
-	qdb, err := db.NewHtmlcoinDB(ctx, connectionString, resultChan, errChan)
+// rpsLimiter is a provider's own token bucket per --rps, nil when unlimited
+func rpsLimiter() *rate.Limiter {
+	if *rps <= 0 {
+		return nil
+	}
+	burst := int(*rps)
+	if burst < 1 {
+		burst = 1
+	}
+	return rate.NewLimiter(rate.Limit(*rps), burst)
+}
+
+func main() {
+	var err error
+	if command != schemaCommand.FullCommand() {
+		checkError(effectiveConfig().Validate())
+	}
+	*blockFrom, *blockTo, err = cache.ValidateScanRange(*blockFrom, *blockTo, *swapRange)
 	checkError(err)
-	dbCloseChan := make(chan error)
-	qdb.Start(ctx, *chainId, dbCloseChan)
-	// channel to signal  work completion to main from dispatcher
-	done := make(chan struct{})
-	// channel to receive os signals
+	chains, err := parseChains(*chainDefinitions, *chainDatabases)
+	checkError(err)
+	if len(chains) > 0 && command != runCommand.FullCommand() {
+		logger.Fatal("--chain can only be used when scanning")
+	}
+	// labeled providers are looked up among those of every chain
+	allProviders := *providers
+	if len(chains) > 0 {
+		allProviders = nil
+		for _, c := range chains {
+			allProviders = append(allProviders, c.providers...)
+		}
+	}
+	checkError(applySigning(allProviders, *providerSigning))
+	checkError(applyHeaders(allProviders, *providerHeaders, *providerTokens))
+	checkError(applyTimestampFormats(allProviders, *timestampFormats))
+	checkError(applyRangeMethods(allProviders, *rangeMethods))
+	checkError(applyMaxBatchSizes(allProviders, *maxBatchSizes))
+	for _, provider := range allProviders {
+		provider.Retry = rpcRetryConfig()
+		provider.Limiter = rpsLimiter()
+	}
+	checkError(applyRateLimits(allProviders, *rateLimits))
+	providerPool = jsonrpc.NewPool(*providers, *providerFailures, *providerCooldown)
+	if len(chains) == 0 && *chainId == 0 && command != schemaCommand.FullCommand() {
+		detected, err := eth.GetChainId(context.Background(), providerPool)
+		checkError(err)
+		*chainId = int(detected)
+		logger.WithField("chainId", *chainId).Info("Chain id detected from the providers")
+	}
+	if len(chains) == 0 {
+		chains = []*chain{{id: *chainId, providers: *providers, pool: providerPool, dbString: getConnectionString()}}
+	}
+	for _, c := range chains {
+		if c.pool == nil {
+			c.pool = jsonrpc.NewPool(c.providers, *providerFailures, *providerCooldown)
+		}
+		if len(chains) > 1 {
+			c.logFields = logrus.Fields{"chainId": c.id}
+		}
+		for _, other := range chains {
+			if other != c && other.dbString == c.dbString {
+				c.sharedChainIds = append(c.sharedChainIds, other.id)
+			}
+		}
+	}
+
+	switch command {
+	case gapsCommand.FullCommand():
+		gaps()
+	case exportCommand.FullCommand():
+		exportHashes()
+	case auditCommand.FullCommand():
+		auditHashes()
+	case verifyCommand.FullCommand():
+		verify()
+	case schemaCommand.FullCommand():
+		printSchema()
+	case runCommand.FullCommand():
+		run(chains)
+	}
+}
+
+// gaps prints the blocks missing from the database as ranges
+func gaps() {
+	ctx := context.Background()
+	qdb, err := db.NewHtmlcoinDB(ctx, getConnectionString(), nil, nil)
+	checkError(err)
+
+	latestBlock, err := eth.GetLatestBlock(ctx, logger.WithField("module", "gaps"), providerPool)
+	checkError(err)
+
+	firstBlock, lastBlock := cache.ScanBounds(*blockFrom, *blockTo, latestBlock)
+	missingBlocks, err := qdb.GetMissingBlocks(ctx, *chainId, firstBlock, lastBlock)
+	checkError(err)
+
+	ranges := cache.CollapseRanges(missingBlocks)
+	logger.WithFields(logrus.Fields{
+		"firstBlock":    firstBlock,
+		"lastBlock":     lastBlock,
+		"missingBlocks": len(missingBlocks),
+		"ranges":        len(ranges),
+	}).Info("Missing blocks")
+	for _, line := range cache.FormatRanges(ranges, *maxRanges) {
+		fmt.Println(line)
+	}
+}
+
+// auditHashes reports the differences between the stored hash pairs and a
+// reference database or provider
+func auditHashes() {
+	ctx := context.Background()
+	qdb, err := db.NewHtmlcoinDB(ctx, getConnectionString(), nil, nil)
+	checkError(err)
+
+	var reference audit.Source
+	if provider, err := jsonrpc.ParseProvider(*auditReference); err == nil && provider.URL.Scheme != "postgres" && provider.URL.Scheme != "postgresql" {
+		provider.Retry = rpcRetryConfig()
+		provider.Limiter = rpsLimiter()
+		reference = audit.NewProviderSource(provider)
+	} else {
+		reference, err = db.NewHtmlcoinDB(ctx, *auditReference, nil, nil)
+		checkError(err)
+	}
+
+	auditLogger := logger.WithField("module", "audit")
+	latestBlock, err := eth.GetLatestBlock(ctx, auditLogger, providerPool)
+	checkError(err)
+
+	firstBlock, lastBlock := cache.ScanBounds(*blockFrom, *blockTo, latestBlock)
+	summary, err := audit.Audit(ctx, qdb, reference, audit.Config{
+		ChainId:    *chainId,
+		FirstBlock: firstBlock,
+		LastBlock:  lastBlock,
+		PageSize:   *auditPageSize,
+	}, func(difference audit.Difference) error {
+		fmt.Println(difference)
+		return nil
+	})
+	checkError(err)
+
+	auditLogger.WithFields(logrus.Fields{
+		"firstBlock": firstBlock,
+		"lastBlock":  lastBlock,
+		"blocks":     summary.Blocks,
+		"missing":    summary.Differences[audit.Missing],
+		"extra":      summary.Differences[audit.Extra],
+		"mismatch":   summary.Differences[audit.Mismatch],
+	}).Info("Audit finished")
+}
+
+// verify reports the missing blocks, the hashes stored for several blocks
+// and the sampled stored blocks no longer matching the chain, requeuing the
+// broken ones with --requeue
+func verify() {
+	ctx := context.Background()
+	qdb, err := db.NewHtmlcoinDB(ctx, getConnectionString(), nil, nil)
+	checkError(err)
+
+	verifyLogger := logger.WithField("module", "verify")
+	latestBlock, err := eth.GetLatestBlock(ctx, verifyLogger, providerPool)
+	checkError(err)
+	firstBlock, lastBlock := cache.ScanBounds(*blockFrom, *blockTo, latestBlock)
+
+	missingBlocks, err := qdb.GetMissingBlocks(ctx, *chainId, firstBlock, lastBlock)
+	checkError(err)
+	ranges := cache.CollapseRanges(missingBlocks)
+	for _, line := range cache.FormatRanges(ranges, *verifyMaxRanges) {
+		fmt.Println("missing", line)
+	}
+
+	broken := map[int64]struct{}{}
+	duplicates, err := qdb.GetDuplicateHashes(ctx, *chainId, firstBlock, lastBlock)
+	checkError(err)
+	for _, duplicate := range duplicates {
+		fmt.Println("duplicate", duplicate.HtmlcoinHash, "blocks", strings.Join(cache.FormatRanges(cache.CollapseRanges(duplicate.Blocks), 0), ", "))
+		for _, block := range duplicate.Blocks {
+			broken[block] = struct{}{}
+		}
+	}
+
+	summary, err := audit.Verify(ctx, qdb, audit.NewProviderSource((*providers)[0]), audit.VerifyConfig{
+		Config: audit.Config{
+			ChainId:    *chainId,
+			FirstBlock: firstBlock,
+			LastBlock:  lastBlock,
+			PageSize:   *verifyPageSize,
+		},
+		Sample: *verifySample,
+	}, func(difference audit.Difference) error {
+		fmt.Println(difference)
+		broken[int64(difference.BlockNumber)] = struct{}{}
+		return nil
+	})
+	checkError(err)
+
+	verifyLogger.WithFields(logrus.Fields{
+		"firstBlock":    firstBlock,
+		"lastBlock":     lastBlock,
+		"missingBlocks": len(missingBlocks),
+		"duplicates":    len(duplicates),
+		"verified":      summary.Blocks,
+		"extra":         summary.Differences[audit.Extra],
+		"mismatch":      summary.Differences[audit.Mismatch],
+	}).Info("Verify finished")
+
+	if !*verifyRequeue || len(broken) == 0 {
+		return
+	}
+	blocks := make([]int64, 0, len(broken))
+	for block := range broken {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i] < blocks[j] })
+	checkError(qdb.RequeueBlocks(ctx, *chainId, blocks))
+	verifyLogger.WithField("blocks", len(blocks)).Info("Requeued the broken blocks")
+}
+
+// printSchema prints the statements creating the tables and indexes of the
+// features enabled, for schemas managed with external migration tooling
+func printSchema() {
+	features := db.Features{Receipts: *withReceipts || *receipts, Blocks: *storeBlocks}
+	schema, err := db.Schema(*schemaDriver, features)
+	checkError(err)
+	for _, table := range schema {
+		fmt.Println(table.Create + ";")
+		for _, index := range table.Indexes {
+			fmt.Println(index + ";")
+		}
+	}
+}
+
+// pushMetrics pushes the final metrics to the pushgateway, if one is configured.
+// The pushgateway being unavailable doesn't fail the run
+func pushMetrics() {
+	if *pushgateway == "" {
+		return
+	}
+	if err := metrics.Push(*pushgateway, "block_processor"); err != nil {
+		logger.Warn("Failed pushing metrics to pushgateway: ", err)
+		return
+	}
+	logger.Info("Pushed metrics to pushgateway")
+}
+
+// exportHashes exports the stored hash pairs to a csv file
+func exportHashes() {
+	ctx, cancelFunc := context.WithCancel(context.Background())
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	// dispatch blocks to block channel
+	go func() {
+		<-sigs
+		logger.Warn("Received ^C ... stopping export, resume it with --resume")
+		cancelFunc()
+	}()
+
+	qdb, err := db.NewHtmlcoinDB(ctx, getConnectionString(), nil, nil)
+	checkError(err)
+
+	exportLogger := logger.WithField("module", "export")
+	latestBlock, err := eth.GetLatestBlock(ctx, exportLogger, providerPool)
+	checkError(err)
+
+	firstBlock, lastBlock := cache.ScanBounds(*blockFrom, *blockTo, latestBlock)
+	err = export.Export(ctx, exportLogger, qdb, export.Config{
+		ChainId:    *chainId,
+		FirstBlock: firstBlock,
+		LastBlock:  lastBlock,
+		ChunkSize:  *exportChunkSize,
+		Output:     *exportOutput,
+		Resume:     *resume,
+	})
+	checkError(err)
+}
+
+// newSink returns the --sink other than postgres, writing the results of
+// resultChan
+func newSink(resultChan <-chan jsonrpc.HashPair) (sink.Sink, error) {
+	sinkLogger := logger.WithField("module", "sink")
+	switch *sinkKind {
+	case "kafka":
+		if len(*kafkaBrokers) == 0 {
+			return nil, fmt.Errorf("--sink kafka needs --kafka-brokers")
+		}
+		return sink.NewKafka(sinkLogger, resultChan, *kafkaBrokers, *kafkaTopic), nil
+	case "stdout":
+		return sink.NewStdout(sinkLogger, resultChan, sink.NDJSON)
+	case "discard":
+		return sink.NewDiscard(sinkLogger, resultChan), nil
+	default:
+		if *sinkPath == "" {
+			return nil, fmt.Errorf("--sink %s needs --sink-path", *sinkKind)
+		}
+		return sink.NewFile(sinkLogger, resultChan, *sinkPath, sink.Format(*sinkKind))
+	}
+}
+
+func run(chains []*chain) {
+	if *follow && *blockFrom != 0 {
+		logger.Fatal("--follow can't be used with --from, a bounded range has no tip to follow")
+	}
+	if *sinkKind != "postgres" && (*leaderLockKey != 0 || *retryFailed || *checkpointEvery > 0 || *resume || *reorgDepth > 0 || *apiAddr != "") {
+		logger.Fatal("--leader-lock-key, --retry-failed, --checkpoint-every, --resume, --reorg-depth and --api-addr need the postgres sink")
+	}
+	driver, _ := storeDriver(getConnectionString())
+	if *sinkKind == "postgres" && driver != "postgres" && (*leaderLockKey != 0 || *retryFailed || *reorgDepth > 0 || *apiAddr != "") {
+		logger.Fatal("--leader-lock-key, --retry-failed, --reorg-depth and --api-addr need a postgres database")
+	}
+	if len(chains) > 1 && (*sinkKind != "postgres" || driver != "postgres" || *leaderLockKey != 0 || *apiAddr != "" || *newHeadsURL != "") {
+		logger.Fatal("several --chain need the postgres sink and database, and can't be used with --leader-lock-key, --api-addr or --new-heads-url")
+	}
+	if *onlySuccessful && !*withReceipts && !*receipts {
+		logger.Fatal("--only-successful-txs needs --with-receipts, the status of transactions is read from their receipts")
+	}
+	if *txInputFormat == string(db.InputDecoded) && *abiFile == "" {
+		logger.Fatal("--tx-input-format decoded needs the --abi of the contracts called")
+	}
+	if *resume && (*blockTo != 0 || *retryFailed) {
+		logger.Fatal("--resume can't be used with --to or --retry-failed, which scan the range below the checkpoint")
+	}
+	if *resume && *checkpointEvery == 0 {
+		*checkpointEvery = 1000
+	}
+	if *maxWorkers > 0 {
+		// autoscaling starts from --workers within its bounds
+		if *numWorkers > *maxWorkers {
+			*numWorkers = *maxWorkers
+		}
+		if *numWorkers < *minWorkers {
+			*numWorkers = *minWorkers
+		}
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+
+	logger.Info("Number of workers: ", *numWorkers)
+	if *doneFile != "" {
+		checkError(donefile.Clear(*doneFile))
+	}
+	var cacheStore *cache.Store
+	if *cacheFile != "" {
+		var err error
+		cacheStore, err = cache.OpenStore(*cacheFile)
+		checkError(err)
+	}
 
-	blockCacheLogger := logger.WithField("module", "blockCache")
+	// the database is only connected to as the postgres sink
+	processors := make([]*processor.Processor, len(chains))
+	for i, c := range chains {
+		var err error
+		processors[i], err = newProcessor(c, cacheStore)
+		checkError(err)
+	}
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		var err error
+		metricsServer, err = metrics.Serve(*metricsAddr, map[string]http.Handler{
+			"/status":  processor.StatusHandler(processors...),
+			"/healthz": processor.HealthHandler(processors...),
+			"/readyz":  processor.ReadyHandler(processors...),
+		})
+		checkError(err)
+		logger.Info("Serving metrics on ", *metricsAddr)
+	}
+	var apiServer *http.Server
+	if *apiAddr != "" {
+		var err error
+		apiServer, err = api.Serve(*apiAddr, api.Handler(logger.WithField("module", "api"), processors[0].DB(), chains[0].id))
+		checkError(err)
+		logger.Info("Answering lookups on ", *apiAddr)
+	}
 
-	blockCache := cache.NewBlockCache(
-		ctx,
-		func(ctx context.Context) ([]int64, error) {
-			latestBlock, err := eth.GetLatestBlock(ctx, blockCacheLogger, (*providers)[0].String())
-			if err != nil {
-				return nil, err
+	// channel to receive os signals
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	// the first ^C stops every chain, a second one exits immediately
+	go func() {
+		<-sigs
+		logger.Warn("Received ^C ... finishing the blocks already dispatched, ^C again to exit immediately")
+		for _, p := range processors {
+			p.Stop()
+		}
+		<-sigs
+		logger.Warn("Received ^C again ... exiting immediately")
+		os.Exit(1)
+	}()
+	// pause database writes for maintenance windows, fetching carries on
+	pauseSigs := make(chan os.Signal, 1)
+	if *sinkKind == "postgres" {
+		signal.Notify(pauseSigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	}
+	go func() {
+		for sig := range pauseSigs {
+			for _, p := range processors {
+				if sig == syscall.SIGUSR1 {
+					p.Pause()
+				} else {
+					p.Resume()
+				}
 			}
+		}
+	}()
 
-			return qdb.GetMissingBlocks(ctx, *chainId, latestBlock)
-		},
-	)
-
-	d := dispatcher.NewDispatcher(
-		blockChan,
-		resultChan,
-		completedBlockChan,
-		*providers,
-		*blockFrom,
-		*blockTo,
-		done,
-		errChan,
-		blockCache,
-	)
-	d.Start(ctx, *numWorkers, *providers, false)
-	// start workers
-	// wg.Add(*numWorkers)
-	// workers.StartWorkers(ctx, *numWorkers, blockChan, resultChan, *providers, &wg, errChan)
-	start = time.Now()
+	// a chain failing doesn't stop the others
+	summaries := make([]processor.Summary, len(processors))
+	errs := make([]error, len(processors))
+	var wg sync.WaitGroup
+	for i, p := range processors {
+		wg.Add(1)
+		go func(i int, p *processor.Processor) {
+			defer wg.Done()
+			summaries[i], errs[i] = p.Run(ctx)
+		}(i, p)
+	}
+	wg.Wait()
+	cancelFunc()
 
 	var status int
-	select {
-	case <-done:
-		logger.Info("Dispatcher finished")
-		status = 0
-	case <-sigs:
-		logger.Warn("Received ^C ... exiting")
-		logger.Warn("Canceling block dispatcher and stopping workers")
-		cancelFunc()
-		status = 1
-	case err := <-errChan:
-		logger.Warn("Received fatal error: ", err)
-		logger.Warn("Canceling block dispatcher and stopping workers")
-		cancelFunc()
-		status = 1
+	var total processor.Summary
+	for i, summary := range summaries {
+		switch err := errs[i]; {
+		case err == processor.ErrStopped:
+			status = 1
+		case err != nil:
+			logger.WithField("chainId", chains[i].id).Error("Scan failed: ", err)
+			status = 1
+		}
+		total.SuccessBlocks += summary.SuccessBlocks
+		total.ScannedBlocks += summary.ScannedBlocks
+		total.DroppedErrors += summary.DroppedErrors
+		if summary.Duration > total.Duration {
+			total.Duration = summary.Duration
+		}
 	}
-	logger.Debug("Waiting for all workers to exit")
-	wg.Wait()
-	logger.Info("All workers stopped. Waiting for DB to finish")
-	close(resultChan)
-	select {
-	case err = <-dbCloseChan:
+	if *doneFile != "" {
+		err := donefile.Finish(*doneFile, status == 0, donefile.Summary{
+			Workers:            *numWorkers,
+			SuccessBlocks:      total.SuccessBlocks,
+			TotalScannedBlocks: total.ScannedBlocks,
+			Duration:           total.Duration.String(),
+			DroppedErrors:      total.DroppedErrors,
+			FinishedAt:         time.Now().UTC(),
+		})
 		if err != nil {
-			logger.Fatal("Error closing DB:", err)
+			logger.Error("Failed writing done file: ", err)
+			status = 1
 		}
-	case <-time.After(time.Second * 2):
-		logger.Fatal("Error waiting for DB to close")
 	}
-
-	logger.WithFields(logrus.Fields{
-		"workers":             *numWorkers,
-		" successBlocks":      qdb.GetRecords(),
-		" totalScannedBlocks": d.GetDispatchedBlocks(),
-		" duration":           time.Since(start).Truncate(time.Second),
-	}).Info()
+	pushMetrics()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+	if apiServer != nil {
+		apiServer.Close()
+	}
+	if cacheStore != nil {
+		cacheStore.Close()
+	}
 	logger.Print("Program finished")
 	os.Exit(status)
 }
@@ -0,0 +1,215 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const namespace = "block_processor"
+
+var (
+	// Registry holds every metric exported by the block processor
+	Registry = prometheus.NewRegistry()
+
+	BlocksDispatched = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_dispatched_total",
+		Help:      "Number of blocks dispatched to workers",
+	})
+	BlocksCompleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_completed_total",
+		Help:      "Number of blocks whose hashes were computed by workers",
+	})
+	BlocksFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_failed_total",
+		Help:      "Number of block processing failures",
+	})
+	BlocksDeadLettered = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_dead_lettered_total",
+		Help:      "Number of blocks recorded as failed after failing every attempt",
+	})
+	BlocksStored = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blocks_stored_total",
+		Help:      "Number of hash pairs written to the database",
+	})
+	CacheBacklog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_backlog_blocks",
+		Help:      "Number of missing blocks not processed yet",
+	})
+	Workers = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "workers",
+		Help:      "Number of workers fetching blocks",
+	})
+	CheckpointContiguous = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "checkpoint_contiguous_block",
+		Help:      "Block up to which every block of the range is committed",
+	})
+	CheckpointHighWater = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "checkpoint_high_water_block",
+		Help:      "Highest block committed",
+	})
+	PausedResults = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "paused_results",
+		Help:      "Results buffered while database writes are paused",
+	})
+	TipLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tip_lag_blocks",
+		Help:      "Number of blocks between the chain tip and the highest committed block",
+	})
+	TipLagAlerts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tip_lag_alerts_total",
+		Help:      "Number of times the tip lag exceeded its threshold",
+	})
+	ProviderQuarantines = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_quarantines_total",
+		Help:      "Number of providers quarantined for serving another chain",
+	}, []string{"provider"})
+	ProviderEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_ejections_total",
+		Help:      "Number of times a provider was skipped for its cooldown after failing calls in a row",
+	}, []string{"provider"})
+	Reorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reorgs_total",
+		Help:      "Number of reorgs detected from a block's parent hash not matching the stored hash",
+	})
+	ErrorsDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "errors_dropped_total",
+		Help:      "Number of errors dropped from the full error channel",
+	})
+	RPCCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_calls_total",
+		Help:      "Number of rpc requests sent, by provider and attempt (first or retry)",
+	}, []string{"provider", "attempt"})
+	RPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_errors_total",
+		Help:      "Number of failed rpc requests (request) and json rpc error responses (response), by provider",
+	}, []string{"provider", "kind"})
+	RPCLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_request_seconds",
+		Help:      "Time taken by rpc requests, retries timed on their own, by provider",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"provider"})
+	DBWriteLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_write_seconds",
+		Help:      "Time taken by database write transactions, retries timed on their own, by write (block or batch)",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"write"})
+	BlockCommitLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "block_commit_latency_seconds",
+		Help:      "Time from a block being dispatched to its hashes being committed",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	})
+	LatencySLOActual = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "latency_slo_actual_seconds",
+		Help:      "Commit latency of the latency objective's percentile of blocks",
+	})
+	LatencySLOMet = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "latency_slo_met",
+		Help:      "Whether the latency objective was met (1) or missed (0)",
+	})
+	BlockProcessingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "block_processing_seconds",
+		Help:      "Time taken by a worker to fetch and hash a block",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"provider"})
+)
+
+func init() {
+	Registry.MustRegister(
+		BlocksDispatched,
+		BlocksCompleted,
+		BlocksFailed,
+		BlocksDeadLettered,
+		BlocksStored,
+		CacheBacklog,
+		Workers,
+		CheckpointContiguous,
+		CheckpointHighWater,
+		PausedResults,
+		TipLag,
+		TipLagAlerts,
+		RPCCalls,
+		RPCErrors,
+		RPCLatency,
+		ProviderQuarantines,
+		ProviderEjections,
+		ErrorsDropped,
+		Reorgs,
+		DBWriteLatency,
+		BlockCommitLatency,
+		LatencySLOActual,
+		LatencySLOMet,
+		BlockProcessingDuration,
+	)
+}
+
+// QueueDepth is a gauge reading the depth of queue, e.g. the length of a
+// channel, whenever metrics are gathered. It must be registered to be exported
+func QueueDepth(queue string, length func() int) prometheus.Collector {
+	return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "queue_depth",
+		Help:        "Number of items waiting in a queue",
+		ConstLabels: prometheus.Labels{"queue": queue},
+	}, func() float64 {
+		return float64(length())
+	})
+}
+
+// Handler serves every registered metric in the prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// Serve exposes every registered metric on /metrics at addr, along with
+// handlers by their path, until the returned server is closed
+func Serve(addr string, handlers map[string]http.Handler) (*http.Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	for path, handler := range handlers {
+		mux.Handle(path, handler)
+	}
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// Push pushes every registered metric to the pushgateway at url, grouped under job
+func Push(url string, job string) error {
+	return push.New(url, job).
+		Client(&http.Client{Timeout: 10 * time.Second}).
+		Gatherer(Registry).
+		Push()
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// sampleCount returns the samples observed by the histogram of vec labelled
+// provider
+func sampleCount(t *testing.T, vec *prometheus.HistogramVec, provider string) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := vec.WithLabelValues(provider).(prometheus.Histogram).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// providerMetric returns the metric of family labelled provider, nil if none
+func providerMetric(family *dto.MetricFamily, provider string) *dto.Metric {
+	for _, m := range family.Metric {
+		for _, label := range m.Label {
+			if label.GetName() == "provider" && label.GetValue() == provider {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+func TestPush(t *testing.T) {
+	t.Run("metrics are pushed to the pushgateway", func(t *testing.T) {
+		// the metrics are global, other tests and runs observe them too
+		storedBefore := testutil.ToFloat64(BlocksStored)
+		samplesBefore := sampleCount(t, BlockProcessingDuration, "local-geth")
+		BlocksStored.Add(3)
+		BlockProcessingDuration.WithLabelValues("local-geth").Observe(0.2)
+
+		pushed := make(map[string]*dto.MetricFamily)
+		var path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			decoder := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+			for {
+				var family dto.MetricFamily
+				if err := decoder.Decode(&family); err != nil {
+					break
+				}
+				pushed[family.GetName()] = &family
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+
+		if err := Push(server.URL, "block_processor"); err != nil {
+			t.Fatal(err)
+		}
+
+		if path != "/metrics/job/block_processor" {
+			t.Errorf("got path %s, want %s", path, "/metrics/job/block_processor")
+		}
+		stored, ok := pushed["block_processor_blocks_stored_total"]
+		if !ok {
+			t.Fatalf("blocks stored counter wasn't pushed, got %v", pushed)
+		}
+		if got := stored.Metric[0].GetCounter().GetValue() - storedBefore; got != 3 {
+			t.Errorf("got %v blocks stored, want 3", got)
+		}
+		histogram, ok := pushed["block_processor_block_processing_seconds"]
+		if !ok {
+			t.Fatalf("block processing histogram wasn't pushed, got %v", pushed)
+		}
+		metric := providerMetric(histogram, "local-geth")
+		if metric == nil {
+			t.Fatalf("block processing histogram of local-geth wasn't pushed, got %v", histogram)
+		}
+		if got := metric.GetHistogram().GetSampleCount() - samplesBefore; got != 1 {
+			t.Errorf("got %d samples, want 1", got)
+		}
+	})
+
+	t.Run("unreachable pushgateway returns an error", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		url := server.URL
+		server.Close()
+
+		if err := Push(url, "block_processor"); err == nil {
+			t.Error("expected an error pushing to a closed pushgateway")
+		}
+	})
+}
+
+func TestHandler(t *testing.T) {
+	depth := 3
+	queue := QueueDepth("test", func() int { return depth })
+	Registry.MustRegister(queue)
+	defer Registry.Unregister(queue)
+
+	scrape := func(t *testing.T) map[string]*dto.MetricFamily {
+		server := httptest.NewServer(Handler())
+		defer server.Close()
+		resp, err := http.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		families, err := (&expfmt.TextParser{}).TextToMetricFamilies(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return families
+	}
+
+	t.Run("queue depth is read live", func(t *testing.T) {
+		for _, want := range []int{3, 7} {
+			depth = want
+			family, ok := scrape(t)["block_processor_queue_depth"]
+			if !ok {
+				t.Fatal("queue depth wasn't exported")
+			}
+			if got := family.Metric[0].GetGauge().GetValue(); got != float64(want) {
+				t.Errorf("got depth %v, want %d", got, want)
+			}
+		}
+	})
+
+	t.Run("rpc latency is exported by provider", func(t *testing.T) {
+		// the histogram is global, other tests and runs observe it too
+		before := map[string]uint64{
+			"local-geth": sampleCount(t, RPCLatency, "local-geth"),
+			"infura":     sampleCount(t, RPCLatency, "infura"),
+		}
+		RPCLatency.WithLabelValues("local-geth").Observe(0.2)
+		RPCLatency.WithLabelValues("infura").Observe(0.1)
+		RPCLatency.WithLabelValues("infura").Observe(0.3)
+
+		family, ok := scrape(t)["block_processor_rpc_request_seconds"]
+		if !ok {
+			t.Fatal("rpc latency wasn't exported")
+		}
+		for provider, want := range map[string]uint64{"local-geth": 1, "infura": 2} {
+			metric := providerMetric(family, provider)
+			if metric == nil {
+				t.Errorf("rpc latency of %s wasn't exported", provider)
+				continue
+			}
+			if got := metric.GetHistogram().GetSampleCount() - before[provider]; got != want {
+				t.Errorf("got %d samples of %s, want %d", got, provider, want)
+			}
+		}
+	})
+}
+
+func TestServe(t *testing.T) {
+	if _, err := Serve("127.0.0.1:-1", nil); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
@@ -0,0 +1,161 @@
+package processor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+const (
+	// benchmarkInterval is how often a benchmark samples the blocks
+	// completed by the workers running
+	benchmarkInterval = time.Second
+	// optimalRateShare is the share of the best rate the optimal number of
+	// workers reaches, more workers gain too little to be worth their calls
+	optimalRateShare = 0.9
+)
+
+// Benchmark is how fast the providers served a run
+type Benchmark struct {
+	Blocks   int64
+	Duration time.Duration
+	// blocks completed per second
+	Rate      float64
+	Providers []ProviderBenchmark
+	// the rate at each number of workers the run went through, by number of
+	// workers
+	Workers []WorkerRate
+	// fewest workers completing blocks at 90% of the best rate, 0 when no
+	// block completed
+	OptimalWorkers int
+}
+
+// ProviderBenchmark is how a provider served the calls of a run
+type ProviderBenchmark struct {
+	Name   string
+	Calls  int64
+	Failed int64
+	// calls answered per second
+	CallRate float64
+	// share of the calls failed
+	ErrorRate float64
+	Latency   time.Duration
+}
+
+// WorkerRate is how fast blocks completed while a number of workers ran
+type WorkerRate struct {
+	Workers  int
+	Duration time.Duration
+	Blocks   int64
+	Rate     float64
+}
+
+// benchmark samples the blocks completed at each number of workers, from the
+// provider stats as of the start of the run
+type benchmark struct {
+	baseline []jsonrpc.ProviderStats
+
+	mutex   sync.Mutex
+	samples map[int]*WorkerRate
+}
+
+func newBenchmark(baseline []jsonrpc.ProviderStats) *benchmark {
+	return &benchmark{baseline: baseline, samples: make(map[int]*WorkerRate)}
+}
+
+// sample adds the blocks completed by workers over duration
+func (b *benchmark) sample(workers int, duration time.Duration, blocks int64) {
+	if workers < 1 {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	rate, ok := b.samples[workers]
+	if !ok {
+		rate = &WorkerRate{Workers: workers}
+		b.samples[workers] = rate
+	}
+	rate.Duration += duration
+	rate.Blocks += blocks
+}
+
+// run samples the blocks completed every benchmark interval until ctx is
+// cancelled. Intervals the workers idled through, with no block completing
+// or in flight, are dropped
+func (b *benchmark) run(ctx context.Context, blocks blockDispatcher) {
+	ticker := time.NewTicker(benchmarkInterval)
+	defer ticker.Stop()
+	lastCompleted := blocks.GetCompletedBlocks()
+	lastSample := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			completed := blocks.GetCompletedBlocks()
+			if completed > lastCompleted || blocks.GetDispatchedBlocks() > completed {
+				b.sample(blocks.Workers(), now.Sub(lastSample), completed-lastCompleted)
+			}
+			lastCompleted = completed
+			lastSample = now
+		}
+	}
+}
+
+// report returns the benchmark of a run completing blocks over duration,
+// ending with the provider stats given
+func (b *benchmark) report(stats []jsonrpc.ProviderStats, blocks int64, duration time.Duration) Benchmark {
+	report := Benchmark{Blocks: blocks, Duration: duration}
+	if duration > 0 {
+		report.Rate = float64(blocks) / duration.Seconds()
+	}
+
+	for i, provider := range stats {
+		calls, failed := provider.Answered, provider.Failed
+		if i < len(b.baseline) {
+			calls -= b.baseline[i].Answered
+			failed -= b.baseline[i].Failed
+		}
+		calls += failed
+		benchmark := ProviderBenchmark{
+			Name:    provider.Name,
+			Calls:   calls,
+			Failed:  failed,
+			Latency: provider.Latency,
+		}
+		if calls > 0 {
+			benchmark.ErrorRate = float64(failed) / float64(calls)
+		}
+		if duration > 0 {
+			benchmark.CallRate = float64(calls-failed) / duration.Seconds()
+		}
+		report.Providers = append(report.Providers, benchmark)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var best float64
+	for _, sample := range b.samples {
+		rate := *sample
+		if rate.Duration > 0 {
+			rate.Rate = float64(rate.Blocks) / rate.Duration.Seconds()
+		}
+		if rate.Rate > best {
+			best = rate.Rate
+		}
+		report.Workers = append(report.Workers, rate)
+	}
+	sort.Slice(report.Workers, func(i, j int) bool {
+		return report.Workers[i].Workers < report.Workers[j].Workers
+	})
+	for _, rate := range report.Workers {
+		if best > 0 && rate.Rate >= optimalRateShare*best {
+			report.OptimalWorkers = rate.Workers
+			break
+		}
+	}
+	return report
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/sink"
+)
+
+func TestBenchmarkReport(t *testing.T) {
+	b := newBenchmark([]jsonrpc.ProviderStats{{Name: "p0", Answered: 10, Failed: 1}})
+	b.sample(2, time.Second, 20)
+	b.sample(8, 2*time.Second, 76)
+	b.sample(4, time.Second, 36)
+	b.sample(4, time.Second, 36)
+	// samples before the workers started are dropped
+	b.sample(0, time.Second, 0)
+
+	report := b.report([]jsonrpc.ProviderStats{{Name: "p0", Answered: 110, Failed: 11, Latency: time.Millisecond}}, 168, 5*time.Second)
+	if report.Rate != 33.6 {
+		t.Errorf("got rate %v, want 33.6", report.Rate)
+	}
+	if len(report.Workers) != 3 || report.Workers[0].Workers != 2 || report.Workers[1].Rate != 36 || report.Workers[2].Rate != 38 {
+		t.Errorf("got worker rates %+v, want 20, 36 and 38 blocks/s for 2, 4 and 8 workers", report.Workers)
+	}
+	// 4 workers reach 90% of the 38 blocks/s of 8
+	if report.OptimalWorkers != 4 {
+		t.Errorf("got %d optimal workers, want 4", report.OptimalWorkers)
+	}
+	want := ProviderBenchmark{Name: "p0", Calls: 110, Failed: 10, CallRate: 20, ErrorRate: 10.0 / 110, Latency: time.Millisecond}
+	if len(report.Providers) != 1 || report.Providers[0] != want {
+		t.Errorf("got providers %+v, want %+v", report.Providers, want)
+	}
+
+	if empty := newBenchmark(nil).report(nil, 0, 0); empty.OptimalWorkers != 0 || empty.Rate != 0 {
+		t.Errorf("got %+v, want no rate without blocks", empty)
+	}
+}
+
+func TestRunBenchmark(t *testing.T) {
+	provider, err := jsonrpc.ParseProvider("synthetic://?latency=2ms&head=100000&chainId=4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := log.GetLogger()
+	p, err := New(
+		WithChain(4444, []*jsonrpc.Provider{provider}),
+		WithWorkers(2),
+		WithRange(100000, 1),
+		WithBenchmark(),
+		WithShutdownTimeout(5*time.Second),
+		WithSink(func(resultChan <-chan jsonrpc.HashPair) (sink.Sink, error) {
+			return sink.NewDiscard(logger.WithField("module", "sink"), resultChan), nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(2500*time.Millisecond, p.Stop)
+
+	summary, err := p.Run(context.Background())
+	if err != ErrStopped {
+		t.Fatalf("got %v, want the run stopped", err)
+	}
+	report := summary.Benchmark
+	if report == nil {
+		t.Fatal("got no benchmark")
+	}
+	if report.Blocks == 0 || report.OptimalWorkers != 2 || len(report.Workers) != 1 {
+		t.Errorf("got %+v, want blocks completed by the 2 workers", report)
+	}
+	if len(report.Providers) != 1 || report.Providers[0].Calls < report.Blocks || report.Providers[0].Failed != 0 {
+		t.Errorf("got providers %+v, want a call per block at least", report.Providers)
+	}
+}
@@ -0,0 +1,82 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// detectChainId sets the chain id to the one the first provider answering
+// serves when unset, then verifies every provider serves it so the chain's
+// blocks are only fetched from its own providers. Providers not answering
+// are skipped, at least one has to serve the chain id
+func (p *Processor) detectChainId(ctx context.Context) error {
+	var matching int
+	var lastErr error
+	for _, provider := range p.providers {
+		served, err := eth.GetChainId(ctx, jsonrpc.NewPool([]*jsonrpc.Provider{provider}, 1, 0))
+		if err != nil {
+			p.logger.WithField("provider", provider.Name()).Warn("Failed getting the chain id of the provider: ", err)
+			lastErr = err
+			continue
+		}
+		if p.chainId == 0 {
+			p.chainId = int(served)
+			p.logger.WithField("chainId", p.chainId).Info("Chain id detected from the providers")
+		}
+		if int64(p.chainId) != served {
+			err = fmt.Errorf("chain id %d disagrees with chain id %d provider %s serves", p.chainId, served, provider.Name())
+			if err = p.chainIdMismatch(err); err != nil {
+				return err
+			}
+			continue
+		}
+		matching++
+	}
+	if matching == 0 && lastErr != nil {
+		return fmt.Errorf("getting the chain id of the providers: %w", lastErr)
+	}
+	return nil
+}
+
+// checkStoredChainIds verifies the database has rows of the chain id, or of
+// no chain id but those shared with it
+func (p *Processor) checkStoredChainIds(ctx context.Context) error {
+	stored, err := p.qdb.GetStoredChainIds(ctx)
+	if err != nil {
+		return fmt.Errorf("getting the chain ids stored: %w", err)
+	}
+	var others []int
+	for _, chainId := range stored {
+		if chainId == p.chainId {
+			return nil
+		}
+		if !containsChainId(p.sharedChainIds, chainId) {
+			others = append(others, chainId)
+		}
+	}
+	if len(others) == 0 {
+		return nil
+	}
+	return p.chainIdMismatch(fmt.Errorf("chain id %d disagrees with chain ids %v stored in the database", p.chainId, others))
+}
+
+// chainIdMismatch returns err, or only logs it when the chain id is forced
+func (p *Processor) chainIdMismatch(err error) error {
+	if !p.forceChainId {
+		return err
+	}
+	p.logger.Warn("Carrying on as the chain id is forced: ", err)
+	return nil
+}
+
+func containsChainId(chainIds []int, chainId int) bool {
+	for _, c := range chainIds {
+		if c == chainId {
+			return true
+		}
+	}
+	return false
+}
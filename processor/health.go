@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Ready reports whether the processor is scanning. New verified the
+// providers serve its chain and connected to its database, a standby
+// waiting to be elected leader isn't ready
+func (p *Processor) Ready() bool {
+	return atomic.LoadInt32(&p.scanning) == 1
+}
+
+// Healthy returns an error once missing blocks went without any completing
+// for the stall timeout, a processor that isn't scanning, or has no blocks
+// left to scan, is healthy
+func (p *Processor) Healthy() error {
+	if p.stallTimeout <= 0 || !p.Ready() || p.blockCache.GetBacklog() == 0 {
+		return nil
+	}
+	stalled := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastCompletion)))
+	if stalled < p.stallTimeout {
+		return nil
+	}
+	return fmt.Errorf("no block completed for %s with %d blocks missing", stalled.Round(time.Second), p.blockCache.GetBacklog())
+}
+
+type healthResponse struct {
+	ChainId int    `json:"chainId"`
+	Error   string `json:"error,omitempty"`
+}
+
+// HealthHandler answers 200 while every processor is healthy, and 503 with
+// the errors of each processor otherwise
+func HealthHandler(processors ...*Processor) http.Handler {
+	return checkHandler(processors, (*Processor).Healthy)
+}
+
+// ReadyHandler answers 200 once every processor is ready, and 503 otherwise
+func ReadyHandler(processors ...*Processor) http.Handler {
+	return checkHandler(processors, func(p *Processor) error {
+		if !p.Ready() {
+			return fmt.Errorf("not scanning")
+		}
+		return nil
+	})
+}
+
+func checkHandler(processors []*Processor, check func(p *Processor) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		responses := make([]healthResponse, len(processors))
+		for i, p := range processors {
+			responses[i].ChainId = p.chainId
+			if err := check(p); err != nil {
+				responses[i].Error = err.Error()
+				status = http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(responses)
+	})
+}
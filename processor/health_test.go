@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/sink"
+)
+
+func TestHealthHandlers(t *testing.T) {
+	p, err := New(
+		WithChain(4444, syntheticProviders(t)),
+		WithResultHandler(func(ctx context.Context, records []sink.Record) error { return nil }),
+		WithStallTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(handler http.Handler) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder.Code
+	}
+
+	if got := get(ReadyHandler(p)); got != http.StatusServiceUnavailable {
+		t.Errorf("got status %d before scanning, want it not ready", got)
+	}
+	if got := get(HealthHandler(p)); got != http.StatusOK {
+		t.Errorf("got status %d before scanning, want it healthy", got)
+	}
+
+	p.blockCache = cache.NewBlockCache(context.Background(), func(ctx context.Context) ([]int64, error) {
+		return []int64{1, 2, 3}, nil
+	})
+	if _, err = p.blockCache.UpdateMissingBlocks(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt64(&p.lastCompletion, time.Now().UnixNano())
+	atomic.StoreInt32(&p.scanning, 1)
+	if got := get(ReadyHandler(p)); got != http.StatusOK {
+		t.Errorf("got status %d while scanning, want it ready", got)
+	}
+	if got := get(HealthHandler(p)); got != http.StatusOK {
+		t.Errorf("got status %d with a block just completed, want it healthy", got)
+	}
+
+	atomic.StoreInt64(&p.lastCompletion, time.Now().Add(-2*time.Minute).UnixNano())
+	if got := get(HealthHandler(p)); got != http.StatusServiceUnavailable {
+		t.Errorf("got status %d with no block completed for 2m, want it unhealthy", got)
+	}
+}
@@ -0,0 +1,542 @@
+// Package processor scans the blocks of a chain and stores the hash pairs of
+// their htmlcoin and eth hashes. It's the pipeline the command runs, for
+// embedding in other Go programs
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/dispatcher"
+	"github.com/denuoweb/ethereum-block-processor/errqueue"
+	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/sink"
+	"github.com/denuoweb/ethereum-block-processor/slo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStopped is returned by Run when Stop was called before every block was
+// processed
+var ErrStopped = errors.New("processor stopped")
+
+// NewSink returns the sink consuming the results sent on resultChan
+type NewSink func(resultChan <-chan jsonrpc.HashPair) (sink.Sink, error)
+
+// Processor scans the blocks of a chain, from the newest block of its range
+// down to the oldest, storing the hash pairs of those missing from its sink.
+// It's connected to its sink by New and scans once Run is called, Run can
+// only be called once
+type Processor struct {
+	logger    *logrus.Entry
+	logFields logrus.Fields
+
+	chainId   int
+	providers []*jsonrpc.Provider
+	pool      *jsonrpc.Pool
+	dbString  string
+	newSink   NewSink
+	workers   int
+	from      int64
+	to        int64
+	follow    bool
+	// the hash pairs and checkpoints are stored to instead of postgres
+	store db.Store
+	// bounds of the autoscaled workers, disabled when maxWorkers is 0
+	minWorkers        int
+	maxWorkers        int
+	autoscaleInterval time.Duration
+
+	errorBuffer        int
+	errorPolicy        errqueue.Policy
+	sloLatency         time.Duration
+	sloPercentile      float64
+	checkpointEvery    int
+	resume             bool
+	retryFailed        bool
+	reorgDepth         int64
+	leaderLockKey      int64
+	leaderLockInterval time.Duration
+	batchSize          int
+	rangeSize          int
+	newHeadsURL        string
+	newHeadsInterval   time.Duration
+	loaderRetries      int
+	loaderRetryBackoff time.Duration
+	backlogInterval    time.Duration
+	progressInterval   time.Duration
+	registerer         prometheus.Registerer
+	tipLagThreshold    int64
+	tipLagInterval     time.Duration
+	chainIdInterval    time.Duration
+	// carry on when the chain id disagrees with the providers or database
+	forceChainId bool
+	// chain ids of other processors storing to the same database
+	sharedChainIds  []int
+	shutdownTimeout time.Duration
+	dispatcherOpts  []dispatcher.Option
+	dbOpts          []db.Option
+	cacheStore      *cache.Store
+	// the scan's pending blocks are saved under in the cache store
+	cacheKey string
+
+	resultChan     chan jsonrpc.HashPair
+	errQueue       *errqueue.Queue
+	resultSink     sink.Sink
+	qdb            *db.HtmlcoinDB
+	checkpoint     *cache.Checkpoint
+	latencyTracker *slo.Tracker
+	// blocks left stale by a reorg, refetched by the dispatcher
+	reorgChan  chan int64
+	stopChan   chan struct{}
+	stopOnce   sync.Once
+	blockCache *cache.BlockCache
+
+	// sparse scans only fetch the blocks with logs matching logFilter
+	logFilter    eth.LogFilter
+	logRangeSize int64
+	logIndex     *eth.LogIndex
+
+	progressMutex sync.Mutex
+	progress      Progress
+	// set once scanning, and when a block last completed in unix nanoseconds
+	scanning       int32
+	lastCompletion int64
+	stallTimeout   time.Duration
+	// samples the blocks completed at each number of workers when set
+	benchmarking bool
+	benchmark    *benchmark
+}
+
+type Option func(p *Processor)
+
+// WithChain sets the chain scanned and the providers its blocks are fetched
+// from, the chain id is detected from the providers when 0
+func WithChain(chainId int, providers []*jsonrpc.Provider) Option {
+	return func(p *Processor) {
+		p.chainId = chainId
+		p.providers = providers
+	}
+}
+
+// WithProviderPool sets the pool tracking the health of the providers, one
+// skipping providers after 3 consecutive failures for 30s is used otherwise
+func WithProviderPool(pool *jsonrpc.Pool) Option {
+	return func(p *Processor) {
+		p.pool = pool
+	}
+}
+
+// WithDatabase stores the hash pairs to the postgres database of
+// connectionString
+func WithDatabase(connectionString string) Option {
+	return func(p *Processor) {
+		p.dbString = connectionString
+	}
+}
+
+// WithSink writes the hash pairs to the sink returned by newSink instead of
+// the postgres database
+func WithSink(newSink NewSink) Option {
+	return func(p *Processor) {
+		p.newSink = newSink
+	}
+}
+
+// WithStore stores the hash pairs to store, such as a sqlite or mysql one,
+// instead of the postgres database. Checkpoints are saved to it as well
+func WithStore(store db.Store) Option {
+	return func(p *Processor) {
+		p.store = store
+		p.newSink = func(resultChan <-chan jsonrpc.HashPair) (sink.Sink, error) {
+			return sink.NewStore(p.logger.WithField("module", "sink"), resultChan, store, p.checkpoint, p.checkpointEvery), nil
+		}
+	}
+}
+
+// WithResultHandler hands the hash pairs to handler as they're produced
+// instead of storing them
+func WithResultHandler(handler sink.Handler) Option {
+	return func(p *Processor) {
+		p.newSink = func(resultChan <-chan jsonrpc.HashPair) (sink.Sink, error) {
+			return sink.NewCallback(p.logger.WithField("module", "sink"), resultChan, handler), nil
+		}
+	}
+}
+
+// WithWorkers sets the number of workers fetching blocks, the number of
+// CPUs by default
+func WithWorkers(workers int) Option {
+	return func(p *Processor) {
+		p.workers = workers
+	}
+}
+
+// WithAutoscale grows and shrinks the workers between minWorkers and
+// maxWorkers every interval, starting from those WithWorkers sets, by the
+// provider latency and error rate and the blocks queued up for the workers
+func WithAutoscale(minWorkers, maxWorkers int, interval time.Duration) Option {
+	return func(p *Processor) {
+		p.minWorkers = minWorkers
+		p.maxWorkers = maxWorkers
+		p.autoscaleInterval = interval
+	}
+}
+
+// WithRange sets the newest and the oldest block scanned, 0 scans from the
+// latest block and down to block 1
+func WithRange(from, to int64) Option {
+	return func(p *Processor) {
+		p.from = from
+		p.to = to
+	}
+}
+
+// WithFollow keeps following the chain tip once the missing blocks are
+// processed instead of returning
+func WithFollow(follow bool) Option {
+	return func(p *Processor) {
+		p.follow = follow
+	}
+}
+
+// WithLogFields adds fields to every line logged by the processor
+func WithLogFields(fields logrus.Fields) Option {
+	return func(p *Processor) {
+		p.logFields = fields
+		p.logger = p.logger.WithFields(fields)
+	}
+}
+
+// WithErrorBuffer sets the number of errors buffered, the number of workers
+// + 1 by default, and what's done with errors sent while it's full
+func WithErrorBuffer(size int, policy errqueue.Policy) Option {
+	return func(p *Processor) {
+		p.errorBuffer = size
+		p.errorPolicy = policy
+	}
+}
+
+// WithLatencyObjective reports whether percentile percent of the blocks
+// were committed within latency of their dispatch
+func WithLatencyObjective(latency time.Duration, percentile float64) Option {
+	return func(p *Processor) {
+		p.sloLatency = latency
+		p.sloPercentile = percentile
+	}
+}
+
+// WithCheckpoint persists the contiguous checkpoint every n committed blocks
+// and resumes from it without an oldest block. resume warns when there's no
+// checkpoint to resume from
+func WithCheckpoint(every int, resume bool) Option {
+	return func(p *Processor) {
+		p.checkpointEvery = every
+		p.resume = resume
+	}
+}
+
+// WithRetryFailed requeues the blocks recorded as failed by earlier runs
+func WithRetryFailed(retry bool) Option {
+	return func(p *Processor) {
+		p.retryFailed = retry
+	}
+}
+
+// WithReorgDepth rewinds and refetches up to depth blocks when a reorg is
+// detected
+func WithReorgDepth(depth int64) Option {
+	return func(p *Processor) {
+		p.reorgDepth = depth
+	}
+}
+
+// WithLeaderLock only scans once elected leader by the advisory lock key,
+// renewed or tried every interval
+func WithLeaderLock(key int64, interval time.Duration) Option {
+	return func(p *Processor) {
+		p.leaderLockKey = key
+		p.leaderLockInterval = interval
+	}
+}
+
+// WithBatchSize sets the maximum number of blocks fetched in a single json
+// rpc batch request
+func WithBatchSize(size int) Option {
+	return func(p *Processor) {
+		p.batchSize = size
+	}
+}
+
+// WithRangeSize sets the maximum number of contiguous blocks fetched in a
+// single range request
+func WithRangeSize(size int) Option {
+	return func(p *Processor) {
+		p.rangeSize = size
+	}
+}
+
+// WithNewHeads subscribes to the new heads of the websocket provider url,
+// the first websocket provider when empty, polling the latest block every
+// interval while not subscribed
+func WithNewHeads(url string, interval time.Duration) Option {
+	return func(p *Processor) {
+		p.newHeadsURL = url
+		p.newHeadsInterval = interval
+	}
+}
+
+// WithMissingBlocksRetries sets the retries of the missing blocks query and
+// the backoff before the first one
+func WithMissingBlocksRetries(retries int, backoff time.Duration) Option {
+	return func(p *Processor) {
+		p.loaderRetries = retries
+		p.loaderRetryBackoff = backoff
+	}
+}
+
+// WithBacklogInterval logs the missing blocks backlog every interval
+func WithBacklogInterval(interval time.Duration) Option {
+	return func(p *Processor) {
+		p.backlogInterval = interval
+	}
+}
+
+// WithProgressInterval logs the progress of the scan every interval
+func WithProgressInterval(interval time.Duration) Option {
+	return func(p *Processor) {
+		p.progressInterval = interval
+	}
+}
+
+// WithMetrics registers the queue depths with registerer and exports the
+// tip lag
+func WithMetrics(registerer prometheus.Registerer) Option {
+	return func(p *Processor) {
+		p.registerer = registerer
+	}
+}
+
+// WithTipLag warns when the highest stored block falls threshold blocks
+// behind the chain tip, checked every interval
+func WithTipLag(threshold int64, interval time.Duration) Option {
+	return func(p *Processor) {
+		p.tipLagThreshold = threshold
+		p.tipLagInterval = interval
+	}
+}
+
+// WithChainIdCheck verifies providers still serve the chain every interval
+func WithChainIdCheck(interval time.Duration) Option {
+	return func(p *Processor) {
+		p.chainIdInterval = interval
+	}
+}
+
+// WithChainIdGuard only warns, when force is set, that the chain id disagrees
+// with the one the providers serve or with the rows of the database. The
+// rows of the shared chain ids, scanned to the same database by other
+// processors, don't disagree
+func WithChainIdGuard(force bool, shared []int) Option {
+	return func(p *Processor) {
+		p.forceChainId = force
+		p.sharedChainIds = shared
+	}
+}
+
+// WithLogFilter only scans the blocks with logs matching filter, found with
+// eth_getLogs queries of up to rangeSize blocks. An empty filter scans
+// every block
+func WithLogFilter(filter eth.LogFilter, rangeSize int64) Option {
+	return func(p *Processor) {
+		p.logFilter = filter
+		p.logRangeSize = rangeSize
+	}
+}
+
+// WithStallTimeout reports the processor unhealthy once missing blocks went
+// without any completing for timeout, 0 never does
+func WithStallTimeout(timeout time.Duration) Option {
+	return func(p *Processor) {
+		p.stallTimeout = timeout
+	}
+}
+
+// WithBenchmark reports how fast the providers served the run in its
+// summary: the rate of blocks and calls, the calls failed and the rate at
+// each number of workers the autoscaling went through
+func WithBenchmark() Option {
+	return func(p *Processor) {
+		p.benchmarking = true
+	}
+}
+
+// WithShutdownTimeout sets how long the blocks already dispatched get to be
+// processed and written once stopped, and the sink to close
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(p *Processor) {
+		p.shutdownTimeout = timeout
+	}
+}
+
+// WithCacheStore saves the blocks pending to store, restoring those a run of
+// the same chain and range left pending so they're dispatched right away
+func WithCacheStore(store *cache.Store) Option {
+	return func(p *Processor) {
+		p.cacheStore = store
+	}
+}
+
+// WithDispatcherOptions sets options of the dispatcher not covered by the
+// processor's own
+func WithDispatcherOptions(opts ...dispatcher.Option) Option {
+	return func(p *Processor) {
+		p.dispatcherOpts = append(p.dispatcherOpts, opts...)
+	}
+}
+
+// WithDBOptions sets options of the postgres database not covered by the
+// processor's own
+func WithDBOptions(opts ...db.Option) Option {
+	return func(p *Processor) {
+		p.dbOpts = append(p.dbOpts, opts...)
+	}
+}
+
+// New returns a processor connected to its sink, the database's tables are
+// created if missing
+func New(opts ...Option) (*Processor, error) {
+	mainLogger, err := log.GetLogger()
+	if err != nil {
+		return nil, err
+	}
+	p := &Processor{
+		logger:             logrus.NewEntry(mainLogger),
+		workers:            runtime.NumCPU(),
+		errorPolicy:        errqueue.Block,
+		sloPercentile:      95,
+		leaderLockInterval: 10 * time.Second,
+		batchSize:          1,
+		rangeSize:          20,
+		newHeadsInterval:   15 * time.Second,
+		loaderRetries:      5,
+		loaderRetryBackoff: time.Second,
+		tipLagInterval:     time.Minute,
+		shutdownTimeout:    30 * time.Second,
+		autoscaleInterval:  10 * time.Second,
+		stopChan:           make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	if len(p.providers) == 0 {
+		return nil, fmt.Errorf("no providers given")
+	}
+	if p.workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d", p.workers)
+	}
+	if p.maxWorkers > 0 && (p.minWorkers < 1 || p.minWorkers > p.maxWorkers || p.workers < p.minWorkers || p.workers > p.maxWorkers || p.autoscaleInterval <= 0) {
+		return nil, fmt.Errorf("invalid autoscaling of %d workers between %d and %d every %s", p.workers, p.minWorkers, p.maxWorkers, p.autoscaleInterval)
+	}
+	if p.follow && p.from != 0 {
+		return nil, fmt.Errorf("a bounded range has no tip to follow")
+	}
+	if !p.logFilter.Empty() && (p.logRangeSize < 1 || p.checkpointEvery > 0) {
+		return nil, fmt.Errorf("a log filter needs a positive range size and can't be used with checkpoints")
+	}
+	if p.newSink != nil && (p.leaderLockKey != 0 || p.retryFailed || p.reorgDepth > 0) {
+		return nil, fmt.Errorf("leader lock, retrying failed blocks and reorg detection need the postgres database")
+	}
+	if p.newSink != nil && p.store == nil && p.checkpointEvery > 0 {
+		return nil, fmt.Errorf("checkpoints need the postgres database or a store")
+	}
+	if p.newSink == nil && p.dbString == "" {
+		return nil, fmt.Errorf("no database or sink given")
+	}
+	if p.pool == nil {
+		p.pool = jsonrpc.NewPool(p.providers, 3, 30*time.Second)
+	}
+	if p.errorBuffer < 1 {
+		p.errorBuffer = p.workers + 1
+		if p.maxWorkers > p.workers {
+			p.errorBuffer = p.maxWorkers + 1
+		}
+	}
+	p.errQueue = errqueue.New(p.errorBuffer, p.errorPolicy)
+	p.resultChan = make(chan jsonrpc.HashPair, p.workers)
+	if p.sloLatency > 0 {
+		p.latencyTracker = slo.NewTracker()
+	}
+	if p.checkpointEvery > 0 {
+		p.checkpoint = cache.NewCheckpoint()
+	}
+	if !p.logFilter.Empty() {
+		p.logIndex = eth.NewLogIndex(p.pool, p.logFilter, p.logRangeSize)
+	}
+	if p.reorgDepth > 0 {
+		p.reorgChan = make(chan int64, p.reorgDepth)
+	}
+	if err = p.detectChainId(context.Background()); err != nil {
+		return nil, err
+	}
+	p.cacheKey = fmt.Sprintf("%d/%d/%d", p.chainId, p.from, p.to)
+
+	if p.newSink != nil {
+		if p.resultSink, err = p.newSink(p.resultChan); err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	dbOpts := append([]db.Option{
+		db.WithLatencyTracker(p.latencyTracker),
+		db.WithCheckpoint(p.checkpoint, p.checkpointEvery),
+		db.WithReorgDetection(p.reorgDepth, p.reorgChan),
+		db.WithLogFields(p.logFields),
+	}, p.dbOpts...)
+	p.qdb, err = db.NewHtmlcoinDB(context.Background(), p.dbString, p.resultChan, p.errQueue.In, dbOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = p.checkStoredChainIds(context.Background()); err != nil {
+		return nil, err
+	}
+	p.resultSink = p.qdb
+	return p, nil
+}
+
+// DB returns the postgres database the hash pairs are stored to, nil with
+// another sink
+func (p *Processor) DB() *db.HtmlcoinDB {
+	return p.qdb
+}
+
+// Pause pauses database writes, fetching carries on
+func (p *Processor) Pause() {
+	if p.qdb != nil {
+		p.qdb.Pause()
+	}
+}
+
+// Resume resumes database writes
+func (p *Processor) Resume() {
+	if p.qdb != nil {
+		p.qdb.Resume()
+	}
+}
+
+// Stop stops dispatching blocks, Run returns once those already dispatched
+// are processed and written
+func (p *Processor) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopChan)
+	})
+}
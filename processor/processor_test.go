@@ -0,0 +1,284 @@
+package processor
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/sink"
+)
+
+func syntheticProviders(t *testing.T) []*jsonrpc.Provider {
+	t.Helper()
+	provider, err := jsonrpc.ParseProvider("synthetic://?latency=1ms&head=50&chainId=4444")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []*jsonrpc.Provider{provider}
+}
+
+// parseProviders parses provider definitions
+func parseProviders(t *testing.T, definitions ...string) []*jsonrpc.Provider {
+	t.Helper()
+	var providers []*jsonrpc.Provider
+	for _, definition := range definitions {
+		provider, err := jsonrpc.ParseProvider(definition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func TestNew(t *testing.T) {
+	handler := WithResultHandler(func(ctx context.Context, records []sink.Record) error { return nil })
+	for name, opts := range map[string][]Option{
+		"no providers":                         {handler},
+		"no sink":                              {WithChain(4444, syntheticProviders(t))},
+		"following a bounded range":            {WithChain(4444, syntheticProviders(t)), handler, WithRange(50, 1), WithFollow(true)},
+		"checkpoints without postgres":         {WithChain(4444, syntheticProviders(t)), handler, WithCheckpoint(1000, false)},
+		"reorg detection without postgres":     {WithChain(4444, syntheticProviders(t)), handler, WithReorgDepth(10)},
+		"a log filter with checkpoints":        {WithChain(4444, syntheticProviders(t)), handler, WithLogFilter(eth.LogFilter{Addresses: []string{"0x01"}}, 100), WithCheckpoint(1000, false)},
+		"workers outside of the autoscaling":   {WithChain(4444, syntheticProviders(t)), handler, WithWorkers(8), WithAutoscale(1, 4, time.Second)},
+		"a chain id the providers don't serve": {WithChain(4445, syntheticProviders(t)), handler},
+		"a provider of another chain":          {WithChain(4444, parseProviders(t, "synthetic://?chainId=4444", "synthetic://?chainId=5555")), handler},
+	} {
+		t.Run(name+" is rejected", func(t *testing.T) {
+			if _, err := New(opts...); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestChainIdDetection(t *testing.T) {
+	handler := WithResultHandler(func(ctx context.Context, records []sink.Record) error { return nil })
+	p, err := New(WithChain(0, syntheticProviders(t)), handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.chainId != 4444 {
+		t.Errorf("got chain id %d, want 4444 detected from the providers", p.chainId)
+	}
+	if _, err = New(WithChain(4445, syntheticProviders(t)), handler, WithChainIdGuard(true, nil)); err != nil {
+		t.Errorf("forced chain id rejected: %v", err)
+	}
+}
+
+func TestRunChains(t *testing.T) {
+	type run struct {
+		chainId   int
+		providers []*jsonrpc.Provider
+		p         *Processor
+		mutex     sync.Mutex
+		records   []sink.Record
+	}
+	runs := []*run{
+		{chainId: 4444, providers: parseProviders(t, "a1=synthetic://?latency=1ms&head=50&chainId=4444", "a2=synthetic://?latency=1ms&head=50&chainId=4444")},
+		{chainId: 5555, providers: parseProviders(t, "b1=synthetic://?latency=1ms&head=50&chainId=5555")},
+	}
+	for _, r := range runs {
+		r := r
+		var err error
+		r.p, err = New(
+			WithChain(r.chainId, r.providers),
+			WithWorkers(2),
+			WithRange(50, 1),
+			WithShutdownTimeout(5*time.Second),
+			WithResultHandler(func(ctx context.Context, batch []sink.Record) error {
+				r.mutex.Lock()
+				defer r.mutex.Unlock()
+				r.records = append(r.records, batch...)
+				if len(r.records) >= 10 {
+					r.p.Stop()
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range runs {
+		wg.Add(1)
+		go func(r *run) {
+			defer wg.Done()
+			if _, err := r.p.Run(context.Background()); err != ErrStopped {
+				t.Errorf("chain %d: got %v, want the run stopped", r.chainId, err)
+			}
+		}(r)
+	}
+	wg.Wait()
+
+	for _, r := range runs {
+		for _, record := range r.records {
+			if record.ChainId != r.chainId {
+				t.Errorf("chain %d: got record %+v of another chain", r.chainId, record)
+			}
+		}
+		// every call of the chain went to its own providers
+		stats := r.p.pool.Stats()
+		if len(stats) != len(r.providers) {
+			t.Fatalf("chain %d: got providers %+v, want its %d", r.chainId, stats, len(r.providers))
+		}
+		for i, provider := range stats {
+			if provider.Name != r.providers[i].Name() || provider.Answered == 0 {
+				t.Errorf("chain %d: got provider %+v, want %s answering calls", r.chainId, provider, r.providers[i].Name())
+			}
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var mutex sync.Mutex
+	var records []sink.Record
+	var p *Processor
+	p, err := New(
+		WithChain(4444, syntheticProviders(t)),
+		WithWorkers(2),
+		WithRange(50, 1),
+		WithShutdownTimeout(5*time.Second),
+		WithResultHandler(func(ctx context.Context, batch []sink.Record) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			records = append(records, batch...)
+			p.Stop()
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := p.Run(context.Background())
+	if err != ErrStopped {
+		t.Fatalf("got %v, want the run stopped", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(records) == 0 {
+		t.Fatal("no results were handed to the handler")
+	}
+	for _, record := range records {
+		if record.ChainId != 4444 || record.BlockNumber < 1 || record.BlockNumber > 50 || record.EthHash == "" {
+			t.Errorf("got record %+v, want a hash pair of a block of the range", record)
+		}
+	}
+	if summary.SuccessBlocks != int64(len(records)) {
+		t.Errorf("got %d successful blocks, want the %d handled", summary.SuccessBlocks, len(records))
+	}
+	if len(summary.UnfinishedBlocks) != 0 {
+		t.Errorf("got blocks %v unfinished, want those dispatched before stopping drained", summary.UnfinishedBlocks)
+	}
+}
+
+func TestRunSparse(t *testing.T) {
+	provider, err := jsonrpc.ParseProvider("synthetic://?latency=1ms&head=50&chainId=4444&logs=10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	blocks := map[int]bool{}
+	var p *Processor
+	p, err = New(
+		WithChain(4444, []*jsonrpc.Provider{provider}),
+		WithWorkers(2),
+		WithRange(50, 1),
+		WithLogFilter(eth.LogFilter{Addresses: []string{"0x0000000000000000000000000000000000000001"}}, 20),
+		WithShutdownTimeout(5*time.Second),
+		WithResultHandler(func(ctx context.Context, batch []sink.Record) error {
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, record := range batch {
+				blocks[record.BlockNumber] = true
+			}
+			if len(blocks) == 5 {
+				p.Stop()
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.Run(context.Background()); err != ErrStopped {
+		t.Fatalf("got %v, want the run stopped", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	for _, block := range []int{10, 20, 30, 40, 50} {
+		if !blocks[block] {
+			t.Errorf("block %d with logs wasn't scanned", block)
+		}
+	}
+	if len(blocks) != 5 {
+		t.Errorf("got blocks %v, want only those with logs", blocks)
+	}
+}
+
+func TestRunStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "hashes.db")
+	store, err := db.OpenStore(ctx, "sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := New(
+		WithChain(4444, syntheticProviders(t)),
+		WithWorkers(2),
+		WithRange(50, 1),
+		WithStore(store),
+		WithCheckpoint(5, false),
+		WithShutdownTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for store.GetRecords() < 20 {
+			time.Sleep(time.Millisecond)
+		}
+		p.Stop()
+	}()
+	summary, err := p.Run(ctx)
+	if err != ErrStopped {
+		t.Fatalf("got %v, want the run stopped", err)
+	}
+
+	// the sink closed the store once the results were written
+	store, err = db.OpenStore(ctx, "sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	missing, err := store.GetMissingBlocks(ctx, 4444, 1, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := int64(50 - len(missing)); stored != summary.SuccessBlocks || stored < 20 {
+		t.Errorf("got %d blocks stored, want the %d successful ones", stored, summary.SuccessBlocks)
+	}
+	// blocks are dispatched in no particular order, the run may stop before
+	// block 50 is stored
+	isMissing := make(map[int64]bool)
+	for _, block := range missing {
+		isMissing[block] = true
+	}
+	highest := int64(50)
+	for highest > 0 && isMissing[highest] {
+		highest--
+	}
+	checkpoint, err := store.GetCheckpoint(ctx, 4444)
+	if err != nil || checkpoint == nil || checkpoint.HighWater != highest {
+		t.Errorf("got checkpoint %+v and %v, want one up to block %d, the highest stored", checkpoint, err, highest)
+	}
+}
@@ -0,0 +1,526 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/dispatcher"
+	"github.com/denuoweb/ethereum-block-processor/eth"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/denuoweb/ethereum-block-processor/slo"
+	"github.com/sirupsen/logrus"
+)
+
+// errCloseTimeout is returned by Run when the sink didn't close within the
+// shutdown timeout
+var errCloseTimeout = errors.New("timed out waiting for DB to close")
+
+// cacheSaveInterval is how often the pending blocks are saved to the cache
+// store, those completed since are fetched again after a crash
+const cacheSaveInterval = 10 * time.Second
+
+// maxUnfinishedRanges caps the ranges of unfinished blocks logged on shutdown
+const maxUnfinishedRanges = 20
+
+// Summary is how a run went
+type Summary struct {
+	SuccessBlocks int64
+	ScannedBlocks int64
+	DroppedErrors int64
+	// blocks dispatched but not processed when the run ended, in ascending
+	// order. They're still missing, the next run fetches them again
+	UnfinishedBlocks []int64
+	Duration         time.Duration
+	// outcome of the latency objective, nil without one
+	Latency *slo.Report
+	// how fast the providers served the run, nil without WithBenchmark
+	Benchmark *Benchmark
+}
+
+// blockDispatcher is what a processor uses of its dispatcher
+type blockDispatcher interface {
+	GetDispatchedBlocks() int64
+	GetCompletedBlocks() int64
+	Workers() int
+	UnfinishedBlocks() []int64
+	Shutdown()
+	Wait()
+}
+
+// Run scans the chain until every missing block is processed, or for good
+// when following the tip, until Stop is called or it fails, logging and
+// returning its summary. Cancelling ctx stops without waiting for the
+// blocks already dispatched
+func (p *Processor) Run(ctx context.Context) (Summary, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// channel to signal work completion from dispatcher
+	done := make(chan struct{})
+	dbCloseChan := make(chan error)
+	d, stopProgress, err := p.start(ctx, done, dbCloseChan)
+	if err != nil {
+		return Summary{}, err
+	}
+	start := time.Now()
+
+	var runErr error
+	select {
+	case <-done:
+		p.logger.Info("Dispatcher finished")
+	case <-p.stopChan:
+		d.Shutdown()
+		runErr = ErrStopped
+	case err := <-p.errQueue.Out:
+		p.logger.Warn("Received fatal error: ", err)
+		p.logger.Warn("Canceling block dispatcher and stopping workers")
+		cancel()
+		runErr = err
+	case <-ctx.Done():
+		runErr = ctx.Err()
+	}
+	stopProgress()
+	atomic.StoreInt32(&p.scanning, 0)
+	p.logger.Debug("Waiting for all workers to exit")
+	if p.waitShutdown(d.Wait) {
+		p.logger.Info("All workers stopped. Waiting for DB to finish")
+		close(p.resultChan)
+	} else {
+		// workers still sending results can't have the channel closed under
+		// them, the DB drains what was sent and closes on cancelation instead
+		p.logger.Warn("Timed out waiting for workers, canceling them")
+		cancel()
+	}
+	unfinished := d.UnfinishedBlocks()
+	if len(unfinished) > 0 {
+		p.logger.WithFields(logrus.Fields{
+			"blocks": len(unfinished),
+			"ranges": strings.Join(cache.FormatRanges(cache.CollapseRanges(unfinished), maxUnfinishedRanges), ", "),
+		}).Warn("Blocks left unfinished, the next run fetches them again")
+	}
+	var closeErr error
+	if !p.waitShutdown(func() { closeErr = <-dbCloseChan }) {
+		p.logger.Error("Error waiting for DB to close")
+		if runErr == nil {
+			runErr = errCloseTimeout
+		}
+	} else if closeErr != nil {
+		p.logger.Error("Error closing DB: ", closeErr)
+		if runErr == nil {
+			runErr = closeErr
+		}
+	}
+	cancel()
+	if err := p.blockCache.Save(); err != nil {
+		p.logger.Error("Error saving the pending blocks: ", err)
+	}
+
+	summary := Summary{
+		SuccessBlocks:    p.resultSink.GetRecords(),
+		ScannedBlocks:    d.GetDispatchedBlocks(),
+		DroppedErrors:    p.errQueue.Dropped(),
+		UnfinishedBlocks: unfinished,
+		Duration:         time.Since(start).Truncate(time.Second),
+	}
+	p.logger.WithFields(logrus.Fields{
+		"workers":             p.workers,
+		" successBlocks":      summary.SuccessBlocks,
+		" totalScannedBlocks": summary.ScannedBlocks,
+		" duration":           summary.Duration,
+		" droppedErrors":      summary.DroppedErrors,
+	}).Info()
+	if p.latencyTracker != nil {
+		report := p.latencyTracker.Evaluate(p.sloPercentile, p.sloLatency)
+		summary.Latency = &report
+		sloLogger := p.logger.WithFields(logrus.Fields{
+			"percentile": report.Percentile,
+			"target":     report.Target,
+			"actual":     report.Actual,
+			"blocks":     report.Blocks,
+		})
+		if report.Met {
+			sloLogger.Info("Latency objective met")
+		} else {
+			sloLogger.Warn("Latency objective missed")
+		}
+	}
+	if p.benchmark != nil {
+		// the run idles from the last block completed until it ends
+		duration := time.Unix(0, atomic.LoadInt64(&p.lastCompletion)).Sub(start)
+		if duration <= 0 {
+			duration = time.Since(start)
+		}
+		report := p.benchmark.report(p.pool.Stats(), d.GetCompletedBlocks(), duration)
+		summary.Benchmark = &report
+		p.logBenchmark(report)
+	}
+	return summary, runErr
+}
+
+// waitShutdown waits for wait to return for up to the shutdown timeout,
+// reporting whether it did
+func (p *Processor) waitShutdown(wait func()) bool {
+	finished := make(chan struct{})
+	go func() {
+		wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return true
+	case <-time.After(p.shutdownTimeout):
+	}
+	return false
+}
+
+// start starts scanning the chain once elected leader, returning the
+// dispatcher and what stops the progress reports
+func (p *Processor) start(ctx context.Context, done chan struct{}, dbCloseChan chan error) (blockDispatcher, context.CancelFunc, error) {
+	errChan := p.errQueue.In
+	if p.leaderLockKey != 0 {
+		leader := p.qdb.NewLeader(p.leaderLockKey, p.leaderLockInterval)
+		p.logger.Info("Standing by until elected leader")
+		// stopping a standby gives up on the lock
+		acquireCtx, cancelAcquire := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-p.stopChan:
+				cancelAcquire()
+			case <-acquireCtx.Done():
+			}
+		}()
+		err := leader.Acquire(acquireCtx)
+		cancelAcquire()
+		select {
+		case <-p.stopChan:
+			return nil, nil, ErrStopped
+		default:
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		go func() {
+			// the lock lapsing means a standby may take over, stop writing
+			if err := leader.Hold(ctx); err == db.ErrLeadershipLost {
+				errChan <- err
+			}
+		}()
+	}
+	if p.retryFailed {
+		requeued, err := p.qdb.RequeueFailedBlocks(ctx, p.chainId)
+		if err != nil {
+			return nil, nil, err
+		}
+		p.logger.Infof("Requeued %d failed blocks", requeued)
+	} else if p.checkpoint != nil && p.to == 0 {
+		// failed blocks below the checkpoint would never be reached again
+		resumed, err := p.resumeCheckpoint(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.resume && !resumed {
+			p.logger.Warn("No checkpoint to resume from, scanning the whole range")
+		}
+	}
+	p.resultSink.Start(ctx, p.chainId, dbCloseChan)
+
+	// channel to pass blocks to workers, holding a full batch or range for
+	// every worker so requests aren't cut short by an empty queue
+	queuedBlocks := p.batchSize
+	for _, provider := range p.providers {
+		if provider.RangeMethod != "" && p.rangeSize > queuedBlocks {
+			queuedBlocks = p.rangeSize
+		}
+	}
+	// sized for the most workers there may be
+	maxWorkers := p.workers
+	if p.maxWorkers > maxWorkers {
+		maxWorkers = p.maxWorkers
+	}
+	blockChan := make(chan int64, maxWorkers*queuedBlocks)
+	completedBlockChan := make(chan int64, maxWorkers)
+	if p.registerer != nil {
+		p.registerer.MustRegister(
+			metrics.QueueDepth("blocks", func() int { return len(blockChan) }),
+			metrics.QueueDepth("results", func() int { return len(p.resultChan) }),
+		)
+	}
+
+	blockCacheLogger := p.logger.WithField("module", "blockCache")
+
+	// a bounded range has no head to follow
+	var headFollower *eth.HeadFollower
+	newHeads := make(chan int64, 1)
+	headsURL := p.newHeadsURL
+	if headsURL == "" {
+		headsURL = websocketProvider(p.providers)
+	}
+	if headsURL != "" && p.from == 0 {
+		headFollower = eth.NewHeadFollower(p.logger.WithField("module", "eth"), headsURL, p.newHeadsInterval, func(ctx context.Context) (int64, error) {
+			return eth.GetLatestBlock(ctx, blockCacheLogger, p.pool)
+		})
+		go headFollower.Run(ctx, newHeads)
+	}
+
+	blockCache := cache.NewBlockCache(
+		ctx,
+		func(ctx context.Context) ([]int64, error) {
+			var latestBlock int64
+			var err error
+			if headFollower != nil {
+				latestBlock = headFollower.Latest()
+			}
+			if latestBlock == 0 {
+				latestBlock, err = eth.GetLatestBlock(ctx, blockCacheLogger, p.pool)
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			firstBlock, lastBlock := cache.ScanBounds(p.from, p.to, latestBlock)
+			// only the query is retried here, the rpc client retries on its own
+			missingBlocks, err := cache.RetryGetMissingBlocks(blockCacheLogger, p.loaderRetries, p.loaderRetryBackoff, func(ctx context.Context) ([]int64, error) {
+				return p.resultSink.GetMissingBlocks(ctx, p.chainId, firstBlock, lastBlock)
+			})(ctx)
+			if err == nil && p.logIndex != nil {
+				missingBlocks, err = p.logIndex.Filter(ctx, firstBlock, lastBlock, missingBlocks)
+			}
+			if err == nil && p.checkpoint != nil {
+				// the database is the source of truth, a block committed while
+				// querying only holds the checkpoint back until the next load
+				p.checkpoint.Reset(firstBlock, lastBlock, missingBlocks)
+			}
+			return missingBlocks, err
+		},
+	)
+
+	// processed blocks drain the backlog
+	go func() {
+		for {
+			select {
+			case block := <-completedBlockChan:
+				blockCache.CompleteBlock(block)
+				atomic.StoreInt64(&p.lastCompletion, time.Now().UnixNano())
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	if p.backlogInterval > 0 {
+		go blockCache.ReportBacklog(ctx, blockCacheLogger, p.backlogInterval)
+	}
+	if p.cacheStore != nil {
+		restored, err := blockCache.Restore(p.cacheStore, p.cacheKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if restored > 0 {
+			blockCacheLogger.Infof("Restored %d pending blocks from the cache store", restored)
+		}
+		go p.saveCache(ctx, blockCacheLogger)
+	}
+	p.blockCache = blockCache
+
+	dispatcherOpts := append([]dispatcher.Option{
+		dispatcher.WithChainIdVerification(int64(p.chainId), p.chainIdInterval),
+		dispatcher.WithLatencyTracker(p.latencyTracker),
+		dispatcher.WithRangeSize(p.rangeSize),
+		dispatcher.WithBatchSize(p.batchSize),
+		dispatcher.WithNewHeads(newHeads),
+		dispatcher.WithRefetch(p.reorgChan),
+		dispatcher.WithProviderPool(p.pool),
+		dispatcher.WithAutoscale(p.minWorkers, p.maxWorkers, p.autoscaleInterval),
+		dispatcher.WithLogFields(p.logFields),
+	}, p.dispatcherOpts...)
+	d := dispatcher.NewDispatcher(
+		blockChan,
+		p.resultChan,
+		completedBlockChan,
+		p.providers,
+		p.from,
+		p.to,
+		done,
+		errChan,
+		blockCache,
+		dispatcherOpts...,
+	)
+	d.Start(ctx, p.workers, p.providers, p.follow)
+	// the lag is exported by the metrics even without a threshold
+	if p.tipLagThreshold > 0 || p.registerer != nil {
+		tipLagLogger := p.logger.WithField("module", "tipLag")
+		go eth.NewTipLagMonitor(
+			tipLagLogger,
+			p.tipLagInterval,
+			p.tipLagThreshold,
+			func(ctx context.Context) (int64, error) {
+				return eth.GetLatestBlock(ctx, tipLagLogger, p.pool)
+			},
+			p.resultSink.GetHighestBlock,
+		).Run(ctx)
+	}
+
+	atomic.StoreInt64(&p.lastCompletion, time.Now().UnixNano())
+	atomic.StoreInt32(&p.scanning, 1)
+
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	if p.progressInterval > 0 {
+		go p.reportProgress(progressCtx, d, blockCache)
+	}
+	if p.benchmarking {
+		p.benchmark = newBenchmark(p.pool.Stats())
+		go p.benchmark.run(progressCtx, d)
+	}
+	return d, stopProgress, nil
+}
+
+// saveCache saves the pending blocks every cacheSaveInterval until ctx is
+// cancelled
+func (p *Processor) saveCache(ctx context.Context, logger *logrus.Entry) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cacheSaveInterval):
+		}
+		if err := p.blockCache.Save(); err != nil {
+			logger.Warn("Error saving the pending blocks: ", err)
+		}
+	}
+}
+
+// resumeCheckpoint continues scanning from the saved contiguous checkpoint,
+// as the effective oldest block, instead of rescanning every block from
+// block 1, reporting whether there was one to resume from. Checkpoints not
+// counting from block 1 don't say all blocks below them are committed and
+// aren't resumed from. The blocks outstanding above the checkpoint, up to
+// the high-water mark, are still missing from the database and found again
+func (p *Processor) resumeCheckpoint(ctx context.Context) (bool, error) {
+	var checkpoints db.Store = p.qdb
+	if p.store != nil {
+		checkpoints = p.store
+	}
+	saved, err := checkpoints.GetCheckpoint(ctx, p.chainId)
+	if err != nil || saved == nil || saved.FirstBlock != 1 || saved.Contiguous < 1 {
+		return false, err
+	}
+	resumeFrom := saved.Contiguous + 1
+	if p.from != 0 && resumeFrom > p.from {
+		resumeFrom = p.from
+	}
+	p.logger.WithFields(logrus.Fields{
+		"contiguous": saved.Contiguous,
+		"highWater":  saved.HighWater,
+		"updatedAt":  saved.UpdatedAt,
+	}).Infof("Resuming from checkpoint, scanning down to block %d", resumeFrom)
+	p.to = resumeFrom
+	p.checkpoint.Resume(saved.FirstBlock)
+	return true, nil
+}
+
+// websocketProvider returns the url of the first websocket provider, whose
+// new heads are subscribed to without a new heads url, empty when none is
+func websocketProvider(providers []*jsonrpc.Provider) string {
+	for _, provider := range providers {
+		if jsonrpc.IsWebsocketURL(provider.URL.String()) {
+			return provider.URL.String()
+		}
+	}
+	return ""
+}
+
+// reportProgress logs the progress through the blocks missing at the last
+// cache update every interval until ctx is cancelled, estimating the time
+// remaining from the rate blocks were completed at over the last interval,
+// and keeps it for Progress. Nothing is reported for scans finishing within
+// the first interval
+func (p *Processor) reportProgress(ctx context.Context, blocks blockDispatcher, blockCache *cache.BlockCache) {
+	ticker := time.NewTicker(p.progressInterval)
+	defer ticker.Stop()
+	lastCompleted := blocks.GetCompletedBlocks()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		completed := blocks.GetCompletedBlocks()
+		rate := float64(completed-lastCompleted) / p.progressInterval.Seconds()
+		lastCompleted = completed
+		progress := Progress{
+			Completed:  completed,
+			Dispatched: blocks.GetDispatchedBlocks(),
+			Stored:     p.resultSink.GetRecords(),
+			Total:      blockCache.GetWorkingSet(),
+			Remaining:  blockCache.GetBacklog(),
+			Rate:       rate,
+			Providers:  providerProgress(p.pool.Stats()),
+			UpdatedAt:  time.Now(),
+		}
+		fields := logrus.Fields{
+			"completed":  progress.Completed,
+			"dispatched": progress.Dispatched,
+			"stored":     progress.Stored,
+			"total":      progress.Total,
+			"remaining":  progress.Remaining,
+			"rate":       rate,
+		}
+		if progress.Total > 0 {
+			progress.Percent = float64(progress.Total-progress.Remaining) * 100 / float64(progress.Total)
+			fields["percent"] = fmt.Sprintf("%.1f", progress.Percent)
+		}
+		if rate > 0 {
+			eta := time.Duration(float64(progress.Remaining) / rate * float64(time.Second)).Round(time.Second)
+			progress.ETA = eta.String()
+			fields["eta"] = eta
+		}
+		p.setProgress(progress)
+		p.logger.WithFields(fields).Info("Scan progress (rate in blocks/s)")
+		if len(p.providers) > 1 {
+			for _, provider := range progress.Providers {
+				p.logger.WithFields(logrus.Fields{
+					"provider":  provider.Name,
+					"share":     fmt.Sprintf("%.2f", provider.Share),
+					"latency":   provider.Latency,
+					"errorRate": fmt.Sprintf("%.2f", provider.ErrorRate),
+					"healthy":   provider.Healthy,
+				}).Info("Provider health")
+			}
+		}
+	}
+}
+
+// logBenchmark logs the rates of a benchmark, overall, at each number of
+// workers and of each provider
+func (p *Processor) logBenchmark(report Benchmark) {
+	p.logger.WithFields(logrus.Fields{
+		"blocks":         report.Blocks,
+		"duration":       report.Duration.Truncate(time.Millisecond),
+		"rate":           fmt.Sprintf("%.2f", report.Rate),
+		"optimalWorkers": report.OptimalWorkers,
+	}).Info("Benchmark (rate in blocks/s)")
+	for _, rate := range report.Workers {
+		p.logger.WithFields(logrus.Fields{
+			"workers":  rate.Workers,
+			"duration": rate.Duration.Truncate(time.Millisecond),
+			"blocks":   rate.Blocks,
+			"rate":     fmt.Sprintf("%.2f", rate.Rate),
+		}).Info("Benchmark workers (rate in blocks/s)")
+	}
+	for _, provider := range report.Providers {
+		p.logger.WithFields(logrus.Fields{
+			"provider":  provider.Name,
+			"calls":     provider.Calls,
+			"failed":    provider.Failed,
+			"callRate":  fmt.Sprintf("%.2f", provider.CallRate),
+			"errorRate": fmt.Sprintf("%.3f", provider.ErrorRate),
+			"latency":   provider.Latency,
+		}).Info("Benchmark provider (rate in calls/s)")
+	}
+}
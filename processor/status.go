@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// Progress is the progress of a scan as of its last report
+type Progress struct {
+	ChainId    int   `json:"chainId"`
+	Completed  int64 `json:"completed"`
+	Dispatched int64 `json:"dispatched"`
+	Stored     int64 `json:"stored"`
+	// missing blocks at the last reload, and those not completed yet
+	Total     int `json:"total"`
+	Remaining int `json:"remaining"`
+	// blocks completed per second over the last interval
+	Rate    float64 `json:"rate"`
+	Percent float64 `json:"percent"`
+	// estimated time remaining at the rate, empty while nothing completes
+	ETA       string             `json:"eta,omitempty"`
+	Providers []ProviderProgress `json:"providers"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+// ProviderProgress is the health of a provider and its share of the calls
+// answered
+type ProviderProgress struct {
+	Name      string  `json:"name"`
+	Share     float64 `json:"share"`
+	Answered  int64   `json:"answered"`
+	Latency   string  `json:"latency"`
+	ErrorRate float64 `json:"errorRate"`
+	Healthy   bool    `json:"healthy"`
+}
+
+// Progress returns the progress of the scan as of its last report, only the
+// chain id is set before the first one
+func (p *Processor) Progress() Progress {
+	p.progressMutex.Lock()
+	defer p.progressMutex.Unlock()
+
+	progress := p.progress
+	progress.ChainId = p.chainId
+	return progress
+}
+
+func (p *Processor) setProgress(progress Progress) {
+	p.progressMutex.Lock()
+	defer p.progressMutex.Unlock()
+
+	p.progress = progress
+}
+
+// providerProgress returns the health of the providers and their shares of
+// the calls answered
+func providerProgress(stats []jsonrpc.ProviderStats) []ProviderProgress {
+	var answered int64
+	for _, s := range stats {
+		answered += s.Answered
+	}
+	providers := make([]ProviderProgress, len(stats))
+	for i, s := range stats {
+		providers[i] = ProviderProgress{
+			Name:      s.Name,
+			Answered:  s.Answered,
+			Latency:   s.Latency.Round(time.Millisecond).String(),
+			ErrorRate: s.ErrorRate,
+			Healthy:   s.Healthy,
+		}
+		if answered > 0 {
+			providers[i].Share = float64(s.Answered) / float64(answered)
+		}
+	}
+	return providers
+}
+
+// StatusHandler answers GET requests with the progress of every processor,
+// as a json list
+func StatusHandler(processors ...*Processor) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		progress := make([]Progress, len(processors))
+		for i, p := range processors {
+			progress[i] = p.Progress()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(progress)
+	})
+}
@@ -0,0 +1,50 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/sink"
+)
+
+func TestStatusHandler(t *testing.T) {
+	p, err := New(
+		WithChain(4444, syntheticProviders(t)),
+		WithResultHandler(func(ctx context.Context, records []sink.Record) error { return nil }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.setProgress(Progress{Completed: 10, Total: 40, Remaining: 30, Rate: 2, Percent: 25, ETA: (15 * time.Second).String()})
+
+	recorder := httptest.NewRecorder()
+	StatusHandler(p).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/status", nil))
+	var progress []Progress
+	if err = json.NewDecoder(recorder.Body).Decode(&progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 1 || progress[0].ChainId != 4444 || progress[0].Completed != 10 || progress[0].ETA != "15s" {
+		t.Errorf("got %+v, want the progress of chain 4444", progress)
+	}
+
+	recorder = httptest.NewRecorder()
+	StatusHandler(p).ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/status", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("got status %d, want %d", recorder.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestProviderProgress(t *testing.T) {
+	providers := providerProgress([]jsonrpc.ProviderStats{
+		{Name: "a", Answered: 30, Healthy: true},
+		{Name: "b", Answered: 10},
+	})
+	if providers[0].Share != 0.75 || providers[1].Share != 0.25 {
+		t.Errorf("got %+v, want shares of 0.75 and 0.25", providers)
+	}
+}
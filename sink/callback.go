@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// Handler is handed the records of every batch of results written, an error
+// stops the sink
+type Handler func(ctx context.Context, records []Record) error
+
+func (h Handler) Write(ctx context.Context, records []Record) error {
+	return h(ctx, records)
+}
+
+func (h Handler) Close() error {
+	return nil
+}
+
+// NewCallback returns a sink handing the results to handler, for programs
+// consuming them as they're produced rather than from a destination
+func NewCallback(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, handler Handler) Sink {
+	return newStream(logger, resultChan, handler)
+}
@@ -0,0 +1,26 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// discard is a writer dropping every record
+type discard struct{}
+
+func (discard) Write(ctx context.Context, records []Record) error {
+	return nil
+}
+
+func (discard) Close() error {
+	return nil
+}
+
+// NewDiscard returns a sink dropping the results of resultChan, for dry runs
+// benchmarking the providers. The blocks dropped are still counted and no
+// longer missing
+func NewDiscard(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair) Sink {
+	return newStream(logger, resultChan, discard{})
+}
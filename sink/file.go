@@ -0,0 +1,181 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// Format is how a file or stdout sink encodes records
+type Format string
+
+const (
+	// NDJSON writes a json record per line
+	NDJSON Format = "ndjson"
+	// CSV writes BlockNum,Eth,Htmlcoin rows like the export command, blocks
+	// skipped as not on the chain have no row
+	CSV Format = "csv"
+)
+
+// csvHeader is the header of csv files, the one exports have
+var csvHeader = []string{"BlockNum", "Eth", "Htmlcoin"}
+
+// encoder writes records in a format to a buffered output
+type encoder struct {
+	format Format
+	output *bufio.Writer
+	csv    *csv.Writer
+	json   *json.Encoder
+	// closes the output, nil for stdout
+	closer io.Closer
+}
+
+func newEncoder(format Format, output io.Writer, closer io.Closer) (*encoder, error) {
+	buffered := bufio.NewWriter(output)
+	e := &encoder{format: format, output: buffered, closer: closer}
+	switch format {
+	case NDJSON:
+		e.json = json.NewEncoder(buffered)
+	case CSV:
+		e.csv = csv.NewWriter(buffered)
+	default:
+		return nil, fmt.Errorf("unknown sink format '%s'", format)
+	}
+	return e, nil
+}
+
+func (e *encoder) Write(ctx context.Context, records []Record) error {
+	for _, record := range records {
+		var err error
+		switch {
+		case e.json != nil:
+			err = e.json.Encode(record)
+		case !record.Skipped:
+			err = e.csv.Write([]string{strconv.Itoa(record.BlockNumber), record.EthHash, record.HtmlcoinHash})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if e.csv != nil {
+		e.csv.Flush()
+		if err := e.csv.Error(); err != nil {
+			return err
+		}
+	}
+	return e.output.Flush()
+}
+
+func (e *encoder) Close() error {
+	err := e.output.Flush()
+	if e.closer != nil {
+		if closeErr := e.closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewFile returns a sink appending the results of resultChan to the file at
+// path in format. The blocks a file already has aren't missing, so an
+// interrupted run can be continued into the same file. A partial last line
+// left by a crash is dropped
+func NewFile(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, path string, format Format) (Sink, error) {
+	written, err := readWritten(logger, path, format)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	out, err := newEncoder(format, file, file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if format == CSV && len(written) == 0 {
+		if info, err := file.Stat(); err == nil && info.Size() == 0 {
+			out.csv.Write(csvHeader)
+		}
+	}
+	stream := newStream(logger, resultChan, out)
+	for block := range written {
+		stream.remember(block)
+	}
+	return stream, nil
+}
+
+// NewStdout returns a sink writing the results of resultChan to stdout in
+// format, logs must then be written elsewhere
+func NewStdout(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, format Format) (Sink, error) {
+	out, err := newEncoder(format, os.Stdout, nil)
+	if err != nil {
+		return nil, err
+	}
+	if format == CSV {
+		out.csv.Write(csvHeader)
+	}
+	return newStream(logger, resultChan, out), nil
+}
+
+// readWritten returns the blocks the file at path has, truncating a partial
+// last line
+func readWritten(logger *logrus.Entry, path string, format Format) (map[int64]bool, error) {
+	written := make(map[int64]bool)
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return written, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if complete := bytes.LastIndexByte(content, '\n') + 1; complete < len(content) {
+		logger.WithField("bytes", len(content)-complete).Warn("Dropping the partial last line of ", path)
+		if err = os.Truncate(path, int64(complete)); err != nil {
+			return nil, err
+		}
+		content = content[:complete]
+	}
+
+	switch format {
+	case NDJSON:
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		for {
+			var record Record
+			if err = decoder.Decode(&record); err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid record in %s: %w", path, err)
+			}
+			written[int64(record.BlockNumber)] = true
+		}
+	case CSV:
+		rows, err := csv.NewReader(bytes.NewReader(content)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv file %s: %w", path, err)
+		}
+		for i, row := range rows {
+			if i == 0 && row[0] == csvHeader[0] {
+				continue
+			}
+			block, err := strconv.ParseInt(row[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid block number in %s: %w", path, err)
+			}
+			written[block] = true
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,89 @@
+package sink
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+)
+
+func TestFile(t *testing.T) {
+	logger, _ := log.GetLogger()
+	write := func(t *testing.T, path string, format Format, pairs ...jsonrpc.HashPair) Sink {
+		t.Helper()
+		resultChan := make(chan jsonrpc.HashPair, len(pairs))
+		s, err := NewFile(logger.WithField("module", "test"), resultChan, path, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closeChan := make(chan error)
+		s.Start(context.Background(), 4444, closeChan)
+		for _, pair := range pairs {
+			resultChan <- pair
+		}
+		close(resultChan)
+		if err := <-closeChan; err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	pair := func(block int) jsonrpc.HashPair {
+		return jsonrpc.HashPair{BlockNumber: block, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin"}
+	}
+
+	t.Run("ndjson records are appended and not missing on reopening", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hashes.ndjson")
+		write(t, path, NDJSON, pair(1), jsonrpc.HashPair{BlockNumber: 2, Skipped: true})
+		s := write(t, path, NDJSON, pair(4))
+
+		content, _ := ioutil.ReadFile(path)
+		want := `{"blockNumber":1,"chainId":4444,"ethHash":"0xeth","htmlcoinHash":"0xhtmlcoin"}
+{"blockNumber":2,"chainId":4444,"skipped":true}
+{"blockNumber":4,"chainId":4444,"ethHash":"0xeth","htmlcoinHash":"0xhtmlcoin"}
+`
+		if string(content) != want {
+			t.Errorf("got %s, want %s", content, want)
+		}
+		missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 5)
+		if !reflect.DeepEqual(missing, []int64{3, 5}) {
+			t.Errorf("got missing blocks %v, want [3 5]", missing)
+		}
+	})
+
+	t.Run("csv rows have the header and columns of exports", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hashes.csv")
+		write(t, path, CSV, pair(1), jsonrpc.HashPair{BlockNumber: 2, Skipped: true})
+		s := write(t, path, CSV, pair(3))
+
+		content, _ := ioutil.ReadFile(path)
+		if want := "BlockNum,Eth,Htmlcoin\n1,0xeth,0xhtmlcoin\n3,0xeth,0xhtmlcoin\n"; string(content) != want {
+			t.Errorf("got %q, want %q", content, want)
+		}
+		// skipped blocks have no row, reopened files fetch them again
+		missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 3)
+		if !reflect.DeepEqual(missing, []int64{2}) {
+			t.Errorf("got missing blocks %v, want [2]", missing)
+		}
+	})
+
+	t.Run("partial last line is dropped", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "hashes.ndjson")
+		ioutil.WriteFile(path, []byte(`{"blockNumber":1,"chainId":4444}`+"\n"+`{"blockNum`), 0644)
+		s := write(t, path, NDJSON, pair(2))
+
+		content, _ := ioutil.ReadFile(path)
+		want := `{"blockNumber":1,"chainId":4444}
+{"blockNumber":2,"chainId":4444,"ethHash":"0xeth","htmlcoinHash":"0xhtmlcoin"}
+`
+		if string(content) != want {
+			t.Errorf("got %s, want %s", content, want)
+		}
+		if missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 2); len(missing) != 0 {
+			t.Errorf("got missing blocks %v, want none", missing)
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+// kafkaWriter produces records to a kafka topic
+type kafkaWriter struct {
+	writer *kafka.Writer
+}
+
+// NewKafka returns a sink producing the results of resultChan to topic as
+// json records keyed by block number, so the records of a block land on a
+// single partition. Every record is acknowledged by all in-sync replicas
+// before it counts as written
+func NewKafka(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, brokers []string, topic string) Sink {
+	writer := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		BatchSize:    maxBatch,
+		// records are handed over in batches already
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	return newStream(logger, resultChan, &kafkaWriter{writer: writer})
+}
+
+func (w *kafkaWriter) Write(ctx context.Context, records []Record) error {
+	messages, err := kafkaMessages(records)
+	if err != nil {
+		return err
+	}
+	return w.writer.WriteMessages(ctx, messages...)
+}
+
+func (w *kafkaWriter) Close() error {
+	return w.writer.Close()
+}
+
+func kafkaMessages(records []Record) ([]kafka.Message, error) {
+	messages := make([]kafka.Message, len(records))
+	for i, record := range records {
+		value, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = kafka.Message{Key: []byte(strconv.Itoa(record.BlockNumber)), Value: value}
+	}
+	return messages, nil
+}
@@ -0,0 +1,20 @@
+package sink
+
+import (
+	"testing"
+)
+
+func TestKafkaMessages(t *testing.T) {
+	t.Run("records are keyed by block number", func(t *testing.T) {
+		messages, err := kafkaMessages([]Record{{BlockNumber: 7, ChainId: 4444, EthHash: "0xeth", HtmlcoinHash: "0xhtmlcoin"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(messages) != 1 || string(messages[0].Key) != "7" {
+			t.Fatalf("got %+v, want a message keyed 7", messages)
+		}
+		if want := `{"blockNumber":7,"chainId":4444,"ethHash":"0xeth","htmlcoinHash":"0xhtmlcoin"}`; string(messages[0].Value) != want {
+			t.Errorf("got %s, want %s", messages[0].Value, want)
+		}
+	})
+}
@@ -0,0 +1,175 @@
+// Package sink writes the hash pairs produced by workers to destinations
+// other than postgres: ndjson or csv files, stdout, kafka and the sqlite or
+// mysql stores of package db
+package sink
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// maxBatch is the most results written to a destination at once
+const maxBatch = 500
+
+// Sink consumes the results workers send on the result channel it was given
+// until the channel is closed, then sends the error it stopped on, nil once
+// every result is written, on closeChan. Blocks already written are no
+// longer missing. db.HtmlcoinDB is the postgres sink
+type Sink interface {
+	Start(ctx context.Context, chainId int, closeChan chan error)
+	GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error)
+	GetRecords() int64
+	GetHighestBlock() int64
+}
+
+// Record is a hash pair as the sinks of this package write it
+type Record struct {
+	BlockNumber  int    `json:"blockNumber"`
+	ChainId      int    `json:"chainId"`
+	EthHash      string `json:"ethHash,omitempty"`
+	HtmlcoinHash string `json:"htmlcoinHash,omitempty"`
+	// the block number doesn't exist on the chain, it has no hashes
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// writer writes records to a destination
+type writer interface {
+	Write(ctx context.Context, records []Record) error
+	Close() error
+}
+
+// stream is a sink writing the results to a writer in batches of the results
+// waiting on the channel. Blocks written are remembered for the missing
+// blocks, those a file already had when it was opened included
+type stream struct {
+	logger     *logrus.Entry
+	resultChan <-chan jsonrpc.HashPair
+	out        writer
+
+	mutex   sync.Mutex
+	written map[int64]bool
+	highest int64
+	records int64 // accessed atomically
+}
+
+func newStream(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, out writer) *stream {
+	return &stream{
+		logger:     logger,
+		resultChan: resultChan,
+		out:        out,
+		written:    make(map[int64]bool),
+	}
+}
+
+func (s *stream) Start(ctx context.Context, chainId int, closeChan chan error) {
+	go func() {
+		var err error
+	results:
+		for err == nil {
+			var batch []jsonrpc.HashPair
+			select {
+			case pair, ok := <-s.resultChan:
+				if !ok {
+					break results
+				}
+				batch = append(batch, pair)
+			case <-ctx.Done():
+				break results
+			}
+			err = s.write(ctx, chainId, s.drain(batch))
+		}
+		if err != nil {
+			s.logger.Error("error writing results: ", err)
+		}
+		if closeErr := s.out.Close(); err == nil {
+			err = closeErr
+		}
+		closeChan <- err
+	}()
+}
+
+// drain adds the results waiting on the channel to batch, up to maxBatch
+func (s *stream) drain(batch []jsonrpc.HashPair) []jsonrpc.HashPair {
+	for len(batch) < maxBatch {
+		select {
+		case pair, ok := <-s.resultChan:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, pair)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// write writes the records of batch, dead-lettered blocks are only logged
+func (s *stream) write(ctx context.Context, chainId int, batch []jsonrpc.HashPair) error {
+	records := make([]Record, 0, len(batch))
+	for _, pair := range batch {
+		if pair.Failure != nil {
+			s.logger.WithFields(logrus.Fields{
+				"block":    pair.BlockNumber,
+				"attempts": pair.Failure.Attempts,
+			}).Warn("Block failed every attempt: ", pair.Failure.Error)
+			continue
+		}
+		records = append(records, Record{
+			BlockNumber:  pair.BlockNumber,
+			ChainId:      chainId,
+			EthHash:      pair.EthHash,
+			HtmlcoinHash: pair.HtmlcoinHash,
+			Skipped:      pair.Skipped,
+		})
+	}
+	if len(records) > 0 {
+		if err := s.out.Write(ctx, records); err != nil {
+			return err
+		}
+	}
+	for _, pair := range batch {
+		s.remember(int64(pair.BlockNumber))
+	}
+	atomic.AddInt64(&s.records, int64(len(records)))
+	return nil
+}
+
+func (s *stream) remember(block int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.written[block] = true
+	if block > s.highest {
+		s.highest = block
+	}
+}
+
+// GetMissingBlocks returns the blocks between firstBlock and latestBlock
+// (inclusive) that haven't been written, in ascending order
+func (s *stream) GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var missing []int64
+	for block := firstBlock; block <= latestBlock; block++ {
+		if !s.written[block] {
+			missing = append(missing, block)
+		}
+	}
+	return missing, nil
+}
+
+// GetRecords returns how many records were written
+func (s *stream) GetRecords() int64 {
+	return atomic.LoadInt64(&s.records)
+}
+
+// GetHighestBlock returns the highest block written
+func (s *stream) GetHighestBlock() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.highest
+}
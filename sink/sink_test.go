@@ -0,0 +1,111 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+)
+
+// the postgres database is a sink too
+var _ Sink = (*db.HtmlcoinDB)(nil)
+
+// fakeWriter records what it's given, failing with err
+type fakeWriter struct {
+	records []Record
+	err     error
+	closed  bool
+}
+
+func (w *fakeWriter) Write(ctx context.Context, records []Record) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.records = append(w.records, records...)
+	return nil
+}
+
+func (w *fakeWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	logger, _ := log.GetLogger()
+
+	t.Run("results are written until the channel closes", func(t *testing.T) {
+		resultChan := make(chan jsonrpc.HashPair, 4)
+		out := &fakeWriter{}
+		s := newStream(logger.WithField("module", "test"), resultChan, out)
+		closeChan := make(chan error)
+		s.Start(context.Background(), 4444, closeChan)
+
+		resultChan <- jsonrpc.HashPair{BlockNumber: 3, EthHash: "0xeth3", HtmlcoinHash: "0xhtmlcoin3"}
+		resultChan <- jsonrpc.HashPair{BlockNumber: 5, Skipped: true}
+		resultChan <- jsonrpc.HashPair{BlockNumber: 4, Failure: &jsonrpc.BlockFailure{Error: "block not found", Attempts: 3}}
+		close(resultChan)
+		if err := <-closeChan; err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Record{
+			{BlockNumber: 3, ChainId: 4444, EthHash: "0xeth3", HtmlcoinHash: "0xhtmlcoin3"},
+			{BlockNumber: 5, ChainId: 4444, Skipped: true},
+		}
+		if !reflect.DeepEqual(out.records, want) {
+			t.Errorf("got %+v, want %+v", out.records, want)
+		}
+		if !out.closed {
+			t.Error("writer wasn't closed")
+		}
+		if records, highest := s.GetRecords(), s.GetHighestBlock(); records != 2 || highest != 5 {
+			t.Errorf("got %d records up to block %d, want 2 up to block 5", records, highest)
+		}
+		// the dead-lettered block isn't refetched by this run either
+		missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 6)
+		if !reflect.DeepEqual(missing, []int64{1, 2, 6}) {
+			t.Errorf("got missing blocks %v, want [1 2 6]", missing)
+		}
+	})
+
+	t.Run("write errors stop the sink", func(t *testing.T) {
+		resultChan := make(chan jsonrpc.HashPair, 1)
+		failure := errors.New("disk full")
+		s := newStream(logger.WithField("module", "test"), resultChan, &fakeWriter{err: failure})
+		closeChan := make(chan error)
+		s.Start(context.Background(), 4444, closeChan)
+
+		resultChan <- jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth1", HtmlcoinHash: "0xhtmlcoin1"}
+		if err := <-closeChan; err != failure {
+			t.Errorf("got %v, want %v", err, failure)
+		}
+		if missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 1); len(missing) != 1 {
+			t.Error("got the failed write remembered as written")
+		}
+	})
+}
+
+func TestDiscard(t *testing.T) {
+	logger, _ := log.GetLogger()
+	resultChan := make(chan jsonrpc.HashPair, 2)
+	s := NewDiscard(logger.WithField("module", "test"), resultChan)
+	closeChan := make(chan error)
+	s.Start(context.Background(), 4444, closeChan)
+
+	resultChan <- jsonrpc.HashPair{BlockNumber: 1, EthHash: "0xeth1", HtmlcoinHash: "0xhtmlcoin1"}
+	resultChan <- jsonrpc.HashPair{BlockNumber: 2, EthHash: "0xeth2", HtmlcoinHash: "0xhtmlcoin2"}
+	close(resultChan)
+	if err := <-closeChan; err != nil {
+		t.Fatal(err)
+	}
+	if records := s.GetRecords(); records != 2 {
+		t.Errorf("got %d records, want the 2 dropped counted", records)
+	}
+	if missing, _ := s.GetMissingBlocks(context.Background(), 4444, 1, 3); !reflect.DeepEqual(missing, []int64{3}) {
+		t.Errorf("got missing blocks %v, want [3]", missing)
+	}
+}
@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/denuoweb/ethereum-block-processor/cache"
+	"github.com/denuoweb/ethereum-block-processor/db"
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// storeWriter saves the records to a store, advancing the checkpoint of
+// the scan when given one and saving it every checkpointEvery records
+type storeWriter struct {
+	logger          *logrus.Entry
+	store           db.Store
+	checkpoint      *cache.Checkpoint
+	checkpointEvery int
+	chainId         int
+	uncheckpointed  int
+}
+
+func (w *storeWriter) Write(ctx context.Context, records []Record) error {
+	pairs := make([]jsonrpc.HashPair, len(records))
+	for i, record := range records {
+		pairs[i] = jsonrpc.HashPair{
+			BlockNumber:  record.BlockNumber,
+			EthHash:      record.EthHash,
+			HtmlcoinHash: record.HtmlcoinHash,
+			Skipped:      record.Skipped,
+		}
+	}
+	w.chainId = records[0].ChainId
+	if err := w.store.SaveHashPairs(ctx, w.chainId, pairs); err != nil {
+		return err
+	}
+	if w.checkpoint == nil {
+		return nil
+	}
+	for _, record := range records {
+		w.checkpoint.Commit(int64(record.BlockNumber))
+	}
+	w.uncheckpointed += len(records)
+	if w.uncheckpointed >= w.checkpointEvery {
+		w.saveCheckpoint(ctx)
+	}
+	return nil
+}
+
+// saveCheckpoint saves the checkpoint if records advanced it since it was
+// last saved, a failure only delays it to the next save
+func (w *storeWriter) saveCheckpoint(ctx context.Context) {
+	if w.checkpoint == nil || w.uncheckpointed == 0 {
+		return
+	}
+	contiguous, highWater := w.checkpoint.Get()
+	if err := w.store.SaveCheckpoint(ctx, w.chainId, w.checkpoint.FirstBlock(), contiguous, highWater); err != nil {
+		w.logger.WithError(err).Warn("Failed to save checkpoint")
+		return
+	}
+	w.uncheckpointed = 0
+}
+
+func (w *storeWriter) Close() error {
+	w.saveCheckpoint(context.Background())
+	return w.store.Close()
+}
+
+// storeSink is a stream saving to a store, whose missing blocks are those
+// the store lacks
+type storeSink struct {
+	*stream
+	store db.Store
+}
+
+// NewStore returns a sink saving the results to store, closed once the
+// results are written. With a checkpoint, it's advanced by the blocks saved
+// and saved to the store every checkpointEvery blocks and when closed
+func NewStore(logger *logrus.Entry, resultChan <-chan jsonrpc.HashPair, store db.Store, checkpoint *cache.Checkpoint, checkpointEvery int) Sink {
+	out := &storeWriter{logger: logger, store: store, checkpoint: checkpoint, checkpointEvery: checkpointEvery}
+	return &storeSink{stream: newStream(logger, resultChan, out), store: store}
+}
+
+func (s *storeSink) GetMissingBlocks(ctx context.Context, chainId int, firstBlock, latestBlock int64) ([]int64, error) {
+	return s.store.GetMissingBlocks(ctx, chainId, firstBlock, latestBlock)
+}
@@ -0,0 +1,97 @@
+package slo
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// Tracker measures the latency of every block from its dispatch to the
+// commit of its hashes. A nil Tracker tracks nothing
+type Tracker struct {
+	mutex      sync.Mutex
+	dispatched map[int64]time.Time
+	latencies  []time.Duration
+	now        func() time.Time
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		dispatched: make(map[int64]time.Time),
+		now:        time.Now,
+	}
+}
+
+// Dispatched records block being dispatched. A block dispatched again, as
+// when it's retried, keeps its first dispatch time so retries count against
+// its latency
+func (t *Tracker) Dispatched(block int64) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, ok := t.dispatched[block]; !ok {
+		t.dispatched[block] = t.now()
+	}
+}
+
+// Committed records block's hashes being committed
+func (t *Tracker) Committed(block int64) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	dispatched, ok := t.dispatched[block]
+	if !ok {
+		return
+	}
+	delete(t.dispatched, block)
+	latency := t.now().Sub(dispatched)
+	t.latencies = append(t.latencies, latency)
+	metrics.BlockCommitLatency.Observe(latency.Seconds())
+}
+
+// Report is the outcome of a latency objective such as 95% of blocks
+// committed within 2s
+type Report struct {
+	Percentile float64
+	Target     time.Duration
+	// latency of the percentile of blocks
+	Actual time.Duration
+	Blocks int
+	Met    bool
+}
+
+// Evaluate reports whether percentile of the committed blocks were
+// committed within target, using the nearest rank percentile. The
+// objective is met when no block was committed
+func (t *Tracker) Evaluate(percentile float64, target time.Duration) Report {
+	t.mutex.Lock()
+	latencies := make([]time.Duration, len(t.latencies))
+	copy(latencies, t.latencies)
+	t.mutex.Unlock()
+
+	report := Report{Percentile: percentile, Target: target, Blocks: len(latencies), Met: true}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		rank := int(math.Ceil(percentile / 100 * float64(len(latencies))))
+		if rank < 1 {
+			rank = 1
+		}
+		report.Actual = latencies[rank-1]
+		report.Met = report.Actual <= target
+	}
+
+	metrics.LatencySLOActual.Set(report.Actual.Seconds())
+	if report.Met {
+		metrics.LatencySLOMet.Set(1)
+	} else {
+		metrics.LatencySLOMet.Set(0)
+	}
+	return report
+}
@@ -0,0 +1,75 @@
+package slo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestTracker returns a tracker committing block i latencies[i] after
+// its dispatch
+func newTestTracker(latencies []time.Duration) *Tracker {
+	tracker := NewTracker()
+	now := time.Unix(0, 0)
+	tracker.now = func() time.Time { return now }
+	for i := range latencies {
+		tracker.Dispatched(int64(i))
+	}
+	for i, latency := range latencies {
+		now = time.Unix(0, 0).Add(latency)
+		tracker.Committed(int64(i))
+	}
+	return tracker
+}
+
+func TestEvaluate(t *testing.T) {
+	// 19 blocks within a second and one slow block
+	latencies := make([]time.Duration, 20)
+	for i := range latencies {
+		latencies[i] = time.Duration(i+1) * 50 * time.Millisecond
+	}
+	latencies[19] = 5 * time.Second
+	tracker := newTestTracker(latencies)
+
+	t.Run("objective is met when the percentile is within target", func(t *testing.T) {
+		report := tracker.Evaluate(95, 2*time.Second)
+		if !report.Met || report.Actual != 950*time.Millisecond || report.Blocks != 20 {
+			t.Errorf("got %+v, want met with a 950ms p95 over 20 blocks", report)
+		}
+		if testutil.ToFloat64(metrics.LatencySLOMet) != 1 {
+			t.Error("got the objective reported as missed")
+		}
+	})
+
+	t.Run("objective is missed when the percentile exceeds target", func(t *testing.T) {
+		report := tracker.Evaluate(100, 2*time.Second)
+		if report.Met || report.Actual != 5*time.Second {
+			t.Errorf("got %+v, want missed with a 5s p100", report)
+		}
+		if testutil.ToFloat64(metrics.LatencySLOMet) != 0 {
+			t.Error("got the objective reported as met")
+		}
+	})
+
+	t.Run("retries count against the first dispatch", func(t *testing.T) {
+		tracker := NewTracker()
+		now := time.Unix(0, 0)
+		tracker.now = func() time.Time { return now }
+		tracker.Dispatched(1)
+		now = now.Add(3 * time.Second)
+		tracker.Dispatched(1)
+		now = now.Add(time.Second)
+		tracker.Committed(1)
+		if report := tracker.Evaluate(95, 2*time.Second); report.Actual != 4*time.Second {
+			t.Errorf("got %v latency, want 4s", report.Actual)
+		}
+	})
+
+	t.Run("nil tracker tracks nothing", func(t *testing.T) {
+		var tracker *Tracker
+		tracker.Dispatched(1)
+		tracker.Committed(1)
+	})
+}
@@ -0,0 +1,54 @@
+package workers
+
+import "time"
+
+const (
+	// provider latency over which workers are shed, as a multiple of the
+	// lowest latency observed
+	autoscaleLatencyFactor = 2
+	// provider error rate over which workers are shed
+	autoscaleErrorRate = 0.1
+)
+
+// Autoscaler sizes a worker pool between Min and Max workers: it grows by a
+// quarter while blocks are queued up for the workers, shrinks by a quarter
+// while the providers slow down or fail calls, and by a worker while
+// there's nothing to process
+type Autoscaler struct {
+	Min int
+	Max int
+	// lowest provider latency observed, the latency of unsaturated providers
+	baseline time.Duration
+}
+
+// Next returns the number of workers to run given the number running,
+// whether blocks are queued up for them and whether any remain to be
+// processed, and the provider latency and error rate observed. A latency of
+// 0 is unknown
+func (a *Autoscaler) Next(workers int, queued, backlog bool, latency time.Duration, errorRate float64) int {
+	if latency > 0 && (a.baseline == 0 || latency < a.baseline) {
+		a.baseline = latency
+	}
+
+	next := workers
+	step := workers / 4
+	if step < 1 {
+		step = 1
+	}
+	switch {
+	case errorRate > autoscaleErrorRate || (latency > 0 && latency > autoscaleLatencyFactor*a.baseline):
+		next -= step
+	case queued:
+		next += step
+	case !backlog:
+		next--
+	}
+
+	if next < a.Min {
+		next = a.Min
+	}
+	if next > a.Max {
+		next = a.Max
+	}
+	return next
+}
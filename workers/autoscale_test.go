@@ -0,0 +1,96 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestAutoscaler(t *testing.T) {
+	t.Run("workers grow while blocks are queued, within the maximum", func(t *testing.T) {
+		a := &Autoscaler{Min: 1, Max: 10}
+		workers := 2
+		for _, want := range []int{3, 4, 5, 6, 7, 8, 10, 10} {
+			if workers = a.Next(workers, true, true, 50*time.Millisecond, 0); workers != want {
+				t.Fatalf("got %d workers, want %d", workers, want)
+			}
+		}
+	})
+
+	t.Run("workers shrink while the latency doubles or calls fail, within the minimum", func(t *testing.T) {
+		a := &Autoscaler{Min: 2, Max: 16}
+		if workers := a.Next(8, true, true, 50*time.Millisecond, 0); workers != 10 {
+			t.Fatalf("got %d workers, want 10", workers)
+		}
+		if workers := a.Next(10, true, true, 150*time.Millisecond, 0); workers != 8 {
+			t.Errorf("got %d workers, want 8 as the latency tripled", workers)
+		}
+		if workers := a.Next(8, true, true, 50*time.Millisecond, 0.5); workers != 6 {
+			t.Errorf("got %d workers, want 6 as calls fail", workers)
+		}
+		if workers := a.Next(2, true, true, 50*time.Millisecond, 0.5); workers != 2 {
+			t.Errorf("got %d workers, want the minimum kept", workers)
+		}
+	})
+
+	t.Run("workers shrink one at a time with nothing to process", func(t *testing.T) {
+		a := &Autoscaler{Min: 1, Max: 16}
+		if workers := a.Next(8, false, false, 0, 0); workers != 7 {
+			t.Errorf("got %d workers, want 7", workers)
+		}
+		if workers := a.Next(8, false, true, 0, 0); workers != 8 {
+			t.Errorf("got %d workers, want 8 kept while blocks are being processed", workers)
+		}
+	})
+}
+
+func TestScale(t *testing.T) {
+	provider, err := jsonrpc.ParseProvider("synthetic://?latency=1ms&head=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	blockChan := make(chan int64, 100)
+	resultChan := make(chan jsonrpc.HashPair, 100)
+	completedBlockChan := make(chan int64, 100)
+	wg := sync.WaitGroup{}
+	workers := StartWorkers(ctx, 2, blockChan, make(chan int64), completedBlockChan, resultChan, []*jsonrpc.Provider{provider}, &wg, make(chan error, 1))
+
+	if size := workers.Scale(6); size != 6 || workers.Size() != 6 {
+		t.Fatalf("got %d workers, want 6", size)
+	}
+	if size := workers.Scale(3); size != 3 || workers.Size() != 3 {
+		t.Fatalf("got %d workers, want 3", size)
+	}
+
+	t.Run("blocks are processed by the workers left", func(t *testing.T) {
+		for i := int64(1); i <= 50; i++ {
+			blockChan <- i
+		}
+		for i := 0; i < 50; i++ {
+			select {
+			case <-completedBlockChan:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("got %d of 50 blocks", i)
+			}
+		}
+	})
+
+	t.Run("retired workers exit", func(t *testing.T) {
+		close(blockChan)
+		exited := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(exited)
+		}()
+		select {
+		case <-exited:
+		case <-time.After(5 * time.Second):
+			t.Fatal("workers didn't exit")
+		}
+	})
+}
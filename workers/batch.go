@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// batchEnabled reports whether the worker fetches queued blocks in batches
+func (w *worker) batchEnabled() bool {
+	return w.state.batchSize > 1
+}
+
+// handleBatch fetches blockNumber along with the blocks queued right behind
+// it on the block channel, up to the batch size, in a single batch request.
+// It returns false when the block channel closed meanwhile
+func (w *worker) handleBatch(ctx context.Context, blockNumber int64) bool {
+	return w.handleQueued(ctx, blockNumber, w.state.batchSize, func([]int64, int64) bool { return true }, w.fetchBatch)
+}
+
+// fetchBatch fetches blocks in a single batch request, failing those the
+// provider answered with an error so they're retried
+func (w *worker) fetchBatch(ctx context.Context, blocks []int64) {
+	batcher, ok := w.rpcClient.(BatchClient)
+	if len(blocks) == 1 || !ok {
+		for _, blockNumber := range blocks {
+			w.handleBlock(ctx, blockNumber)
+		}
+		return
+	}
+	logger := w.logger.WithField("blocks", len(blocks))
+	logger.Debug("Processing batch of blocks")
+	start := time.Now()
+	w.beat(blocks[0])
+
+	requests := make([]jsonrpc.Request, len(blocks))
+	for i, blockNumber := range blocks {
+		requests[i] = jsonrpc.Request{Method: "eth_getBlockByNumber", Params: []interface{}{fmt.Sprintf("0x%x", blockNumber), true}}
+	}
+	responses, err := batcher.CallBatch(ctx, requests)
+	w.beat(idle)
+	if ctx.Err() != nil {
+		// the block in flight is requeued if the worker was replaced as
+		// stuck, the rest of the batch must be retried
+		for _, blockNumber := range blocks[1:] {
+			w.state.fails.updateFailedBlocks(blockNumber)
+		}
+		return
+	}
+	if err != nil {
+		logger.Error("RPC client batch call error: ", err)
+		for _, blockNumber := range blocks {
+			w.fail(blockNumber, err)
+		}
+		return
+	}
+	if responses[0].Retries > 0 {
+		logger.WithField("retries", responses[0].Retries).Info("Batch fetched after retrying")
+	}
+
+	for i, blockNumber := range blocks {
+		w.totalBlocks++
+		if responses[i].Error != nil {
+			logger.WithField("Blocknumber", blockNumber).Error("rpc response error: ", responses[i].Error)
+			w.fail(blockNumber, responses[i].Error)
+			continue
+		}
+		w.processBlock(ctx, blockNumber, responses[i], start)
+	}
+}
@@ -0,0 +1,78 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// batchClient answers batches of eth_getBlockByNumber, failing block 5
+type batchClient struct {
+	rangeClient
+	batches [][]string
+}
+
+func (c *batchClient) CallBatch(ctx context.Context, requests []jsonrpc.Request) ([]*jsonrpc.JSONRPCResponse, error) {
+	var batch []string
+	var responses []*jsonrpc.JSONRPCResponse
+	for _, request := range requests {
+		batch = append(batch, request.Params[0].(string))
+		if request.Params[0] == "0x5" {
+			responses = append(responses, &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Error: &jsonrpc.JSONRPCError{Code: -32000, Message: "header not found"}})
+			continue
+		}
+		rpcResponse, err := c.Call(ctx, request.Method, request.Params...)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, rpcResponse)
+	}
+	c.batches = append(c.batches, batch)
+	return responses, nil
+}
+
+func TestBatchRequests(t *testing.T) {
+	client := &batchClient{}
+	state := NewWorkers()
+	state.batchSize = 4
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return client
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 10)
+	processedBlockChan := make(chan int64, 10)
+	provider, _ := jsonrpc.ParseProvider("batched=http://127.0.0.1:8545")
+	wg := sync.WaitGroup{}
+	w := state.newWorker(ctx, 1, blockChan, failedBlocksChan, processedBlockChan, resultChan, provider, &wg, errChan)
+
+	for _, block := range []int64{20, 5, 3, 9, 11} {
+		blockChan <- block
+	}
+	// blocks queued past the batch size are left for the next batch
+	if !w.handle(ctx, 7, true) || !w.handle(ctx, <-blockChan, true) {
+		t.Fatal("worker quit")
+	}
+
+	want := "[[0x7 0x14 0x5 0x3] [0x9 0xb]]"
+	if fmt.Sprint(client.batches) != want {
+		t.Errorf("got batches %v, want %v", client.batches, want)
+	}
+	var blocks []int
+	for len(resultChan) > 0 {
+		blocks = append(blocks, (<-resultChan).BlockNumber)
+	}
+	sort.Ints(blocks)
+	if fmt.Sprint(blocks) != "[3 7 9 11 20]" {
+		t.Errorf("got results for blocks %v, want [3 7 9 11 20]", blocks)
+	}
+	if failed := state.GetFailedBlocks(); fmt.Sprint(failed) != "[5]" {
+		t.Errorf("got failed blocks %v, want [5]", failed)
+	}
+}
@@ -0,0 +1,62 @@
+package workers
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// blockData returns the header and the transactions of the block, which
+// must be given in full
+func (block *decodedBlock) blockData() (*jsonrpc.BlockData, error) {
+	data := &jsonrpc.BlockData{
+		Timestamp:    int64(block.ethBlock.Time),
+		Miner:        block.htmlcoinBlock.Miner,
+		GasUsed:      int64(block.ethBlock.GasUsed),
+		ParentHash:   block.htmlcoinBlock.ParentHash,
+		Transactions: make([]jsonrpc.TransactionData, len(block.htmlcoinBlock.Transactions)),
+	}
+	for i, tx := range block.htmlcoinBlock.Transactions {
+		fields, ok := tx.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("transaction %d of block %s isn't given in full", i, block.htmlcoinBlock.Number)
+		}
+		field := func(name string) string {
+			value, _ := fields[name].(string)
+			return value
+		}
+		transaction := jsonrpc.TransactionData{Hash: field("hash"), From: field("from"), To: field("to"), Input: field("input"), Index: int64(i)}
+		if transaction.Hash == "" {
+			return nil, fmt.Errorf("transaction %d of block %s has no hash", i, block.htmlcoinBlock.Number)
+		}
+		var err error
+		if index := field("transactionIndex"); index != "" {
+			if transaction.Index, err = jsonrpc.ParseQuantity(index, jsonrpc.NumberAuto); err != nil {
+				return nil, fmt.Errorf("invalid index of transaction %s: %w", transaction.Hash, err)
+			}
+		}
+		if transaction.Gas, err = jsonrpc.ParseQuantity(field("gas"), jsonrpc.NumberAuto); err != nil {
+			return nil, fmt.Errorf("invalid gas of transaction %s: %w", transaction.Hash, err)
+		}
+		if transaction.Value, err = weiValue(field("value")); err != nil {
+			return nil, fmt.Errorf("invalid value of transaction %s: %w", transaction.Hash, err)
+		}
+		data.Transactions[i] = transaction
+	}
+	return data, nil
+}
+
+// weiValue returns the hex quantity value as a decimal number, values
+// overflow int64 so they're kept as big integers
+func weiValue(value string) (string, error) {
+	if value == "" || value == "0x" {
+		return "0", nil
+	}
+	wei, ok := new(big.Int).SetString(strings.TrimPrefix(value, "0x"), 16)
+	if !ok || !strings.HasPrefix(value, "0x") {
+		return "", fmt.Errorf("invalid quantity %q", value)
+	}
+	return wei.String(), nil
+}
@@ -0,0 +1,46 @@
+package workers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestBlockData(t *testing.T) {
+	var response jsonrpc.JSONRPCResponse
+	if err := json.Unmarshal(mockJsonRPCResponse, &response); err != nil {
+		t.Fatal(err)
+	}
+	block, err := decodeBlock(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("header and transactions are taken from the block", func(t *testing.T) {
+		data, err := block.blockData()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.Timestamp != 0x60d751c4 || data.ParentHash != "0x07d98f4c28cf29a7f60c960ef0d3d836a84b73e6488c32074fa7e0ca0ba8bce4" || len(data.Transactions) != 3 {
+			t.Fatalf("got %+v, want the header of block 0xf4245 and its 3 transactions", data)
+		}
+		tx := data.Transactions[1]
+		// the value overflows an int64
+		if tx.Hash != "0xe14ecd01d5b4a323b55d464ce9efaeaf3d30477d076dd82db6018d39b9f55614" || tx.Index != 1 || tx.From != "0x9e3d8ccc7d59db008d736de6c125323309ebdbc2" || tx.Value != "156696819000000000000" {
+			t.Errorf("got transaction %+v", tx)
+		}
+		if !strings.HasPrefix(tx.Input, "0x02000000019da13cd4") {
+			t.Errorf("got input %q, want the transaction's", tx.Input)
+		}
+	})
+
+	t.Run("blocks of transaction hashes are rejected", func(t *testing.T) {
+		hashesOnly := *block
+		hashesOnly.htmlcoinBlock.Transactions = []interface{}{"0xa"}
+		if _, err := hashesOnly.blockData(); err == nil {
+			t.Error("got no error, want the transactions to be required in full")
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+// getChainId returns the chain id provider currently serves
+func getChainId(ctx context.Context, provider *jsonrpc.Provider) (int64, error) {
+	rpcClient := jsonrpc.NewProviderClient(provider, 0)
+	rpcClient.SetNullResult(jsonrpc.NullResultError)
+	var chainId string
+	if err := rpcClient.CallResult(ctx, &chainId, "eth_chainId"); err != nil {
+		return 0, err
+	}
+	return jsonrpc.ParseQuantity(chainId, jsonrpc.NumberAuto)
+}
+
+func (workers *Workers) isQuarantined(provider *jsonrpc.Provider) bool {
+	workers.mutex.Lock()
+	defer workers.mutex.Unlock()
+	return workers.quarantined[provider]
+}
+
+// quarantine cancels the workers of provider so it serves no more blocks,
+// returning the blocks they were fetching and the number of workers left
+func (workers *Workers) quarantine(provider *jsonrpc.Provider) ([]int64, int) {
+	workers.mutex.Lock()
+	workers.quarantined[provider] = true
+	var quarantined []*worker
+	alive := make([]*worker, 0, len(workers.workers))
+	for _, w := range workers.workers {
+		if w.provider == provider {
+			quarantined = append(quarantined, w)
+		} else {
+			alive = append(alive, w)
+		}
+	}
+	workers.workers = alive
+	workers.mutex.Unlock()
+
+	var blocks []int64
+	for _, w := range quarantined {
+		w.cancel()
+		if block := atomic.LoadInt64(&w.inFlight); block != idle {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks, len(alive)
+}
+
+// providers returns the providers of the running workers
+func (workers *Workers) providers() []*jsonrpc.Provider {
+	workers.mutex.Lock()
+	defer workers.mutex.Unlock()
+	seen := make(map[*jsonrpc.Provider]bool)
+	var providers []*jsonrpc.Provider
+	for _, w := range workers.workers {
+		if !seen[w.provider] {
+			seen[w.provider] = true
+			providers = append(providers, w.provider)
+		}
+	}
+	return providers
+}
+
+// MonitorChainIds verifies every interval, starting right away, that each
+// provider still serves chainId until ctx is cancelled. A provider whose
+// chain id changed, such as a gateway switching backends, is quarantined:
+// its workers are cancelled and the blocks they were fetching re-enqueued
+// on requeueChan. Once every provider is quarantined an error is sent on
+// errChan as no block can be processed anymore
+func (workers *Workers) MonitorChainIds(ctx context.Context, interval time.Duration, chainId int64, requeueChan chan<- int64, errChan chan<- error) {
+	monitorLogger, _ := log.GetLogger()
+	logger := monitorLogger.WithFields(workers.logFields).WithField("module", "chainIdMonitor")
+	for {
+		for _, provider := range workers.providers() {
+			providerChainId, err := workers.getChainId(ctx, provider)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				logger.WithField("endpoint", provider.Name()).Warn("Failed verifying chain id: ", err)
+				continue
+			}
+			if providerChainId == chainId {
+				continue
+			}
+
+			blocks, remaining := workers.quarantine(provider)
+			metrics.ProviderQuarantines.WithLabelValues(provider.Name()).Inc()
+			logger.WithFields(logrus.Fields{
+				"endpoint":         provider.Name(),
+				"chainId":          chainId,
+				"providerChainId":  providerChainId,
+				"remainingWorkers": remaining,
+			}).Error("PROVIDER CHAIN ID CHANGED, provider quarantined")
+			for _, block := range blocks {
+				select {
+				case requeueChan <- block:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if remaining == 0 {
+				select {
+				case errChan <- fmt.Errorf("all providers quarantined, provider %s switched to chain id %d", provider.Name(), providerChainId):
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestChainIdQuarantine(t *testing.T) {
+	switching, _ := jsonrpc.ParseProvider("switching=http://127.0.0.1:8545")
+	steady, _ := jsonrpc.ParseProvider("steady=http://127.0.0.1:8546")
+
+	// switching serves chain 4444 on its first check then another chain
+	var switchingChecks int32
+	state := NewWorkers()
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return &staleClient{}
+	}
+	state.getChainId = func(ctx context.Context, provider *jsonrpc.Provider) (int64, error) {
+		if provider == switching && atomic.AddInt32(&switchingChecks, 1) > 1 {
+			return 1, nil
+		}
+		return 4444, nil
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 1)
+	processedBlockChan := make(chan int64, 10)
+	wg := sync.WaitGroup{}
+
+	var switchingWorkers []*worker
+	for i, provider := range []*jsonrpc.Provider{switching, steady, switching} {
+		w := state.newWorker(ctx, i, blockChan, failedBlocksChan, processedBlockChan, resultChan, provider, &wg, errChan)
+		if provider == switching {
+			switchingWorkers = append(switchingWorkers, w)
+		}
+		wg.Add(1)
+		go w.Start()
+	}
+	go state.MonitorChainIds(ctx, 50*time.Millisecond, 4444, failedBlocksChan, errChan)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !state.isQuarantined(switching) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Run("provider switching chains is quarantined", func(t *testing.T) {
+		if !state.isQuarantined(switching) {
+			t.Fatal("switching provider wasn't quarantined")
+		}
+		for _, w := range switchingWorkers {
+			if w.ctx.Err() == nil {
+				t.Errorf("worker %d of the quarantined provider wasn't cancelled", w.id)
+			}
+		}
+	})
+
+	t.Run("steady provider keeps serving blocks", func(t *testing.T) {
+		if state.isQuarantined(steady) {
+			t.Error("steady provider was quarantined")
+		}
+		if providers := state.providers(); len(providers) != 1 || providers[0] != steady {
+			t.Errorf("got providers %v, want only the steady provider", providers)
+		}
+		blockChan <- 7
+		select {
+		case got := <-resultChan:
+			if got.BlockNumber != 7 {
+				t.Errorf("got block %d, want 7", got.BlockNumber)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("block wasn't processed by the steady provider")
+		}
+	})
+}
+
+func TestAllProvidersQuarantined(t *testing.T) {
+	provider, _ := jsonrpc.ParseProvider("switched=http://127.0.0.1:8545")
+	state := NewWorkers()
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return &staleClient{}
+	}
+	state.getChainId = func(ctx context.Context, provider *jsonrpc.Provider) (int64, error) {
+		return 1, nil
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 1)
+	wg := sync.WaitGroup{}
+	w := state.newWorker(ctx, 0, blockChan, failedBlocksChan, make(chan int64, 1), resultChan, provider, &wg, errChan)
+	wg.Add(1)
+	go w.Start()
+	go state.MonitorChainIds(ctx, time.Minute, 4444, failedBlocksChan, errChan)
+
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Error("got a nil error, want all providers quarantined")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("quarantining every provider wasn't reported")
+	}
+}
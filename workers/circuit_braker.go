@@ -19,6 +19,11 @@ type CBClient interface {
 	GetState() string
 }
 
+// BatchClient makes json rpc batches in single requests
+type BatchClient interface {
+	CallBatch(ctx context.Context, requests []jsonrpc.Request) ([]*jsonrpc.JSONRPCResponse, error)
+}
+
 type ClientCircuitBreakerProxy struct {
 	client CBClient
 	logger *logrus.Entry
@@ -99,6 +104,31 @@ func (c *ClientCircuitBreakerProxy) Call(ctx context.Context, method string, par
 	return resp, err
 }
 
+// CallBatch makes requests as a batch through the circuit breaker, one at a
+// time when the client can't make batches. Only a batch failing as a whole
+// counts as a failure, entries carrying error objects don't
+func (c *ClientCircuitBreakerProxy) CallBatch(ctx context.Context, requests []jsonrpc.Request) ([]*jsonrpc.JSONRPCResponse, error) {
+	result, err := c.gb.Execute(func() (interface{}, error) {
+		if batcher, ok := c.client.(BatchClient); ok {
+			return batcher.CallBatch(ctx, requests)
+		}
+		responses := make([]*jsonrpc.JSONRPCResponse, len(requests))
+		for i, request := range requests {
+			resp, err := c.client.Call(ctx, request.Method, request.Params...)
+			if err != nil {
+				return nil, err
+			}
+			responses[i] = resp
+		}
+		return responses, nil
+	})
+	if err != nil {
+		c.logger.Warnf("Returning error %s", err)
+		return nil, err
+	}
+	return result.([]*jsonrpc.JSONRPCResponse), nil
+}
+
 func (c *ClientCircuitBreakerProxy) GetState() string {
 	return c.gb.State().String()
 }
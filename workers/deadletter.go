@@ -0,0 +1,40 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// clearAttempts forgets the failed attempts of a block that succeeded
+func (r *results) clearAttempts(blockNumber int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.attempts, blockNumber)
+}
+
+// fail records a failed attempt at blockNumber, dead-lettering the block
+// once it has failed deadLetterAttempts times so the scan carries on without
+// it. Dead-lettering more than maxFailures blocks aborts the run
+func (w *worker) fail(blockNumber int64, err error) {
+	attempts := w.state.fails.updateFailedBlocks(blockNumber)
+	if w.state.deadLetterAttempts == 0 || attempts < w.state.deadLetterAttempts {
+		return
+	}
+
+	w.state.fails.mu.Lock()
+	delete(w.state.fails.attempts, blockNumber)
+	w.state.fails.deadLettered++
+	deadLettered := w.state.fails.deadLettered
+	w.state.fails.mu.Unlock()
+
+	w.logger.WithField("attempts", attempts).Error("block failed every attempt, dead-lettering it")
+	metrics.BlocksDeadLettered.Inc()
+	w.beat(idle)
+	w.resultChan <- jsonrpc.HashPair{BlockNumber: int(blockNumber), Failure: &jsonrpc.BlockFailure{Error: err.Error(), Attempts: attempts}}
+	w.processedBlockChan <- blockNumber
+	if w.state.maxFailures > 0 && deadLettered == w.state.maxFailures+1 {
+		w.erroChan <- fmt.Errorf("%d blocks dead-lettered, more than the maximum of %d", deadLettered, w.state.maxFailures)
+	}
+}
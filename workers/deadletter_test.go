@@ -0,0 +1,67 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestDeadLetters(t *testing.T) {
+	state := NewWorkers()
+	state.deadLetterAttempts = 2
+	state.maxFailures = 1
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return &notFoundClient{}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	processedBlockChan := make(chan int64, 10)
+	provider, _ := jsonrpc.ParseProvider("failing=http://127.0.0.1:8545")
+	wg := sync.WaitGroup{}
+	w := state.newWorker(ctx, 1, blockChan, make(chan int64), processedBlockChan, resultChan, provider, &wg, errChan)
+
+	t.Run("block is retried until it failed every attempt", func(t *testing.T) {
+		w.handleBlock(ctx, 7)
+		select {
+		case pair := <-resultChan:
+			t.Fatalf("got block %d dead-lettered after a single attempt", pair.BlockNumber)
+		default:
+		}
+	})
+
+	t.Run("block that failed every attempt is dead-lettered", func(t *testing.T) {
+		w.handleBlock(ctx, 7)
+		select {
+		case pair := <-resultChan:
+			if pair.BlockNumber != 7 || pair.Failure == nil || pair.Failure.Attempts != 2 || pair.Failure.Error != jsonrpc.ErrNotFound.Error() {
+				t.Errorf("got %+v, want block 7 dead-lettered after 2 attempts", pair)
+			}
+		default:
+			t.Fatal("block wasn't dead-lettered")
+		}
+		if got := <-processedBlockChan; got != 7 {
+			t.Errorf("got block %d completed, want 7", got)
+		}
+		select {
+		case err := <-errChan:
+			t.Fatalf("got %v, want the run to carry on", err)
+		default:
+		}
+	})
+
+	t.Run("run is aborted past the maximum of dead-lettered blocks", func(t *testing.T) {
+		w.handleBlock(ctx, 8)
+		w.handleBlock(ctx, 8)
+		<-resultChan
+		<-processedBlockChan
+		select {
+		case <-errChan:
+		default:
+			t.Fatal("run wasn't aborted")
+		}
+	})
+}
@@ -0,0 +1,115 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// decodedBlock is a fetched block in both its htmlcoin and ethereum forms
+type decodedBlock struct {
+	htmlcoinBlock jsonrpc.GetBlockByNumberResponse
+	ethBlock      jsonrpc.EthBlockHeader
+}
+
+// decodeBlock decodes an eth_getBlockByNumber response. A null block
+// hasn't been mined yet and is reported as jsonrpc.ErrNotFound, it must not
+// be stored as an empty block
+func decodeBlock(rpcResponse *jsonrpc.JSONRPCResponse) (*decodedBlock, error) {
+	var block decodedBlock
+	err := jsonrpc.DecodeResult(rpcResponse, &block.htmlcoinBlock, jsonrpc.NullResultNotFound)
+	if err == jsonrpc.ErrNotFound {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not convert result to htmlcoin.GetBlockByNumberResponse: %w", err)
+	}
+	err = jsonrpc.GetBlockFromRPCResponse(rpcResponse, &block.ethBlock)
+	if err != nil {
+		return nil, fmt.Errorf("could not convert result to htmlcoin.EthBlockHeader: %w", err)
+	}
+	return &block, nil
+}
+
+// validateNumber checks the block is the requested one
+func (block *decodedBlock) validateNumber(requested int64) error {
+	number, err := jsonrpc.ParseQuantity(block.htmlcoinBlock.Number, jsonrpc.NumberAuto)
+	if err != nil {
+		return fmt.Errorf("invalid block number %q: %w", block.htmlcoinBlock.Number, err)
+	}
+	if number != requested {
+		return fmt.Errorf("requested block %d but got block %d", requested, number)
+	}
+	return nil
+}
+
+// stats returns the block's size and gasUsed/gasLimit ratio, each nil when
+// it can't be determined, as some providers omit the size
+func (block *decodedBlock) stats() (size *int64, gasUsedRatio *float64) {
+	if block.htmlcoinBlock.Size != "" {
+		if parsed, err := jsonrpc.ParseQuantity(block.htmlcoinBlock.Size, jsonrpc.NumberAuto); err == nil {
+			size = &parsed
+		}
+	}
+	if block.ethBlock.GasLimit > 0 {
+		ratio := float64(block.ethBlock.GasUsed) / float64(block.ethBlock.GasLimit)
+		gasUsedRatio = &ratio
+	}
+	return size, gasUsedRatio
+}
+
+type decodeJob struct {
+	rpcResponse *jsonrpc.JSONRPCResponse
+	result      chan decodeResult
+}
+
+type decodeResult struct {
+	block *decodedBlock
+	err   error
+}
+
+// DecodePool decodes blocks on a fixed number of goroutines, capping how
+// many blocks are decoded at once regardless of how many are fetched
+type DecodePool struct {
+	jobs chan decodeJob
+}
+
+func NewDecodePool(ctx context.Context, size int) *DecodePool {
+	return newDecodePool(ctx, size, decodeBlock)
+}
+
+func newDecodePool(ctx context.Context, size int, decode func(*jsonrpc.JSONRPCResponse) (*decodedBlock, error)) *DecodePool {
+	pool := &DecodePool{jobs: make(chan decodeJob)}
+	for i := 0; i < size; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-pool.jobs:
+					block, err := decode(job.rpcResponse)
+					job.result <- decodeResult{block: block, err: err}
+				}
+			}
+		}()
+	}
+	return pool
+}
+
+// Decode waits for a free decode goroutine to decode rpcResponse
+func (pool *DecodePool) Decode(ctx context.Context, rpcResponse *jsonrpc.JSONRPCResponse) (*decodedBlock, error) {
+	// buffered so the decode goroutine never waits on a caller that gave up
+	job := decodeJob{rpcResponse: rpcResponse, result: make(chan decodeResult, 1)}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case pool.jobs <- job:
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case result := <-job.result:
+		return result.block, result.err
+	}
+}
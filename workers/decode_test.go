@@ -0,0 +1,194 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestDecodePool(t *testing.T) {
+	var response jsonrpc.JSONRPCResponse
+	if err := json.Unmarshal(mockJsonRPCResponse, &response); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("concurrent decodes never exceed the pool size", func(t *testing.T) {
+		const poolSize = 3
+		var decoding, maxDecoding int32
+		decode := func(rpcResponse *jsonrpc.JSONRPCResponse) (*decodedBlock, error) {
+			current := atomic.AddInt32(&decoding, 1)
+			defer atomic.AddInt32(&decoding, -1)
+			for {
+				max := atomic.LoadInt32(&maxDecoding)
+				if current <= max || atomic.CompareAndSwapInt32(&maxDecoding, max, current) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			return decodeBlock(rpcResponse)
+		}
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		pool := newDecodePool(ctx, poolSize, decode)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				block, err := pool.Decode(ctx, &response)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if block.htmlcoinBlock.Hash != want.HtmlcoinHash {
+					t.Errorf("got hash %s, want %s", block.htmlcoinBlock.Hash, want.HtmlcoinHash)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if maxDecoding > poolSize {
+			t.Errorf("got %d concurrent decodes, want at most %d", maxDecoding, poolSize)
+		}
+		if maxDecoding < poolSize {
+			t.Errorf("got %d concurrent decodes, want the pool to be used fully (%d)", maxDecoding, poolSize)
+		}
+	})
+
+	t.Run("decode gives up when the context is cancelled", func(t *testing.T) {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		cancelFunc()
+		pool := newDecodePool(context.Background(), 0, decodeBlock)
+		if _, err := pool.Decode(ctx, &response); err != context.Canceled {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("null blocks are reported as not found", func(t *testing.T) {
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		pool := NewDecodePool(ctx, 1)
+		if _, err := pool.Decode(ctx, &jsonrpc.JSONRPCResponse{}); err != jsonrpc.ErrNotFound {
+			t.Errorf("got %v, want %v", err, jsonrpc.ErrNotFound)
+		}
+	})
+}
+
+// staleClient serves the block lag blocks before the requested one, as a
+// provider serving cached responses would
+type staleClient struct {
+	lag int64
+}
+
+func (c *staleClient) Call(ctx context.Context, method string, params ...interface{}) (*jsonrpc.JSONRPCResponse, error) {
+	requested, err := strconv.ParseInt(params[0].(string), 0, 64)
+	if err != nil {
+		return nil, err
+	}
+	var response jsonrpc.JSONRPCResponse
+	if err = json.Unmarshal(mockJsonRPCResponse, &response); err != nil {
+		return nil, err
+	}
+	response.Result.(map[string]interface{})["number"] = fmt.Sprintf("0x%x", requested-c.lag)
+	return &response, nil
+}
+
+func (c *staleClient) GetState() string {
+	return "UNDEFINED"
+}
+
+func TestBlockNumberValidation(t *testing.T) {
+	state := NewWorkers()
+	state.validateBlockNumber = true
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		if provider.Label == "stale" {
+			return &staleClient{lag: 1}
+		}
+		return &staleClient{}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 1)
+	processedBlockChan := make(chan int64, 10)
+	staleProvider, _ := jsonrpc.ParseProvider("stale=http://127.0.0.1:8545")
+	goodProvider, _ := jsonrpc.ParseProvider("good=http://127.0.0.1:8546")
+	wg := sync.WaitGroup{}
+
+	stale := state.newWorker(ctx, 1, blockChan, failedBlocksChan, processedBlockChan, resultChan, staleProvider, &wg, errChan)
+	stale.handleBlock(ctx, 100)
+
+	t.Run("mismatched block is rejected", func(t *testing.T) {
+		select {
+		case got := <-resultChan:
+			t.Fatalf("got result for block %d from a stale provider", got.BlockNumber)
+		default:
+		}
+		if failed := state.GetFailedBlocks(); len(failed) != 1 || failed[0] != 100 {
+			t.Errorf("got failed blocks %v, want [100]", failed)
+		}
+	})
+
+	t.Run("rejected block is retried against another provider", func(t *testing.T) {
+		good := state.newWorker(ctx, 2, blockChan, failedBlocksChan, processedBlockChan, resultChan, goodProvider, &wg, errChan)
+		for _, block := range state.GetAndResetFailedBlocks() {
+			good.handleBlock(ctx, block)
+		}
+		select {
+		case got := <-resultChan:
+			if got.BlockNumber != 100 {
+				t.Errorf("got block %d, want 100", got.BlockNumber)
+			}
+		default:
+			t.Fatal("retried block wasn't stored")
+		}
+	})
+}
+
+func TestBlockStats(t *testing.T) {
+	decode := func(t *testing.T, fields map[string]interface{}) *decodedBlock {
+		t.Helper()
+		var response jsonrpc.JSONRPCResponse
+		if err := json.Unmarshal(mockJsonRPCResponse, &response); err != nil {
+			t.Fatal(err)
+		}
+		for field, value := range fields {
+			if value == nil {
+				delete(response.Result.(map[string]interface{}), field)
+			} else {
+				response.Result.(map[string]interface{})[field] = value
+			}
+		}
+		block, err := decodeBlock(&response)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	t.Run("size and gasUsed ratio are decoded", func(t *testing.T) {
+		size, ratio := decode(t, map[string]interface{}{"gasUsed": "0x1482"}).stats()
+		if size == nil || *size != 1678 {
+			t.Errorf("got size %v, want 1678", size)
+		}
+		if ratio == nil || *ratio != 0.25 {
+			t.Errorf("got gasUsed ratio %v, want 0.25", ratio)
+		}
+	})
+
+	t.Run("missing size is left unset", func(t *testing.T) {
+		if size, _ := decode(t, map[string]interface{}{"size": nil}).stats(); size != nil {
+			t.Errorf("got size %d, want none", *size)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package workers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inFlight value of a worker that isn't fetching a block
+const idle int64 = -1
+
+// beat records progress, block is the block now being fetched or idle
+func (w *worker) beat(block int64) {
+	atomic.StoreInt64(&w.inFlight, block)
+	atomic.StoreInt64(&w.heartbeat, time.Now().UnixNano())
+}
+
+// stuckBlock returns the block the worker has been fetching without
+// progress for longer than timeout
+func (w *worker) stuckBlock(timeout time.Duration) (int64, bool) {
+	block := atomic.LoadInt64(&w.inFlight)
+	if block == idle {
+		return 0, false
+	}
+	lastBeat := time.Unix(0, atomic.LoadInt64(&w.heartbeat))
+	return block, time.Since(lastBeat) > timeout
+}
+
+// replaceStuckWorkers cancels the workers that made no progress within
+// timeout and starts new workers in their place, returning the blocks
+// the stuck workers were fetching
+func (workers *Workers) replaceStuckWorkers(timeout time.Duration) []int64 {
+	workers.mutex.Lock()
+	var stuck []*worker
+	var blocks []int64
+	alive := make([]*worker, 0, len(workers.workers))
+	for _, w := range workers.workers {
+		if block, ok := w.stuckBlock(timeout); ok {
+			stuck = append(stuck, w)
+			blocks = append(blocks, block)
+		} else {
+			alive = append(alive, w)
+		}
+	}
+	workers.workers = alive
+	workers.mutex.Unlock()
+
+	for i, w := range stuck {
+		w.logger.WithFields(logrus.Fields{
+			"block":   blocks[i],
+			"timeout": timeout,
+		}).Warn("worker made no progress, replacing it")
+		w.cancel()
+		if workers.isQuarantined(w.provider) {
+			continue
+		}
+		replacement := workers.newWorker(
+			w.parentCtx,
+			w.id,
+			w.blockChan,
+			w.failedBlocksChan,
+			w.processedBlockChan,
+			w.resultChan,
+			w.provider,
+			w.wg,
+			w.erroChan,
+		)
+		w.wg.Add(1)
+		go replacement.Start()
+	}
+
+	return blocks
+}
+
+// MonitorHeartbeats replaces workers that made no progress on a block
+// within timeout until ctx is cancelled, re-enqueuing the blocks they were
+// fetching on requeueChan
+func (workers *Workers) MonitorHeartbeats(ctx context.Context, timeout time.Duration, requeueChan chan<- int64) {
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, block := range workers.replaceStuckWorkers(timeout) {
+			select {
+			case requeueChan <- block:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// stallingClient hangs on its first call regardless of the context, as a
+// hung connection that evades the client timeout would
+type stallingClient struct {
+	calls   *int32
+	release chan struct{}
+}
+
+func (c *stallingClient) Call(ctx context.Context, method string, params ...interface{}) (*jsonrpc.JSONRPCResponse, error) {
+	if atomic.AddInt32(c.calls, 1) == 1 {
+		<-c.release
+	}
+	var response jsonrpc.JSONRPCResponse
+	err := json.Unmarshal(mockJsonRPCResponse, &response)
+	return &response, err
+}
+
+func (c *stallingClient) GetState() string {
+	return "UNDEFINED"
+}
+
+func TestStuckWorkerIsReplaced(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	defer close(release)
+
+	state := NewWorkers()
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return &stallingClient{calls: &calls, release: release}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 1)
+	processedBlockChan := make(chan int64, 10)
+	provider, _ := jsonrpc.ParseProvider("http://127.0.0.1:8545")
+	wg := sync.WaitGroup{}
+
+	stalled := state.newWorker(ctx, 1, blockChan, failedBlocksChan, processedBlockChan, resultChan, provider, &wg, errChan)
+	wg.Add(1)
+	go stalled.Start()
+	go state.MonitorHeartbeats(ctx, 200*time.Millisecond, failedBlocksChan)
+
+	blockChan <- 1
+
+	t.Run("stalled worker's block is reprocessed by its replacement", func(t *testing.T) {
+		select {
+		case got := <-resultChan:
+			if got.BlockNumber != 1 {
+				t.Errorf("got block %d, want 1", got.BlockNumber)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("stalled block was never reprocessed")
+		}
+	})
+
+	t.Run("stalled worker is cancelled and replaced", func(t *testing.T) {
+		if stalled.ctx.Err() == nil {
+			t.Error("stalled worker wasn't cancelled")
+		}
+		state.mutex.Lock()
+		defer state.mutex.Unlock()
+		if len(state.workers) != 1 || state.workers[0] == stalled {
+			t.Errorf("got workers %v, want a single replacement worker", state.workers)
+		}
+	})
+
+	t.Run("released stalled worker doesn't report its stale result", func(t *testing.T) {
+		release <- struct{}{}
+		select {
+		case got := <-resultChan:
+			t.Errorf("got stale result for block %d", got.BlockNumber)
+		case <-time.After(500 * time.Millisecond):
+		}
+	})
+}
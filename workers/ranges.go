@@ -0,0 +1,150 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/sirupsen/logrus"
+)
+
+// json rpc error code of calls to methods a provider doesn't have
+const methodNotFound = -32601
+
+// rangeEnabled reports whether the worker coalesces contiguous blocks into
+// range requests, which takes a provider with a range method
+func (w *worker) rangeEnabled() bool {
+	return w.provider.RangeMethod != "" && w.state.rangeSize > 1 && !w.rangeUnsupported
+}
+
+// handleRun fetches blockNumber along with the contiguous blocks queued
+// right behind it on the block channel, up to the range size, in a single
+// range request. It returns false when the block channel closed meanwhile
+func (w *worker) handleRun(ctx context.Context, blockNumber int64) bool {
+	return w.handleQueued(ctx, blockNumber, w.state.rangeSize, extendsRun, w.handleRange)
+}
+
+// handleQueued hands blockNumber to handle along with the blocks queued
+// right behind it on the block channel that accept takes, up to size blocks.
+// It returns false when the block channel closed meanwhile
+func (w *worker) handleQueued(ctx context.Context, blockNumber int64, size int, accept func(run []int64, n int64) bool, handle func(ctx context.Context, run []int64)) bool {
+	run := []int64{blockNumber}
+	var next int64
+	pending, closed := false, false
+collect:
+	for len(run) < size {
+		select {
+		case n, ok := <-w.blockChan:
+			if !ok {
+				closed = true
+				break collect
+			}
+			if !accept(run, n) {
+				// the run ends here, the block is handled on its own
+				next, pending = n, true
+				break collect
+			}
+			run = append(run, n)
+		default:
+			break collect
+		}
+	}
+
+	handle(ctx, run)
+	if pending && !w.handle(ctx, next, true) {
+		return false
+	}
+	if closed {
+		w.handleExit("channel closed... worker quitting")
+		return false
+	}
+	return true
+}
+
+// extendsRun reports whether n continues the run of contiguous blocks, in
+// either direction
+func extendsRun(run []int64, n int64) bool {
+	last := run[len(run)-1]
+	if len(run) == 1 {
+		return n == last+1 || n == last-1
+	}
+	return n == last+(run[1]-run[0])
+}
+
+// handleRange fetches a run of contiguous blocks with the provider's range
+// method, falling back to fetching blocks one at a time when it fails or
+// leaves blocks out
+func (w *worker) handleRange(ctx context.Context, run []int64) {
+	if len(run) == 1 {
+		w.handleBlock(ctx, run[0])
+		return
+	}
+	first, last := run[0], run[len(run)-1]
+	if first > last {
+		first, last = last, first
+	}
+	logger := w.logger.WithFields(logrus.Fields{"firstBlock": first, "lastBlock": last})
+	logger.Debug("Processing range of blocks")
+	start := time.Now()
+	w.beat(run[0])
+
+	rpcResponse, err := w.rpcClient.Call(ctx, w.provider.RangeMethod, fmt.Sprintf("0x%x", first), fmt.Sprintf("0x%x", last), true)
+	w.beat(idle)
+	if ctx.Err() != nil {
+		// the block in flight is requeued if the worker was replaced as
+		// stuck, the rest of the run must be retried
+		for _, blockNumber := range run[1:] {
+			w.state.fails.updateFailedBlocks(blockNumber)
+		}
+		return
+	}
+	var blocks map[int64]interface{}
+	if err == nil {
+		blocks, err = rangeBlocks(rpcResponse)
+	}
+	if rpcErr, ok := err.(*jsonrpc.JSONRPCError); ok && rpcErr.Code == methodNotFound {
+		logger.Warn("provider doesn't support its range method, fetching blocks one at a time from now on")
+		w.rangeUnsupported = true
+	} else if err != nil {
+		logger.Warn("range request failed, fetching blocks one at a time: ", err)
+	}
+
+	for _, blockNumber := range run {
+		block, ok := blocks[blockNumber]
+		if !ok {
+			w.handleBlock(ctx, blockNumber)
+			continue
+		}
+		w.totalBlocks++
+		w.processBlock(ctx, blockNumber, &jsonrpc.JSONRPCResponse{JSONRPC: rpcResponse.JSONRPC, Result: block, ID: rpcResponse.ID}, start)
+	}
+}
+
+// rangeBlocks indexes the blocks of a range response by number
+func rangeBlocks(rpcResponse *jsonrpc.JSONRPCResponse) (map[int64]interface{}, error) {
+	if rpcResponse.Error != nil {
+		return nil, rpcResponse.Error
+	}
+	results, ok := rpcResponse.Result.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("range result isn't an array of blocks")
+	}
+	blocks := make(map[int64]interface{}, len(results))
+	for _, result := range results {
+		block, ok := result.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		number, ok := block["number"].(string)
+		if !ok {
+			continue
+		}
+		blockNumber, err := jsonrpc.ParseQuantity(number, jsonrpc.NumberAuto)
+		if err != nil {
+			continue
+		}
+		blocks[blockNumber] = block
+	}
+	return blocks, nil
+}
@@ -0,0 +1,142 @@
+package workers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+const rangeMethod = "htmlcoin_getBlocksByRange"
+
+// rangeClient serves single blocks and, when capable, ranges of blocks,
+// recording the calls made
+type rangeClient struct {
+	capable bool
+	mutex   sync.Mutex
+	calls   []string
+}
+
+func (c *rangeClient) block(number int64) (interface{}, error) {
+	var response jsonrpc.JSONRPCResponse
+	if err := json.Unmarshal(mockJsonRPCResponse, &response); err != nil {
+		return nil, err
+	}
+	response.Result.(map[string]interface{})["number"] = fmt.Sprintf("0x%x", number)
+	return response.Result, nil
+}
+
+func (c *rangeClient) Call(ctx context.Context, method string, params ...interface{}) (*jsonrpc.JSONRPCResponse, error) {
+	c.mutex.Lock()
+	c.calls = append(c.calls, fmt.Sprintf("%s %v", method, params[:len(params)-1]))
+	c.mutex.Unlock()
+
+	switch {
+	case method == "eth_getBlockByNumber":
+		number, _ := strconv.ParseInt(params[0].(string), 0, 64)
+		block, err := c.block(number)
+		return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Result: block, ID: 1}, err
+	case method == rangeMethod && c.capable:
+		first, _ := strconv.ParseInt(params[0].(string), 0, 64)
+		last, _ := strconv.ParseInt(params[1].(string), 0, 64)
+		var blocks []interface{}
+		for number := first; number <= last; number++ {
+			block, err := c.block(number)
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, block)
+		}
+		return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Result: blocks, ID: 1}, nil
+	default:
+		return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Error: &jsonrpc.JSONRPCError{Code: -32601, Message: "the method does not exist"}, ID: 1}, nil
+	}
+}
+
+func (c *rangeClient) GetState() string {
+	return "UNDEFINED"
+}
+
+func TestRangeRequests(t *testing.T) {
+	run := func(t *testing.T, client *rangeClient) ([]string, []int) {
+		state := NewWorkers()
+		state.rangeSize = 10
+		state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+			return client
+		}
+
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		errChan, blockChan, resultChan := createChannels()
+		failedBlocksChan := make(chan int64, 10)
+		processedBlockChan := make(chan int64, 10)
+		provider, _ := jsonrpc.ParseProvider("ranged=http://127.0.0.1:8545")
+		provider.RangeMethod = rangeMethod
+		wg := sync.WaitGroup{}
+		w := state.newWorker(ctx, 1, blockChan, failedBlocksChan, processedBlockChan, resultChan, provider, &wg, errChan)
+
+		// a descending run of 6-3 queued behind 7, then an unrelated block
+		for _, block := range []int64{6, 5, 4, 3, 20} {
+			blockChan <- block
+		}
+		if !w.handle(ctx, 7, true) {
+			t.Fatal("worker quit")
+		}
+
+		var blocks []int
+		for len(resultChan) > 0 {
+			blocks = append(blocks, (<-resultChan).BlockNumber)
+		}
+		sort.Ints(blocks)
+		if failed := state.GetFailedBlocks(); len(failed) != 0 {
+			t.Errorf("got failed blocks %v", failed)
+		}
+		return client.calls, blocks
+	}
+	wantBlocks := []int{3, 4, 5, 6, 7, 20}
+
+	t.Run("contiguous run is fetched in a single range request", func(t *testing.T) {
+		calls, blocks := run(t, &rangeClient{capable: true})
+		want := []string{rangeMethod + " [0x3 0x7]", "eth_getBlockByNumber [0x14]"}
+		if fmt.Sprint(calls) != fmt.Sprint(want) {
+			t.Errorf("got calls %v, want %v", calls, want)
+		}
+		if fmt.Sprint(blocks) != fmt.Sprint(wantBlocks) {
+			t.Errorf("got results for blocks %v, want %v", blocks, wantBlocks)
+		}
+	})
+
+	t.Run("blocks are fetched one at a time when the provider lacks the range method", func(t *testing.T) {
+		calls, blocks := run(t, &rangeClient{capable: false})
+		if len(calls) != 7 || calls[0] != rangeMethod+" [0x3 0x7]" {
+			t.Errorf("got calls %v, want a failed range request then one call per block", calls)
+		}
+		if fmt.Sprint(blocks) != fmt.Sprint(wantBlocks) {
+			t.Errorf("got results for blocks %v, want %v", blocks, wantBlocks)
+		}
+	})
+}
+
+func TestExtendsRun(t *testing.T) {
+	for _, tc := range []struct {
+		run  []int64
+		n    int64
+		want bool
+	}{
+		{[]int64{7}, 8, true},
+		{[]int64{7}, 6, true},
+		{[]int64{7}, 9, false},
+		{[]int64{7, 6}, 5, true},
+		{[]int64{7, 6}, 8, false},
+		{[]int64{7, 8}, 9, true},
+	} {
+		if got := extendsRun(tc.run, tc.n); got != tc.want {
+			t.Errorf("extendsRun(%v, %d) = %v, want %v", tc.run, tc.n, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// transactionHashes returns the hashes of the block's transactions, given
+// in full or as hashes
+func (block *decodedBlock) transactionHashes() ([]string, error) {
+	hashes := make([]string, len(block.htmlcoinBlock.Transactions))
+	for i, tx := range block.htmlcoinBlock.Transactions {
+		switch tx := tx.(type) {
+		case string:
+			hashes[i] = tx
+		case map[string]interface{}:
+			hash, _ := tx["hash"].(string)
+			if hash == "" {
+				return nil, fmt.Errorf("transaction %d of block %s has no hash", i, block.htmlcoinBlock.Number)
+			}
+			hashes[i] = hash
+		default:
+			return nil, fmt.Errorf("unexpected transaction %d of block %s: %v", i, block.htmlcoinBlock.Number, tx)
+		}
+	}
+	return hashes, nil
+}
+
+// callEach makes requests in a batch when the client can, json rpc error
+// objects are returned in their responses and not as errors
+func (w *worker) callEach(ctx context.Context, requests []jsonrpc.Request) ([]*jsonrpc.JSONRPCResponse, error) {
+	if batcher, ok := w.rpcClient.(BatchClient); ok {
+		return batcher.CallBatch(ctx, requests)
+	}
+	responses := make([]*jsonrpc.JSONRPCResponse, len(requests))
+	for i, request := range requests {
+		rpcResponse, err := w.rpcClient.Call(ctx, request.Method, request.Params...)
+		if rpcResponse == nil {
+			return nil, err
+		}
+		responses[i] = rpcResponse
+	}
+	return responses, nil
+}
+
+// fetchReceipts fetches the receipts of the given transactions of a block,
+// with eth_getBlockReceipts where the provider supports it and otherwise in
+// a single batch of eth_getTransactionReceipt calls
+func (w *worker) fetchReceipts(ctx context.Context, blockNumber int64, hashes []string) ([]jsonrpc.GetTransactionReceiptResponse, error) {
+	w.beat(blockNumber)
+	if !w.blockReceiptsUnsupported {
+		responses, err := w.callEach(ctx, []jsonrpc.Request{{Method: "eth_getBlockReceipts", Params: []interface{}{fmt.Sprintf("0x%x", blockNumber)}}})
+		if err != nil {
+			return nil, err
+		}
+		if responses[0].Error == nil {
+			var receipts []jsonrpc.GetTransactionReceiptResponse
+			err = jsonrpc.GetReceiptFromRPCResponse(responses[0], &receipts)
+			if err == nil && len(receipts) == len(hashes) {
+				return receipts, nil
+			}
+		}
+		w.logger.Debug("Provider doesn't support eth_getBlockReceipts, fetching receipts by transaction from now on")
+		w.blockReceiptsUnsupported = true
+	}
+
+	requests := make([]jsonrpc.Request, len(hashes))
+	for i, hash := range hashes {
+		requests[i] = jsonrpc.Request{Method: "eth_getTransactionReceipt", Params: []interface{}{hash}}
+	}
+	responses, err := w.callEach(ctx, requests)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts := make([]jsonrpc.GetTransactionReceiptResponse, len(hashes))
+	for i, rpcResponse := range responses {
+		if rpcResponse.Error != nil {
+			return nil, fmt.Errorf("receipt of transaction %s: %w", hashes[i], rpcResponse.Error)
+		}
+		if err = jsonrpc.GetReceiptFromRPCResponse(rpcResponse, &receipts[i]); err != nil {
+			return nil, fmt.Errorf("receipt of transaction %s: %w", hashes[i], err)
+		}
+	}
+	return receipts, nil
+}
+
+// omitReverted leaves the transactions whose receipt has a status of 0x0
+// out of pair, along with their receipts and so their logs. The block is
+// still handed over. Receipts from before byzantium have no status and are
+// kept
+func omitReverted(pair *jsonrpc.HashPair) {
+	reverted := make(map[string]bool)
+	receipts := pair.Receipts[:0]
+	for _, receipt := range pair.Receipts {
+		if receipt.Status == "0x0" {
+			reverted[receipt.TransactionHash] = true
+			continue
+		}
+		receipts = append(receipts, receipt)
+	}
+	pair.Receipts = receipts
+	if pair.Block == nil || len(reverted) == 0 {
+		return
+	}
+	transactions := pair.Block.Transactions[:0]
+	for _, transaction := range pair.Block.Transactions {
+		if !reverted[transaction.Hash] {
+			transactions = append(transactions, transaction)
+		}
+	}
+	pair.Block.Transactions = transactions
+}
@@ -0,0 +1,117 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// receiptClient answers receipt calls, with eth_getBlockReceipts when
+// blockReceipts is set. The transactions in reverted have a status of 0x0
+type receiptClient struct {
+	rangeClient
+	blockReceipts bool
+	reverted      map[string]bool
+	batches       []int
+}
+
+func (c *receiptClient) receipt(hash string) map[string]interface{} {
+	status := "0x1"
+	if c.reverted[hash] {
+		status = "0x0"
+	}
+	return map[string]interface{}{"transactionHash": hash, "transactionIndex": "0x0", "gasUsed": "0x5208", "status": status, "logs": []interface{}{}}
+}
+
+func (c *receiptClient) Call(ctx context.Context, method string, params ...interface{}) (*jsonrpc.JSONRPCResponse, error) {
+	switch {
+	case method == "eth_getBlockReceipts" && c.blockReceipts:
+		var receipts []interface{}
+		for _, hash := range []string{"0xa", "0xb", "0xc"} {
+			receipts = append(receipts, c.receipt(hash))
+		}
+		return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Result: receipts, ID: 1}, nil
+	case method == "eth_getTransactionReceipt":
+		return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", Result: c.receipt(params[0].(string)), ID: 1}, nil
+	}
+	return c.rangeClient.Call(ctx, method, append(params, true)...)
+}
+
+func (c *receiptClient) CallBatch(ctx context.Context, requests []jsonrpc.Request) ([]*jsonrpc.JSONRPCResponse, error) {
+	c.batches = append(c.batches, len(requests))
+	responses := make([]*jsonrpc.JSONRPCResponse, len(requests))
+	for i, request := range requests {
+		responses[i], _ = c.Call(ctx, request.Method, request.Params...)
+	}
+	return responses, nil
+}
+
+func TestReceipts(t *testing.T) {
+	fetch := func(t *testing.T, client *receiptClient, opts ...Option) jsonrpc.HashPair {
+		t.Helper()
+		state := NewWorkers()
+		state.withReceipts = true
+		for _, opt := range opts {
+			opt(state)
+		}
+		state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+			return client
+		}
+		ctx, cancelFunc := context.WithCancel(context.Background())
+		defer cancelFunc()
+		errChan, blockChan, resultChan := createChannels()
+		provider, _ := jsonrpc.ParseProvider("receipts=http://127.0.0.1:8545")
+		wg := sync.WaitGroup{}
+		w := state.newWorker(ctx, 1, blockChan, make(chan int64), make(chan int64, 1), resultChan, provider, &wg, errChan)
+		if !w.handle(ctx, 1, true) {
+			t.Fatal("worker quit")
+		}
+		if failed := state.GetFailedBlocks(); len(failed) > 0 {
+			t.Fatalf("got failed blocks %v", failed)
+		}
+		return <-resultChan
+	}
+
+	t.Run("receipts are fetched with eth_getBlockReceipts", func(t *testing.T) {
+		client := &receiptClient{blockReceipts: true}
+		pair := fetch(t, client)
+		if len(pair.Receipts) != 3 || pair.Receipts[2].TransactionHash != "0xc" {
+			t.Errorf("got receipts %+v, want those of 0xa, 0xb and 0xc", pair.Receipts)
+		}
+		if fmt.Sprint(client.batches) != "[1]" {
+			t.Errorf("got receipt batches %v, want [1]", client.batches)
+		}
+	})
+
+	t.Run("receipts are fetched in a single batch without eth_getBlockReceipts", func(t *testing.T) {
+		client := &receiptClient{}
+		pair := fetch(t, client)
+		if len(pair.Receipts) != 3 || pair.Receipts[0].TransactionHash != "0x2a980732ab97f270e8e7e227d55e62170a5f782ec5b4dcd80af69ec5cc2f84e7" {
+			t.Errorf("got receipts %+v, want those of the block's transactions", pair.Receipts)
+		}
+		// the eth_getBlockReceipts probe, then the receipts by transaction
+		if fmt.Sprint(client.batches) != "[1 3]" {
+			t.Errorf("got receipt batches %v, want [1 3]", client.batches)
+		}
+	})
+
+	t.Run("only successful transactions are handed over with the block", func(t *testing.T) {
+		const reverted = "0xe14ecd01d5b4a323b55d464ce9efaeaf3d30477d076dd82db6018d39b9f55614"
+		client := &receiptClient{reverted: map[string]bool{reverted: true}}
+		pair := fetch(t, client, WithStoreBlocks(true), WithOnlySuccessfulTransactions(true))
+		if pair.BlockNumber != 1 || pair.Block == nil {
+			t.Fatalf("got %+v, want block 1 handed over", pair)
+		}
+		if len(pair.Receipts) != 2 || len(pair.Block.Transactions) != 2 {
+			t.Fatalf("got %d receipts and %d transactions, want the 2 successful ones", len(pair.Receipts), len(pair.Block.Transactions))
+		}
+		for i, receipt := range pair.Receipts {
+			if receipt.TransactionHash == reverted || pair.Block.Transactions[i].Hash == reverted {
+				t.Errorf("got the reverted transaction %s stored", reverted)
+			}
+		}
+	})
+}
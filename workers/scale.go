@@ -0,0 +1,57 @@
+package workers
+
+import (
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
+)
+
+// Size returns the number of workers running, quarantined and retired
+// workers aside
+func (workers *Workers) Size() int {
+	workers.mutex.Lock()
+	defer workers.mutex.Unlock()
+	return len(workers.workers)
+}
+
+// Scale starts or retires workers until n are running, returning how many
+// are. New workers are spread over the providers like the first ones,
+// skipping quarantined providers, retired workers exit once done with the
+// block they're processing. It's a no-op on workers StartWorkers didn't start
+func (workers *Workers) Scale(n int) int {
+	if workers.spawn == nil || n < 1 {
+		return workers.Size()
+	}
+
+	workers.mutex.Lock()
+	var retired []*worker
+	if n < len(workers.workers) {
+		retired = workers.workers[n:]
+		workers.workers = workers.workers[:n:n]
+	}
+	type spawned struct {
+		id       int
+		provider *jsonrpc.Provider
+	}
+	var started []spawned
+	for missing := n - len(workers.workers); missing > 0 && len(workers.quarantined) < len(workers.spawnProviders); {
+		id := workers.nextId
+		workers.nextId++
+		provider := workers.spawnProviders[id%len(workers.spawnProviders)]
+		if workers.quarantined[provider] {
+			continue
+		}
+		started = append(started, spawned{id, provider})
+		missing--
+	}
+	workers.mutex.Unlock()
+
+	for _, w := range retired {
+		close(w.retire)
+	}
+	for _, s := range started {
+		workers.spawn(s.id, s.provider)
+	}
+	size := workers.Size()
+	metrics.Workers.Set(float64(size))
+	return size
+}
@@ -0,0 +1,47 @@
+package workers
+
+import (
+	"sync"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// notFoundBlocks counts the not found responses given for blocks by each
+// provider
+type notFoundBlocks struct {
+	mutex  sync.Mutex
+	counts map[int64]map[*jsonrpc.Provider]int
+}
+
+func newNotFoundBlocks() *notFoundBlocks {
+	return &notFoundBlocks{counts: make(map[int64]map[*jsonrpc.Provider]int)}
+}
+
+// isSkipped records provider not finding block and reports whether every
+// running provider did at least skippedBlockAttempts times, meaning the
+// block number legitimately doesn't exist rather than being briefly
+// unavailable on one provider
+func (workers *Workers) isSkipped(block int64, provider *jsonrpc.Provider) bool {
+	if workers.skippedBlockAttempts < 1 {
+		return false
+	}
+	providers := workers.providers()
+
+	notFound := workers.notFound
+	notFound.mutex.Lock()
+	defer notFound.mutex.Unlock()
+	counts, ok := notFound.counts[block]
+	if !ok {
+		counts = make(map[*jsonrpc.Provider]int)
+		notFound.counts[block] = counts
+	}
+	counts[provider]++
+
+	for _, p := range providers {
+		if counts[p] < workers.skippedBlockAttempts {
+			return false
+		}
+	}
+	delete(notFound.counts, block)
+	return true
+}
@@ -0,0 +1,71 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+// notFoundClient reports every block as not found
+type notFoundClient struct{}
+
+func (c *notFoundClient) Call(ctx context.Context, method string, params ...interface{}) (*jsonrpc.JSONRPCResponse, error) {
+	return &jsonrpc.JSONRPCResponse{JSONRPC: "2.0", ID: 1}, nil
+}
+
+func (c *notFoundClient) GetState() string {
+	return "UNDEFINED"
+}
+
+func TestSkippedBlocks(t *testing.T) {
+	state := NewWorkers()
+	state.skippedBlockAttempts = 2
+	state.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+		return &notFoundClient{}
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan, blockChan, resultChan := createChannels()
+	failedBlocksChan := make(chan int64, 1)
+	processedBlockChan := make(chan int64, 10)
+	first, _ := jsonrpc.ParseProvider("first=http://127.0.0.1:8545")
+	second, _ := jsonrpc.ParseProvider("second=http://127.0.0.1:8546")
+	wg := sync.WaitGroup{}
+	w1 := state.newWorker(ctx, 1, blockChan, failedBlocksChan, processedBlockChan, resultChan, first, &wg, errChan)
+	w2 := state.newWorker(ctx, 2, blockChan, failedBlocksChan, processedBlockChan, resultChan, second, &wg, errChan)
+
+	t.Run("block missing on some providers is retried", func(t *testing.T) {
+		w1.handleBlock(ctx, 13)
+		w1.handleBlock(ctx, 13)
+		w2.handleBlock(ctx, 13)
+		select {
+		case pair := <-resultChan:
+			t.Fatalf("got block %d recorded before every provider reported it", pair.BlockNumber)
+		default:
+		}
+		if failed := state.GetTotalFailedBlocks(); failed != 3 {
+			t.Errorf("got %d failures, want 3", failed)
+		}
+	})
+
+	t.Run("block consistently not found by every provider is skipped", func(t *testing.T) {
+		w2.handleBlock(ctx, 13)
+		select {
+		case pair := <-resultChan:
+			if pair.BlockNumber != 13 || !pair.Skipped {
+				t.Errorf("got %+v, want block 13 skipped", pair)
+			}
+		default:
+			t.Fatal("block wasn't recorded as skipped")
+		}
+		if got := <-processedBlockChan; got != 13 {
+			t.Errorf("got block %d completed, want 13", got)
+		}
+		if failed := state.GetTotalFailedBlocks(); failed != 3 {
+			t.Errorf("got %d failures, want the skipped block not to fail", failed)
+		}
+	})
+}
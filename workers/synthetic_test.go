@@ -0,0 +1,60 @@
+package workers
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
+)
+
+func TestSyntheticPipeline(t *testing.T) {
+	const blocks = 200
+	const numWorkers = 8
+	provider, err := jsonrpc.ParseProvider("synthetic://?latency=10ms&head=1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	errChan := make(chan error, 1)
+	blockChan := make(chan int64, blocks)
+	failedBlocksChan := make(chan int64)
+	completedBlockChan := make(chan int64, blocks)
+	resultChan := make(chan jsonrpc.HashPair, blocks)
+	wg := sync.WaitGroup{}
+
+	start := time.Now()
+	StartWorkers(ctx, numWorkers, blockChan, failedBlocksChan, completedBlockChan, resultChan, []*jsonrpc.Provider{provider}, &wg, errChan, WithDecodeWorkers(2))
+	for i := int64(1); i <= blocks; i++ {
+		blockChan <- i
+	}
+
+	seen := make(map[int]bool)
+	for len(seen) < blocks {
+		select {
+		case pair := <-resultChan:
+			if seen[pair.BlockNumber] {
+				t.Fatalf("got block %d twice", pair.BlockNumber)
+			}
+			seen[pair.BlockNumber] = true
+			if pair.HtmlcoinHash != jsonrpc.SyntheticBlockHash(int64(pair.BlockNumber)) {
+				t.Errorf("got hash %s for block %d", pair.HtmlcoinHash, pair.BlockNumber)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("got %d of %d blocks", len(seen), blocks)
+		}
+	}
+	elapsed := time.Since(start)
+	t.Logf("processed %d blocks in %v (%.0f blocks/s)", blocks, elapsed, blocks/elapsed.Seconds())
+
+	// 10ms per block spread over the workers, with generous slack
+	ideal := blocks * 10 * time.Millisecond / numWorkers
+	if elapsed > 8*ideal {
+		t.Errorf("took %v, want under %v", elapsed, 8*ideal)
+	}
+	close(blockChan)
+	wg.Wait()
+}
@@ -3,12 +3,12 @@ package workers
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"sync"
 	"time"
 
 	"github.com/denuoweb/ethereum-block-processor/jsonrpc"
 	"github.com/denuoweb/ethereum-block-processor/log"
+	"github.com/denuoweb/ethereum-block-processor/metrics"
 	"github.com/sirupsen/logrus"
 	"github.com/sony/gobreaker"
 )
@@ -16,7 +16,10 @@ import (
 type results struct {
 	failBlocks    []int64
 	totalFailures int
-	mu            *sync.Mutex
+	// failed attempts per block, and blocks dead-lettered after too many
+	attempts     map[int64]int
+	deadLettered int
+	mu           *sync.Mutex
 }
 
 type workerStatus int
@@ -41,20 +44,68 @@ type Workers struct {
 	fails   *results
 	workers []*worker
 	mutex   sync.Mutex
+	// creates the rpc client a worker fetches blocks with
+	newClient func(provider *jsonrpc.Provider, id int) CBClient
+	// decodes fetched blocks on decodeWorkers, blocks are decoded by their
+	// worker when nil
+	decodeWorkers int
+	decodePool    *DecodePool
+	// reject blocks whose number isn't the one requested
+	validateBlockNumber bool
+	// returns the chain id a provider currently serves
+	getChainId func(ctx context.Context, provider *jsonrpc.Provider) (int64, error)
+	// providers serving another chain, their workers aren't replaced
+	quarantined map[*jsonrpc.Provider]bool
+	// not found responses every provider must give before a block is
+	// recorded as skipped, 0 never skips blocks
+	skippedBlockAttempts int
+	notFound             *notFoundBlocks
+	// maximum number of contiguous blocks fetched in a single range request,
+	// from providers with a range method
+	rangeSize int
+	// maximum number of blocks fetched in a single batch request
+	batchSize int
+	// fetch the receipts of every block's transactions
+	withReceipts bool
+	// leave the transactions that reverted, and their receipts, out
+	onlySuccessful bool
+	// hand over the header and the transactions of every block
+	storeBlocks bool
+	// added to every line logged by the workers
+	logFields logrus.Fields
+	// failed attempts after which a block is dead-lettered, 0 retries
+	// blocks indefinitely
+	deadLetterAttempts int
+	// dead-lettered blocks over which the run is aborted, 0 never aborts
+	maxFailures int
+	// starts a worker with the given id on the given provider, set by
+	// StartWorkers for Scale
+	spawn          func(id int, provider *jsonrpc.Provider)
+	spawnProviders []*jsonrpc.Provider
+	nextId         int
 }
 
 func NewWorkers() *Workers {
 	return &Workers{
 		fails: &results{
 			failBlocks: make([]int64, 0),
+			attempts:   make(map[int64]int),
 			mu:         &sync.Mutex{},
 		},
+		newClient: func(provider *jsonrpc.Provider, id int) CBClient {
+			return jsonrpc.NewProviderClient(provider, id)
+		},
+		getChainId:  getChainId,
+		quarantined: make(map[*jsonrpc.Provider]bool),
+		notFound:    newNotFoundBlocks(),
 	}
 }
 
 type worker struct {
 	id                 int
 	ctx                context.Context
+	parentCtx          context.Context
+	cancel             context.CancelFunc
 	state              *Workers
 	rpcClient          CBClient
 	blockChan          <-chan int64
@@ -62,13 +113,23 @@ type worker struct {
 	processedBlockChan chan int64
 	resultChan         chan<- jsonrpc.HashPair
 	wg                 *sync.WaitGroup
-	url                string
+	provider           *jsonrpc.Provider
 	logger             *logrus.Entry
 	erroChan           chan error
 	totalBlocks        uint64
 	succesBlocks       uint64
 	status             workerStatus
 	cbChan             chan gobreaker.State
+	// unix nanoseconds of the last progress made, accessed atomically
+	heartbeat int64
+	// block being fetched or idle, accessed atomically
+	inFlight int64
+	// the provider doesn't have its range method
+	rangeUnsupported bool
+	// the provider doesn't have eth_getBlockReceipts
+	blockReceiptsUnsupported bool
+	// closed to have the worker exit once done with its block
+	retire chan struct{}
 }
 
 func (workers *Workers) newWorker(
@@ -78,7 +139,7 @@ func (workers *Workers) newWorker(
 	failedBlocksChan <-chan int64,
 	processedBlockChan chan int64,
 	resultChan chan<- jsonrpc.HashPair,
-	url string,
+	provider *jsonrpc.Provider,
 	wg *sync.WaitGroup,
 	errChan chan error,
 ) *worker {
@@ -86,32 +147,38 @@ func (workers *Workers) newWorker(
 	// Create a rpc client as CBClient interface
 	var rpcClient CBClient
 	// Assign a new jsonRPCClient to the rpcClient variable
-	rpcClient = jsonrpc.NewClient(url, id)
+	rpcClient = workers.newClient(provider, id)
 	// channel to receive notifications from circuit breaker
 	cbChan := make(chan gobreaker.State, 3)
 	// Wrap the rpcClient with a Cirbuit Breaker proxy
 	rpcClient = NewClientCircuitBreakerProxy(rpcClient, cbChan)
 
+	workerCtx, cancel := context.WithCancel(ctx)
 	w := &worker{
 		id:                 id,
-		ctx:                ctx,
+		ctx:                workerCtx,
+		parentCtx:          ctx,
+		cancel:             cancel,
 		state:              workers,
 		blockChan:          blockChan,
 		failedBlocksChan:   failedBlocksChan,
 		processedBlockChan: processedBlockChan,
 		resultChan:         resultChan,
 		wg:                 wg,
-		url:                url,
+		provider:           provider,
 		erroChan:           errChan,
 		status:             RUNNING,
 		cbChan:             cbChan,
 		rpcClient:          rpcClient,
+		heartbeat:          time.Now().UnixNano(),
+		inFlight:           idle,
+		retire:             make(chan struct{}),
 	}
 
-	logger := workerLogger.WithFields(logrus.Fields{
+	logger := workerLogger.WithFields(workers.logFields).WithFields(logrus.Fields{
 		"component": "worker",
 		"WorkerId":  id,
-		"endpoint":  url,
+		"endpoint":  provider.Name(),
 		"status":    w.status.String(),
 		"cbState":   w.rpcClient.GetState(),
 	})
@@ -121,11 +188,113 @@ func (workers *Workers) newWorker(
 	workers.workers = append(workers.workers, w)
 	workers.mutex.Unlock()
 
-	w.Start()
-
 	return w
 }
 
+type Option func(workers *Workers)
+
+// WithDecodeWorkers decodes the fetched blocks on a pool of decodeWorkers
+// shared by every worker, blocks are decoded by their worker when 0
+func WithDecodeWorkers(decodeWorkers int) Option {
+	return func(workers *Workers) {
+		workers.decodeWorkers = decodeWorkers
+	}
+}
+
+// WithBlockNumberValidation rejects fetched blocks whose number isn't the
+// requested one, so they are retried instead of stored
+func WithBlockNumberValidation(validate bool) Option {
+	return func(workers *Workers) {
+		workers.validateBlockNumber = validate
+	}
+}
+
+// WithSkippedBlockAttempts records blocks every provider reported not found
+// attempts times as skipped, 0 never skips blocks
+func WithSkippedBlockAttempts(attempts int) Option {
+	return func(workers *Workers) {
+		workers.skippedBlockAttempts = attempts
+	}
+}
+
+// WithRangeSize fetches up to size contiguous blocks in a single request
+// from providers with a range method
+func WithRangeSize(size int) Option {
+	return func(workers *Workers) {
+		workers.rangeSize = size
+	}
+}
+
+// WithBatchSize fetches up to size queued blocks in a single json rpc batch
+// request, from providers without a range method
+func WithBatchSize(size int) Option {
+	return func(workers *Workers) {
+		workers.batchSize = size
+	}
+}
+
+// WithReceipts fetches the receipts of every block's transactions
+func WithReceipts(fetch bool) Option {
+	return func(workers *Workers) {
+		workers.withReceipts = fetch
+	}
+}
+
+// WithOnlySuccessfulTransactions leaves the transactions whose receipt
+// reports they reverted out of every block handed over, along with their
+// receipts and logs. It needs receipts
+func WithOnlySuccessfulTransactions(only bool) Option {
+	return func(workers *Workers) {
+		workers.onlySuccessful = only
+	}
+}
+
+// WithStoreBlocks hands over the header and the transactions of every block
+// along with its hashes
+func WithStoreBlocks(store bool) Option {
+	return func(workers *Workers) {
+		workers.storeBlocks = store
+	}
+}
+
+// WithDeadLetters dead-letters blocks that failed attempts times, aborting
+// the run once more than maxFailures blocks are. An attempts of 0 retries
+// failed blocks indefinitely
+func WithDeadLetters(attempts, maxFailures int) Option {
+	return func(workers *Workers) {
+		workers.deadLetterAttempts = attempts
+		workers.maxFailures = maxFailures
+	}
+}
+
+// WithProviderPool has workers call their provider through pool, sharing
+// provider health and failing over to the other providers, or when weighted
+// a provider of the pool picked by its observed throughput on every call.
+// A nil pool has workers call their provider directly
+func WithProviderPool(pool *jsonrpc.Pool, weighted bool) Option {
+	return func(workers *Workers) {
+		if pool != nil && weighted {
+			workers.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+				return pool.Weighted()
+			}
+		} else if pool != nil {
+			workers.newClient = func(provider *jsonrpc.Provider, id int) CBClient {
+				return pool.Preferring(provider)
+			}
+		}
+	}
+}
+
+// WithLogFields adds fields to every line logged by the workers
+func WithLogFields(fields logrus.Fields) Option {
+	return func(workers *Workers) {
+		workers.logFields = fields
+	}
+}
+
+// StartWorkers starts numWorkers workers spread over providers, fetching the
+// blocks of blockChan, and of failedBlocksChan while none is queued, and
+// sending their hashes on resultChan and their numbers on completedBlockChan
 func StartWorkers(
 	ctx context.Context,
 	numWorkers int,
@@ -133,34 +302,47 @@ func StartWorkers(
 	failedBlocksChan <-chan int64,
 	completedBlockChan chan int64,
 	resultChan chan<- jsonrpc.HashPair,
-	providers []*url.URL,
+	providers []*jsonrpc.Provider,
 	wg *sync.WaitGroup,
 	errChan chan error,
+	opts ...Option,
 ) *Workers {
 	p := len(providers)
 	state := NewWorkers()
+	for _, opt := range opts {
+		opt(state)
+	}
+	if state.decodeWorkers > 0 {
+		state.decodePool = NewDecodePool(ctx, state.decodeWorkers)
+	}
+	state.spawnProviders = providers
+	state.spawn = func(id int, provider *jsonrpc.Provider) {
+		w := state.newWorker(
+			ctx,
+			id,
+			blockChan,
+			failedBlocksChan,
+			completedBlockChan,
+			resultChan,
+			provider,
+			wg,
+			errChan,
+		)
+		wg.Add(1)
+		go w.Start()
+	}
 	for i := 0; i < numWorkers; i++ {
-		go func(i int) {
-			state.newWorker(
-				ctx,
-				i,
-				blockChan,
-				failedBlocksChan,
-				completedBlockChan,
-				resultChan,
-				providers[i%p].String(),
-				wg,
-				errChan,
-			)
-			// w.Start()
-		}(i)
+		state.spawn(i, providers[i%p])
 	}
+	state.nextId = numWorkers
+	metrics.Workers.Set(float64(numWorkers))
 
 	return state
 }
 
+// Start runs the worker loop until its context is cancelled or the block
+// channel is closed, the caller must have added the worker to its WaitGroup
 func (w *worker) Start() {
-	w.wg.Add(1)
 	defer w.wg.Done()
 	ctx := w.ctx
 	// main worker loop
@@ -171,6 +353,9 @@ func (w *worker) Start() {
 		case <-ctx.Done():
 			w.handleExit("received Cancel signal... worker quitting")
 			return
+		case <-w.retire:
+			w.handleExit("retired... worker quitting")
+			return
 		case blockNumber, ok := <-w.blockChan:
 			// block ready, process it
 			if !w.handle(ctx, blockNumber, ok) {
@@ -182,6 +367,9 @@ func (w *worker) Start() {
 			case <-ctx.Done():
 				w.handleExit("received Cancel signal... worker quitting")
 				return
+			case <-w.retire:
+				w.handleExit("retired... worker quitting")
+				return
 			case blockNumber, ok := <-w.blockChan:
 				// block ready, process it
 				if !w.handle(ctx, blockNumber, ok) {
@@ -199,6 +387,9 @@ func (w *worker) Start() {
 		case <-ctx.Done():
 			w.handleExit("received Cancel signal... worker quitting")
 			return
+		case <-w.retire:
+			w.handleExit("retired... worker quitting")
+			return
 		// Read next available block in channel for processing it
 		case blockNumber, ok := <-w.blockChan:
 			if !w.handle(ctx, blockNumber, ok) {
@@ -221,6 +412,12 @@ func (w *worker) handle(ctx context.Context, blockNumber int64, ok bool) bool {
 		// Check the circuit with the RPC endpoint is close (available)
 		if w.rpcClient.GetState() == gobreaker.StateClosed.String() {
 			w.handleStateChange(RUNNING)
+			if w.rangeEnabled() {
+				return w.handleRun(ctx, blockNumber)
+			}
+			if w.batchEnabled() {
+				return w.handleBatch(ctx, blockNumber)
+			}
 			w.handleBlock(ctx, blockNumber)
 			// Circuit breaker is open, so halt the worker until it circuit is closed
 		} else {
@@ -284,52 +481,131 @@ func (w *worker) handleBlock(ctx context.Context, blockNumber int64) {
 		"Blocknumber": blockNumber,
 	})
 	w.logger.Debug("Processing block")
+	start := time.Now()
+	w.beat(blockNumber)
+	defer w.beat(idle)
 
 	rpcResponse, err := w.rpcClient.Call(ctx, "eth_getBlockByNumber", fmt.Sprintf("0x%x", blockNumber), true)
+	if ctx.Err() != nil {
+		// cancelled, possibly replaced as stuck, the block is processed elsewhere
+		return
+	}
 	if err != nil {
-		if err != ctx.Err() {
-			w.logger.Error("RPC client call error: ", err)
-			w.state.fails.updateFailedBlocks(blockNumber)
-		}
+		w.logger.Error("RPC client call error: ", err)
+		w.fail(blockNumber, err)
 		return
 	}
+	if rpcResponse.Retries > 0 {
+		w.logger.WithField("retries", rpcResponse.Retries).Info("Block fetched after retrying")
+	}
 	if rpcResponse.Error != nil {
 		w.logger.Error("rpc response error: ", rpcResponse.Error)
-		w.state.fails.updateFailedBlocks(blockNumber)
+		w.fail(blockNumber, rpcResponse.Error)
 		return
 	}
+	w.processBlock(ctx, blockNumber, rpcResponse, start)
+}
 
-	var htmlcoinBlock jsonrpc.GetBlockByNumberResponse
-	err = jsonrpc.GetBlockFromRPCResponse(rpcResponse, &htmlcoinBlock)
+// processBlock decodes a fetched block and sends its hashes to be stored,
+// failing the block so it's retried when it can't be
+func (w *worker) processBlock(ctx context.Context, blockNumber int64, rpcResponse *jsonrpc.JSONRPCResponse, start time.Time) {
+	err := jsonrpc.NormalizeTimestamp(rpcResponse, w.provider.TimestampFormat)
 	if err != nil {
-		w.logger.Error("could not convert result to htmlcoin.GetBlockByNumberResponse: ", err)
-		w.state.fails.updateFailedBlocks(blockNumber)
+		w.logger.Error(err)
+		w.fail(blockNumber, err)
+		return
+	}
+
+	var block *decodedBlock
+	if w.state.decodePool != nil {
+		block, err = w.state.decodePool.Decode(ctx, rpcResponse)
+	} else {
+		block, err = decodeBlock(rpcResponse)
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	if err == jsonrpc.ErrNotFound {
+		if w.state.isSkipped(blockNumber, w.provider) {
+			w.logger.Warn("block consistently not found by every provider, recording it as skipped")
+			w.beat(idle)
+			w.state.fails.clearAttempts(blockNumber)
+			w.resultChan <- jsonrpc.HashPair{BlockNumber: int(blockNumber), Skipped: true}
+			w.processedBlockChan <- blockNumber
+			return
+		}
+		w.logger.Warn("block not found")
+		w.fail(blockNumber, err)
 		return
 	}
-	var ethBlock jsonrpc.EthBlockHeader
-	err = jsonrpc.GetBlockFromRPCResponse(rpcResponse, &ethBlock)
 	if err != nil {
-		w.logger.Error("could not convert result to htmlcoin.EthBlockHeader: ", err)
-		w.state.fails.updateFailedBlocks(blockNumber)
+		w.logger.Error(err)
+		w.fail(blockNumber, err)
 		return
 	}
+	if w.state.validateBlockNumber {
+		// a provider serving stale responses can return another block, fail
+		// it so it's retried, possibly against another provider
+		if err = block.validateNumber(blockNumber); err != nil {
+			w.logger.Warn(err)
+			w.fail(blockNumber, err)
+			return
+		}
+	}
 
 	hashPair := jsonrpc.HashPair{
-		HtmlcoinHash:    htmlcoinBlock.Hash,
-		EthHash:     ethBlock.Hash().String(),
-		BlockNumber: int(blockNumber),
+		HtmlcoinHash: block.htmlcoinBlock.Hash,
+		EthHash:      block.ethBlock.Hash().String(),
+		BlockNumber:  int(blockNumber),
+		Empty:        len(block.htmlcoinBlock.Transactions) == 0,
+		ParentHash:   block.htmlcoinBlock.ParentHash,
+	}
+	hashPair.Size, hashPair.GasUsedRatio = block.stats()
+	if w.state.withReceipts && !hashPair.Empty {
+		// a block is only stored with all of its receipts
+		hashes, err := block.transactionHashes()
+		if err == nil {
+			hashPair.Receipts, err = w.fetchReceipts(ctx, blockNumber, hashes)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			w.logger.Error("failed to fetch receipts: ", err)
+			w.fail(blockNumber, err)
+			return
+		}
+	}
+	if w.state.storeBlocks {
+		if hashPair.Block, err = block.blockData(); err != nil {
+			w.logger.Error(err)
+			w.fail(blockNumber, err)
+			return
+		}
+	}
+	if w.state.onlySuccessful {
+		omitReverted(&hashPair)
 	}
+	metrics.BlockProcessingDuration.WithLabelValues(w.provider.Name()).Observe(time.Since(start).Seconds())
+	// waiting on the database isn't a stuck fetch
+	w.beat(idle)
+	w.state.fails.clearAttempts(blockNumber)
 	w.resultChan <- hashPair
 	w.processedBlockChan <- blockNumber
 	w.succesBlocks++
 
 }
 
-func (r *results) updateFailedBlocks(blockNumber int64) {
+// updateFailedBlocks records a failed attempt at blockNumber, returning the
+// attempts it has failed so far
+func (r *results) updateFailedBlocks(blockNumber int64) int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.failBlocks = append(r.failBlocks, blockNumber)
 	r.totalFailures++
+	r.attempts[blockNumber]++
+	metrics.BlocksFailed.Inc()
+	return r.attempts[blockNumber]
 }
 
 func (state *Workers) GetTotalFailedBlocks() int {
@@ -374,7 +650,7 @@ func (w *worker) handleStateChange(status workerStatus) {
 		w.status = status
 		w.logger = w.logger.WithFields(logrus.Fields{
 			"WorkerId": w.id,
-			"endpoint": w.url,
+			"endpoint": w.provider.Name(),
 			"status":   w.status.String(),
 			"cbState":  w.rpcClient.GetState(),
 		})